@@ -14,6 +14,8 @@ import (
 	"strings"
 	"time"
 
+	"github.com/cuttle-ai/file-uploader-service/models"
+	"github.com/cuttle-ai/file-uploader-service/retry"
 	"github.com/cuttle-ai/file-uploader-service/version"
 
 	"github.com/cuttle-ai/configs/config"
@@ -51,8 +53,76 @@ var (
 	FileDumpDirectory = Separator + "cuttle.ai" + Separator + "uploaded-files" + Separator
 	//DoSCPFileTransfer will do file transfers over scp to the datastore. If false, it will do a simple cp assuming the datastore store is the same server
 	DoSCPFileTransfer = true
+	//MaxUploadSize is the maximum size in bytes of an uploaded file
+	MaxUploadSize = int64(10 << 30)
+	//MaxDecompressedSize is the maximum total size in bytes of the files decompressed from an uploaded archive
+	MaxDecompressedSize = int64(10 << 30)
+	//MaxCompressionRatio is the maximum ratio of the decompressed size to the size of an uploaded archive
+	MaxCompressionRatio = int64(100)
+	//MaxArchiveEntries is the maximum no. of files allowed in an uploaded archive
+	MaxArchiveEntries = 100
+	//MaxExplodedRows is the maximum no. of rows a record in a json file can be exploded into by its arrays
+	MaxExplodedRows = 10000
+	//RemoteImportTimeout is the time within which a file imported from a remote url has to be downloaded in milliseconds
+	RemoteImportTimeout = time.Duration(30 * time.Minute)
+	//RemoteImportAllowPrivate allows importing the files hosted at the loopback, private and link local addresses. Enable it only if the files are imported from the private network
+	RemoteImportAllowPrivate = false
+	//RefreshCheckInterval is the interval in milliseconds at which the refresh schedules of the datasets are checked
+	RefreshCheckInterval = time.Duration(1 * time.Minute)
+	//JobWorkers is the no. of workers running the background jobs
+	JobWorkers = 4
+	//JobPollInterval is the interval in milliseconds at which an idle worker checks for the queued jobs
+	JobPollInterval = time.Duration(5 * time.Second)
+	//JobHeartbeatInterval is the interval in milliseconds at which a running job reports. A job not reported for thrice the interval is recovered
+	JobHeartbeatInterval = time.Duration(30 * time.Second)
+	//JobMaxAttempts is the maximum no. of times an interrupted job is run
+	JobMaxAttempts = 3
+	//ShutdownTimeout is the time in milliseconds given to the in-flight requests, refreshes and jobs to finish while shutting down.
+	//The jobs still running after that are queued again to be resumed by another instance
+	ShutdownTimeout = time.Duration(25 * time.Second)
+	//DefaultRetryPolicy is the retry policy of the calls to the other services in the stages of the pipeline without a policy
+	DefaultRetryPolicy = retry.Policy{MaxAttempts: 3, InitialBackoff: time.Second, MaxBackoff: 30 * time.Second}
+	//RetryPolicies are the retry policies of the calls to the other services in the stages of the pipeline
+	RetryPolicies = map[string]retry.Policy{
+		models.JobStageUpload:     {MaxAttempts: 3, InitialBackoff: time.Second, MaxBackoff: 10 * time.Second},
+		models.JobStageOptimize:   {MaxAttempts: 5, InitialBackoff: 2 * time.Second, MaxBackoff: time.Minute},
+		models.JobStageDictUpdate: {MaxAttempts: 5, InitialBackoff: 2 * time.Second, MaxBackoff: time.Minute},
+	}
 )
 
+//GetRetryPolicy returns the retry policy of the stage of the pipeline
+func GetRetryPolicy(stage string) retry.Policy {
+	if p, ok := RetryPolicies[stage]; ok {
+		return p
+	}
+	return DefaultRetryPolicy
+}
+
+//parseRetryPolicy parses the retry policy given as max attempts, initial backoff and max backoff in milliseconds separated by colons
+func parseRetryPolicy(s string) (retry.Policy, bool) {
+	parts := strings.Split(s, ":")
+	if len(parts) != 3 {
+		return retry.Policy{}, false
+	}
+	attempts, err := strconv.Atoi(parts[0])
+	if err != nil {
+		return retry.Policy{}, false
+	}
+	initial, err := strconv.ParseInt(parts[1], 10, 64)
+	if err != nil {
+		return retry.Policy{}, false
+	}
+	max, err := strconv.ParseInt(parts[2], 10, 64)
+	if err != nil {
+		return retry.Policy{}, false
+	}
+	return retry.Policy{
+		MaxAttempts:    attempts,
+		InitialBackoff: time.Duration(initial * int64(time.Millisecond)),
+		MaxBackoff:     time.Duration(max * int64(time.Millisecond)),
+	}, true
+}
+
 //SkipVault will skip the vault initialization if set true
 var SkipVault bool
 
@@ -211,6 +281,115 @@ func init() {
 	if os.Getenv("DO_SCP_FILE_TRANSFER") == "false" {
 		DoSCPFileTransfer = false
 	}
+
+	//max upload size
+	if len(os.Getenv("MAX_UPLOAD_SIZE")) != 0 {
+		//if successful convert max upload size
+		if s, err := strconv.ParseInt(os.Getenv("MAX_UPLOAD_SIZE"), 10, 64); err == nil {
+			MaxUploadSize = s
+		}
+	}
+
+	//archive limits
+	if len(os.Getenv("MAX_DECOMPRESSED_SIZE")) != 0 {
+		//if successful convert max decompressed size
+		if s, err := strconv.ParseInt(os.Getenv("MAX_DECOMPRESSED_SIZE"), 10, 64); err == nil {
+			MaxDecompressedSize = s
+		}
+	}
+	if len(os.Getenv("MAX_COMPRESSION_RATIO")) != 0 {
+		//if successful convert max compression ratio
+		if r, err := strconv.ParseInt(os.Getenv("MAX_COMPRESSION_RATIO"), 10, 64); err == nil {
+			MaxCompressionRatio = r
+		}
+	}
+	if len(os.Getenv("MAX_ARCHIVE_ENTRIES")) != 0 {
+		//if successful convert max archive entries
+		if e, err := strconv.Atoi(os.Getenv("MAX_ARCHIVE_ENTRIES")); err == nil {
+			MaxArchiveEntries = e
+		}
+	}
+
+	//max exploded rows
+	if len(os.Getenv("MAX_EXPLODED_ROWS")) != 0 {
+		//if successful convert max exploded rows
+		if r, err := strconv.Atoi(os.Getenv("MAX_EXPLODED_ROWS")); err == nil {
+			MaxExplodedRows = r
+		}
+	}
+
+	//remote import timeout
+	if len(os.Getenv("REMOTE_IMPORT_TIMEOUT")) != 0 {
+		//if successful convert timeout
+		if t, err := strconv.ParseInt(os.Getenv("REMOTE_IMPORT_TIMEOUT"), 10, 64); err == nil {
+			RemoteImportTimeout = time.Duration(t * int64(time.Millisecond))
+		}
+	}
+	if os.Getenv("REMOTE_IMPORT_ALLOW_PRIVATE") == "true" {
+		RemoteImportAllowPrivate = true
+	}
+
+	//refresh check interval
+	if len(os.Getenv("REFRESH_CHECK_INTERVAL")) != 0 {
+		//if successful convert interval
+		if t, err := strconv.ParseInt(os.Getenv("REFRESH_CHECK_INTERVAL"), 10, 64); err == nil {
+			RefreshCheckInterval = time.Duration(t * int64(time.Millisecond))
+		}
+	}
+
+	//job queue
+	if len(os.Getenv("JOB_WORKERS")) != 0 {
+		//if successful convert job workers
+		if w, err := strconv.Atoi(os.Getenv("JOB_WORKERS")); err == nil {
+			JobWorkers = w
+		}
+	}
+	if len(os.Getenv("JOB_POLL_INTERVAL")) != 0 {
+		//if successful convert interval
+		if t, err := strconv.ParseInt(os.Getenv("JOB_POLL_INTERVAL"), 10, 64); err == nil {
+			JobPollInterval = time.Duration(t * int64(time.Millisecond))
+		}
+	}
+	if len(os.Getenv("JOB_HEARTBEAT_INTERVAL")) != 0 {
+		//if successful convert interval
+		if t, err := strconv.ParseInt(os.Getenv("JOB_HEARTBEAT_INTERVAL"), 10, 64); err == nil {
+			JobHeartbeatInterval = time.Duration(t * int64(time.Millisecond))
+		}
+	}
+	if len(os.Getenv("JOB_MAX_ATTEMPTS")) != 0 {
+		//if successful convert max attempts
+		if m, err := strconv.Atoi(os.Getenv("JOB_MAX_ATTEMPTS")); err == nil {
+			JobMaxAttempts = m
+		}
+	}
+
+	//shutdown timeout
+	if len(os.Getenv("SHUTDOWN_TIMEOUT")) != 0 {
+		//if successful convert timeout
+		if t, err := strconv.ParseInt(os.Getenv("SHUTDOWN_TIMEOUT"), 10, 64); err == nil {
+			ShutdownTimeout = time.Duration(t * int64(time.Millisecond))
+		}
+	}
+
+	//retry policies
+	if len(os.Getenv("DEFAULT_RETRY_POLICY")) != 0 {
+		//if successful parse the default policy
+		if p, ok := parseRetryPolicy(os.Getenv("DEFAULT_RETRY_POLICY")); ok {
+			DefaultRetryPolicy = p
+		}
+	}
+	if len(os.Getenv("RETRY_POLICIES")) != 0 {
+		//the policies are given as stage=policy separated by commas
+		for _, v := range strings.Split(os.Getenv("RETRY_POLICIES"), ",") {
+			kv := strings.SplitN(strings.TrimSpace(v), "=", 2)
+			if len(kv) != 2 {
+				continue
+			}
+			if p, ok := parseRetryPolicy(kv[1]); ok {
+				RetryPolicies[strings.ToUpper(kv[0])] = p
+			}
+		}
+	}
 }
 
 var (
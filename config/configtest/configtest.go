@@ -0,0 +1,26 @@
+// Copyright 2019 Cuttle.ai. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+//Package configtest has the app context shared by the integration tests which need the database
+package configtest
+
+import (
+	"os"
+	"testing"
+
+	authConfig "github.com/cuttle-ai/auth-service/config"
+	"github.com/cuttle-ai/file-uploader-service/config"
+	"github.com/cuttle-ai/file-uploader-service/log"
+)
+
+//AppContext returns the app context for the integration tests with the session of the user owning the records created by the tests.
+//The test is skipped if the database is not enabled
+func AppContext(t *testing.T) *config.AppContext {
+	if os.Getenv(config.EnabledDB) != "true" {
+		t.Skip("database is not enabled")
+	}
+	a := config.NewAppContext(log.NewLogger(0))
+	a.Session = authConfig.Session{Authenticated: true, User: &authConfig.User{}}
+	return a
+}
@@ -122,6 +122,15 @@ func (a *AppContext) ConnectToDB() error {
 	}
 	a.Db.AutoMigrate(&models.FileUpload{})
 	a.Db.AutoMigrate(&models.FileUploadError{})
+	a.Db.AutoMigrate(&models.FileUploadStatusHistory{})
+	a.Db.AutoMigrate(&models.ResumableUpload{})
+	a.Db.AutoMigrate(&models.RefreshSchedule{})
+	a.Db.AutoMigrate(&models.RefreshRun{})
+	a.Db.AutoMigrate(&models.Job{})
+	a.Db.AutoMigrate(&models.JobStage{})
+	a.Db.AutoMigrate(&models.RetryAttempt{})
+	a.Db.AutoMigrate(&models.DatasetPipeline{})
+	a.Db.AutoMigrate(&models.DatasetRule{})
 	a.Db.AutoMigrate(&brainModels.Dataset{})
 	a.Db.AutoMigrate(&brainModels.Node{})
 	a.Db.AutoMigrate(&brainModels.NodeMetadata{})
@@ -25,6 +25,12 @@ var FileUploadServiceID = "Brain-File-Upload-Service"
 //FileUploadServiceRPCID is the file upload service's rpc service id to be used with the discovery service
 var FileUploadServiceRPCID = "Brain-File-Upload-Service-RPC"
 
+//discoveryClient is the client of the discovery service with which the services are registered
+var discoveryClient *api.Client
+
+//rpcListener is the listener of the rpc service
+var rpcListener net.Listener
+
 func init() {
 	/*
 	 * We will communicate with the consul client
@@ -43,6 +49,7 @@ func init() {
 		log.Fatal("Error while initing the discovery service client", err.Error())
 		return
 	}
+	discoveryClient = client
 
 	//service instances for the http service
 	log.Println("Connected with discovery service")
@@ -102,5 +109,27 @@ func StartRPC() {
 	if e != nil {
 		log.Fatal("Error while listening to the rpc port", e.Error())
 	}
+	rpcListener = l
 	go http.Serve(l, nil)
 }
+
+//StopRPC stops the rpc service from accepting the connections
+func StopRPC() error {
+	if rpcListener == nil {
+		return nil
+	}
+	return rpcListener.Close()
+}
+
+//Deregister deregisters the http and rpc services from the discovery service so that no more requests are routed to this instance.
+//The services are registered without an id. So the names are their ids
+func Deregister() error {
+	if discoveryClient == nil {
+		return nil
+	}
+	err := discoveryClient.Agent().ServiceDeregister(FileUploadServiceID)
+	if err != nil {
+		return err
+	}
+	return discoveryClient.Agent().ServiceDeregister(FileUploadServiceRPCID)
+}
@@ -0,0 +1,309 @@
+// Copyright 2019 Cuttle.ai. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+//Package archive has the utilities to expand the compressed and archived uploads (gzip, zip, tar and tar.gz)
+//into the files inside them. The kind of the archive is found from its magic bytes. Expanding is guarded by limits on the decompressed size, compression ratio and no. of entries
+//so that a zip bomb can't exhaust the disk of the server
+package archive
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+//ErrLimitExceeded is returned when the archive exceeds the limits while expanding
+var ErrLimitExceeded = errors.New("archive exceeds the allowed limits")
+
+//Limits are the limits within which an archive has to be expanded
+type Limits struct {
+	//MaxSize is the maximum total size of the decompressed files in bytes
+	MaxSize int64
+	//MaxRatio is the maximum ratio of the decompressed size to the size of the archive
+	MaxRatio int64
+	//MaxEntries is the maximum no. of files in the archive
+	MaxEntries int
+}
+
+//Entry is a file expanded from the archive
+type Entry struct {
+	//Filename is the location to which the file is expanded
+	Filename string
+	//Name is the name of the file inside the archive
+	Name string
+}
+
+//Following are the kinds of archives supported
+const (
+	//kindNone is not an archive
+	kindNone = iota
+	//kindGzip is a gzip compressed file. It can be a compressed tar as well
+	kindGzip
+	//kindZip is a zip archive
+	kindZip
+	//kindTar is a tar archive
+	kindTar
+)
+
+//gzipSignature is the magic bytes with which the gzip files start
+var gzipSignature = []byte{0x1f, 0x8b}
+
+//zipSignature is the magic bytes with which the zip files start
+var zipSignature = []byte("PK\x03\x04")
+
+//tarSignature is the magic bytes found at the tarSignatureOffset in a tar file
+var tarSignature = []byte("ustar")
+
+//tarSignatureOffset is the offset at which the tar signature is found in a tar file
+const tarSignatureOffset = 257
+
+//kind returns the kind of the archive from the first few bytes of the file
+func kind(head []byte) int {
+	switch {
+	case bytes.HasPrefix(head, gzipSignature):
+		return kindGzip
+	case bytes.HasPrefix(head, zipSignature):
+		return kindZip
+	case len(head) >= tarSignatureOffset+len(tarSignature) && bytes.Equal(head[tarSignatureOffset:tarSignatureOffset+len(tarSignature)], tarSignature):
+		return kindTar
+	}
+	return kindNone
+}
+
+//head returns the first few bytes of the reader enough to find the kind of archive
+func head(r io.Reader) ([]byte, error) {
+	h := make([]byte, tarSignatureOffset+len(tarSignature))
+	n, err := io.ReadFull(r, h)
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		return nil, err
+	}
+	return h[:n], nil
+}
+
+//IsArchive returns true if the file is a compressed or archived file as per its content
+func IsArchive(filename string) (bool, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return false, err
+	}
+	defer f.Close()
+	h, err := head(f)
+	if err != nil {
+		return false, err
+	}
+	return kind(h) != kindNone, nil
+}
+
+//Expand will expand the archive into the given directory and returns the files expanded from the same.
+//Directories and hidden files inside the archive are ignored. Nested archives are not expanded
+func Expand(filename string, dir string, limits Limits) ([]Entry, error) {
+	/*
+	 * We will find the budget for the decompressed size
+	 * Then we will create the directory
+	 * Then we will expand the archive
+	 */
+	//finding the budget
+	//the budget is the least of the max size and the max ratio of the archive size
+	info, err := os.Stat(filename)
+	if err != nil {
+		return nil, err
+	}
+	budget := limits.MaxSize
+	if limits.MaxRatio > 0 && (budget <= 0 || info.Size()*limits.MaxRatio < budget) {
+		budget = info.Size() * limits.MaxRatio
+	}
+	e := &expander{dir: dir, budget: budget, limits: limits}
+
+	//creating the directory
+	err = os.MkdirAll(dir, 0755)
+	if err != nil {
+		return nil, err
+	}
+
+	//expanding the archive
+	err = e.expand(filename)
+	if err != nil {
+		os.RemoveAll(dir)
+		return nil, err
+	}
+	if len(e.entries) == 0 {
+		os.RemoveAll(dir)
+		return nil, errors.New("couldn't find any files in the archive")
+	}
+	return e.entries, nil
+}
+
+//expander expands the files from an archive keeping track of the limits
+type expander struct {
+	dir     string
+	budget  int64
+	written int64
+	limits  Limits
+	entries []Entry
+}
+
+//skip returns true if the entry with the given name has to be ignored.
+//The relative parts of the path aren't hidden files. They are cleaned while writing the entry
+func skip(name string) bool {
+	for _, p := range strings.Split(name, "/") {
+		if p == "." || p == ".." {
+			continue
+		}
+		if strings.HasPrefix(p, ".") || p == "__MACOSX" {
+			return true
+		}
+	}
+	return false
+}
+
+//write writes the content of an entry with the given name to the directory
+func (e *expander) write(name string, r io.Reader) error {
+	/*
+	 * We will check the no. of entries
+	 * Then we will create the file with a name that can't escape the directory
+	 * Then we will copy the content within the budget
+	 */
+	//checking the no. of entries
+	if e.limits.MaxEntries > 0 && len(e.entries) >= e.limits.MaxEntries {
+		return fmt.Errorf("%w: more than %d files", ErrLimitExceeded, e.limits.MaxEntries)
+	}
+
+	//creating the file
+	name = strings.TrimPrefix(path.Clean("/"+name), "/")
+	filename := filepath.Join(e.dir, fmt.Sprintf("%d_%s", len(e.entries)+1, path.Base(name)))
+	f, err := os.Create(filename)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	//copying the content
+	if e.budget > 0 {
+		r = io.LimitReader(r, e.budget-e.written+1)
+	}
+	n, err := io.Copy(f, r)
+	e.written += n
+	if err != nil {
+		return err
+	}
+	if e.budget > 0 && e.written > e.budget {
+		return fmt.Errorf("%w: decompressed size is more than %d bytes", ErrLimitExceeded, e.budget)
+	}
+	e.entries = append(e.entries, Entry{Filename: filename, Name: name})
+	return nil
+}
+
+//expand expands the archive as per its kind. A gzip compressed file can either be a compressed tar or a single compressed file
+func (e *expander) expand(filename string) error {
+	/*
+	 * We will open the file and find its kind
+	 * Zip archives are expanded as such
+	 * Gzip compressed files are decompressed to find whether it is a tar
+	 * Then we will expand the tar or the decompressed file
+	 */
+	//opening the file
+	f, err := os.Open(filename)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	h, err := head(f)
+	if err != nil {
+		return err
+	}
+	k := kind(h)
+
+	//expanding the zip archive
+	if k == kindZip {
+		return e.zip(filename)
+	}
+	if k == kindNone {
+		return fmt.Errorf("%s is not an archive", filepath.Base(filename))
+	}
+
+	//decompressing the gzip file
+	_, err = f.Seek(0, io.SeekStart)
+	if err != nil {
+		return err
+	}
+	var r io.Reader = f
+	name := filepath.Base(filename)
+	if k == kindGzip {
+		gr, err := gzip.NewReader(f)
+		if err != nil {
+			return err
+		}
+		defer gr.Close()
+		//the head of a small compressed file can be shorter than the one needed to find the kind of the decompressed file
+		br := bufio.NewReaderSize(gr, tarSignatureOffset+len(tarSignature))
+		dH, _ := br.Peek(tarSignatureOffset + len(tarSignature))
+		k = kind(dH)
+		r = br
+		//the name of the compressed file is the name of the file without the compression extension
+		if len(gr.Name) != 0 {
+			name = filepath.Base(gr.Name)
+		} else if ext := filepath.Ext(name); strings.EqualFold(ext, ".gz") || strings.EqualFold(ext, ".gzip") {
+			name = strings.TrimSuffix(name, ext)
+		}
+	}
+
+	//expanding the tar or the decompressed file
+	if k == kindTar {
+		return e.tar(r)
+	}
+	return e.write(name, r)
+}
+
+//tar expands a tar archive
+func (e *expander) tar(r io.Reader) error {
+	tr := tar.NewReader(r)
+	for {
+		h, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if h.Typeflag != tar.TypeReg || skip(h.Name) {
+			continue
+		}
+		if err := e.write(h.Name, tr); err != nil {
+			return err
+		}
+	}
+}
+
+//zip expands a zip archive
+func (e *expander) zip(filename string) error {
+	zr, err := zip.OpenReader(filename)
+	if err != nil {
+		return err
+	}
+	defer zr.Close()
+	for _, v := range zr.File {
+		if v.FileInfo().IsDir() || skip(v.Name) {
+			continue
+		}
+		rc, err := v.Open()
+		if err != nil {
+			return err
+		}
+		err = e.write(v.Name, rc)
+		rc.Close()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,142 @@
+// Copyright 2019 Cuttle.ai. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package archive_test
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/cuttle-ai/file-uploader-service/file/archive"
+)
+
+/*
+ * This file contains the tests for expanding the archives within the limits
+ */
+
+//entry is a file to be written to the archives of the tests
+type entry struct {
+	name    string
+	content string
+}
+
+//writeZip writes the entries to a zip archive
+func writeZip(w io.Writer, entries []entry) error {
+	zw := zip.NewWriter(w)
+	for _, v := range entries {
+		f, err := zw.Create(v.name)
+		if err != nil {
+			return err
+		}
+		if _, err := io.WriteString(f, v.content); err != nil {
+			return err
+		}
+	}
+	return zw.Close()
+}
+
+//writeTarGz writes the entries to a gzip compressed tar archive
+func writeTarGz(w io.Writer, entries []entry) error {
+	gw := gzip.NewWriter(w)
+	tw := tar.NewWriter(gw)
+	for _, v := range entries {
+		if err := tw.WriteHeader(&tar.Header{Name: v.name, Mode: 0644, Size: int64(len(v.content)), Typeflag: tar.TypeReg}); err != nil {
+			return err
+		}
+		if _, err := io.WriteString(tw, v.content); err != nil {
+			return err
+		}
+	}
+	if err := tw.Close(); err != nil {
+		return err
+	}
+	return gw.Close()
+}
+
+//writeGz writes the content of the only entry to a gzip compressed file
+func writeGz(w io.Writer, entries []entry) error {
+	gw := gzip.NewWriter(w)
+	if _, err := io.WriteString(gw, entries[0].content); err != nil {
+		return err
+	}
+	return gw.Close()
+}
+
+func TestExpand(t *testing.T) {
+	dir, err := ioutil.TempDir("", "archive")
+	if err != nil {
+		t.Fatal("couldn't create the temp dir", err)
+	}
+	defer os.RemoveAll(dir)
+
+	limits := archive.Limits{MaxSize: 1 << 20, MaxRatio: 100, MaxEntries: 3}
+	csv := entry{"sales.csv", "a,b\n1,2\n"}
+	cases := []struct {
+		name     string
+		filename string
+		write    func(io.Writer, []entry) error
+		entries  []entry
+		limits   archive.Limits
+		names    []string
+		exceeded bool
+		fail     bool
+	}{
+		{"zip", "files.zip", writeZip, []entry{csv, {"data/items.csv", "c\n3\n"}}, limits, []string{"sales.csv", "data/items.csv"}, false, false},
+		{"tar.gz", "files.tar.gz", writeTarGz, []entry{csv, {"data/items.csv", "c\n3\n"}}, limits, []string{"sales.csv", "data/items.csv"}, false, false},
+		{"gzip", "sales.csv.gz", writeGz, []entry{csv}, limits, []string{"sales.csv"}, false, false},
+		{"hidden and __MACOSX entries", "hidden.zip", writeZip, []entry{csv, {".DS_Store", "x"}, {"__MACOSX/._sales.csv", "x"}, {"data/.hidden.csv", "x"}}, limits, []string{"sales.csv"}, false, false},
+		{"only hidden entries", "only-hidden.zip", writeZip, []entry{{".DS_Store", "x"}, {"__MACOSX/._sales.csv", "x"}}, limits, nil, false, true},
+		{"entry escaping the directory in a zip", "escape.zip", writeZip, []entry{{"../../escaped.csv", "a\n1\n"}}, limits, []string{"escaped.csv"}, false, false},
+		{"entry escaping the directory in a tar", "escape.tar.gz", writeTarGz, []entry{{"../escaped.csv", "a\n1\n"}}, limits, []string{"escaped.csv"}, false, false},
+		{"too many entries", "many.zip", writeZip, []entry{csv, csv, csv, csv}, limits, nil, true, false},
+		{"high compression ratio", "bomb.zip", writeZip, []entry{{"bomb.csv", strings.Repeat("0", 1<<19)}}, limits, nil, true, false},
+		{"decompressed size exceeded", "large.tar.gz", writeTarGz, []entry{csv, {"large.csv", strings.Repeat("0", 1<<10)}}, archive.Limits{MaxSize: 512}, nil, true, false},
+		{"no limits", "bomb.tar.gz", writeTarGz, []entry{{"bomb.csv", strings.Repeat("0", 1<<19)}}, archive.Limits{}, []string{"bomb.csv"}, false, false},
+	}
+	for i, c := range cases {
+		filename := filepath.Join(dir, c.filename)
+		f, err := os.Create(filename)
+		if err != nil {
+			t.Fatal("couldn't create the archive", err)
+		}
+		err = c.write(f, c.entries)
+		f.Close()
+		if err != nil {
+			t.Fatal("couldn't write the archive", err)
+		}
+
+		out := filepath.Join(dir, "expanded", c.filename)
+		entries, err := archive.Expand(filename, out, c.limits)
+		if errors.Is(err, archive.ErrLimitExceeded) != c.exceeded || (err != nil && !c.exceeded) != c.fail {
+			t.Error("test case", i+1, c.name, "expected limit exceeded as", c.exceeded, "and failure as", c.fail, "got", err)
+			continue
+		}
+		if err != nil {
+			//the partially expanded files are removed
+			if _, sErr := os.Stat(out); !os.IsNotExist(sErr) {
+				t.Error("test case", i+1, c.name, "expected the expanded files to be removed. got", sErr)
+			}
+			continue
+		}
+		names := []string{}
+		for _, e := range entries {
+			names = append(names, e.Name)
+			if rel, err := filepath.Rel(out, e.Filename); err != nil || strings.HasPrefix(rel, "..") {
+				t.Error("test case", i+1, c.name, "expected the file to be expanded in", out, "got", e.Filename)
+			}
+		}
+		if !reflect.DeepEqual(names, c.names) {
+			t.Error("test case", i+1, c.name, "expected the entries", c.names, "got", names)
+		}
+	}
+}
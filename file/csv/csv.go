@@ -6,8 +6,10 @@
 package csv
 
 import (
+	"context"
 	"encoding/csv"
 	"errors"
+	"fmt"
 	"io"
 	"os"
 	"strconv"
@@ -18,6 +20,7 @@ import (
 	brainModels "github.com/cuttle-ai/brain/models"
 	"github.com/cuttle-ai/db-toolkit/datastores/services"
 	"github.com/cuttle-ai/file-uploader-service/config"
+	"github.com/cuttle-ai/file-uploader-service/file"
 	"github.com/cuttle-ai/file-uploader-service/models"
 	"github.com/cuttle-ai/file-uploader-service/models/db"
 	"github.com/cuttle-ai/octopus/interpreter"
@@ -30,10 +33,62 @@ type CSV struct {
 	Filename string
 	//Name is the dataset name
 	Name string
+	//Options has the dialect with which the file has to be parsed
+	Options models.FileUploadOptions
 	//Resource holds the db instance of the underlying file
 	Resource db.FileUpload
 	//Table is the underlying octopus table node
 	Table *interpreter.TableNode
+	//Encoding is the character encoding in which the file was uploaded
+	Encoding string
+	//rows is the no. of data rows read from the file
+	rows int64
+	//written is the no. of bytes dumped to the datastore by the last upload
+	written int64
+	//skipped is the no. of rows left out by the last normalization
+	skipped int64
+	//coerced is the no. of rows coerced by the last normalization as per the error policy
+	coerced int64
+	//rejected are the rows found invalid after the validation with the columns having the errors. They are left out or coerced while normalizing
+	rejected map[int64][]int
+}
+
+func init() {
+	file.Register(file.Format{
+		Type:       models.FileUploadTypeCSV,
+		Extensions: []string{".csv", ".tsv", ".psv", ".txt"},
+		MIMETypes:  []string{"text/csv", "application/csv", "text/tab-separated-values", "text/plain"},
+		Text:       true,
+		New:        New,
+		Get:        Get,
+	})
+}
+
+//New returns the csv file to be stored for the uploaded file. The file is transcoded to utf-8 from its encoding.
+//If no dialect is given, it will be sniffed from the file
+func New(filename string, uploadname string, options models.FileUploadOptions) ([]file.File, error) {
+	encoding, err := file.Transcode(filename, options.Charset)
+	if err != nil {
+		return nil, err
+	}
+	if !HasDialect(options) {
+		sniffed, err := Sniff(filename)
+		if err != nil {
+			return nil, err
+		}
+		options.Delimiter = sniffed.Delimiter
+		options.Quote = sniffed.Quote
+		options.Headerless = sniffed.Headerless
+	}
+	if err := CheckDialect(options); err != nil {
+		return nil, err
+	}
+	return []file.File{&CSV{Filename: filename, Name: uploadname, Options: options, Encoding: encoding}}, nil
+}
+
+//Get returns the csv file for the file upload stored in db
+func Get(fileModel db.FileUpload) (file.File, error) {
+	return &CSV{Filename: fileModel.Location, Options: fileModel.Options, Resource: fileModel, Encoding: fileModel.Encoding}, nil
 }
 
 //ID returns the underlying file's id in db
@@ -41,89 +96,345 @@ func (c CSV) ID() uint {
 	return c.Resource.ID
 }
 
+//Rows returns the no. of data rows read from the file while normalizing it or identifying its columns
+func (c CSV) Rows() int64 {
+	return c.rows
+}
+
+//BytesWritten returns the no. of bytes dumped to the datastore by the last upload
+func (c CSV) BytesWritten() int64 {
+	return c.written
+}
+
+//Tolerated returns the no. of rows left out and coerced while normalizing the file as per the error policy
+func (c CSV) Tolerated() (int64, int64, bool) {
+	return c.skipped, c.coerced, true
+}
+
 //Store stores the csv info to database
 func (c *CSV) Store(a *config.AppContext) (*brainModels.Dataset, error) {
 	/*
-	 * We will db transaction we have to save the file upload and the dataset info
-	 * Then we will create the file upload
-	 * Then we will create the dataset with resource id as the of the file
-	 * Then we will create the dataset user mappings
+	 * We will store the file upload record along with its dataset
 	 */
-	//starting the transaction
-	tx := a.Db.Begin()
-	defer func() {
-		if r := recover(); r != nil {
-			tx.Rollback()
+	fileRecord := &models.FileUpload{Name: c.Name, UserID: a.Session.User.ID, Location: c.Filename, Status: models.FileUploadStatusUploaded, Type: models.FileUploadTypeCSV, Options: c.Options, Encoding: c.Encoding}
+	return db.StoreFileUpload(a, fileRecord)
+}
+
+//normalizedFilename returns the location of the standard csv file to which the files of other dialects are normalized
+func (c CSV) normalizedFilename() string {
+	return c.Filename + ".normalized.csv"
+}
+
+//normalized returns true if the file has to be normalized before identifying the columns and uploading.
+//Files of other dialects and the files whose rows with errors are left out or coerced as per the error policy are normalized
+func (c CSV) normalized() bool {
+	return !IsDefaultDialect(c.Options) || file.ErrorPolicy(c.Options) != models.FileUploadErrorPolicyStrict
+}
+
+//source returns the location of the standard csv file to be used for identifying the columns and uploading.
+//If the file has to be normalized and is not normalized yet, it will be normalized
+func (c *CSV) source(ctx context.Context) (string, error) {
+	if !c.normalized() {
+		return c.Filename, nil
+	}
+	if _, err := os.Stat(c.normalizedFilename()); os.IsNotExist(err) {
+		_, err = c.normalize(ctx)
+		if err != nil {
+			return "", err
 		}
-	}()
-	if err := tx.Error; err != nil {
-		//error while beginning the transaction
-		return nil, err
 	}
+	return c.normalizedFilename(), nil
+}
 
-	//saving the file upload
-	fileRecord := &models.FileUpload{Name: c.Name, UserID: a.Session.User.ID, Location: c.Filename, Status: models.FileUploadStatusUploaded, Type: models.FileUploadTypeCSV}
-	if err := tx.Create(fileRecord).Error; err != nil {
-		//error while creating the upload
-		tx.Rollback()
-		a.Log.Error("error while creating the file upload record")
+//normalize will parse the file as per its dialect and write it as a standard csv file with a header row.
+//If the file doesn't have a header row, column names column_1, column_2 etc are used.
+//The rows which couldn't be parsed are left out. The rows with more or less values than the header are left out
+//or coerced to the no. of columns in the header as per the error policy.
+//It returns the errors existing while parsing the file. The partially written file is removed if the normalization fails
+func (c *CSV) normalize(ctx context.Context) (result []error, err error) {
+	/*
+	 * We will open the file and create the normalized file
+	 * Then we will read the records and write them to the normalized file
+	 * return the errors if any
+	 */
+	//opening the file and creating the normalized file
+	f, err := os.Open(c.Filename)
+	if err != nil {
 		return nil, err
 	}
-
-	//saving the dataset record
-	dataset := &brainModels.Dataset{Name: c.Name, UserID: fileRecord.UserID, ResourceID: fileRecord.ID, Source: brainModels.DatasetSourceFile}
-	if err := tx.Create(dataset).Error; err != nil {
-		//error while creating the dataset
-		tx.Rollback()
-		a.Log.Error("error while creating the datset record")
+	defer f.Close()
+	r, err := newReader(file.ContextReader{Ctx: ctx, R: f}, c.Options)
+	if err != nil {
 		return nil, err
 	}
-	dataset.UploadedDataset = fileRecord
+	out, err := os.Create(c.normalizedFilename())
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		out.Close()
+		if err != nil {
+			os.Remove(c.normalizedFilename())
+		}
+	}()
+	w := csv.NewWriter(out)
 
-	//creating the dataset user mappings
-	datasetMapping := &brainModels.DatsetUserMapping{DatasetID: dataset.ID, UserID: fileRecord.UserID, AccessType: brainModels.DatasetAccessTypeCreator}
-	if err := tx.Create(datasetMapping).Error; err != nil {
-		//error while creating the dataset user mapping
-		tx.Rollback()
-		a.Log.Error("error while creating the datset user mapping")
+	//writing the records
+	errorResults := []error{}
+	_, quote, _ := dialect(c.Options)
+	policy := file.ErrorPolicy(c.Options)
+	fields := 0
+	records := int64(0)
+	c.skipped = 0
+	c.coerced = 0
+	var header []string
+	for {
+		record, err := r.Read()
+		if err == io.EOF {
+			break
+		}
+		pErr, isParseError := err.(*csv.ParseError)
+		if err != nil && !isParseError {
+			return nil, err
+		}
+		row := records
+		if c.Options.Headerless {
+			row++
+		}
+		if isParseError {
+			//line numbers has to be in the original file
+			pErr.StartLine += c.Options.SkipLines
+			pErr.Line += c.Options.SkipLines
+		}
+		if isParseError && pErr.Err != csv.ErrFieldCount {
+			//the records which couldn't be parsed are left out
+			vErr := file.RowError(models.FileUploadErrorCodeParse, row, "%s", pErr.Error())
+			vErr.Line = int64(pErr.Line)
+			errorResults = append(errorResults, vErr)
+			c.skipped++
+			if fields != 0 {
+				records++
+			}
+			continue
+		}
+		records++
+		if isParseError {
+			vErr := fieldCountError(row, int64(pErr.Line), record, header)
+			if policy == models.FileUploadErrorPolicySkip {
+				errorResults = append(errorResults, vErr)
+				c.skipped++
+				continue
+			}
+			if policy == models.FileUploadErrorPolicyCoerce {
+				//the missing values are set to null and the values beyond the header are dropped
+				vErr.Severity = models.FileUploadErrorSeverityWarning
+				record = coerce(record, fields)
+				c.coerced++
+			}
+			errorResults = append(errorResults, vErr)
+		}
+		//the rows rejected after the validation like the ones violating the rules
+		if columns, ok := c.rejected[row]; ok {
+			if policy == models.FileUploadErrorPolicySkip {
+				c.skipped++
+				continue
+			}
+			if !isParseError {
+				//the rows with a wrong no. of values are already counted
+				c.coerced++
+			}
+			record = file.BlankColumns(record, columns)
+		}
+		record = swapQuotes(record, quote)
+		if fields == 0 {
+			fields = len(record)
+			header = record
+			if c.Options.Headerless {
+				header = make([]string, fields)
+				for i := range header {
+					header[i] = fmt.Sprintf("column_%d", i+1)
+				}
+				if err := w.Write(header); err != nil {
+					return nil, err
+				}
+			}
+		}
+		if err := w.Write(record); err != nil {
+			return nil, err
+		}
+	}
+	if fields == 0 {
+		return nil, errors.New("couldn't find any records in the file")
+	}
+	c.rows = records
+	if !c.Options.Headerless {
+		c.rows--
+	}
+	w.Flush()
+	if err := w.Error(); err != nil {
 		return nil, err
 	}
+	return errorResults, nil
+}
 
-	//returning the result
-	return dataset, tx.Commit().Error
+//Reject normalizes the file again leaving out the rows of the errors or setting their values with the errors to null as per the error policy.
+//The rows can't be rejected if the error policy is strict
+func (c *CSV) Reject(ctx context.Context, errs []error) (bool, error) {
+	policy := file.ErrorPolicy(c.Options)
+	if policy == models.FileUploadErrorPolicyStrict {
+		return false, errors.New("rows of the file can't be left out or coerced as the error policy is strict")
+	}
+	c.rejected = file.RejectedRows(errs)
+	_, err := c.normalize(ctx)
+	return policy == models.FileUploadErrorPolicyCoerce, err
 }
 
-//Validate will validate the csv file and returns the errors existing while parsing the csv file
-func (c *CSV) Validate() ([]error, error) {
+//Validate will validate the csv file and returns the errors existing while parsing the csv file.
+//Files of dialects other than the standard csv and the files with an error policy other than strict are normalized while validating
+func (c *CSV) Validate(ctx context.Context) ([]error, error) {
 	/*
+	 * If the file is not of the standard dialect or the error policy is not strict, we will normalize it
 	 * We will open the file
 	 * Then we will validate the same
 	 * return the errors if any
 	 */
+	//normalizing the file of other dialects or error policies
+	if c.normalized() {
+		errs, err := c.normalize(ctx)
+		if err != nil {
+			c.Resource.Status = models.FileUploadStatusInvalid
+			return nil, err
+		}
+		c.Resource.Status = models.FileUploadStatusValidated
+		if len(errs) == 0 {
+			return nil, nil
+		}
+		return errs, nil
+	}
+
 	f, err := os.Open(c.Filename)
 	if err != nil {
-		c.Resource.Status = models.FileUploadStatusValidatingError
+		c.Resource.Status = models.FileUploadStatusInvalid
 		return nil, err
 	}
 	defer f.Close()
-	invalids, _, err := csvlint.Validate(f, rune(','), true)
+	invalids, _, err := csvlint.Validate(file.ContextReader{Ctx: ctx, R: f}, rune(','), true)
 	if err != nil {
-		c.Resource.Status = models.FileUploadStatusValidatingError
+		c.Resource.Status = models.FileUploadStatusInvalid
 		return nil, err
 	}
 	c.Resource.Status = models.FileUploadStatusValidated
 	if len(invalids) == 0 {
 		return nil, nil
 	}
+	header, _ := readHeader(c.Filename)
 	errorResults := []error{}
 	for _, v := range invalids {
-		errorResults = append(errorResults, v)
+		if v.Record == nil {
+			errorResults = append(errorResults, file.RowError(models.FileUploadErrorCodeParse, int64(v.Num), "%s", v.Error()))
+			continue
+		}
+		errorResults = append(errorResults, fieldCountError(int64(v.Num), 0, v.Record, header))
 	}
 	return errorResults, nil
 }
 
+//ReadRows reads the rows of the file as per its dialect. The rows which couldn't be parsed are skipped
+func (c CSV) ReadRows(ctx context.Context, fn func(row int64, record []string) error) error {
+	f, err := os.Open(c.Filename)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	r, err := newReader(file.ContextReader{Ctx: ctx, R: f}, c.Options)
+	if err != nil {
+		return err
+	}
+	r.FieldsPerRecord = -1
+	_, quote, _ := dialect(c.Options)
+	row := int64(0)
+	if c.Options.Headerless {
+		//the header is generated while normalizing
+		row++
+	}
+	read := false
+	for {
+		record, err := r.Read()
+		if err == io.EOF {
+			return nil
+		}
+		if _, ok := err.(*csv.ParseError); ok {
+			//the rows which couldn't be parsed are numbered once the header is read as in the normalization
+			if read {
+				row++
+			}
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		record = swapQuotes(record, quote)
+		if row == 1 && c.Options.Headerless {
+			header := make([]string, len(record))
+			for i := range header {
+				header[i] = fmt.Sprintf("column_%d", i+1)
+			}
+			if err := fn(0, header); err != nil {
+				return err
+			}
+		}
+		if err := fn(row, record); err != nil {
+			return err
+		}
+		read = true
+		row++
+	}
+}
+
+//readHeader returns the header row of the standard csv file
+func readHeader(filename string) ([]string, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	r := csv.NewReader(f)
+	r.LazyQuotes = true
+	return r.Read()
+}
+
+//coerce returns the record with the given no. of values. The missing values are left empty and the values beyond are dropped
+func coerce(record []string, fields int) []string {
+	result := make([]string, fields)
+	copy(result, record)
+	return result
+}
+
+//fieldCountError returns the validation error of a row whose no. of values doesn't match the no. of columns in the header.
+//The column is the first value beyond the header or the first missing value
+func fieldCountError(row int64, line int64, record []string, header []string) file.ValidationError {
+	if header == nil {
+		//the header couldn't be read
+		v := file.RowError(models.FileUploadErrorCodeFieldCount, row, "row %d has %d values which doesn't match the columns in the header", row, len(record))
+		v.Line = line
+		v.Value = strings.Join(record, ",")
+		return v
+	}
+	v := file.RowError(models.FileUploadErrorCodeFieldCount, row, "row %d has %d values while the header has %d columns", row, len(record), len(header))
+	v.Line = line
+	v.Value = strings.Join(record, ",")
+	if len(record) > len(header) {
+		v.Column = len(header) + 1
+		v.Value = record[len(header)]
+		return v
+	}
+	v.Column = len(record) + 1
+	v.ColumnName = header[len(record)]
+	return v
+}
+
 //IdentifyColumns will identify the columns in the file and store them in the database
-func (c *CSV) IdentifyColumns(columns []interpreter.ColumnNode) ([]interpreter.ColumnNode, error) {
+func (c *CSV) IdentifyColumns(ctx context.Context, columns []interpreter.ColumnNode) ([]interpreter.ColumnNode, error) {
 	/*
 	 * We will open the file
 	 * Will read the column names
@@ -132,14 +443,19 @@ func (c *CSV) IdentifyColumns(columns []interpreter.ColumnNode) ([]interpreter.C
 	 */
 
 	//opening the file
-	f, err := os.Open(c.Filename)
+	filename, err := c.source(ctx)
+	if err != nil {
+		return nil, err
+	}
+	f, err := os.Open(filename)
 	if err != nil {
 		//error while opening the file
 		return nil, err
 	}
+	defer f.Close()
 
 	//reading the column names in the file
-	r := csv.NewReader(f)
+	r := csv.NewReader(file.ContextReader{Ctx: ctx, R: f})
 	cols, err := r.Read()
 	//even if the error was EOF or aything else, we will report it as error since
 	//we couldn't read the cols
@@ -168,6 +484,7 @@ func (c *CSV) IdentifyColumns(columns []interpreter.ColumnNode) ([]interpreter.C
 	//this is a classical bruteforce approach of going through the entire dataset
 	//and making sure the data type is accurate
 	//have to improve the below piece of code
+	c.rows = 0
 	for {
 		// Read each record from csv
 		record, err := r.Read()
@@ -177,6 +494,7 @@ func (c *CSV) IdentifyColumns(columns []interpreter.ColumnNode) ([]interpreter.C
 		if err != nil {
 			return nil, err
 		}
+		c.rows++
 		for i, v := range record {
 			columns[i].DataType, columns[i].DateFormat = predictColumn(v, columns[i].DataType)
 			if columns[i].DataType == interpreter.DataTypeInt || columns[i].DataType == interpreter.DataTypeFloat {
@@ -272,7 +590,7 @@ func checkForDates(value string) (string, bool) {
 }
 
 //Upload will attempt to upload the file to the analytics engine and report any error occurred
-func (c *CSV) Upload(a *config.AppContext, table interpreter.TableNode, appendData bool, createTable bool, dataStore services.Service) error {
+func (c *CSV) Upload(ctx context.Context, a *config.AppContext, table interpreter.TableNode, appendData bool, createTable bool, dataStore services.Service) error {
 	/*
 	 * We will first get the underlyign datastore
 	 * Then we will read the file and order the columns in that file
@@ -288,11 +606,16 @@ func (c *CSV) Upload(a *config.AppContext, table interpreter.TableNode, appendDa
 
 	//reading the file and figuring out the order
 	//opening the file
-	f, err := os.Open(c.Filename)
+	filename, err := c.source(ctx)
+	if err != nil {
+		return err
+	}
+	f, err := os.Open(filename)
 	if err != nil {
 		//error while opening the file
 		return err
 	}
+	defer f.Close()
 
 	//reading the column names in the file
 	r := csv.NewReader(f)
@@ -316,21 +639,27 @@ func (c *CSV) Upload(a *config.AppContext, table interpreter.TableNode, appendDa
 	}
 
 	//we start uploading the data
-	err = dS.DumpCSV(c.Filename, table.Name, sortedCols, appendData, createTable, config.DoSCPFileTransfer, a.Log)
+	//the dump can't be interrupted. So we check for the cancellation before starting it
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	c.written = 0
+	err = dS.DumpCSV(filename, table.Name, sortedCols, appendData, createTable, config.DoSCPFileTransfer, a.Log)
 	if err != nil {
 		//error while dumping the csv to the datastore
 		a.Log.Error("error while dumping the csv to the datastore")
 		return err
 	}
+	if info, err := f.Stat(); err == nil {
+		c.written = info.Size()
+	}
 	return nil
 }
 
 //UpdateStatus updates the status of the file upload in db
 func (c *CSV) UpdateStatus(a *config.AppContext) error {
 	/*
-	 * We will update the status
+	 * We will move the file upload to the status set by the validation
 	 */
-	return a.Db.Model(&c.Resource).Updates(map[string]interface{}{
-		"status": c.Resource.Status,
-	}).Error
+	return c.Resource.UpdateStatus(a, c.Resource.Status, "")
 }
@@ -0,0 +1,277 @@
+// Copyright 2019 Cuttle.ai. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package csv_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"unicode/utf16"
+
+	"github.com/cuttle-ai/file-uploader-service/file"
+	"github.com/cuttle-ai/file-uploader-service/file/csv"
+	"github.com/cuttle-ai/file-uploader-service/models"
+)
+
+/*
+ * This file contains the tests for the validation errors, the error policies, the rows rejected after the validation,
+ * the rows read from the csv files and their encodings
+ */
+
+func TestValidateErrors(t *testing.T) {
+	dir, err := ioutil.TempDir("", "csv")
+	if err != nil {
+		t.Fatal("couldn't create the temp dir", err)
+	}
+	defer os.RemoveAll(dir)
+
+	cases := []struct {
+		name    string
+		content string
+		options models.FileUploadOptions
+		err     file.ValidationError
+	}{
+		{"extra value", "a,b\n1,2\n3,4,5\n", models.FileUploadOptions{}, file.ValidationError{Row: 2, Column: 3, Code: models.FileUploadErrorCodeFieldCount, Value: "5"}},
+		{"missing value", "a,b\n1,2\n3\n", models.FileUploadOptions{}, file.ValidationError{Row: 2, Column: 2, ColumnName: "b", Code: models.FileUploadErrorCodeFieldCount, Value: "3"}},
+		{"other dialect", "a;b\n1;2\n3;4;5\n", models.FileUploadOptions{Delimiter: ";"}, file.ValidationError{Row: 2, Line: 3, Column: 3, Code: models.FileUploadErrorCodeFieldCount, Value: "5"}},
+		{"headerless", "1;2\n3\n", models.FileUploadOptions{Delimiter: ";", Headerless: true}, file.ValidationError{Row: 2, Line: 2, Column: 2, ColumnName: "column_2", Code: models.FileUploadErrorCodeFieldCount, Value: "3"}},
+	}
+	for i, c := range cases {
+		filename := filepath.Join(dir, c.name+".csv")
+		if err := ioutil.WriteFile(filename, []byte(c.content), 0644); err != nil {
+			t.Fatal("couldn't write the file", err)
+		}
+		f := &csv.CSV{Filename: filename, Options: c.options}
+		errs, err := f.Validate(context.Background())
+		if err != nil {
+			t.Error("test case", i+1, c.name, "expected no error. got", err)
+			continue
+		}
+		if len(errs) != 1 {
+			t.Error("test case", i+1, c.name, "expected 1 validation error. got", errs)
+			continue
+		}
+		var v file.ValidationError
+		if !errors.As(errs[0], &v) {
+			t.Error("test case", i+1, c.name, "expected a validation error. got", errs[0])
+			continue
+		}
+		v.Message = ""
+		v.Severity = ""
+		if v != c.err {
+			t.Errorf("test case %d %s expected the error %+v. got %+v", i+1, c.name, c.err, v)
+		}
+	}
+}
+
+func TestReadRows(t *testing.T) {
+	dir, err := ioutil.TempDir("", "csv")
+	if err != nil {
+		t.Fatal("couldn't create the temp dir", err)
+	}
+	defer os.RemoveAll(dir)
+
+	cases := []struct {
+		name    string
+		content string
+		options models.FileUploadOptions
+		rows    map[int64][]string
+	}{
+		{"header", "a,b\n1,2\n3,4,5\n", models.FileUploadOptions{}, map[int64][]string{0: {"a", "b"}, 1: {"1", "2"}, 2: {"3", "4", "5"}}},
+		{"headerless", "1;2\n3\n", models.FileUploadOptions{Delimiter: ";", Headerless: true}, map[int64][]string{0: {"column_1", "column_2"}, 1: {"1", "2"}, 2: {"3"}}},
+	}
+	for i, c := range cases {
+		filename := filepath.Join(dir, c.name+".csv")
+		if err := ioutil.WriteFile(filename, []byte(c.content), 0644); err != nil {
+			t.Fatal("couldn't write the file", err)
+		}
+		f := &csv.CSV{Filename: filename, Options: c.options}
+		rows := map[int64][]string{}
+		err := f.ReadRows(context.Background(), func(row int64, record []string) error {
+			rows[row] = append([]string{}, record...)
+			return nil
+		})
+		if err != nil {
+			t.Error("test case", i+1, c.name, "expected no error. got", err)
+			continue
+		}
+		if fmt.Sprint(rows) != fmt.Sprint(c.rows) {
+			t.Error("test case", i+1, c.name, "expected the rows", c.rows, "got", rows)
+		}
+	}
+}
+
+func TestErrorPolicy(t *testing.T) {
+	dir, err := ioutil.TempDir("", "csv")
+	if err != nil {
+		t.Fatal("couldn't create the temp dir", err)
+	}
+	defer os.RemoveAll(dir)
+
+	content := "a,b\n1,2\n3,4,5\n6\n7,8\n"
+	cases := []struct {
+		name      string
+		options   models.FileUploadOptions
+		skipped   int64
+		coerced   int64
+		tolerated bool
+	}{
+		{"strict", models.FileUploadOptions{}, 0, 0, false},
+		{"skip", models.FileUploadOptions{ErrorPolicy: models.FileUploadErrorPolicySkip}, 2, 0, true},
+		{"coerce", models.FileUploadOptions{ErrorPolicy: models.FileUploadErrorPolicyCoerce}, 0, 2, true},
+		{"max errors", models.FileUploadOptions{ErrorPolicy: models.FileUploadErrorPolicySkip, MaxErrors: 1}, 0, 0, false},
+		{"max error percent", models.FileUploadOptions{ErrorPolicy: models.FileUploadErrorPolicyCoerce, MaxErrorPercent: 50}, 0, 2, true},
+		{"max error percent exceeded", models.FileUploadOptions{ErrorPolicy: models.FileUploadErrorPolicyCoerce, MaxErrorPercent: 25}, 0, 0, false},
+	}
+	for i, c := range cases {
+		filename := filepath.Join(dir, c.name+".csv")
+		if err := ioutil.WriteFile(filename, []byte(content), 0644); err != nil {
+			t.Fatal("couldn't write the file", err)
+		}
+		f := &csv.CSV{Filename: filename, Options: c.options}
+		errs, err := f.Validate(context.Background())
+		if err != nil {
+			t.Error("test case", i+1, c.name, "expected no error. got", err)
+			continue
+		}
+		if len(errs) != 2 {
+			t.Error("test case", i+1, c.name, "expected 2 validation errors. got", errs)
+			continue
+		}
+		skipped, coerced, ok := file.Tolerates(f, c.options, errs)
+		if skipped != c.skipped || coerced != c.coerced || ok != c.tolerated {
+			t.Error("test case", i+1, c.name, "expected", c.skipped, "rows skipped,", c.coerced, "rows coerced and tolerated", c.tolerated, "got", skipped, coerced, ok)
+		}
+	}
+}
+
+func TestReject(t *testing.T) {
+	dir, err := ioutil.TempDir("", "csv")
+	if err != nil {
+		t.Fatal("couldn't create the temp dir", err)
+	}
+	defer os.RemoveAll(dir)
+
+	//the value in the second row violates a rule on the second column
+	content := "a,b\n1,2\n3,x\n5,6\n"
+	violation := file.RowError(models.FileUploadErrorCodeOutOfRange, 2, "row 2 has x in the column b which is not a number")
+	violation.Column = 2
+	cases := []struct {
+		name       string
+		options    models.FileUploadOptions
+		fail       bool
+		coerced    bool
+		tolerated  bool
+		normalized string
+	}{
+		{"strict", models.FileUploadOptions{}, true, false, false, ""},
+		{"skip", models.FileUploadOptions{ErrorPolicy: models.FileUploadErrorPolicySkip}, false, false, true, "a,b\n1,2\n5,6\n"},
+		{"coerce", models.FileUploadOptions{ErrorPolicy: models.FileUploadErrorPolicyCoerce}, false, true, true, "a,b\n1,2\n3,\n5,6\n"},
+		{"max errors", models.FileUploadOptions{ErrorPolicy: models.FileUploadErrorPolicySkip, MaxErrors: 1}, false, false, true, "a,b\n1,2\n5,6\n"},
+		{"max error percent exceeded", models.FileUploadOptions{ErrorPolicy: models.FileUploadErrorPolicySkip, MaxErrorPercent: 10}, false, false, false, "a,b\n1,2\n5,6\n"},
+	}
+	for i, c := range cases {
+		filename := filepath.Join(dir, c.name+".csv")
+		if err := ioutil.WriteFile(filename, []byte(content), 0644); err != nil {
+			t.Fatal("couldn't write the file", err)
+		}
+		f := &csv.CSV{Filename: filename, Options: c.options}
+		if _, err := f.Validate(context.Background()); err != nil {
+			t.Error("test case", i+1, c.name, "expected no error while validating. got", err)
+			continue
+		}
+		coerced, err := f.Reject(context.Background(), []error{violation})
+		if (err != nil) != c.fail {
+			t.Error("test case", i+1, c.name, "expected failure as", c.fail, "got", err)
+			continue
+		}
+		if c.fail {
+			continue
+		}
+		_, _, ok := file.Tolerates(f, c.options, []error{violation})
+		if coerced != c.coerced || ok != c.tolerated {
+			t.Error("test case", i+1, c.name, "expected coerced as", c.coerced, "and tolerated as", c.tolerated, "got", coerced, ok)
+		}
+		b, err := ioutil.ReadFile(filename + ".normalized.csv")
+		if err != nil {
+			t.Error("test case", i+1, c.name, "couldn't read the normalized file", err)
+			continue
+		}
+		if string(b) != c.normalized {
+			t.Error("test case", i+1, c.name, "expected the normalized file", c.normalized, "got", string(b))
+		}
+	}
+}
+
+//utf16Bytes returns the content encoded in utf-16 with the given byte order
+func utf16Bytes(content string, littleEndian bool) []byte {
+	result := []byte{}
+	for _, v := range utf16.Encode([]rune(content)) {
+		if littleEndian {
+			result = append(result, byte(v), byte(v>>8))
+		} else {
+			result = append(result, byte(v>>8), byte(v))
+		}
+	}
+	return result
+}
+
+func TestEncoding(t *testing.T) {
+	dir, err := ioutil.TempDir("", "csv")
+	if err != nil {
+		t.Fatal("couldn't create the temp dir", err)
+	}
+	defer os.RemoveAll(dir)
+
+	content := "name,city\nJosé,Zürich\n"
+	rows := map[int64][]string{0: {"name", "city"}, 1: {"José", "Zürich"}}
+	cases := []struct {
+		name     string
+		content  []byte
+		charset  string
+		encoding string
+		rows     map[int64][]string
+	}{
+		{"utf-8", []byte(content), "", file.EncodingUTF8, rows},
+		{"utf-8 with bom", append([]byte("\xef\xbb\xbf"), content...), "", file.EncodingUTF8, rows},
+		{"utf-16le with bom", append([]byte("\xff\xfe"), utf16Bytes(content, true)...), "", file.EncodingUTF16LE, rows},
+		{"utf-16be with bom", append([]byte("\xfe\xff"), utf16Bytes(content, false)...), "", file.EncodingUTF16BE, rows},
+		{"utf-16le", utf16Bytes(content, true), "", file.EncodingUTF16LE, rows},
+		{"windows-1252", []byte("name,city\nJos\xe9,Z\xfcrich\n"), "", file.EncodingWindows1252, rows},
+		{"charset", []byte("name\n\xa3\xf3d\xbc\n"), "ISO-8859-2", "iso-8859-2", map[int64][]string{0: {"name"}, 1: {"Łódź"}}},
+		{"bom over charset", append([]byte("\xff\xfe"), utf16Bytes(content, true)...), "windows-1252", file.EncodingUTF16LE, rows},
+	}
+	for i, c := range cases {
+		filename := filepath.Join(dir, c.name+".csv")
+		if err := ioutil.WriteFile(filename, c.content, 0644); err != nil {
+			t.Fatal("couldn't write the file", err)
+		}
+		fs, err := csv.New(filename, c.name, models.FileUploadOptions{Charset: c.charset})
+		if err != nil {
+			t.Error("test case", i+1, c.name, "expected no error. got", err)
+			continue
+		}
+		f := fs[0].(*csv.CSV)
+		if f.Encoding != c.encoding {
+			t.Error("test case", i+1, c.name, "expected the encoding", c.encoding, "got", f.Encoding)
+		}
+		got := map[int64][]string{}
+		err = f.ReadRows(context.Background(), func(row int64, record []string) error {
+			got[row] = append([]string{}, record...)
+			return nil
+		})
+		if err != nil {
+			t.Error("test case", i+1, c.name, "expected no error. got", err)
+			continue
+		}
+		if fmt.Sprint(got) != fmt.Sprint(c.rows) {
+			t.Error("test case", i+1, c.name, "expected the rows", c.rows, "got", got)
+		}
+	}
+}
@@ -0,0 +1,317 @@
+// Copyright 2019 Cuttle.ai. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package csv
+
+import (
+	"bufio"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/cuttle-ai/file-uploader-service/models"
+)
+
+//DefaultDelimiter is the delimiter used when no delimiter is given for the file
+const DefaultDelimiter = ","
+
+//DefaultQuote is the quote character used when no quote character is given for the file
+const DefaultQuote = "\""
+
+//SniffSize is the no. of bytes read from the start of the file for sniffing the dialect
+const SniffSize = 64 * 1024
+
+//SniffLines is the max no. of lines used for sniffing the dialect
+const SniffLines = 20
+
+//sniffDelimiters is the list of the delimiters tried while sniffing the dialect in the order of preference
+var sniffDelimiters = []rune{',', '\t', ';', '|'}
+
+//CheckDialect checks whether the dialect options are valid
+func CheckDialect(o models.FileUploadOptions) error {
+	/*
+	 * We will check that each of the characters is a single character
+	 * Then we will check they don't conflict with each other
+	 */
+	//checking for single characters
+	chars := map[string]string{"delimiter": o.Delimiter, "quote": o.Quote, "comment": o.Comment}
+	for k, v := range chars {
+		if len(v) != 0 && utf8.RuneCountInString(v) != 1 {
+			return fmt.Errorf("%s has to be a single character. Found %s", k, v)
+		}
+	}
+	if len(o.Quote) > 1 {
+		return errors.New("quote has to be an ascii character")
+	}
+	if o.SkipLines < 0 {
+		return errors.New("no. of lines to be skipped can't be negative")
+	}
+
+	//checking the conflicts
+	delimiter, quote, comment := dialect(o)
+	if delimiter == quote {
+		return errors.New("delimiter and quote can't be the same character")
+	}
+	if delimiter == comment || quote == comment {
+		return errors.New("comment can't be the same character as the delimiter or quote")
+	}
+	if delimiter == '\n' || delimiter == '\r' || quote == '\n' || quote == '\r' || comment == '\n' || comment == '\r' {
+		return errors.New("delimiter, quote and comment can't be a new line character")
+	}
+	return nil
+}
+
+//dialect returns the delimiter, quote and comment characters of the options with the defaults applied.
+//Comment is returned as 0 if there are no comments
+func dialect(o models.FileUploadOptions) (rune, rune, rune) {
+	delimiter, quote, comment := ',', '"', rune(0)
+	if len(o.Delimiter) != 0 {
+		delimiter, _ = utf8.DecodeRuneInString(o.Delimiter)
+	}
+	if len(o.Quote) != 0 {
+		quote, _ = utf8.DecodeRuneInString(o.Quote)
+	}
+	if len(o.Comment) != 0 {
+		comment, _ = utf8.DecodeRuneInString(o.Comment)
+	}
+	return delimiter, quote, comment
+}
+
+//IsDefaultDialect returns true if the options are of the standard csv dialect.
+//Files of the standard dialect needn't be normalized before the upload
+func IsDefaultDialect(o models.FileUploadOptions) bool {
+	delimiter, quote, comment := dialect(o)
+	return delimiter == ',' && quote == '"' && comment == 0 && !o.Headerless && o.SkipLines == 0
+}
+
+//HasDialect returns true if any of the dialect options were given
+func HasDialect(o models.FileUploadOptions) bool {
+	return len(o.Delimiter) != 0 || len(o.Quote) != 0 || len(o.Comment) != 0 || o.Headerless || o.SkipLines != 0
+}
+
+//quoteReader swaps the custom quote character with the double quotes and vice versa
+//so that the encoding/csv reader which supports only double quotes can parse the file
+type quoteReader struct {
+	r     io.Reader
+	quote byte
+}
+
+func (q quoteReader) Read(p []byte) (int, error) {
+	n, err := q.r.Read(p)
+	for i := 0; i < n; i++ {
+		if p[i] == q.quote {
+			p[i] = '"'
+		} else if p[i] == '"' {
+			p[i] = q.quote
+		}
+	}
+	return n, err
+}
+
+//swapQuotes swaps back the quote characters in the values read using the quoteReader
+func swapQuotes(record []string, quote rune) []string {
+	if quote == '"' {
+		return record
+	}
+	for i, v := range record {
+		record[i] = strings.Map(func(r rune) rune {
+			if r == '"' {
+				return quote
+			}
+			if r == quote {
+				return '"'
+			}
+			return r
+		}, v)
+	}
+	return record
+}
+
+//newReader returns the csv reader for the given file contents as per the dialect options.
+//The lines to be skipped are consumed from the reader
+func newReader(f io.Reader, o models.FileUploadOptions) (*csv.Reader, error) {
+	/*
+	 * We will skip the lines
+	 * Then we will wrap the reader for custom quotes
+	 * Then we will create the reader with the delimiter and comment
+	 */
+	//skipping the lines
+	br := bufio.NewReader(f)
+	for i := 0; i < o.SkipLines; i++ {
+		_, err := br.ReadString('\n')
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	//wrapping the reader for custom quotes
+	delimiter, quote, comment := dialect(o)
+	var rd io.Reader = br
+	if quote != '"' {
+		rd = quoteReader{r: br, quote: byte(quote)}
+	}
+
+	//creating the reader
+	r := csv.NewReader(rd)
+	r.Comma = delimiter
+	r.Comment = comment
+	r.LazyQuotes = true
+	return r, nil
+}
+
+//Sniff will try to detect the dialect of the delimited file by going through its first few lines.
+//The delimiter, quote and whether the file has a header row are detected
+func Sniff(filename string) (models.FileUploadOptions, error) {
+	/*
+	 * We will read the first few lines of the file
+	 * Then we will find the delimiter
+	 * Then we will find the quote character
+	 * Then we will find whether the file has a header row
+	 */
+	//reading the lines
+	result := models.FileUploadOptions{}
+	f, err := os.Open(filename)
+	if err != nil {
+		return result, err
+	}
+	defer f.Close()
+	buf := make([]byte, SniffSize)
+	n, err := io.ReadFull(f, buf)
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		return result, err
+	}
+	content := strings.Replace(string(buf[:n]), "\r\n", "\n", -1)
+	lines := []string{}
+	for i, l := range strings.Split(content, "\n") {
+		if n == SniffSize && i == strings.Count(content, "\n") {
+			//last line might be truncated
+			break
+		}
+		if len(strings.TrimSpace(l)) == 0 {
+			continue
+		}
+		lines = append(lines, l)
+		if len(lines) == SniffLines {
+			break
+		}
+	}
+	if len(lines) == 0 {
+		return result, nil
+	}
+
+	//finding the quote
+	quote := sniffQuote(lines)
+	result.Quote = string(quote)
+
+	//finding the delimiter
+	result.Delimiter = string(sniffDelimiter(lines, quote))
+
+	//finding the header
+	r, err := newReader(strings.NewReader(strings.Join(lines, "\n")), result)
+	if err != nil {
+		return result, err
+	}
+	r.FieldsPerRecord = -1
+	records, err := r.ReadAll()
+	if err != nil {
+		return result, nil
+	}
+	result.Headerless = !hasHeader(records)
+	return result, nil
+}
+
+//sniffQuote returns the quote character in the lines. Single quote is returned only if it is
+//found around the fields more often than the double quotes
+func sniffQuote(lines []string) rune {
+	count := map[rune]int{}
+	for _, l := range lines {
+		for _, q := range []rune{'"', '\''} {
+			if strings.HasPrefix(l, string(q)) {
+				count[q]++
+			}
+			for _, d := range sniffDelimiters {
+				count[q] += strings.Count(l, string(d)+string(q)) + strings.Count(l, string(q)+string(d))
+			}
+		}
+	}
+	if count['\''] > count['"'] {
+		return '\''
+	}
+	return '"'
+}
+
+//sniffDelimiter returns the delimiter in the lines. A delimiter occurring the same no. of times
+//in every line is preferred over the others. Default delimiter is returned if nothing could be found
+func sniffDelimiter(lines []string, quote rune) rune {
+	best, bestScore, bestCount := ',', 0, 0
+	for _, d := range sniffDelimiters {
+		//count of the delimiter in each line outside the quotes
+		frequency := map[int]int{}
+		for _, l := range lines {
+			c, quoted := 0, false
+			for _, r := range l {
+				if r == quote {
+					quoted = !quoted
+				} else if r == d && !quoted {
+					c++
+				}
+			}
+			frequency[c]++
+		}
+		//the mode of the counts is the expected count per line
+		mode, modeFrequency := 0, 0
+		for c, f := range frequency {
+			if f > modeFrequency || (f == modeFrequency && c > mode) {
+				mode, modeFrequency = c, f
+			}
+		}
+		if mode == 0 {
+			continue
+		}
+		score := modeFrequency * 1000 / len(lines)
+		if score > bestScore || (score == bestScore && mode > bestCount) {
+			best, bestScore, bestCount = d, score, mode
+		}
+	}
+	return best
+}
+
+//hasHeader finds whether the first record is a header. Only the columns which are numeric in all the
+//other records decide the same. If the value of the first record in such columns is not numeric, the first record is a header.
+//If there are no numeric columns, the first record is assumed to be a header
+func hasHeader(records [][]string) bool {
+	if len(records) < 2 {
+		return true
+	}
+	votes := 0
+	for i, h := range records[0] {
+		numeric := true
+		for _, r := range records[1:] {
+			if i >= len(r) {
+				continue
+			}
+			if _, err := strconv.ParseFloat(strings.TrimSpace(r[i]), 64); err != nil {
+				numeric = false
+				break
+			}
+		}
+		if !numeric {
+			continue
+		}
+		if _, err := strconv.ParseFloat(strings.TrimSpace(h), 64); err != nil {
+			votes++
+		} else {
+			votes--
+		}
+	}
+	return votes >= 0
+}
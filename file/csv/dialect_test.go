@@ -0,0 +1,76 @@
+// Copyright 2019 Cuttle.ai. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package csv_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/cuttle-ai/file-uploader-service/file/csv"
+)
+
+/*
+ * This file contains the tests for sniffing the dialect of the delimited files
+ */
+
+func TestSniff(t *testing.T) {
+	dir, err := ioutil.TempDir("", "dialect")
+	if err != nil {
+		t.Fatal("couldn't create the temp dir", err)
+	}
+	defer os.RemoveAll(dir)
+
+	cases := []struct {
+		name       string
+		content    string
+		delimiter  string
+		quote      string
+		headerless bool
+	}{
+		{"comma", "name,price\napple,1.5\npear,2\n", ",", "\"", false},
+		{"tab", "name\tprice\tqty\napple\t1.5\t3\npear\t2\t4\n", "\t", "\"", false},
+		{"semicolon", "name;price\napple;1,5\npear;2,25\n", ";", "\"", false},
+		{"pipe", "name|price\napple|1.5\npear|2\n", "|", "\"", false},
+		{"windows line endings", "name;price\r\napple;1\r\npear;2\r\n", ";", "\"", false},
+		{"delimiter inside the quotes", "name,price\n\"apple; red\",1\n\"pear; green\",2\n", ",", "\"", false},
+		{"single quotes", "'name','price'\n'apple, red',1\n'pear, green',2\n", ",", "'", false},
+		{"double quotes with apostrophes", "\"name\",\"price\"\n\"farmer's apple\",1\n\"pear\",2\n", ",", "\"", false},
+		{"headerless", "1,2.5,3\n4,5,6\n7,8,9\n", ",", "\"", true},
+		{"headerless with a text column", "apple,1.5\npear,2\n", ",", "\"", true},
+		{"headerless tab", "1\t2\n3\t4\n", "\t", "\"", true},
+		{"numeric header", "2019,2020\n1,2\n3,4\n", ",", "\"", true},
+		{"single column", "price\n1\n2\n", ",", "\"", false},
+		{"empty", "\n\n", "", "", false},
+	}
+	for i, c := range cases {
+		filename := filepath.Join(dir, strconv.Itoa(i+1)+".csv")
+		if err := ioutil.WriteFile(filename, []byte(c.content), 0644); err != nil {
+			t.Fatal("couldn't write the file", err)
+		}
+		o, err := csv.Sniff(filename)
+		if err != nil {
+			t.Error("test case", i+1, c.name, "expected no error while sniffing. got", err)
+			continue
+		}
+		if o.Delimiter != c.delimiter || o.Quote != c.quote || o.Headerless != c.headerless {
+			t.Errorf("test case %d %s expected the delimiter %q, quote %q and headerless as %t got %q, %q and %t",
+				i+1, c.name, c.delimiter, c.quote, c.headerless, o.Delimiter, o.Quote, o.Headerless)
+		}
+	}
+
+	//only the lines within the sniff size are used and the truncated last line is left out
+	filename := filepath.Join(dir, "large.csv")
+	content := "a;b\n" + strings.Repeat("1;2\n", csv.SniffSize/4) + "3;4;5;6;7;8"
+	if err := ioutil.WriteFile(filename, []byte(content), 0644); err != nil {
+		t.Fatal("couldn't write the file", err)
+	}
+	if o, err := csv.Sniff(filename); err != nil || o.Delimiter != ";" {
+		t.Error("expected the delimiter of the large file to be sniffed. got", o.Delimiter, err)
+	}
+}
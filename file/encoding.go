@@ -0,0 +1,245 @@
+// Copyright 2019 Cuttle.ai. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package file
+
+/*
+ * This file contains the utilities to detect the character encoding of the text files and transcode them to utf-8
+ */
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/cuttle-ai/file-uploader-service/models/db"
+	"golang.org/x/text/encoding"
+	"golang.org/x/text/encoding/htmlindex"
+	"golang.org/x/text/encoding/unicode"
+	"golang.org/x/text/transform"
+)
+
+const (
+	//EncodingUTF8 is the utf-8 encoding to which the text files are transcoded
+	EncodingUTF8 = "utf-8"
+	//EncodingUTF16LE is the little endian utf-16 encoding of the files exported from excel on windows
+	EncodingUTF16LE = "utf-16le"
+	//EncodingUTF16BE is the big endian utf-16 encoding
+	EncodingUTF16BE = "utf-16be"
+	//EncodingWindows1252 is the encoding assumed for the files which are not valid utf-8
+	EncodingWindows1252 = "windows-1252"
+)
+
+//boms are the byte order marks with which the text files start and their encodings
+var boms = []struct {
+	bom      []byte
+	encoding string
+}{
+	{[]byte("\xef\xbb\xbf"), EncodingUTF8},
+	{[]byte("\xff\xfe"), EncodingUTF16LE},
+	{[]byte("\xfe\xff"), EncodingUTF16BE},
+}
+
+//getEncoding returns the encoding for the given name along with its canonical name
+func getEncoding(name string) (encoding.Encoding, string, error) {
+	enc, err := htmlindex.Get(strings.TrimSpace(name))
+	if err != nil {
+		return nil, "", fmt.Errorf("unsupported character encoding %s", name)
+	}
+	canonical, err := htmlindex.Name(enc)
+	if err != nil {
+		return nil, "", fmt.Errorf("unsupported character encoding %s", name)
+	}
+	return enc, canonical, nil
+}
+
+//CheckEncoding returns error if the character encoding given by the user is not supported
+func CheckEncoding(name string) error {
+	if len(strings.TrimSpace(name)) == 0 {
+		return nil
+	}
+	_, _, err := getEncoding(name)
+	return err
+}
+
+//DetectEncoding detects the character encoding of the text file. It returns true if the file starts with a byte order mark.
+//The encoding is detected by the byte order mark, then by the null bytes of the ascii characters in utf-16.
+//Files which are valid utf-8 are of utf-8 and the rest are assumed to be of windows-1252
+func DetectEncoding(filename string) (string, bool, error) {
+	/*
+	 * We will read the head of the file
+	 * Then we will check for the byte order marks
+	 * Then we will check for the null bytes of utf-16
+	 * Then we will check whether the file is valid utf-8
+	 */
+	//reading the head of the file
+	head, err := Head(filename)
+	if err != nil {
+		return "", false, err
+	}
+
+	//checking for the byte order marks
+	for _, v := range boms {
+		if bytes.HasPrefix(head, v.bom) {
+			return v.encoding, true, nil
+		}
+	}
+
+	//checking for the null bytes of utf-16
+	if enc, ok := detectUTF16(head); ok {
+		return enc, false, nil
+	}
+
+	//checking whether the file is valid utf-8
+	valid, err := validUTF8(filename)
+	if err != nil {
+		return "", false, err
+	}
+	if valid {
+		return EncodingUTF8, false, nil
+	}
+	return EncodingWindows1252, false, nil
+}
+
+//detectUTF16 detects utf-16 files without a byte order mark. The ascii characters in them have a null byte
+//which comes after the character in little endian and before it in big endian
+func detectUTF16(head []byte) (string, bool) {
+	even, odd := 0, 0
+	n := len(head) - len(head)%2
+	for i := 0; i < n; i += 2 {
+		if head[i] == 0 {
+			even++
+		}
+		if head[i+1] == 0 {
+			odd++
+		}
+	}
+	units := n / 2
+	if units == 0 {
+		return "", false
+	}
+	if odd*2 > units && even*10 < units {
+		return EncodingUTF16LE, true
+	}
+	if even*2 > units && odd*10 < units {
+		return EncodingUTF16BE, true
+	}
+	return "", false
+}
+
+//validUTF8 returns true if the whole file is valid utf-8
+func validUTF8(filename string) (bool, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return false, err
+	}
+	defer f.Close()
+	buf := make([]byte, 32*1024)
+	carry := 0
+	for {
+		n, err := f.Read(buf[carry:])
+		n += carry
+		if err == io.EOF {
+			return utf8.Valid(buf[:n]), nil
+		}
+		if err != nil {
+			return false, err
+		}
+		//the incomplete character at the end of the chunk is checked with the next one
+		carry = incompleteRune(buf[:n])
+		if !utf8.Valid(buf[:n-carry]) {
+			return false, nil
+		}
+		copy(buf, buf[n-carry:n])
+	}
+}
+
+//incompleteRune returns the no. of bytes at the end of the buffer which are the start of an incomplete utf-8 character
+func incompleteRune(p []byte) int {
+	for i := 1; i < utf8.UTFMax && i <= len(p); i++ {
+		if !utf8.RuneStart(p[len(p)-i]) {
+			continue
+		}
+		if utf8.FullRune(p[len(p)-i:]) {
+			return 0
+		}
+		return i
+	}
+	return 0
+}
+
+//Transcode rewrites the text file in utf-8 without the byte order mark and returns the encoding of the file.
+//The encoding is detected from the file if no encoding is given. The byte order mark if any takes precedence over the given encoding.
+//Files in utf-8 without a byte order mark are left as such. The file is replaced only after it is transcoded completely
+func Transcode(filename string, name string) (result string, err error) {
+	/*
+	 * We will detect the encoding of the file
+	 * Then we will decide the encoding from which the file is transcoded
+	 * Then we will transcode the file to a temporary file
+	 * Then we will replace the file with the transcoded one
+	 */
+	//detecting the encoding
+	detected, bom, err := DetectEncoding(filename)
+	if err != nil {
+		return "", err
+	}
+
+	//deciding the encoding
+	if len(strings.TrimSpace(name)) == 0 || bom {
+		name = detected
+	}
+	enc, canonical, err := getEncoding(name)
+	if err != nil {
+		return "", err
+	}
+	if canonical == EncodingUTF8 && !bom {
+		return canonical, nil
+	}
+
+	//transcoding the file
+	in, err := os.Open(filename)
+	if err != nil {
+		return "", err
+	}
+	defer in.Close()
+	tmp := filename + ".utf8"
+	out, err := os.Create(tmp)
+	if err != nil {
+		return "", err
+	}
+	defer func() {
+		out.Close()
+		if err != nil {
+			os.Remove(tmp)
+		}
+	}()
+	_, err = io.Copy(out, transform.NewReader(in, unicode.BOMOverride(enc.NewDecoder())))
+	if err != nil {
+		return "", fmt.Errorf("couldn't transcode the file from %s to utf-8: %s", canonical, err.Error())
+	}
+	err = out.Close()
+	if err != nil {
+		return "", err
+	}
+
+	//replacing the file
+	err = os.Rename(tmp, filename)
+	if err != nil {
+		return "", err
+	}
+	return canonical, nil
+}
+
+//TranscodeUpload transcodes the file of the file upload to utf-8 if it is of a text format and returns the encoding of the file.
+//It is used when the file of an existing upload is replaced by a new one. Empty string is returned for the other formats
+func TranscodeUpload(fileModel db.FileUpload) (string, error) {
+	f, ok := GetFormat(fileModel.Type)
+	if !ok || !f.Text {
+		return "", nil
+	}
+	return Transcode(fileModel.Location, fileModel.Options.Charset)
+}
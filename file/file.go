@@ -3,63 +3,167 @@
 // license that can be found in the LICENSE file.
 
 //Package file has the utilities required for resolving file type and processing them
-//There are sub directories which has implmentation for each file type supported by the system
+//There are sub directories which has implmentation for each file type supported by the system.
+//Each of them registers the format in the registry of this package
 package file
 
 import (
+	"context"
 	"errors"
 	"fmt"
+	"io"
 	"strings"
 
 	brainModels "github.com/cuttle-ai/brain/models"
 	"github.com/cuttle-ai/db-toolkit/datastores/services"
 	"github.com/cuttle-ai/file-uploader-service/config"
-	"github.com/cuttle-ai/file-uploader-service/file/csv"
+	"github.com/cuttle-ai/file-uploader-service/file/archive"
 	"github.com/cuttle-ai/file-uploader-service/models"
 	"github.com/cuttle-ai/file-uploader-service/models/db"
 	"github.com/cuttle-ai/octopus/interpreter"
 )
 
-//Type denotes the type of the file
-type Type int
-
-//Following constants has the list of supported file types
-const (
-	//UNRESOLVED is the type that is not resolved or not supported
-	UNRESOLVED Type = 0
-	//CSV is the comma separated files ~ files ending with the extension .csv
-	CSV Type = 1
-)
-
-//File interface has to be implemented by the file formats supported the platform
+//File interface has to be implemented by the file formats supported the platform.
+//The operations going through the file stop with the error of the context once the context is cancelled
 type File interface {
 	//Store stores the file info in the db so that it can be accessed later
 	Store(*config.AppContext) (*brainModels.Dataset, error)
 	//Validate will validate the file and returns the errors occurred
-	Validate() ([]error, error)
+	Validate(ctx context.Context) ([]error, error)
 	//IdentifyColumns will try to identify the columns in the file. If no columns are passed as arguments, it will read from the file.
 	//Else it will validate the given columns with the ones in the data file and try to refine the data type in the columns
-	IdentifyColumns(columns []interpreter.ColumnNode) ([]interpreter.ColumnNode, error)
+	IdentifyColumns(ctx context.Context, columns []interpreter.ColumnNode) ([]interpreter.ColumnNode, error)
 	//Upload will upload the data inside the file to the platform analytics engine replacing the existing data if the 3rd argument is true.
-	Upload(a *config.AppContext, table interpreter.TableNode, appendData bool, createTable bool, dataStore services.Service) error
-	//UpdateStatus updates the status of the file in db
+	//A write to the datastore can't be interrupted. So the cancellation is honoured only till the first write to an existing table.
+	//A table created by the upload may be left partially written when cancelled and has to be dropped by the caller
+	Upload(ctx context.Context, a *config.AppContext, table interpreter.TableNode, appendData bool, createTable bool, dataStore services.Service) error
+	//UpdateStatus moves the file in db to the status set by the validation
 	UpdateStatus(*config.AppContext) error
 	//ID returns the unique identified for the underlying resource in database
 	ID() uint
 }
 
-//ProcessFile will process a given file
-func ProcessFile(filename string, uploadname string) (File, error) {
-	if strings.Index(filename, ".csv") == len(filename)-4 {
-		return &csv.CSV{Filename: filename, Name: uploadname}, nil
+//RowCounter is optionally implemented by the files which can report the no. of data rows read from them
+type RowCounter interface {
+	//Rows returns the no. of data rows read from the file by the last operation which went through the whole file
+	Rows() int64
+}
+
+//RowReader is optionally implemented by the files which can read their rows as they are in the file. It is used to report the rejected rows
+type RowReader interface {
+	//ReadRows invokes the function with the header as row 0 followed by each data row and its no. as reported in the validation errors.
+	//It stops with the error returned by the function or the error of the context once the context is done
+	ReadRows(ctx context.Context, fn func(row int64, record []string) error) error
+}
+
+//Tolerant is optionally implemented by the files which can leave out or coerce the rows with errors while validating them as per the error policy of the upload.
+//The files which don't implement it are always validated strictly
+type Tolerant interface {
+	//Tolerated returns the no. of rows left out and coerced by the last validation as per the error policy.
+	//ok is false if the errors can't be tolerated like a file which couldn't be read till the end
+	Tolerated() (skipped int64, coerced int64, ok bool)
+}
+
+//Rejecter is optionally implemented by the tolerant files whose rows found invalid after the validation, like the rows violating
+//the rules of the dataset, can be left out or coerced as per the error policy of the upload. The rows are numbered as in ReadRows
+type Rejecter interface {
+	//Reject writes the data to be loaded again leaving out the rows of the errors or setting their values with the errors to null.
+	//It returns true if the values were coerced instead of leaving out the rows. The rows left out and coerced are counted in Tolerated
+	Reject(ctx context.Context, errs []error) (coerced bool, err error)
+}
+
+//ByteCounter is optionally implemented by the files which can report the no. of bytes written to the datastore
+type ByteCounter interface {
+	//BytesWritten returns the no. of bytes written to the datastore by the last upload
+	BytesWritten() int64
+}
+
+//ContextReader is a reader which stops reading with the error of the context once the context is done.
+//It is used to stop going through a file in the middle of an operation when the operation is cancelled
+type ContextReader struct {
+	//Ctx is the context of the operation reading the file
+	Ctx context.Context
+	//R is the underlying reader
+	R io.Reader
+}
+
+//Read reads from the underlying reader if the context is not done
+func (c ContextReader) Read(p []byte) (int, error) {
+	if err := c.Ctx.Err(); err != nil {
+		return 0, err
+	}
+	return c.R.Read(p)
+}
+
+//ProcessFile will process a given file. A file can have more than one dataset in it like the sheets in a workbook.
+//So it returns the list of files to be stored, one for each dataset. The format of the file is resolved from
+//its signature, extension, the mime type given while uploading or at last its content
+func ProcessFile(filename string, uploadname string, mimeType string, options models.FileUploadOptions) ([]File, error) {
+	f, err := Resolve(filename, mimeType)
+	if err != nil {
+		return nil, err
+	}
+	return f.New(filename, uploadname, options)
+}
+
+//ProcessUpload will process an uploaded file. If the file is compressed or an archive, it is expanded
+//and each of the supported files in it are processed. Files in the archive that are not supported are skipped
+func ProcessUpload(filename string, uploadname string, mimeType string, options models.FileUploadOptions) ([]File, error) {
+	/*
+	 * If the file starts with the signature of a supported format we will process it as such
+	 * Else if the file is not an archive we will process it as per its name or content
+	 * Else we will expand the archive within the limits
+	 * Then we will process each of the files in it
+	 */
+	//processing the file of a supported format
+	//the signature is checked first since formats like excel workbooks are zip files as well
+	f, ok, err := ResolveSignature(filename)
+	if err != nil {
+		return nil, err
+	}
+	if ok {
+		return f.New(filename, uploadname, options)
+	}
+	isArchive, err := archive.IsArchive(filename)
+	if err != nil {
+		return nil, err
+	}
+	if !isArchive {
+		return ProcessFile(filename, uploadname, mimeType, options)
+	}
+
+	//expanding the archive
+	entries, err := archive.Expand(filename, filename+"_expanded", archive.Limits{
+		MaxSize:    config.MaxDecompressedSize,
+		MaxRatio:   config.MaxCompressionRatio,
+		MaxEntries: config.MaxArchiveEntries,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	//processing the files in the archive
+	result := []File{}
+	errs := []string{}
+	for _, v := range entries {
+		fs, err := ProcessFile(v.Filename, v.Name, "", options)
+		if err != nil {
+			errs = append(errs, v.Name+": "+err.Error())
+			continue
+		}
+		result = append(result, fs...)
+	}
+	if len(result) == 0 {
+		return nil, errors.New("couldn't find any supported files in the archive. " + strings.Join(errs, ", "))
 	}
-	return nil, errors.New("unidentified file format")
+	return result, nil
 }
 
 //GetFile will return the file interface if the type is valid
 func GetFile(fileType string, fileModel db.FileUpload) (File, error) {
-	if fileType == models.FileUploadTypeCSV {
-		return &csv.CSV{Filename: fileModel.Location, Resource: fileModel}, nil
+	f, ok := GetFormat(fileType)
+	if !ok {
+		return nil, fmt.Errorf("unidentified file type %s", fileType)
 	}
-	return nil, fmt.Errorf("unidentified file type %s", fileType)
+	return f.Get(fileModel)
 }
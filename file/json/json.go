@@ -0,0 +1,519 @@
+// Copyright 2019 Cuttle.ai. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+//Package json has the implementation of the file interface for json files.
+//The file can either be an array of objects or newline delimited objects (ndjson).
+//Nested objects are flattened into dotted column names and the records are normalized to a csv file
+//after validation. The csv implementation is used for identifying the columns and uploading the data
+package json
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+
+	brainModels "github.com/cuttle-ai/brain/models"
+	"github.com/cuttle-ai/db-toolkit/datastores/services"
+	"github.com/cuttle-ai/file-uploader-service/config"
+	"github.com/cuttle-ai/file-uploader-service/file"
+	fCSV "github.com/cuttle-ai/file-uploader-service/file/csv"
+	"github.com/cuttle-ai/file-uploader-service/models"
+	"github.com/cuttle-ai/file-uploader-service/models/db"
+	"github.com/cuttle-ai/octopus/interpreter"
+)
+
+//Separator is the separator used between the keys of nested objects while flattening them into column names
+const Separator = "."
+
+//JSON handles the files of json type
+type JSON struct {
+	//Filename is the name of the file
+	Filename string
+	//Name is the dataset name
+	Name string
+	//Options are the options with which the file has to be parsed
+	Options models.FileUploadOptions
+	//Resource holds the db instance of the underlying file
+	Resource db.FileUpload
+	//Table is the underlying octopus table node
+	Table *interpreter.TableNode
+	//Encoding is the character encoding in which the file was uploaded
+	Encoding string
+	//rows is the no. of records read from the file. The rows exploded from a record are counted once like in the validation errors
+	rows int64
+	//written is the no. of bytes dumped to the datastore by the last upload
+	written int64
+	//skipped is the no. of records left out by the last validation since they couldn't be read or flattened or were rejected
+	skipped int64
+	//coerced is the no. of records whose values with the errors were set to null by the last validation as per the error policy
+	coerced int64
+	//truncated indicates that the rest of the file couldn't be read by the last validation after a syntax error
+	truncated bool
+	//rejected are the records found invalid after the validation along with the columns having the errors.
+	//They are left out or coerced as per the error policy while writing the normalized csv file
+	rejected map[int64][]int
+}
+
+func init() {
+	file.Register(file.Format{
+		Type:       models.FileUploadTypeJSON,
+		Extensions: []string{".json", ".ndjson", ".jsonl"},
+		MIMETypes:  []string{"application/json", "application/x-ndjson", "application/jsonl"},
+		Detect:     Detect,
+		Text:       true,
+		New:        New,
+		Get:        Get,
+	})
+}
+
+//Detect returns true if the file starts with an array or object
+func Detect(filename string, head []byte) bool {
+	head = bytes.TrimLeft(bytes.TrimPrefix(head, []byte("\xef\xbb\xbf")), " \t\r\n")
+	return len(head) != 0 && (head[0] == '{' || head[0] == '[')
+}
+
+//New returns the json file to be stored for the uploaded file. The file is transcoded to utf-8 from its encoding
+func New(filename string, uploadname string, options models.FileUploadOptions) ([]file.File, error) {
+	encoding, err := file.Transcode(filename, options.Charset)
+	if err != nil {
+		return nil, err
+	}
+	return []file.File{&JSON{Filename: filename, Name: uploadname, Options: options, Encoding: encoding}}, nil
+}
+
+//Get returns the json file for the file upload stored in db
+func Get(fileModel db.FileUpload) (file.File, error) {
+	return &JSON{Filename: fileModel.Location, Options: fileModel.Options, Resource: fileModel, Encoding: fileModel.Encoding}, nil
+}
+
+//ID returns the underlying file's id in db
+func (j JSON) ID() uint {
+	return j.Resource.ID
+}
+
+//Rows returns the no. of records read from the file while validating it or identifying its columns.
+//The records are counted instead of the rows exploded from them, since the errors are in the records
+func (j JSON) Rows() int64 {
+	return j.rows
+}
+
+//BytesWritten returns the no. of bytes dumped to the datastore by the last upload
+func (j JSON) BytesWritten() int64 {
+	return j.written
+}
+
+//Tolerated returns the no. of records left out and coerced while validating the file. The records which couldn't be read or flattened
+//can't be coerced, so they are always left out. The errors can't be tolerated if the file couldn't be read till the end
+func (j JSON) Tolerated() (int64, int64, bool) {
+	return j.skipped, j.coerced, !j.truncated
+}
+
+//Reject writes the normalized csv file again leaving out the records of the errors or setting their values with the errors to null
+//in all the rows exploded from them as per the error policy. The records can't be rejected if the error policy is strict
+func (j *JSON) Reject(ctx context.Context, errs []error) (bool, error) {
+	policy := file.ErrorPolicy(j.Options)
+	if policy == models.FileUploadErrorPolicyStrict {
+		return false, errors.New("records of the file can't be left out or coerced as the error policy is strict")
+	}
+	j.rejected = file.RejectedRows(errs)
+	_, err := j.Validate(ctx)
+	return policy == models.FileUploadErrorPolicyCoerce, err
+}
+
+//Store stores the json file info to database
+func (j *JSON) Store(a *config.AppContext) (*brainModels.Dataset, error) {
+	/*
+	 * We will store the file upload record along with its dataset
+	 */
+	fileRecord := &models.FileUpload{Name: j.Name, UserID: a.Session.User.ID, Location: j.Filename, Status: models.FileUploadStatusUploaded, Type: models.FileUploadTypeJSON, Options: j.Options, Encoding: j.Encoding}
+	return db.StoreFileUpload(a, fileRecord)
+}
+
+//csvFilename returns the location of the normalized csv file of the json file
+func (j JSON) csvFilename() string {
+	return j.Filename + ".csv"
+}
+
+//records iterates through the records in the json file and invokes the given function with the flattened rows of each record.
+//The index of the record starting from 1 is also passed to the function. If the function returns an error or the context is done the iteration stops
+func (j *JSON) records(ctx context.Context, fn func(index int, rows []map[string]string, err error) error) error {
+	/*
+	 * We will open the file
+	 * Then we will find whether the file is an array or stream of objects
+	 * Then we will decode the records one by one and flatten them
+	 */
+	//opening the file
+	f, err := os.Open(j.Filename)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	//finding whether the file is an array
+	r := bufio.NewReader(file.ContextReader{Ctx: ctx, R: f})
+	isArray := false
+	for {
+		b, err := r.ReadByte()
+		if err == io.EOF {
+			return errors.New("couldn't find any records in the file")
+		}
+		if err != nil {
+			return err
+		}
+		if b == ' ' || b == '\t' || b == '\r' || b == '\n' {
+			continue
+		}
+		isArray = b == '['
+		r.UnreadByte()
+		break
+	}
+
+	//decoding the records
+	//the opening bracket of an array is read as a token so that the decoder skips the commas between the records
+	dec := json.NewDecoder(r)
+	dec.UseNumber()
+	if isArray {
+		if _, err := dec.Token(); err != nil {
+			return err
+		}
+	}
+	for i := 1; ; i++ {
+		if isArray && !dec.More() {
+			return nil
+		}
+		record := map[string]interface{}{}
+		err := dec.Decode(&record)
+		if err == io.EOF && !isArray {
+			return nil
+		}
+		if cErr := ctx.Err(); cErr != nil {
+			//the record couldn't be read since the context is done
+			return cErr
+		}
+		if _, ok := err.(*json.UnmarshalTypeError); ok {
+			//record is not an object. we can continue with the next record
+			if err := fn(i, nil, file.RowError(models.FileUploadErrorCodeRecord, int64(i), "record %d is not an object", i)); err != nil {
+				return err
+			}
+			continue
+		}
+		if err != nil {
+			//the rest of the file can't be read after a syntax error
+			j.truncated = true
+			return fn(i, nil, file.RowError(models.FileUploadErrorCodeRecord, int64(i), "record %d couldn't be read: %s", i, err.Error()))
+		}
+		rows, err := flatten("", record, j.Options.ExplodeArrays, config.MaxExplodedRows)
+		if err != nil {
+			err = file.RowError(models.FileUploadErrorCodeRecord, int64(i), "record %d has %s", i, err.Error())
+		}
+		if err := fn(i, rows, err); err != nil {
+			return err
+		}
+	}
+}
+
+//flatten flattens the given value into rows with dotted column names.
+//Nested objects are flattened to their keys prefixed with the key of the parent.
+//If explode is true, a row is created for each of the elements in an array. Else error is returned for arrays.
+//Error is returned if the value is exploded into more than the given max no. of rows
+func flatten(prefix string, value interface{}, explode bool, max int) ([]map[string]string, error) {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		//the rows of an object is the cartesian product of the rows of its children
+		//keys are sorted so that the columns are in the same order for every record
+		result := []map[string]string{{}}
+		for _, k := range sortedKeys(v) {
+			child := v[k]
+			key := k
+			if len(prefix) != 0 {
+				key = prefix + Separator + k
+			}
+			cRows, err := flatten(key, child, explode, max)
+			if err != nil {
+				return nil, err
+			}
+			if len(result)*len(cRows) > max {
+				return nil, tooManyRows(prefix, max)
+			}
+			product := []map[string]string{}
+			for _, row := range result {
+				for _, cRow := range cRows {
+					nRow := map[string]string{}
+					for rk, rv := range row {
+						nRow[rk] = rv
+					}
+					for ck, cv := range cRow {
+						nRow[ck] = cv
+					}
+					product = append(product, nRow)
+				}
+			}
+			result = product
+		}
+		return result, nil
+	case []interface{}:
+		if !explode {
+			return nil, fmt.Errorf("an array at %s. Arrays are supported only if they are exploded into rows", prefix)
+		}
+		if len(v) == 0 {
+			return []map[string]string{{prefix: ""}}, nil
+		}
+		result := []map[string]string{}
+		for _, e := range v {
+			eRows, err := flatten(prefix, e, explode, max)
+			if err != nil {
+				return nil, err
+			}
+			if len(result)+len(eRows) > max {
+				return nil, tooManyRows(prefix, max)
+			}
+			result = append(result, eRows...)
+		}
+		return result, nil
+	case nil:
+		return []map[string]string{{prefix: ""}}, nil
+	case json.Number:
+		return []map[string]string{{prefix: v.String()}}, nil
+	case string:
+		return []map[string]string{{prefix: v}}, nil
+	default:
+		return []map[string]string{{prefix: fmt.Sprint(v)}}, nil
+	}
+}
+
+//tooManyRows returns the error for a value exploded into more than the max no. of rows
+func tooManyRows(prefix string, max int) error {
+	if len(prefix) == 0 {
+		return fmt.Errorf("arrays exploding into more than %d rows", max)
+	}
+	return fmt.Errorf("arrays at %s exploding into more than %d rows", prefix, max)
+}
+
+//sortedKeys returns the keys of the given map in the sorted order
+func sortedKeys(m interface{}) []string {
+	keys := []string{}
+	switch v := m.(type) {
+	case map[string]interface{}:
+		for k := range v {
+			keys = append(keys, k)
+		}
+	case map[string]string:
+		for k := range v {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+//Validate will validate the json file and writes the normalized csv file.
+//It returns the errors existing in the records of the file
+func (j *JSON) Validate(ctx context.Context) ([]error, error) {
+	/*
+	 * We will go through the records to find the columns and errors
+	 * If there are no columns found we will return error
+	 * Then we will go through the records again to write the csv file
+	 * return the errors if any
+	 */
+	//finding the columns and errors
+	j.truncated = false
+	errorResults := []error{}
+	columns := []string{}
+	columnIndex := map[string]int{}
+	err := j.records(ctx, func(index int, rows []map[string]string, err error) error {
+		if err != nil {
+			errorResults = append(errorResults, err)
+			return nil
+		}
+		for _, row := range rows {
+			for _, k := range sortedKeys(row) {
+				if _, ok := columnIndex[k]; ok {
+					continue
+				}
+				columnIndex[k] = len(columns)
+				columns = append(columns, k)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		j.Resource.Status = models.FileUploadStatusInvalid
+		return nil, err
+	}
+	if len(columns) == 0 {
+		j.Resource.Status = models.FileUploadStatusInvalid
+		return nil, errors.New("couldn't find any columns in the records of the file")
+	}
+
+	//writing the csv file
+	err = j.writeCSV(ctx, columns, columnIndex)
+	if err != nil {
+		j.Resource.Status = models.FileUploadStatusInvalid
+		return nil, err
+	}
+
+	j.Resource.Status = models.FileUploadStatusValidated
+	if len(errorResults) == 0 {
+		return nil, nil
+	}
+	return errorResults, nil
+}
+
+//writeCSV writes the records in the file to the normalized csv file with the given columns.
+//The partially written file is removed if the writing fails
+func (j *JSON) writeCSV(ctx context.Context, columns []string, columnIndex map[string]int) (err error) {
+	f, err := os.Create(j.csvFilename())
+	if err != nil {
+		return err
+	}
+	defer func() {
+		f.Close()
+		if err != nil {
+			os.Remove(j.csvFilename())
+		}
+	}()
+	w := csv.NewWriter(f)
+	if err := w.Write(columns); err != nil {
+		return err
+	}
+	policy := file.ErrorPolicy(j.Options)
+	j.rows = 0
+	j.skipped = 0
+	j.coerced = 0
+	err = j.records(ctx, func(index int, rows []map[string]string, err error) error {
+		j.rows++
+		blank, rejected := j.rejected[int64(index)]
+		if err != nil || (rejected && (policy != models.FileUploadErrorPolicyCoerce || len(blank) == 0)) {
+			//the records with errors and the ones rejected after the validation are read but left out
+			//unless the values with the errors can be set to null
+			j.skipped++
+			return nil
+		}
+		if rejected {
+			j.coerced++
+		}
+		for _, row := range rows {
+			record := make([]string, len(columns))
+			for k, v := range row {
+				record[columnIndex[k]] = v
+			}
+			if rejected {
+				record = file.BlankColumns(record, blank)
+			}
+			if err := w.Write(record); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	w.Flush()
+	return w.Error()
+}
+
+//ReadRows reads the flattened rows of the records in the file in the order of the columns of the normalized csv file.
+//The no. of a row is the no. of its record as in the validation errors, so the rows exploded from a record have the same no.
+//The records with errors are skipped
+func (j *JSON) ReadRows(ctx context.Context, fn func(row int64, record []string) error) error {
+	/*
+	 * We will read the columns from the normalized csv file
+	 * Then we will go through the records in the file
+	 */
+	//reading the columns
+	c, err := j.converted(ctx)
+	if err != nil {
+		return err
+	}
+	f, err := os.Open(c.Filename)
+	if err != nil {
+		return err
+	}
+	columns, err := csv.NewReader(f).Read()
+	f.Close()
+	if err != nil {
+		return err
+	}
+	columnIndex := map[string]int{}
+	for i, v := range columns {
+		columnIndex[v] = i
+	}
+	if err := fn(0, columns); err != nil {
+		return err
+	}
+
+	//going through the records
+	return j.records(ctx, func(index int, rows []map[string]string, err error) error {
+		if err != nil {
+			return nil
+		}
+		for _, row := range rows {
+			record := make([]string, len(columns))
+			for k, v := range row {
+				record[columnIndex[k]] = v
+			}
+			if err := fn(int64(index), record); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+//converted returns the csv implementation of the normalized file. If the file is not normalized yet, it will be normalized
+func (j *JSON) converted(ctx context.Context) (*fCSV.CSV, error) {
+	if _, err := os.Stat(j.csvFilename()); os.IsNotExist(err) {
+		_, err = j.Validate(ctx)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return &fCSV.CSV{Filename: j.csvFilename(), Name: j.Name, Resource: j.Resource, Table: j.Table}, nil
+}
+
+//IdentifyColumns will identify the columns in the json file
+func (j *JSON) IdentifyColumns(ctx context.Context, columns []interpreter.ColumnNode) ([]interpreter.ColumnNode, error) {
+	c, err := j.converted(ctx)
+	if err != nil {
+		return nil, err
+	}
+	columns, err = c.IdentifyColumns(ctx, columns)
+	if err != nil || j.rows != 0 {
+		return columns, err
+	}
+
+	//the records are counted if the file was validated by an earlier run, since the normalized csv file has the exploded rows
+	err = j.records(ctx, func(index int, rows []map[string]string, err error) error {
+		j.rows++
+		return nil
+	})
+	return columns, err
+}
+
+//Upload will attempt to upload the json file to the analytics engine and report any error occurred
+func (j *JSON) Upload(ctx context.Context, a *config.AppContext, table interpreter.TableNode, appendData bool, createTable bool, dataStore services.Service) error {
+	c, err := j.converted(ctx)
+	if err != nil {
+		return err
+	}
+	err = c.Upload(ctx, a, table, appendData, createTable, dataStore)
+	j.written = c.BytesWritten()
+	return err
+}
+
+//UpdateStatus updates the status of the file upload in db
+func (j *JSON) UpdateStatus(a *config.AppContext) error {
+	/*
+	 * We will move the file upload to the status set by the validation
+	 */
+	return j.Resource.UpdateStatus(a, j.Resource.Status, "")
+}
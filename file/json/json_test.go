@@ -0,0 +1,136 @@
+// Copyright 2019 Cuttle.ai. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package json_test
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/cuttle-ai/file-uploader-service/config"
+	"github.com/cuttle-ai/file-uploader-service/file"
+	"github.com/cuttle-ai/file-uploader-service/file/json"
+	"github.com/cuttle-ai/file-uploader-service/models"
+)
+
+/*
+ * This file contains the tests for exploding the arrays in the records of the json files and rejecting the records
+ */
+
+func TestExplodeArrays(t *testing.T) {
+	dir, err := ioutil.TempDir("", "json")
+	if err != nil {
+		t.Fatal("couldn't create the temp dir", err)
+	}
+	defer os.RemoveAll(dir)
+	max := config.MaxExplodedRows
+	config.MaxExplodedRows = 4
+	defer func() { config.MaxExplodedRows = max }()
+
+	cases := []struct {
+		name       string
+		content    string
+		rows       []int64
+		normalized string
+	}{
+		{"within the limit", `[{"a":[1,2],"b":[3,4]}]`, nil, "a,b\n1,3\n1,4\n2,3\n2,4\n"},
+		{"array exceeding the limit", `[{"a":[1,2,3,4,5]},{"a":[6]}]`, []int64{1}, "a\n6\n"},
+		{"cartesian product exceeding the limit", `[{"a":[1]},{"a":[1,2,3],"b":[4,5]}]`, []int64{2}, "a\n1\n"},
+		{"nested arrays exceeding the limit", `{"a":[{"b":[1,2,3]},{"b":[4,5]}]}` + "\n" + `{"a":{"b":2}}`, []int64{1}, "a.b\n2\n"},
+	}
+	for i, c := range cases {
+		filename := filepath.Join(dir, c.name+".json")
+		if err := ioutil.WriteFile(filename, []byte(c.content), 0644); err != nil {
+			t.Fatal("couldn't write the file", err)
+		}
+		f := &json.JSON{Filename: filename, Options: models.FileUploadOptions{ExplodeArrays: true}}
+		errs, err := f.Validate(context.Background())
+		if err != nil {
+			t.Error("test case", i+1, c.name, "expected no error while validating. got", err)
+			continue
+		}
+		if len(errs) != len(c.rows) {
+			t.Error("test case", i+1, c.name, "expected errors in the records", c.rows, "got", errs)
+			continue
+		}
+		for j, e := range errs {
+			vErr, ok := e.(file.ValidationError)
+			if !ok || vErr.Code != models.FileUploadErrorCodeRecord || vErr.Row != c.rows[j] {
+				t.Error("test case", i+1, c.name, "expected a record error in the record", c.rows[j], "got", e)
+			}
+		}
+		normalized, err := ioutil.ReadFile(filename + ".csv")
+		if err != nil {
+			t.Error("test case", i+1, c.name, "couldn't read the normalized csv file", err)
+			continue
+		}
+		if string(normalized) != c.normalized {
+			t.Errorf("test case %d %s expected the normalized csv file %q got %q", i+1, c.name, c.normalized, string(normalized))
+		}
+	}
+}
+
+func TestReject(t *testing.T) {
+	dir, err := ioutil.TempDir("", "json")
+	if err != nil {
+		t.Fatal("couldn't create the temp dir", err)
+	}
+	defer os.RemoveAll(dir)
+
+	//the value in the second record exploded into two rows violates a rule on the first column
+	content := `[{"a":1,"b":[1,2]},{"a":"x","b":[3,4]},{"a":5,"b":6}]`
+	violation := file.RowError(models.FileUploadErrorCodeOutOfRange, 2, "row 2 has x in the column a which is not a number")
+	violation.Column = 1
+	cases := []struct {
+		name       string
+		options    models.FileUploadOptions
+		fail       bool
+		coerced    bool
+		tolerated  bool
+		skipped    int64
+		normalized string
+	}{
+		{"strict", models.FileUploadOptions{}, true, false, false, 0, ""},
+		{"skip", models.FileUploadOptions{ErrorPolicy: models.FileUploadErrorPolicySkip}, false, false, true, 1, "a,b\n1,1\n1,2\n5,6\n"},
+		{"coerce", models.FileUploadOptions{ErrorPolicy: models.FileUploadErrorPolicyCoerce}, false, true, true, 0, "a,b\n1,1\n1,2\n,3\n,4\n5,6\n"},
+		{"max error percent of the records", models.FileUploadOptions{ErrorPolicy: models.FileUploadErrorPolicySkip, MaxErrorPercent: 34}, false, false, true, 1, "a,b\n1,1\n1,2\n5,6\n"},
+		{"max error percent of the records exceeded", models.FileUploadOptions{ErrorPolicy: models.FileUploadErrorPolicySkip, MaxErrorPercent: 30}, false, false, false, 1, "a,b\n1,1\n1,2\n5,6\n"},
+	}
+	for i, c := range cases {
+		filename := filepath.Join(dir, c.name+".json")
+		if err := ioutil.WriteFile(filename, []byte(content), 0644); err != nil {
+			t.Fatal("couldn't write the file", err)
+		}
+		c.options.ExplodeArrays = true
+		f := &json.JSON{Filename: filename, Options: c.options}
+		if _, err := f.Validate(context.Background()); err != nil {
+			t.Error("test case", i+1, c.name, "expected no error while validating. got", err)
+			continue
+		}
+		coerced, err := f.Reject(context.Background(), []error{violation})
+		if (err != nil) != c.fail {
+			t.Error("test case", i+1, c.name, "expected failure as", c.fail, "got", err)
+			continue
+		}
+		if c.fail {
+			continue
+		}
+		skipped, _, ok := file.Tolerates(f, c.options, []error{violation})
+		if coerced != c.coerced || ok != c.tolerated || f.Rows() != 3 || (ok && skipped != c.skipped) {
+			t.Error("test case", i+1, c.name, "expected coerced as", c.coerced, "tolerated as", c.tolerated, "with", c.skipped, "of the 3 records left out. got",
+				coerced, ok, skipped, f.Rows())
+		}
+		b, err := ioutil.ReadFile(filename + ".csv")
+		if err != nil {
+			t.Error("test case", i+1, c.name, "couldn't read the normalized csv file", err)
+			continue
+		}
+		if string(b) != c.normalized {
+			t.Errorf("test case %d %s expected the normalized csv file %q got %q", i+1, c.name, c.normalized, string(b))
+		}
+	}
+}
@@ -5,14 +5,19 @@
 package file
 
 import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/cuttle-ai/file-uploader-service/config"
+	"github.com/cuttle-ai/file-uploader-service/models/db"
 )
 
 /*
- * This file contains the utilities required for generating name of the file
+ * This file contains the utilities required for generating name of the file and the locations of the files replacing the uploaded files
  * While working in a concurrent environment name generation has to pipelined
  */
 
@@ -48,6 +53,45 @@ func GenerateName(in chan NameGenerate) {
 	}
 }
 
+//NewLocation creates a new directory next to the one having the file and returns the location with the same name as the file in it.
+//A file replacing an uploaded file is written there so that the uploads sharing the file like the sheets of a workbook are not changed
+//and the existing file is intact till the new one is written completely
+func NewLocation(location string) (string, error) {
+	req := NewNameGenerate()
+	req.Name = filepath.Dir(filepath.Dir(location))
+	GenerateNameChan <- req
+	out := <-req.Out
+	err := os.MkdirAll(out.Generated, 0755)
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(out.Generated, filepath.Base(location)), nil
+}
+
+//ReleaseLocation removes the replaced file along with the files derived from it like the normalized file, if none of the file uploads use the file anymore.
+//The directory of the file is removed if it is empty after that
+func ReleaseLocation(a *config.AppContext, location string) error {
+	n, err := db.CountFileUploadsAt(a, location)
+	if err != nil || n != 0 {
+		return err
+	}
+	infos, err := ioutil.ReadDir(filepath.Dir(location))
+	if err != nil {
+		return err
+	}
+	for _, v := range infos {
+		if v.Name() != filepath.Base(location) && !strings.HasPrefix(v.Name(), filepath.Base(location)+".") {
+			continue
+		}
+		if err := os.RemoveAll(filepath.Join(filepath.Dir(location), v.Name())); err != nil {
+			return err
+		}
+	}
+	//the directory is removed only if it is empty
+	os.Remove(filepath.Dir(location))
+	return nil
+}
+
 func init() {
 	/*
 	 * Will start the name generation go routine
@@ -0,0 +1,582 @@
+// Copyright 2019 Cuttle.ai. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+//Package parquet has the implementation of the file interface for apache parquet files.
+//The data types of the columns are taken from the schema embedded in the file instead of predicting them from the values.
+//While uploading, the row groups are streamed one by one to a csv file dumped to the datastore so that the whole file is never loaded into memory
+package parquet
+
+import (
+	"context"
+	"encoding/binary"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+	"strconv"
+	"time"
+
+	brainModels "github.com/cuttle-ai/brain/models"
+	"github.com/cuttle-ai/db-toolkit/datastores/services"
+	"github.com/cuttle-ai/file-uploader-service/config"
+	"github.com/cuttle-ai/file-uploader-service/file"
+	"github.com/cuttle-ai/file-uploader-service/models"
+	"github.com/cuttle-ai/file-uploader-service/models/db"
+	"github.com/cuttle-ai/octopus/interpreter"
+	"github.com/google/uuid"
+	"github.com/xitongsys/parquet-go/common"
+	pq "github.com/xitongsys/parquet-go/parquet"
+	"github.com/xitongsys/parquet-go/reader"
+	"github.com/xitongsys/parquet-go/source"
+)
+
+//DateFormat is the format in which the date and timestamp values are written to the datastore
+const DateFormat = "1/2/2006"
+
+//Parquet handles the files of parquet type
+type Parquet struct {
+	//Filename is the name of the file
+	Filename string
+	//Name is the dataset name
+	Name string
+	//Resource holds the db instance of the underlying file
+	Resource db.FileUpload
+	//Table is the underlying octopus table node
+	Table *interpreter.TableNode
+	//rows is the no. of rows in the file as per its footer
+	rows int64
+	//written is the no. of bytes dumped to the datastore by the last upload
+	written int64
+}
+
+func init() {
+	file.Register(file.Format{
+		Type:       models.FileUploadTypeParquet,
+		Extensions: []string{".parquet"},
+		MIMETypes:  []string{"application/vnd.apache.parquet", "application/x-parquet"},
+		Signatures: [][]byte{[]byte("PAR1")},
+		New:        New,
+		Get:        Get,
+	})
+}
+
+//New returns the parquet file to be stored for the uploaded file
+func New(filename string, uploadname string, options models.FileUploadOptions) ([]file.File, error) {
+	return []file.File{&Parquet{Filename: filename, Name: uploadname}}, nil
+}
+
+//Get returns the parquet file for the file upload stored in db
+func Get(fileModel db.FileUpload) (file.File, error) {
+	return &Parquet{Filename: fileModel.Location, Resource: fileModel}, nil
+}
+
+//localFile implements the source.ParquetFile for the files in local file system
+type localFile struct {
+	*os.File
+	name string
+}
+
+//Open opens the given file. If the name is empty, the same file is opened again
+func (l localFile) Open(name string) (source.ParquetFile, error) {
+	if len(name) == 0 {
+		name = l.name
+	}
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	return localFile{File: f, name: name}, nil
+}
+
+//Create creates the given file
+func (l localFile) Create(name string) (source.ParquetFile, error) {
+	f, err := os.Create(name)
+	if err != nil {
+		return nil, err
+	}
+	return localFile{File: f, name: name}, nil
+}
+
+//column has the info about a column in the parquet file
+type column struct {
+	//Name is the name of the column
+	Name string
+	//Path is the path of the column in the schema
+	Path string
+	//Element is the schema element of the column
+	Element *pq.SchemaElement
+	//DataType is the data type of the column
+	DataType string
+	//DateFormat is the date format of the column if it's a date
+	DateFormat string
+}
+
+//ID returns the underlying file's id in db
+func (p Parquet) ID() uint {
+	return p.Resource.ID
+}
+
+//Rows returns the no. of rows in the file as per its footer. It is available once the file is opened for any of the operations
+func (p Parquet) Rows() int64 {
+	return p.rows
+}
+
+//BytesWritten returns the no. of bytes dumped to the datastore by the last upload
+func (p Parquet) BytesWritten() int64 {
+	return p.written
+}
+
+//Store stores the parquet info to database
+func (p *Parquet) Store(a *config.AppContext) (*brainModels.Dataset, error) {
+	/*
+	 * We will store the file upload record along with its dataset
+	 */
+	fileRecord := &models.FileUpload{Name: p.Name, UserID: a.Session.User.ID, Location: p.Filename, Status: models.FileUploadStatusUploaded, Type: models.FileUploadTypeParquet}
+	return db.StoreFileUpload(a, fileRecord)
+}
+
+//open opens the parquet file and reads its footer. The returned function has to be called to close the file
+func (p Parquet) open() (*reader.ParquetReader, func(), error) {
+	f, err := os.Open(p.Filename)
+	if err != nil {
+		return nil, nil, err
+	}
+	pr, err := reader.NewParquetColumnReader(localFile{File: f, name: p.Filename}, 1)
+	if err != nil {
+		f.Close()
+		return nil, nil, err
+	}
+	return pr, func() {
+		pr.ReadStop()
+		f.Close()
+	}, nil
+}
+
+//columns returns the columns in the schema of the file. Nested and repeated columns are returned as errors
+func columns(pr *reader.ParquetReader) ([]column, []error) {
+	result := []column{}
+	errs := []error{}
+	for i, e := range pr.SchemaHandler.SchemaElements {
+		if i == 0 {
+			//root of the schema
+			continue
+		}
+		path := pr.SchemaHandler.IndexMap[int32(i)]
+		if e.GetNumChildren() > 0 {
+			vErr := file.RowError(models.FileUploadErrorCodeSchema, 0, "column %s is a nested column. Only flat schemas are supported", pr.SchemaHandler.GetExName(i))
+			vErr.ColumnName = pr.SchemaHandler.GetExName(i)
+			errs = append(errs, vErr)
+			continue
+		}
+		if len(common.StrToPath(path)) > 2 {
+			//a field inside a nested column which is already reported
+			continue
+		}
+		if e.GetRepetitionType() == pq.FieldRepetitionType_REPEATED {
+			vErr := file.RowError(models.FileUploadErrorCodeSchema, 0, "column %s is a repeated column. Only flat schemas are supported", pr.SchemaHandler.GetExName(i))
+			vErr.ColumnName = pr.SchemaHandler.GetExName(i)
+			errs = append(errs, vErr)
+			continue
+		}
+		dT, dF := dataType(e)
+		result = append(result, column{Name: pr.SchemaHandler.GetExName(i), Path: path, Element: e, DataType: dT, DateFormat: dF})
+	}
+	return result, errs
+}
+
+//dataType returns the data type and date format of the column from the logical or converted type in the schema.
+//If neither are available, it is inferred from the physical type
+func dataType(e *pq.SchemaElement) (string, string) {
+	if lT := e.GetLogicalType(); lT != nil {
+		switch {
+		case lT.IsSetDATE(), lT.IsSetTIMESTAMP():
+			return interpreter.DataTypeDate, DateFormat
+		case lT.IsSetDECIMAL():
+			return interpreter.DataTypeFloat, ""
+		case lT.IsSetINTEGER():
+			return interpreter.DataTypeInt, ""
+		case lT.IsSetSTRING(), lT.IsSetENUM(), lT.IsSetJSON(), lT.IsSetUUID(), lT.IsSetTIME(), lT.IsSetBSON():
+			return interpreter.DataTypeString, ""
+		}
+	}
+	if e.ConvertedType != nil {
+		switch e.GetConvertedType() {
+		case pq.ConvertedType_DATE, pq.ConvertedType_TIMESTAMP_MILLIS, pq.ConvertedType_TIMESTAMP_MICROS:
+			return interpreter.DataTypeDate, DateFormat
+		case pq.ConvertedType_DECIMAL:
+			return interpreter.DataTypeFloat, ""
+		case pq.ConvertedType_INT_8, pq.ConvertedType_INT_16, pq.ConvertedType_INT_32, pq.ConvertedType_INT_64,
+			pq.ConvertedType_UINT_8, pq.ConvertedType_UINT_16, pq.ConvertedType_UINT_32, pq.ConvertedType_UINT_64:
+			return interpreter.DataTypeInt, ""
+		default:
+			return interpreter.DataTypeString, ""
+		}
+	}
+	switch e.GetType() {
+	case pq.Type_INT32, pq.Type_INT64:
+		return interpreter.DataTypeInt, ""
+	case pq.Type_INT96:
+		//int96 are the legacy timestamps
+		return interpreter.DataTypeDate, DateFormat
+	case pq.Type_FLOAT, pq.Type_DOUBLE:
+		return interpreter.DataTypeFloat, ""
+	}
+	return interpreter.DataTypeString, ""
+}
+
+//format formats a value read from the column to be written to the csv
+func (c column) format(v interface{}) string {
+	if v == nil {
+		return ""
+	}
+	e := c.Element
+	if c.DataType == interpreter.DataTypeDate {
+		if t, ok := toTime(v, e); ok {
+			return t.Format(DateFormat)
+		}
+	}
+	if lT := e.GetLogicalType(); (lT != nil && lT.IsSetDECIMAL()) || (e.ConvertedType != nil && e.GetConvertedType() == pq.ConvertedType_DECIMAL) {
+		return decimal(v, e.GetScale())
+	}
+	switch t := v.(type) {
+	case float32:
+		return strconv.FormatFloat(float64(t), 'f', -1, 32)
+	case float64:
+		return strconv.FormatFloat(t, 'f', -1, 64)
+	case string:
+		return t
+	}
+	return fmt.Sprint(v)
+}
+
+//toTime converts a date or timestamp value to time
+func toTime(v interface{}, e *pq.SchemaElement) (time.Time, bool) {
+	switch t := v.(type) {
+	case int32:
+		//dates are stored as the no. of days from the unix epoch
+		return time.Unix(int64(t)*24*60*60, 0).UTC(), true
+	case int64:
+		unit := time.Millisecond
+		if lT := e.GetLogicalType(); lT != nil && lT.IsSetTIMESTAMP() && lT.GetTIMESTAMP().Unit != nil {
+			if lT.GetTIMESTAMP().Unit.MICROS != nil {
+				unit = time.Microsecond
+			} else if lT.GetTIMESTAMP().Unit.NANOS != nil {
+				unit = time.Nanosecond
+			}
+		} else if e.ConvertedType != nil && e.GetConvertedType() == pq.ConvertedType_TIMESTAMP_MICROS {
+			unit = time.Microsecond
+		}
+		return time.Unix(0, t*int64(unit)).UTC(), true
+	case string:
+		//int96 timestamps has the nano seconds of the day in the first 8 bytes and the julian day in the last 4 bytes
+		if len(t) != 12 {
+			return time.Time{}, false
+		}
+		nanos := binary.LittleEndian.Uint64([]byte(t[:8]))
+		days := int64(binary.LittleEndian.Uint32([]byte(t[8:])))
+		//2440588 is the julian day of the unix epoch
+		return time.Unix((days-2440588)*24*60*60, int64(nanos)).UTC(), true
+	}
+	return time.Time{}, false
+}
+
+//decimal formats the unscaled decimal value with the given scale
+func decimal(v interface{}, scale int32) string {
+	unscaled := new(big.Int)
+	switch t := v.(type) {
+	case int32:
+		unscaled.SetInt64(int64(t))
+	case int64:
+		unscaled.SetInt64(t)
+	case string:
+		//byte arrays are stored as big endian two's complement
+		b := []byte(t)
+		unscaled.SetBytes(b)
+		if len(b) > 0 && b[0]&0x80 != 0 {
+			unscaled.Sub(unscaled, new(big.Int).Lsh(big.NewInt(1), uint(len(b)*8)))
+		}
+	default:
+		return fmt.Sprint(v)
+	}
+	denom := new(big.Int).Exp(big.NewInt(10), big.NewInt(int64(scale)), nil)
+	return new(big.Rat).SetFrac(unscaled, denom).FloatString(int(scale))
+}
+
+//Validate will validate the parquet file and returns the errors in its schema. Only the footer of the file is read
+func (p *Parquet) Validate(ctx context.Context) ([]error, error) {
+	/*
+	 * We will open the file and read the footer
+	 * Then we will validate the schema
+	 * return the errors if any
+	 */
+	//opening the file
+	pr, closeFn, err := p.open()
+	if err != nil {
+		p.Resource.Status = models.FileUploadStatusInvalid
+		return nil, err
+	}
+	defer closeFn()
+	p.rows = pr.GetNumRows()
+
+	//validating the schema
+	cols, errs := columns(pr)
+	if len(cols) == 0 && len(errs) == 0 {
+		p.Resource.Status = models.FileUploadStatusInvalid
+		return nil, errors.New("couldn't find any columns in the schema of the file")
+	}
+	p.Resource.Status = models.FileUploadStatusValidated
+	if len(errs) == 0 {
+		return nil, nil
+	}
+	return errs, nil
+}
+
+//IdentifyColumns will identify the columns from the schema of the file.
+//If columns are given, their data types are updated with the ones in the schema. Only the footer of the file is read
+func (p *Parquet) IdentifyColumns(ctx context.Context, columnNodes []interpreter.ColumnNode) ([]interpreter.ColumnNode, error) {
+	/*
+	 * We will open the file
+	 * Then we will read the columns from the schema
+	 * If no columns are given we will create them
+	 * Else we will update the data type of the existing columns
+	 */
+	//opening the file
+	pr, closeFn, err := p.open()
+	if err != nil {
+		return nil, err
+	}
+	defer closeFn()
+	p.rows = pr.GetNumRows()
+
+	//reading the columns
+	cols, errs := columns(pr)
+	if len(errs) != 0 {
+		return nil, errs[0]
+	}
+
+	//creating the columns
+	if len(columnNodes) == 0 {
+		for k := range cols {
+			columnNodes = append(columnNodes, interpreter.ColumnNode{
+				UID:  uuid.New().String(),
+				Name: strconv.Itoa(k),
+				Word: []rune(cols[k].Name),
+			})
+		}
+	}
+	colIndex := map[string]int{}
+	for k, col := range cols {
+		colIndex[col.Name] = k
+	}
+
+	//updating the data type
+	for i := range columnNodes {
+		k, ok := colIndex[string(columnNodes[i].Word)]
+		if !ok {
+			return nil, fmt.Errorf("couldn't find the column %s in the file", string(columnNodes[i].Word))
+		}
+		columnNodes[i].DataType, columnNodes[i].DateFormat = cols[k].DataType, cols[k].DateFormat
+		if columnNodes[i].DataType == interpreter.DataTypeInt || columnNodes[i].DataType == interpreter.DataTypeFloat {
+			columnNodes[i].AggregationFn = interpreter.AggregationFnSum
+		} else {
+			columnNodes[i].AggregationFn = interpreter.AggregationFnCount
+		}
+	}
+	return columnNodes, nil
+}
+
+//Upload will attempt to upload the file to the analytics engine and report any error occurred.
+//The row groups are written one by one to a temporary csv file which is dumped to the datastore in one go, so that a cancelled
+//or failed upload never leaves a part of the file in the table. The cancellation is honoured till the dump starts
+func (p *Parquet) Upload(ctx context.Context, a *config.AppContext, table interpreter.TableNode, appendData bool, createTable bool, dataStore services.Service) error {
+	/*
+	 * We will first open the file and order the columns
+	 * Then we will write the row groups one by one to a csv file
+	 * Then we will get the underlying datastore
+	 * Then we will dump the csv file to the datastore
+	 */
+	//opening the file
+	pr, closeFn, err := p.open()
+	if err != nil {
+		//error while opening the file
+		return err
+	}
+	defer closeFn()
+	p.rows = pr.GetNumRows()
+	p.written = 0
+
+	//ordering the columns
+	cols, errs := columns(pr)
+	if len(errs) != 0 {
+		return errs[0]
+	}
+	header := []string{}
+	colIndex := map[string]int{}
+	for k, col := range cols {
+		header = append(header, col.Name)
+		colIndex[col.Name] = k
+	}
+	sortedCols := make([]interpreter.ColumnNode, len(cols))
+	for _, v := range table.Children {
+		sortedCols[colIndex[string(v.Word)]] = v
+	}
+
+	//writing the row groups to the csv file
+	dump := p.Filename + ".csv"
+	defer os.Remove(dump)
+	err = p.writeCSV(ctx, pr, cols, header, dump)
+	if err != nil {
+		//error while writing the row groups to the csv
+		a.Log.Error("error while writing the row groups of", p.Filename, "to csv")
+		return err
+	}
+	//the dump can't be interrupted. So we check for the cancellation before starting it
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	size := int64(0)
+	if info, err := os.Stat(dump); err == nil {
+		size = info.Size()
+	}
+
+	//getting the underlying datastore
+	dS, err := dataStore.Datastore()
+	if err != nil {
+		//error while getting the datastore connection
+		a.Log.Error("error while getting the datastore connection")
+		return err
+	}
+
+	//dumping the csv file
+	err = dS.DumpCSV(dump, table.Name, sortedCols, appendData, createTable, config.DoSCPFileTransfer, a.Log)
+	if err != nil {
+		//error while dumping the csv to the datastore
+		a.Log.Error("error while dumping the row groups of", p.Filename, "to the datastore")
+		return err
+	}
+	p.written = size
+	return nil
+}
+
+//ReadRows reads the rows of the file row group by row group with the values formatted as they are written to the datastore
+func (p Parquet) ReadRows(ctx context.Context, fn func(row int64, record []string) error) error {
+	/*
+	 * We will open the file and read the columns
+	 * Then we will read the row groups one by one
+	 */
+	//opening the file
+	pr, closeFn, err := p.open()
+	if err != nil {
+		return err
+	}
+	defer closeFn()
+	cols, errs := columns(pr)
+	if len(errs) != 0 {
+		return errs[0]
+	}
+	header := []string{}
+	for _, col := range cols {
+		header = append(header, col.Name)
+	}
+	if err := fn(0, header); err != nil {
+		return err
+	}
+
+	//reading the row groups
+	row := int64(1)
+	for _, rg := range pr.Footer.GetRowGroups() {
+		n := rg.GetNumRows()
+		values, err := readValues(ctx, pr, cols, n)
+		if err != nil {
+			return err
+		}
+		for r := int64(0); r < n; r++ {
+			record := make([]string, len(cols))
+			for i, c := range cols {
+				record[i] = c.format(values[i][r])
+			}
+			if err := fn(row, record); err != nil {
+				return err
+			}
+			row++
+		}
+	}
+	return nil
+}
+
+//readValues reads the next n values of the columns. It stops once the context is done
+func readValues(ctx context.Context, pr *reader.ParquetReader, cols []column, n int64) ([][]interface{}, error) {
+	values := make([][]interface{}, len(cols))
+	for i, c := range cols {
+		if n == 0 {
+			break
+		}
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		v, _, _, err := pr.ReadColumnByPath(c.Path, n)
+		if err != nil {
+			return nil, err
+		}
+		if int64(len(v)) != n {
+			return nil, fmt.Errorf("expected %d values in the column %s. Got %d", n, c.Name, len(v))
+		}
+		values[i] = v
+	}
+	return values, nil
+}
+
+//writeCSV writes the row groups of the file one by one to the given csv file. It stops once the context is done
+func (p Parquet) writeCSV(ctx context.Context, pr *reader.ParquetReader, cols []column, header []string, filename string) error {
+	f, err := os.Create(filename)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	w := csv.NewWriter(f)
+	if err := w.Write(header); err != nil {
+		return err
+	}
+	for _, rg := range pr.Footer.GetRowGroups() {
+		err = writeRowGroup(ctx, w, pr, cols, rg.GetNumRows())
+		if err != nil {
+			return err
+		}
+	}
+	w.Flush()
+	if err := w.Error(); err != nil {
+		return err
+	}
+	return f.Close()
+}
+
+//writeRowGroup reads the next n rows from the columns and writes them to the csv writer. It stops once the context is done
+func writeRowGroup(ctx context.Context, w *csv.Writer, pr *reader.ParquetReader, cols []column, n int64) error {
+	values, err := readValues(ctx, pr, cols, n)
+	if err != nil {
+		return err
+	}
+	record := make([]string, len(cols))
+	for r := int64(0); r < n; r++ {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		for i, c := range cols {
+			record[i] = c.format(values[i][r])
+		}
+		if err := w.Write(record); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+//UpdateStatus updates the status of the file upload in db
+func (p *Parquet) UpdateStatus(a *config.AppContext) error {
+	/*
+	 * We will move the file upload to the status set by the validation
+	 */
+	return p.Resource.UpdateStatus(a, p.Resource.Status, "")
+}
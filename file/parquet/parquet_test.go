@@ -0,0 +1,225 @@
+// Copyright 2019 Cuttle.ai. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package parquet_test
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/cuttle-ai/db-toolkit/datastores/services"
+	"github.com/cuttle-ai/file-uploader-service/config"
+	"github.com/cuttle-ai/file-uploader-service/file/parquet"
+	"github.com/cuttle-ai/file-uploader-service/log"
+	"github.com/cuttle-ai/file-uploader-service/models"
+	"github.com/cuttle-ai/octopus/interpreter"
+	"github.com/xitongsys/parquet-go/source"
+	"github.com/xitongsys/parquet-go/writer"
+)
+
+/*
+ * This file contains the tests for reading the values of the parquet files and uploading them
+ */
+
+//testFile implements the source.ParquetFile for writing the parquet files of the tests
+type testFile struct {
+	*os.File
+}
+
+func (t testFile) Open(name string) (source.ParquetFile, error) {
+	f, err := os.Open(name)
+	return testFile{f}, err
+}
+
+func (t testFile) Create(name string) (source.ParquetFile, error) {
+	f, err := os.Create(name)
+	return testFile{f}, err
+}
+
+//writeParquet writes the rows to a parquet file with a row group for every given no. of rows
+func writeParquet(t *testing.T, filename string, obj interface{}, rows []interface{}, rowGroup int) {
+	f, err := os.Create(filename)
+	if err != nil {
+		t.Fatal("couldn't create the parquet file", err)
+	}
+	defer f.Close()
+	pw, err := writer.NewParquetWriter(testFile{f}, obj, 1)
+	if err != nil {
+		t.Fatal("couldn't create the parquet writer", err)
+	}
+	for i, v := range rows {
+		if err := pw.Write(v); err != nil {
+			t.Fatal("couldn't write the row", i, err)
+		}
+		if (i+1)%rowGroup == 0 {
+			if err := pw.Flush(true); err != nil {
+				t.Fatal("couldn't flush the row group", err)
+			}
+		}
+	}
+	if err := pw.WriteStop(); err != nil {
+		t.Fatal("couldn't write the parquet file", err)
+	}
+}
+
+type record struct {
+	ID   int64  `parquet:"name=id, type=INT64"`
+	Name string `parquet:"name=name, type=UTF8, encoding=PLAIN_DICTIONARY"`
+}
+
+//cancelAfter is a context which is cancelled after its error is checked the given no. of times
+type cancelAfter struct {
+	context.Context
+	checks int
+}
+
+func (c *cancelAfter) Err() error {
+	if c.checks <= 0 {
+		return context.Canceled
+	}
+	c.checks--
+	return nil
+}
+
+func TestUploadCancel(t *testing.T) {
+	dir, err := ioutil.TempDir("", "parquet")
+	if err != nil {
+		t.Fatal("couldn't create the temp dir", err)
+	}
+	defer os.RemoveAll(dir)
+	filename := filepath.Join(dir, "records.parquet")
+	rows := []interface{}{}
+	for i := 0; i < 9; i++ {
+		rows = append(rows, record{ID: int64(i), Name: "name"})
+	}
+	writeParquet(t, filename, new(record), rows, 3)
+
+	cancelled, cancel := context.WithCancel(context.Background())
+	cancel()
+	cases := []struct {
+		name   string
+		ctx    context.Context
+		append bool
+	}{
+		{"cancelled before the upload", cancelled, false},
+		{"cancelled in the first row group", &cancelAfter{Context: context.Background(), checks: 2}, false},
+		{"cancelled between the row groups of a new table", &cancelAfter{Context: context.Background(), checks: 5}, false},
+		{"cancelled between the row groups of an append", &cancelAfter{Context: context.Background(), checks: 5}, true},
+		{"cancelled in the last row group", &cancelAfter{Context: context.Background(), checks: 12}, true},
+		{"cancelled after the row groups are written", &cancelAfter{Context: context.Background(), checks: 15}, true},
+	}
+	a := &config.AppContext{Log: log.NewLogger(0)}
+	table := interpreter.TableNode{Children: []interpreter.ColumnNode{{Word: []rune("id")}, {Word: []rune("name")}}}
+	table.Name = "table_test"
+	for i, c := range cases {
+		fs, _ := parquet.New(filename, c.name, models.FileUploadOptions{})
+		f := fs[0].(*parquet.Parquet)
+		//the datastore is never reached as the upload is cancelled before the dump
+		err := f.Upload(c.ctx, a, table, c.append, !c.append, services.Service{})
+		if !errors.Is(err, context.Canceled) {
+			t.Error("test case", i+1, c.name, "expected the upload to be cancelled. got", err)
+		}
+		if f.BytesWritten() != 0 {
+			t.Error("test case", i+1, c.name, "expected no bytes to be written. got", f.BytesWritten())
+		}
+		if _, err := os.Stat(filename + ".csv"); !os.IsNotExist(err) {
+			t.Error("test case", i+1, c.name, "expected the csv of the row groups to be removed. got", err)
+		}
+	}
+}
+
+//typed has the columns of the logical and legacy types converted while reading the parquet files
+type typed struct {
+	Date     int32   `parquet:"name=date, type=DATE"`
+	Millis   int64   `parquet:"name=millis, type=TIMESTAMP_MILLIS"`
+	Micros   int64   `parquet:"name=micros, type=TIMESTAMP_MICROS"`
+	Legacy   string  `parquet:"name=legacy, type=INT96"`
+	Price    int32   `parquet:"name=price, type=DECIMAL, scale=2, precision=9, basetype=INT32"`
+	Amount   int64   `parquet:"name=amount, type=DECIMAL, scale=3, precision=18, basetype=INT64"`
+	Balance  string  `parquet:"name=balance, type=DECIMAL, scale=2, precision=10, basetype=FIXED_LEN_BYTE_ARRAY, length=5"`
+	Total    string  `parquet:"name=total, type=DECIMAL, scale=4, precision=20, basetype=BYTE_ARRAY"`
+	Ratio    float64 `parquet:"name=ratio, type=DOUBLE"`
+	Quantity *int64  `parquet:"name=quantity, type=INT64, repetitiontype=OPTIONAL"`
+}
+
+//int96 returns the legacy int96 timestamp of the nano seconds in the given julian day
+func int96(day uint32, nanos uint64) string {
+	b := make([]byte, 12)
+	binary.LittleEndian.PutUint64(b, nanos)
+	binary.LittleEndian.PutUint32(b[8:], day)
+	return string(b)
+}
+
+func TestReadRows(t *testing.T) {
+	dir, err := ioutil.TempDir("", "parquet")
+	if err != nil {
+		t.Fatal("couldn't create the temp dir", err)
+	}
+	defer os.RemoveAll(dir)
+	filename := filepath.Join(dir, "typed.parquet")
+	quantity := int64(7)
+	rows := []interface{}{
+		//2020-01-01 and the timestamps on the following days. 2458852 is the julian day of 2020-01-03
+		typed{18262, 1577923200000, 1578009600000000, int96(2458852, 23*3600*1e9), 12345, -1234567, "\xff\xff\xff\xcf\xc7", "\x07\x5b\xcd\x15", 0.1, &quantity},
+		//the last milli second of a day, the epoch and the smallest negative values
+		typed{0, 1577923199999, 0, int96(2440588, 0), -5, 0, "\x00\x00\x00\x00\x00", "\xff", -2.5, nil},
+	}
+	writeParquet(t, filename, new(typed), rows, 1)
+
+	cases := []struct {
+		name     string
+		dataType string
+		values   []string
+	}{
+		{"date", interpreter.DataTypeDate, []string{"1/1/2020", "1/1/1970"}},
+		{"millis", interpreter.DataTypeDate, []string{"1/2/2020", "1/1/2020"}},
+		{"micros", interpreter.DataTypeDate, []string{"1/3/2020", "1/1/1970"}},
+		{"legacy", interpreter.DataTypeDate, []string{"1/3/2020", "1/1/1970"}},
+		{"price", interpreter.DataTypeFloat, []string{"123.45", "-0.05"}},
+		{"amount", interpreter.DataTypeFloat, []string{"-1234.567", "0.000"}},
+		{"balance", interpreter.DataTypeFloat, []string{"-123.45", "0.00"}},
+		{"total", interpreter.DataTypeFloat, []string{"12345.6789", "-0.0001"}},
+		{"ratio", interpreter.DataTypeFloat, []string{"0.1", "-2.5"}},
+		{"quantity", interpreter.DataTypeInt, []string{"7", ""}},
+	}
+	fs, _ := parquet.New(filename, "typed", models.FileUploadOptions{})
+	f := fs[0].(*parquet.Parquet)
+	columns, err := f.IdentifyColumns(context.Background(), nil)
+	if err != nil {
+		t.Fatal("couldn't identify the columns", err)
+	}
+	records := [][]string{}
+	err = f.ReadRows(context.Background(), func(row int64, record []string) error {
+		records = append(records, record)
+		return nil
+	})
+	if err != nil {
+		t.Fatal("couldn't read the rows", err)
+	}
+	if len(columns) != len(cases) || len(records) != len(rows)+1 {
+		t.Fatal("expected", len(cases), "columns and", len(rows), "rows with the header. got", len(columns), "columns and", len(records), "rows")
+	}
+	for i, c := range cases {
+		if string(columns[i].Word) != c.name || records[0][i] != c.name {
+			t.Error("test case", i+1, c.name, "expected the column", c.name, "got", string(columns[i].Word), records[0][i])
+			continue
+		}
+		if columns[i].DataType != c.dataType {
+			t.Error("test case", i+1, c.name, "expected the data type", c.dataType, "got", columns[i].DataType)
+		}
+		values := []string{}
+		for _, r := range records[1:] {
+			values = append(values, r[i])
+		}
+		if !reflect.DeepEqual(values, c.values) {
+			t.Error("test case", i+1, c.name, "expected the values", c.values, "got", values)
+		}
+	}
+}
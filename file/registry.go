@@ -0,0 +1,183 @@
+// Copyright 2019 Cuttle.ai. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package file
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"mime"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/cuttle-ai/file-uploader-service/models"
+	"github.com/cuttle-ai/file-uploader-service/models/db"
+)
+
+/*
+ * This file contains the registry of the file formats supported by the platform
+ * Each format package registers itself in its init function. So a format can be added by
+ * creating a package for the same and importing it in the main package
+ */
+
+//HeadSize is the no. of bytes read from the start of a file for sniffing its format
+const HeadSize = 512
+
+//Format is a file format supported by the platform
+type Format struct {
+	//Type is the type of the format stored with the file uploads. It is one of the FileUploadType constants in models
+	Type string
+	//Extensions are the file extensions of the format including the dot
+	Extensions []string
+	//MIMETypes are the mime types of the format
+	MIMETypes []string
+	//Signatures are the magic bytes with which the files of the format start
+	Signatures [][]byte
+	//Detect is optional. It confirms whether the file is of the format from its name and the first few bytes.
+	//If the format has signatures, it is invoked only for the files starting with one of the signatures
+	Detect func(filename string, head []byte) bool
+	//Text indicates that the files of the format are text files. They are transcoded to utf-8 before processing them
+	Text bool
+	//New returns the files to be stored for an uploaded file. A file can have more than one dataset in it like the sheets in a workbook
+	New func(filename string, uploadname string, options models.FileUploadOptions) ([]File, error)
+	//Get returns the file for a file upload stored in db
+	Get func(fileModel db.FileUpload) (File, error)
+}
+
+var (
+	formatsLock = &sync.RWMutex{}
+	formats     = []Format{}
+)
+
+//Register registers a file format. It panics if a format is already registered for the type
+func Register(f Format) {
+	formatsLock.Lock()
+	defer formatsLock.Unlock()
+	for _, v := range formats {
+		if v.Type == f.Type {
+			panic("file format is already registered for the type " + f.Type)
+		}
+	}
+	formats = append(formats, f)
+}
+
+//Formats returns the registered file formats
+func Formats() []Format {
+	formatsLock.RLock()
+	defer formatsLock.RUnlock()
+	return append([]Format{}, formats...)
+}
+
+//GetFormat returns the format registered for the given type
+func GetFormat(fileType string) (Format, bool) {
+	for _, v := range Formats() {
+		if v.Type == fileType {
+			return v, true
+		}
+	}
+	return Format{}, false
+}
+
+//Head returns the first few bytes of the file for sniffing its format
+func Head(filename string) ([]byte, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	head := make([]byte, HeadSize)
+	n, err := io.ReadFull(f, head)
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		return nil, err
+	}
+	return head[:n], nil
+}
+
+//Resolve resolves the format of the file. The format is resolved by the signature of the file first since it can't be mistaken,
+//then by the extension of the file and the mime type given while uploading the file. The formats without a signature are
+//detected from the content of the file only if its name doesn't resolve, like a csv file starting with a bracket is still a csv file
+func Resolve(filename string, mimeType string) (Format, error) {
+	/*
+	 * We will read the head of the file
+	 * Then we will try to resolve by the signatures
+	 * Then we will try to resolve by the name
+	 * Then we will try the detection of the formats without signatures
+	 */
+	//reading the head of the file
+	head, err := Head(filename)
+	if err != nil {
+		return Format{}, err
+	}
+
+	//resolving by the signatures
+	if f, ok := resolveSignature(filename, head); ok {
+		return f, nil
+	}
+
+	//resolving by the name
+	f, err := ResolveName(filename, mimeType)
+	if err == nil {
+		return f, nil
+	}
+
+	//resolving by the detection of the formats without signatures
+	for _, v := range Formats() {
+		if len(v.Signatures) == 0 && v.Detect != nil && v.Detect(filename, head) {
+			return v, nil
+		}
+	}
+	return Format{}, err
+}
+
+//ResolveSignature resolves the format of the file by the signature with which it starts.
+//It returns false if the file doesn't start with the signature of any format
+func ResolveSignature(filename string) (Format, bool, error) {
+	head, err := Head(filename)
+	if err != nil {
+		return Format{}, false, err
+	}
+	f, ok := resolveSignature(filename, head)
+	return f, ok, nil
+}
+
+//resolveSignature resolves the format from the head of the file by the signatures
+func resolveSignature(filename string, head []byte) (Format, bool) {
+	for _, v := range Formats() {
+		for _, s := range v.Signatures {
+			if bytes.HasPrefix(head, s) && (v.Detect == nil || v.Detect(filename, head)) {
+				return v, true
+			}
+		}
+	}
+	return Format{}, false
+}
+
+//ResolveName resolves the format of the file by its extension and then by the mime type given while uploading the file
+func ResolveName(filename string, mimeType string) (Format, error) {
+	fs := Formats()
+
+	//resolving by the extension
+	ext := strings.ToLower(filepath.Ext(filename))
+	for _, v := range fs {
+		for _, e := range v.Extensions {
+			if len(ext) != 0 && ext == e {
+				return v, nil
+			}
+		}
+	}
+
+	//resolving by the mime type
+	mType, _, _ := mime.ParseMediaType(mimeType)
+	for _, v := range fs {
+		for _, m := range v.MIMETypes {
+			if len(mType) != 0 && mType == m {
+				return v, nil
+			}
+		}
+	}
+	return Format{}, fmt.Errorf("unidentified file format for %s", filepath.Base(filename))
+}
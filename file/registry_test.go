@@ -0,0 +1,60 @@
+// Copyright 2019 Cuttle.ai. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package file_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/cuttle-ai/file-uploader-service/file"
+	_ "github.com/cuttle-ai/file-uploader-service/file/csv"
+	_ "github.com/cuttle-ai/file-uploader-service/file/json"
+	_ "github.com/cuttle-ai/file-uploader-service/file/parquet"
+	"github.com/cuttle-ai/file-uploader-service/models"
+)
+
+/*
+ * This file contains the tests for resolving the format of the files
+ */
+
+func TestResolve(t *testing.T) {
+	dir, err := ioutil.TempDir("", "registry")
+	if err != nil {
+		t.Fatal("couldn't create the temp dir", err)
+	}
+	defer os.RemoveAll(dir)
+
+	cases := []struct {
+		name     string
+		filename string
+		content  string
+		mimeType string
+		fileType string
+		fail     bool
+	}{
+		{"csv", "sales.csv", "id,name\n1,apple\n", "", models.FileUploadTypeCSV, false},
+		{"csv starting with a bracket", "sales.csv", "[id],name\n1,apple\n", "", models.FileUploadTypeCSV, false},
+		{"csv starting with a brace", "sales.txt", "{id},name\n1,apple\n", "application/json", models.FileUploadTypeCSV, false},
+		{"json", "sales.json", "[{\"id\":1}]", "", models.FileUploadTypeJSON, false},
+		{"json by the mime type", "sales", "id\n1\n", "application/json", models.FileUploadTypeJSON, false},
+		{"json without an extension", "sales", "{\"id\":1}\n", "", models.FileUploadTypeJSON, false},
+		{"json with an unknown extension", "sales.dat", "\xef\xbb\xbf [{\"id\":1}]", "", models.FileUploadTypeJSON, false},
+		{"parquet with a csv extension", "sales.csv", "PAR1 rest of the file", "", models.FileUploadTypeParquet, false},
+		{"unidentified", "sales.dat", "id,name\n1,apple\n", "", "", true},
+	}
+	for i, c := range cases {
+		filename := filepath.Join(dir, c.filename)
+		if err := ioutil.WriteFile(filename, []byte(c.content), 0644); err != nil {
+			t.Fatal("couldn't write the file", err)
+		}
+		f, err := file.Resolve(filename, c.mimeType)
+		if (err != nil) != c.fail || f.Type != c.fileType {
+			t.Error("test case", i+1, c.name, "expected the format", c.fileType, "and failure as", c.fail, "got", f.Type, err)
+		}
+		os.Remove(filename)
+	}
+}
@@ -0,0 +1,321 @@
+// Copyright 2019 Cuttle.ai. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+//Package remote has the utilities to download the files published over http(s) so that they can be imported as datasets.
+//Downloads are bounded by a max size and the deadline of the context passed
+package remote
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"syscall"
+	"time"
+)
+
+//ErrTooLarge is returned when the remote file is larger than the max size allowed
+var ErrTooLarge = errors.New("remote file is larger than the allowed size")
+
+//ErrNotModified is returned when the remote file hasn't changed since the etag or the last modified time given in the request
+var ErrNotModified = errors.New("remote file is not modified")
+
+//ErrForbiddenAddress is returned when the remote file is hosted at a loopback, private, link local or other internal address
+var ErrForbiddenAddress = errors.New("remote file is hosted at an internal address which can't be accessed")
+
+//ErrTooManyRedirects is returned when the remote server redirects more than MaxRedirects times
+var ErrTooManyRedirects = errors.New("remote server redirected too many times")
+
+//MaxRedirects is the max no. of redirects followed while downloading a remote file
+const MaxRedirects = 5
+
+//DefaultFilename is the name of the file used when the name couldn't be found from the response or the url
+const DefaultFilename = "download"
+
+//ProgressSteps is the no. of times the progress is reported during a download if the size of the file is known
+const ProgressSteps = 10
+
+//ProgressBytes is the no. of bytes after which the progress is reported during a download if the size of the file is not known
+const ProgressBytes = 10 << 20
+
+//Request is a request to download a remote file
+type Request struct {
+	//URL is the url of the file. Only http and https urls are supported
+	URL string
+	//Headers are the headers to be sent with the request like the authorization headers
+	Headers map[string]string
+	//MaxSize is the maximum size of the file in bytes. If zero, there is no limit
+	MaxSize int64
+	//Progress is optional. It is invoked with the no. of bytes downloaded and the total size as the download progresses.
+	//Total is -1 if the size is not known
+	Progress func(downloaded int64, total int64)
+	//ETag is optional. It is the etag of the file from a previous download. If the file has the same etag, ErrNotModified is returned
+	ETag string
+	//LastModified is optional. It is the last modified time of the file from a previous download.
+	//If the file hasn't been modified since then, ErrNotModified is returned
+	LastModified string
+	//AllowPrivate allows downloading the files hosted at the loopback, private and link local addresses.
+	//It has to be enabled only for the deployments importing files from the servers in their private network
+	AllowPrivate bool
+}
+
+//Result has the info about a downloaded file
+type Result struct {
+	//Filename is the name of the file as per the response or the url
+	Filename string
+	//MimeType is the content type of the file as per the response
+	MimeType string
+	//Checksum is the hex encoded sha256 checksum of the file
+	Checksum string
+	//Size is the size of the file in bytes
+	Size int64
+	//ETag is the etag of the file as per the response
+	ETag string
+	//LastModified is the last modified time of the file as per the response
+	LastModified string
+}
+
+//Validate validates the url of the request. Urls having the ip of an internal address as the host are not valid unless they are allowed in the request
+func (r Request) Validate() error {
+	u, err := url.Parse(r.URL)
+	if err != nil {
+		return err
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return fmt.Errorf("unsupported scheme %s in the url. Only http and https are supported", u.Scheme)
+	}
+	if len(u.Host) == 0 {
+		return errors.New("host is missing in the url")
+	}
+	if ip := net.ParseIP(u.Hostname()); ip != nil && !r.AllowPrivate && forbidden(ip) {
+		return ErrForbiddenAddress
+	}
+	return nil
+}
+
+//forbiddenNetworks are the networks internal to the deployment which can't be accessed while downloading the remote files
+var forbiddenNetworks = parseNetworks(
+	"0.0.0.0/8",
+	"10.0.0.0/8",
+	"100.64.0.0/10",
+	"127.0.0.0/8",
+	"169.254.0.0/16",
+	"172.16.0.0/12",
+	"192.0.0.0/24",
+	"192.168.0.0/16",
+	"198.18.0.0/15",
+	"224.0.0.0/4",
+	"240.0.0.0/4",
+	"::/128",
+	"::1/128",
+	"fc00::/7",
+	"fe80::/10",
+	"ff00::/8",
+)
+
+//parseNetworks parses the networks in cidr notation
+func parseNetworks(cidrs ...string) []*net.IPNet {
+	result := []*net.IPNet{}
+	for _, v := range cidrs {
+		_, n, err := net.ParseCIDR(v)
+		if err != nil {
+			panic("invalid network " + v)
+		}
+		result = append(result, n)
+	}
+	return result
+}
+
+//forbidden returns true if the ip is in one of the forbidden networks
+func forbidden(ip net.IP) bool {
+	for _, v := range forbiddenNetworks {
+		if v.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+//client returns the http client with which the file of the request is downloaded.
+//The addresses are checked after the host is resolved at every connection so that a host can't resolve to an internal address,
+//and the redirects are checked the same way as the url of the request
+func (r Request) client() *http.Client {
+	dialer := &net.Dialer{
+		Timeout:   30 * time.Second,
+		KeepAlive: 30 * time.Second,
+		Control: func(network, address string, c syscall.RawConn) error {
+			if r.AllowPrivate {
+				return nil
+			}
+			host, _, err := net.SplitHostPort(address)
+			if err != nil {
+				return err
+			}
+			ip := net.ParseIP(host)
+			if ip == nil || forbidden(ip) {
+				return ErrForbiddenAddress
+			}
+			return nil
+		},
+	}
+	return &http.Client{
+		//the proxies of the environment are not used since the address of the proxy would be checked instead of the remote server
+		Transport: &http.Transport{
+			DialContext:           dialer.DialContext,
+			MaxIdleConns:          10,
+			IdleConnTimeout:       90 * time.Second,
+			TLSHandshakeTimeout:   10 * time.Second,
+			ExpectContinueTimeout: 1 * time.Second,
+		},
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			if len(via) > MaxRedirects {
+				return ErrTooManyRedirects
+			}
+			redirect := r
+			redirect.URL = req.URL.String()
+			return redirect.Validate()
+		},
+	}
+}
+
+//Download downloads the file as per the request to the given location. The file is written to a temporary
+//file next to the location and moved to the location only if the whole file could be downloaded.
+//ErrForbiddenAddress is returned if the url or any of its redirects is hosted at an internal address unless they are allowed in the request.
+//ErrTooLarge is returned as soon as the file exceeds the max size.
+//If the etag or the last modified time is given in the request, the file is downloaded only if it has changed since then
+func Download(ctx context.Context, r Request, location string) (Result, error) {
+	/*
+	 * We will validate the request
+	 * Then we will send the request
+	 * Then we will check the response status and size
+	 * Then we will stream the response to the temporary file
+	 * Then we will move the file to the location
+	 */
+	//validating the request
+	result := Result{}
+	err := r.Validate()
+	if err != nil {
+		return result, err
+	}
+
+	//sending the request
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, r.URL, nil)
+	if err != nil {
+		return result, err
+	}
+	for k, v := range r.Headers {
+		req.Header.Set(k, v)
+	}
+	if len(r.ETag) != 0 {
+		req.Header.Set("If-None-Match", r.ETag)
+	}
+	if len(r.LastModified) != 0 {
+		req.Header.Set("If-Modified-Since", r.LastModified)
+	}
+	client := r.client()
+	defer client.CloseIdleConnections()
+	res, err := client.Do(req)
+	if err != nil {
+		return result, err
+	}
+	defer res.Body.Close()
+
+	//checking the response status and size
+	if res.StatusCode == http.StatusNotModified {
+		return result, ErrNotModified
+	}
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		return result, fmt.Errorf("unexpected response status %s from the remote server", res.Status)
+	}
+	if r.MaxSize > 0 && res.ContentLength > r.MaxSize {
+		return result, ErrTooLarge
+	}
+	result.Filename = filename(res)
+	result.MimeType = res.Header.Get("Content-Type")
+	result.ETag = res.Header.Get("ETag")
+	result.LastModified = res.Header.Get("Last-Modified")
+
+	//streaming the response
+	tmp := location + ".part"
+	f, err := os.Create(tmp)
+	if err != nil {
+		return result, err
+	}
+	h := sha256.New()
+	var src io.Reader = res.Body
+	if r.MaxSize > 0 {
+		src = io.LimitReader(src, r.MaxSize+1)
+	}
+	var dst io.Writer = io.MultiWriter(f, h)
+	if r.Progress != nil {
+		dst = io.MultiWriter(dst, &progress{total: res.ContentLength, fn: r.Progress})
+	}
+	n, err := io.Copy(dst, src)
+	cErr := f.Close()
+	if err == nil {
+		err = cErr
+	}
+	if err == nil && r.MaxSize > 0 && n > r.MaxSize {
+		err = ErrTooLarge
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return result, err
+	}
+
+	//moving the file
+	err = os.Rename(tmp, location)
+	if err != nil {
+		os.Remove(tmp)
+		return result, err
+	}
+	result.Checksum = hex.EncodeToString(h.Sum(nil))
+	result.Size = n
+	return result, nil
+}
+
+//filename returns the name of the downloaded file from the content disposition header of the response.
+//If not found, the last element in the path of the url is used
+func filename(res *http.Response) string {
+	if _, params, err := mime.ParseMediaType(res.Header.Get("Content-Disposition")); err == nil {
+		if name := filepath.Base(params["filename"]); len(params["filename"]) != 0 && name != "." && name != string(filepath.Separator) {
+			return name
+		}
+	}
+	name := path.Base(res.Request.URL.Path)
+	if name == "." || name == "/" || len(name) == 0 {
+		return DefaultFilename
+	}
+	return name
+}
+
+//progress reports the progress of the download in steps
+type progress struct {
+	total      int64
+	downloaded int64
+	reported   int64
+	fn         func(downloaded int64, total int64)
+}
+
+func (p *progress) Write(b []byte) (int, error) {
+	p.downloaded += int64(len(b))
+	step := int64(ProgressBytes)
+	if p.total > 0 {
+		step = p.total / ProgressSteps
+	}
+	if p.downloaded-p.reported >= step {
+		p.reported = p.downloaded
+		p.fn(p.downloaded, p.total)
+	}
+	return len(b), nil
+}
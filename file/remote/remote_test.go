@@ -0,0 +1,168 @@
+// Copyright 2019 Cuttle.ai. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package remote_test
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/cuttle-ai/file-uploader-service/file/remote"
+)
+
+/*
+ * This file contains the tests for downloading the remote files and the checks on the addresses of the remote servers
+ */
+
+const content = "name,age\nalice,30\nbob,40\n"
+
+//checksum is the sha256 checksum of the content
+const checksum = "20db4a52beced41209ee43a4cfc75b57f00c45fcff4e019bf926dd6e87d0b724"
+
+func newServer() *httptest.Server {
+	m := http.NewServeMux()
+	m.HandleFunc("/data/people.csv", func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer token" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		w.Header().Set("Content-Type", "text/csv")
+		w.Write([]byte(content))
+	})
+	m.HandleFunc("/versioned.csv", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("ETag", `"v1"`)
+		if r.Header.Get("If-None-Match") == `"v1"` {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		w.Write([]byte(content))
+	})
+	m.HandleFunc("/export", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Disposition", `attachment; filename="report.csv"`)
+		w.Write([]byte(content))
+	})
+	m.HandleFunc("/redirect", func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, r.URL.Query().Get("to"), http.StatusFound)
+	})
+	m.HandleFunc("/loop", func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, "/loop", http.StatusFound)
+	})
+	return httptest.NewServer(m)
+}
+
+func TestDownload(t *testing.T) {
+	s := newServer()
+	defer s.Close()
+	dir, err := ioutil.TempDir("", "remote")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	cases := []struct {
+		name     string
+		req      remote.Request
+		filename string
+		etag     string
+		fail     bool
+		err      error
+	}{
+		{"with headers", remote.Request{URL: s.URL + "/data/people.csv", Headers: map[string]string{"Authorization": "Bearer token"}}, "people.csv", "", false, nil},
+		{"content disposition", remote.Request{URL: s.URL + "/export"}, "report.csv", "", false, nil},
+		{"modified", remote.Request{URL: s.URL + "/versioned.csv", ETag: `"v0"`}, "versioned.csv", `"v1"`, false, nil},
+		{"not modified", remote.Request{URL: s.URL + "/versioned.csv", ETag: `"v1"`}, "", "", true, remote.ErrNotModified},
+		{"unauthorized", remote.Request{URL: s.URL + "/data/people.csv"}, "", "", true, nil},
+		{"not found", remote.Request{URL: s.URL + "/missing.csv"}, "", "", true, nil},
+		{"too large", remote.Request{URL: s.URL + "/export", MaxSize: 10}, "", "", true, remote.ErrTooLarge},
+		{"unsupported scheme", remote.Request{URL: "ftp://example.com/data.csv"}, "", "", true, nil},
+	}
+	for i, c := range cases {
+		location := filepath.Join(dir, c.name)
+		//the test server listens on the loopback address
+		c.req.AllowPrivate = true
+		progressed := false
+		c.req.Progress = func(downloaded int64, total int64) {
+			progressed = true
+		}
+		res, err := remote.Download(context.Background(), c.req, location)
+		if c.fail {
+			if err == nil {
+				t.Errorf("case %d %s: expected an error", i, c.name)
+			}
+			if c.err != nil && err != c.err {
+				t.Errorf("case %d %s: expected %v, got %v", i, c.name, c.err, err)
+			}
+			if _, sErr := os.Stat(location); !os.IsNotExist(sErr) {
+				t.Errorf("case %d %s: file shouldn't exist after a failed download", i, c.name)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("case %d %s: unexpected error %v", i, c.name, err)
+			continue
+		}
+		b, _ := ioutil.ReadFile(location)
+		if string(b) != content || res.Size != int64(len(content)) {
+			t.Errorf("case %d %s: downloaded content doesn't match. Got %d bytes", i, c.name, res.Size)
+		}
+		if res.Filename != c.filename {
+			t.Errorf("case %d %s: expected the filename %s, got %s", i, c.name, c.filename, res.Filename)
+		}
+		if res.ETag != c.etag {
+			t.Errorf("case %d %s: expected the etag %s, got %s", i, c.name, c.etag, res.ETag)
+		}
+		if res.Checksum != checksum {
+			t.Errorf("case %d %s: expected the checksum %s, got %s", i, c.name, checksum, res.Checksum)
+		}
+		if !progressed {
+			t.Errorf("case %d %s: progress was not reported", i, c.name)
+		}
+	}
+}
+
+func TestForbiddenAddresses(t *testing.T) {
+	s := newServer()
+	defer s.Close()
+	dir, err := ioutil.TempDir("", "remote")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	cases := []struct {
+		name string
+		req  remote.Request
+		err  error
+	}{
+		{"loopback", remote.Request{URL: s.URL + "/export"}, remote.ErrForbiddenAddress},
+		{"localhost", remote.Request{URL: strings.Replace(s.URL, "127.0.0.1", "localhost", 1) + "/export"}, remote.ErrForbiddenAddress},
+		{"metadata", remote.Request{URL: "http://169.254.169.254/latest/meta-data/"}, remote.ErrForbiddenAddress},
+		{"private", remote.Request{URL: "http://10.0.0.1/data.csv"}, remote.ErrForbiddenAddress},
+		{"ipv6 loopback", remote.Request{URL: "http://[::1]/data.csv"}, remote.ErrForbiddenAddress},
+		{"mapped loopback", remote.Request{URL: "http://[::ffff:127.0.0.1]/data.csv"}, remote.ErrForbiddenAddress},
+		{"redirect to unsupported scheme", remote.Request{URL: s.URL + "/redirect?to=ftp://example.com/data.csv", AllowPrivate: true}, nil},
+		{"too many redirects", remote.Request{URL: s.URL + "/loop", AllowPrivate: true}, remote.ErrTooManyRedirects},
+	}
+	for i, c := range cases {
+		location := filepath.Join(dir, c.name)
+		_, err := remote.Download(context.Background(), c.req, location)
+		if err == nil {
+			t.Errorf("case %d %s: expected an error", i, c.name)
+			continue
+		}
+		if c.err != nil && !errors.Is(err, c.err) {
+			t.Errorf("case %d %s: expected %v, got %v", i, c.name, c.err, err)
+		}
+		if _, sErr := os.Stat(location); !os.IsNotExist(sErr) {
+			t.Errorf("case %d %s: file shouldn't exist after a failed download", i, c.name)
+		}
+	}
+}
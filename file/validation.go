@@ -0,0 +1,181 @@
+// Copyright 2019 Cuttle.ai. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package file
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/cuttle-ai/file-uploader-service/models"
+)
+
+//SnippetLength is the max no. of characters of the offending value kept with a validation error
+const SnippetLength = 100
+
+//ValidationError is an error found in a file while validating it along with its position in the file.
+//The file formats return them from the Validate method so that the users can be pointed at the failing rows
+type ValidationError struct {
+	//Row is the no. of the data row or record starting from 1. Zero if the error is not in a row like an error in the schema
+	Row int64
+	//Line is the line in the file at which the error occurred. Zero if not known
+	Line int64
+	//Column is the index of the column starting from 1. Zero if not known
+	Column int
+	//ColumnName is the name of the column. Empty if not known
+	ColumnName string
+	//Code is the code of the error. It is one of the models.FileUploadErrorCode constants
+	Code string
+	//Value is a snippet of the offending value
+	Value string
+	//Severity is the severity of the error. It is one of the models.FileUploadErrorSeverity constants. Errors are assumed if empty
+	Severity string
+	//Message is the description of the error
+	Message string
+}
+
+//Error returns the description of the error
+func (v ValidationError) Error() string {
+	return v.Message
+}
+
+//RowError returns a validation error in the given row with the message formatted as per the format
+func RowError(code string, row int64, format string, a ...interface{}) ValidationError {
+	return ValidationError{Row: row, Code: code, Severity: models.FileUploadErrorSeverityError, Message: fmt.Sprintf(format, a...)}
+}
+
+//Snippet returns the value truncated to SnippetLength characters
+func Snippet(value string) string {
+	r := []rune(value)
+	if len(r) <= SnippetLength {
+		return value
+	}
+	return string(r[:SnippetLength]) + "..."
+}
+
+//UploadError returns the error record of the file upload for the error found while validating it.
+//The position of the error in the file is filled if the error is a ValidationError
+func UploadError(fileUploadID uint, err error) models.FileUploadError {
+	result := models.FileUploadError{FileUploadID: fileUploadID, Error: err.Error(), Code: models.FileUploadErrorCodeUnknown, Severity: models.FileUploadErrorSeverityError}
+	var v ValidationError
+	if !errors.As(err, &v) {
+		return result
+	}
+	result.Row = v.Row
+	result.Line = v.Line
+	result.Column = v.Column
+	result.ColumnName = v.ColumnName
+	result.Value = Snippet(v.Value)
+	if len(v.Code) != 0 {
+		result.Code = v.Code
+	}
+	if len(v.Severity) != 0 {
+		result.Severity = v.Severity
+	}
+	return result
+}
+
+//ErrorPolicy returns the error policy of the upload. Strict is returned if the policy is not given
+func ErrorPolicy(options models.FileUploadOptions) string {
+	if len(options.ErrorPolicy) == 0 {
+		return models.FileUploadErrorPolicyStrict
+	}
+	return strings.ToUpper(options.ErrorPolicy)
+}
+
+//CheckErrorPolicy returns error if the error policy of the upload or its limits are not valid
+func CheckErrorPolicy(options models.FileUploadOptions) error {
+	switch ErrorPolicy(options) {
+	case models.FileUploadErrorPolicyStrict, models.FileUploadErrorPolicySkip, models.FileUploadErrorPolicyCoerce:
+	default:
+		return fmt.Errorf("unsupported error policy %s. it has to be one of %s, %s or %s", options.ErrorPolicy,
+			models.FileUploadErrorPolicyStrict, models.FileUploadErrorPolicySkip, models.FileUploadErrorPolicyCoerce)
+	}
+	if options.MaxErrors < 0 {
+		return fmt.Errorf("max errors %d can't be negative", options.MaxErrors)
+	}
+	if options.MaxErrorPercent < 0 || options.MaxErrorPercent > 100 {
+		return fmt.Errorf("max error percent %v has to be between 0 and 100", options.MaxErrorPercent)
+	}
+	return nil
+}
+
+//Tolerates returns the no. of rows left out and coerced if the errors found while validating the file can be tolerated as per the error policy of the upload.
+//The errors are tolerated only if the policy is not strict, the file implements Tolerant, all the errors are in the data rows
+//and the no. of rows with errors is within the limits of the policy
+func Tolerates(f File, options models.FileUploadOptions, errs []error) (skipped int64, coerced int64, ok bool) {
+	/*
+	 * If there are no errors, there is nothing to tolerate
+	 * We will check whether the policy and the file allows the errors to be tolerated
+	 * Then we will make sure that all the errors are in the data rows
+	 * Then we will check the limits of the policy
+	 */
+	//no errors to tolerate
+	if len(errs) == 0 {
+		return 0, 0, true
+	}
+
+	//checking the policy and the file
+	t, isTolerant := f.(Tolerant)
+	if ErrorPolicy(options) == models.FileUploadErrorPolicyStrict || !isTolerant {
+		return 0, 0, false
+	}
+	skipped, coerced, ok = t.Tolerated()
+	if !ok {
+		return 0, 0, false
+	}
+
+	//the errors not in the data rows like the ones in the header can't be tolerated
+	for _, err := range errs {
+		var v ValidationError
+		if !errors.As(err, &v) || v.Row == 0 {
+			return 0, 0, false
+		}
+	}
+
+	//checking the limits
+	n := skipped + coerced
+	if options.MaxErrors > 0 && n > options.MaxErrors {
+		return 0, 0, false
+	}
+	if options.MaxErrorPercent > 0 {
+		rows := int64(0)
+		if r, isCounter := f.(RowCounter); isCounter {
+			rows = r.Rows()
+		}
+		if rows == 0 || float64(n)*100/float64(rows) > options.MaxErrorPercent {
+			return 0, 0, false
+		}
+	}
+	return skipped, coerced, true
+}
+
+//RejectedRows returns the rows of the errors along with the columns having the errors in them. The errors not in a data row are ignored
+func RejectedRows(errs []error) map[int64][]int {
+	result := map[int64][]int{}
+	for _, err := range errs {
+		var v ValidationError
+		if !errors.As(err, &v) || v.Row == 0 {
+			continue
+		}
+		if _, ok := result[v.Row]; !ok {
+			result[v.Row] = []int{}
+		}
+		if v.Column != 0 {
+			result[v.Row] = append(result[v.Row], v.Column)
+		}
+	}
+	return result
+}
+
+//BlankColumns sets the values of the given columns of the record to empty so that they are loaded as null. The columns start from 1
+func BlankColumns(record []string, columns []int) []string {
+	for _, c := range columns {
+		if c > 0 && c <= len(record) {
+			record[c-1] = ""
+		}
+	}
+	return record
+}
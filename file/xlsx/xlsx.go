@@ -0,0 +1,397 @@
+// Copyright 2019 Cuttle.ai. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+//Package xlsx has the implementation of the file interface for excel workbooks.
+//Each sheet in the workbook is treated as a separate dataset. The sheet is converted to a csv file
+//after validation and the csv implementation is used for identifying the columns and uploading the data
+package xlsx
+
+import (
+	"archive/zip"
+	"context"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	brainModels "github.com/cuttle-ai/brain/models"
+	"github.com/cuttle-ai/db-toolkit/datastores/services"
+	"github.com/cuttle-ai/file-uploader-service/config"
+	"github.com/cuttle-ai/file-uploader-service/file"
+	fCSV "github.com/cuttle-ai/file-uploader-service/file/csv"
+	"github.com/cuttle-ai/file-uploader-service/models"
+	"github.com/cuttle-ai/file-uploader-service/models/db"
+	"github.com/cuttle-ai/octopus/interpreter"
+	"github.com/tealeg/xlsx"
+)
+
+//DateFormat is the format in which the date cells in the sheet are written to the converted csv file
+const DateFormat = "1/2/2006"
+
+//XLSX handles the files of xlsx type
+type XLSX struct {
+	//Filename is the name of the file
+	Filename string
+	//Name is the dataset name
+	Name string
+	//Sheet is the name of the sheet in the workbook to be used as the dataset
+	Sheet string
+	//Options are the options with which the file has to be parsed
+	Options models.FileUploadOptions
+	//Resource holds the db instance of the underlying file
+	Resource db.FileUpload
+	//Table is the underlying octopus table node
+	Table *interpreter.TableNode
+	//rows is the no. of data rows read from the sheet
+	rows int64
+	//written is the no. of bytes dumped to the datastore by the last upload
+	written int64
+	//skipped is the no. of rows left out by the last validation as per the error policy
+	skipped int64
+	//coerced is the no. of rows coerced by the last validation as per the error policy
+	coerced int64
+	//rejected are the rows found invalid after the validation with the columns having the errors. They are left out or coerced while converting
+	rejected map[int64][]int
+}
+
+func init() {
+	file.Register(file.Format{
+		Type:       models.FileUploadTypeXLSX,
+		Extensions: []string{".xlsx"},
+		MIMETypes:  []string{"application/vnd.openxmlformats-officedocument.spreadsheetml.sheet"},
+		Signatures: [][]byte{[]byte("PK\x03\x04")},
+		Detect:     Detect,
+		New:        New,
+		Get:        Get,
+	})
+}
+
+//Detect returns true if the zip file is an excel workbook. Excel workbooks have the workbook part in the xl directory
+func Detect(filename string, head []byte) bool {
+	zr, err := zip.OpenReader(filename)
+	if err != nil {
+		return false
+	}
+	defer zr.Close()
+	for _, v := range zr.File {
+		if v.Name == "xl/workbook.xml" {
+			return true
+		}
+	}
+	return false
+}
+
+//New returns the files to be stored for the uploaded workbook, one for each non empty sheet.
+//If there are more than one sheet, the sheet name is added to the dataset name
+func New(filename string, uploadname string, options models.FileUploadOptions) ([]file.File, error) {
+	sheets, err := Sheets(filename)
+	if err != nil {
+		return nil, err
+	}
+	if len(sheets) == 0 {
+		return nil, errors.New("couldn't find any sheet with data in the workbook")
+	}
+	result := []file.File{}
+	for _, v := range sheets {
+		name := uploadname
+		if len(sheets) > 1 {
+			name = uploadname + " - " + v
+		}
+		result = append(result, &XLSX{Filename: filename, Name: name, Sheet: v, Options: options})
+	}
+	return result, nil
+}
+
+//Get returns the sheet for the file upload stored in db
+func Get(fileModel db.FileUpload) (file.File, error) {
+	return &XLSX{Filename: fileModel.Location, Sheet: fileModel.Sheet, Options: fileModel.Options, Resource: fileModel}, nil
+}
+
+//Sheets returns the names of the non empty sheets in the given workbook
+func Sheets(filename string) ([]string, error) {
+	wb, err := xlsx.OpenFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	result := []string{}
+	for _, v := range wb.Sheets {
+		if len(v.Rows) == 0 {
+			continue
+		}
+		result = append(result, v.Name)
+	}
+	return result, nil
+}
+
+//ID returns the underlying file's id in db
+func (x XLSX) ID() uint {
+	return x.Resource.ID
+}
+
+//Rows returns the no. of data rows read from the sheet while validating it or identifying its columns
+func (x XLSX) Rows() int64 {
+	return x.rows
+}
+
+//BytesWritten returns the no. of bytes dumped to the datastore by the last upload
+func (x XLSX) BytesWritten() int64 {
+	return x.written
+}
+
+//Tolerated returns the no. of rows left out and coerced while validating the sheet as per the error policy
+func (x XLSX) Tolerated() (int64, int64, bool) {
+	return x.skipped, x.coerced, true
+}
+
+//Reject converts the sheet again leaving out the rows of the errors or setting their values with the errors to null as per the error policy.
+//The rows can't be rejected if the error policy is strict
+func (x *XLSX) Reject(ctx context.Context, errs []error) (bool, error) {
+	policy := file.ErrorPolicy(x.Options)
+	if policy == models.FileUploadErrorPolicyStrict {
+		return false, errors.New("rows of the sheet can't be left out or coerced as the error policy is strict")
+	}
+	x.rejected = file.RejectedRows(errs)
+	_, err := x.Validate(ctx)
+	return policy == models.FileUploadErrorPolicyCoerce, err
+}
+
+//Store stores the xlsx sheet info to database
+func (x *XLSX) Store(a *config.AppContext) (*brainModels.Dataset, error) {
+	/*
+	 * We will store the file upload record along with its dataset
+	 */
+	fileRecord := &models.FileUpload{Name: x.Name, UserID: a.Session.User.ID, Location: x.Filename, Status: models.FileUploadStatusUploaded, Type: models.FileUploadTypeXLSX, Sheet: x.Sheet, Options: x.Options}
+	return db.StoreFileUpload(a, fileRecord)
+}
+
+//csvFilename returns the location of the csv file to which the sheet is converted
+func (x XLSX) csvFilename() string {
+	sheet := strings.Map(func(r rune) rune {
+		if (r >= 'a' && r <= 'z') || (r >= 'A' && r <= 'Z') || (r >= '0' && r <= '9') {
+			return r
+		}
+		return '_'
+	}, x.Sheet)
+	return x.Filename + "_" + sheet + ".csv"
+}
+
+//sheet opens the workbook and returns the sheet of the file
+func (x XLSX) sheet() (*xlsx.Sheet, error) {
+	wb, err := xlsx.OpenFile(x.Filename)
+	if err != nil {
+		return nil, err
+	}
+	s, ok := wb.Sheet[x.Sheet]
+	if !ok {
+		return nil, fmt.Errorf("couldn't find the sheet %s in the workbook", x.Sheet)
+	}
+	return s, nil
+}
+
+//cellValue returns the value of the cell to be written to the csv.
+//Numeric values are written without the display formatting and dates are written in DateFormat
+func cellValue(c *xlsx.Cell, date1904 bool) string {
+	if c == nil {
+		return ""
+	}
+	if c.Type() == xlsx.CellTypeNumeric || c.Type() == xlsx.CellTypeDate {
+		if c.IsTime() {
+			t, err := c.GetTime(date1904)
+			if err == nil {
+				return t.Format(DateFormat)
+			}
+		}
+		return c.Value
+	}
+	return c.String()
+}
+
+//Validate will validate the sheet and converts it to a csv file.
+//It returns the errors existing in the header and rows of the sheet
+func (x *XLSX) Validate(ctx context.Context) ([]error, error) {
+	/*
+	 * We will open the sheet
+	 * Then we will validate the header
+	 * Then we will write the rows to the csv file validating the number of cells in each row
+	 * return the errors if any
+	 */
+	//opening the sheet
+	s, err := x.sheet()
+	if err != nil {
+		x.Resource.Status = models.FileUploadStatusInvalid
+		return nil, err
+	}
+	if len(s.Rows) == 0 {
+		x.Resource.Status = models.FileUploadStatusInvalid
+		return nil, errors.New("couldn't find the header row in the sheet " + x.Sheet)
+	}
+
+	//validating the header
+	date1904 := s.File != nil && s.File.Date1904
+	errorResults := []error{}
+	header := []string{}
+	headerIndex := map[string]int{}
+	for i, c := range s.Rows[0].Cells {
+		h := strings.TrimSpace(cellValue(c, date1904))
+		if len(h) == 0 {
+			vErr := file.RowError(models.FileUploadErrorCodeHeader, 0, "column %d in the header row is empty", i+1)
+			vErr.Line = 1
+			vErr.Column = i + 1
+			errorResults = append(errorResults, vErr)
+		} else if j, ok := headerIndex[h]; ok {
+			vErr := file.RowError(models.FileUploadErrorCodeHeader, 0, "column %d in the header row has the same name %s as column %d", i+1, h, j+1)
+			vErr.Line = 1
+			vErr.Column = i + 1
+			vErr.ColumnName = h
+			vErr.Value = h
+			errorResults = append(errorResults, vErr)
+		}
+		headerIndex[h] = i
+		header = append(header, h)
+	}
+
+	//writing the rows to the csv file
+	rowErrs, err := x.writeCSV(ctx, s, header, date1904)
+	if err != nil {
+		x.Resource.Status = models.FileUploadStatusInvalid
+		return nil, err
+	}
+	errorResults = append(errorResults, rowErrs...)
+	x.rows = int64(len(s.Rows) - 1)
+
+	x.Resource.Status = models.FileUploadStatusValidated
+	if len(errorResults) == 0 {
+		return nil, nil
+	}
+	return errorResults, nil
+}
+
+//ReadRows reads the rows of the sheet with all their cells including the ones beyond the header
+func (x XLSX) ReadRows(ctx context.Context, fn func(row int64, record []string) error) error {
+	s, err := x.sheet()
+	if err != nil {
+		return err
+	}
+	date1904 := s.File != nil && s.File.Date1904
+	for i, r := range s.Rows {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		record := []string{}
+		for _, c := range r.Cells {
+			record = append(record, cellValue(c, date1904))
+		}
+		if err := fn(int64(i), record); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+//writeCSV writes the rows of the sheet to the csv file with the given header. It returns the errors in the rows having more cells than the header.
+//The values beyond the header are dropped and such rows are left out if the error policy is to skip them.
+//The partially written file is removed if the writing fails
+func (x *XLSX) writeCSV(ctx context.Context, s *xlsx.Sheet, header []string, date1904 bool) (errorResults []error, err error) {
+	f, err := os.Create(x.csvFilename())
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		f.Close()
+		if err != nil {
+			os.Remove(x.csvFilename())
+		}
+	}()
+	w := csv.NewWriter(f)
+	if err := w.Write(header); err != nil {
+		return nil, err
+	}
+	policy := file.ErrorPolicy(x.Options)
+	x.skipped = 0
+	x.coerced = 0
+	for i, r := range s.Rows[1:] {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		record := make([]string, len(header))
+		invalid := false
+		for j, c := range r.Cells {
+			v := cellValue(c, date1904)
+			if j >= len(header) {
+				if len(v) != 0 {
+					vErr := file.RowError(models.FileUploadErrorCodeFieldCount, int64(i+1), "row %d has a value in column %d which is beyond the %d columns in the header", i+2, j+1, len(header))
+					vErr.Line = int64(i + 2)
+					vErr.Column = j + 1
+					vErr.Value = v
+					if policy == models.FileUploadErrorPolicyCoerce {
+						vErr.Severity = models.FileUploadErrorSeverityWarning
+					}
+					errorResults = append(errorResults, vErr)
+					invalid = true
+				}
+				continue
+			}
+			record[j] = v
+		}
+		//the rows rejected after the validation like the ones violating the rules
+		if columns, ok := x.rejected[int64(i+1)]; ok {
+			record = file.BlankColumns(record, columns)
+			invalid = true
+		}
+		if invalid && policy == models.FileUploadErrorPolicySkip {
+			x.skipped++
+			continue
+		}
+		if invalid && policy == models.FileUploadErrorPolicyCoerce {
+			x.coerced++
+		}
+		if err := w.Write(record); err != nil {
+			return nil, err
+		}
+	}
+	w.Flush()
+	return errorResults, w.Error()
+}
+
+//converted returns the csv implementation of the converted sheet. If the sheet is not converted yet, it will be converted
+func (x *XLSX) converted(ctx context.Context) (*fCSV.CSV, error) {
+	if _, err := os.Stat(x.csvFilename()); os.IsNotExist(err) {
+		_, err = x.Validate(ctx)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return &fCSV.CSV{Filename: x.csvFilename(), Name: x.Name, Resource: x.Resource, Table: x.Table}, nil
+}
+
+//IdentifyColumns will identify the columns in the sheet
+func (x *XLSX) IdentifyColumns(ctx context.Context, columns []interpreter.ColumnNode) ([]interpreter.ColumnNode, error) {
+	c, err := x.converted(ctx)
+	if err != nil {
+		return nil, err
+	}
+	columns, err = c.IdentifyColumns(ctx, columns)
+	x.rows = c.Rows()
+	return columns, err
+}
+
+//Upload will attempt to upload the sheet to the analytics engine and report any error occurred
+func (x *XLSX) Upload(ctx context.Context, a *config.AppContext, table interpreter.TableNode, appendData bool, createTable bool, dataStore services.Service) error {
+	c, err := x.converted(ctx)
+	if err != nil {
+		return err
+	}
+	err = c.Upload(ctx, a, table, appendData, createTable, dataStore)
+	x.written = c.BytesWritten()
+	return err
+}
+
+//UpdateStatus updates the status of the file upload in db
+func (x *XLSX) UpdateStatus(a *config.AppContext) error {
+	/*
+	 * We will move the file upload to the status set by the validation
+	 */
+	return x.Resource.UpdateStatus(a, x.Resource.Status, "")
+}
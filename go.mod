@@ -1,6 +1,6 @@
 module github.com/cuttle-ai/file-uploader-service
 
-go 1.13
+go 1.14
 
 replace github.com/cuttle-ai/auth-service => ../auth-service/
 
@@ -25,4 +25,9 @@ require (
 	github.com/google/uuid v1.1.1
 	github.com/hashicorp/consul/api v1.4.0
 	github.com/jinzhu/gorm v1.9.12
+	github.com/lib/pq v1.3.0
+	github.com/robfig/cron/v3 v3.0.1
+	github.com/tealeg/xlsx v1.0.5
+	github.com/xitongsys/parquet-go v1.5.2
+	golang.org/x/text v0.3.2
 )
@@ -0,0 +1,500 @@
+// Copyright 2019 Cuttle.ai. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+//Package jobs has the database backed job queue of the service. The background work like the upload pipeline is
+//enqueued as jobs and run by a bounded pool of workers. Since the jobs are persisted, the jobs interrupted by a restart are recovered.
+//While shutting down, the running jobs are drained so that they stop at a checkpoint and are resumed from it by another instance
+package jobs
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	authConfig "github.com/cuttle-ai/auth-service/config"
+	"github.com/cuttle-ai/file-uploader-service/config"
+	"github.com/cuttle-ai/file-uploader-service/models"
+	"github.com/cuttle-ai/file-uploader-service/models/db"
+)
+
+//Handler runs a job. The app context has the session of the user who enqueued the job.
+//The context is cancelled when the cancellation of the job is requested
+type Handler func(ctx context.Context, a *config.AppContext, j *db.Job) error
+
+//ErrNotCancellable is returned while cancelling a job which is neither queued nor running
+var ErrNotCancellable = errors.New("job is neither queued nor running")
+
+//ErrInterrupted is returned by Checkpoint when the service is shutting down
+var ErrInterrupted = errors.New("job was interrupted by the shutdown of the service")
+
+//errDatasetLocked is returned when the dataset of a job is locked by another job or a request replacing the file of the dataset
+var errDatasetLocked = errors.New("dataset is locked")
+
+//cancelGrace is the time given to the jobs cancelled while shutting down to stop before they are queued again
+const cancelGrace = 2 * time.Second
+
+var (
+	handlersLock = &sync.RWMutex{}
+	handlers     = map[string]Handler{}
+)
+
+//runningJob is a job running in this instance
+type runningJob struct {
+	//cancel cancels the context of the job
+	cancel context.CancelFunc
+	//abandoned indicates that the job has been queued again since it didn't stop while shutting down
+	abandoned bool
+}
+
+var (
+	runningLock = &sync.Mutex{}
+	//running has the jobs running in this instance
+	running = map[uint]*runningJob{}
+	//active tracks the workers claiming or running a job
+	active = &sync.WaitGroup{}
+)
+
+var (
+	stopOnce = &sync.Once{}
+	//stopping is closed when the service starts shutting down
+	stopping = make(chan struct{})
+)
+
+//wake is used to wake up an idle worker when a job is enqueued
+var wake = make(chan struct{}, 1)
+
+//jobKey is the key with which the job is stored in the context given to its handler
+type jobKey struct{}
+
+//WithJob returns a copy of the context with the job
+func WithJob(ctx context.Context, j *db.Job) context.Context {
+	return context.WithValue(ctx, jobKey{}, j)
+}
+
+//FromContext returns the job in the context. Nil is returned if the context doesn't have a job
+func FromContext(ctx context.Context) *db.Job {
+	j, _ := ctx.Value(jobKey{}).(*db.Job)
+	return j
+}
+
+//Register registers the handler for a job type. It panics if a handler is already registered for the type
+func Register(jobType string, h Handler) {
+	handlersLock.Lock()
+	defer handlersLock.Unlock()
+	if _, ok := handlers[jobType]; ok {
+		panic("job handler is already registered for the type " + jobType)
+	}
+	handlers[jobType] = h
+}
+
+//getHandler returns the handler registered for the job type
+func getHandler(jobType string) (Handler, bool) {
+	handlersLock.RLock()
+	defer handlersLock.RUnlock()
+	h, ok := handlers[jobType]
+	return h, ok
+}
+
+//Enqueue enqueues the job to be run as the user of the app context. The jobs on a dataset are run one after the other
+func Enqueue(a *config.AppContext, j *db.Job) error {
+	/*
+	 * We will set the user of the job
+	 * Then we will set the dataset of the job so that it is queued behind the other jobs on the dataset
+	 * Then we will create the job
+	 * Then we will wake up an idle worker
+	 */
+	//setting the user
+	j.Status = models.JobStatusQueued
+	if a.Session.User != nil {
+		j.UserID = a.Session.User.ID
+		j.UserType = a.Session.User.UserType
+	}
+	j.SessionID = a.Session.ID
+
+	//setting the dataset
+	err := j.ResolveDataset(a)
+	if err != nil {
+		return err
+	}
+
+	//creating the job
+	err = j.Create(a)
+	if err != nil {
+		return err
+	}
+
+	//waking up an idle worker
+	Wake()
+	return nil
+}
+
+//Wake wakes up an idle worker to claim the queued jobs. The jobs enqueued in a transaction are claimed only after it is committed,
+//so the worker has to be woken up again once the transaction is committed
+func Wake() {
+	select {
+	case wake <- struct{}{}:
+	default:
+	}
+}
+
+//Cancel cancels the job. A queued job is cancelled right away and true is returned.
+//For a running job, the cancellation is requested and the job is cancelled by the worker running it.
+//ErrNotCancellable is returned if the job is neither queued nor running
+func Cancel(a *config.AppContext, j *db.Job) (bool, error) {
+	/*
+	 * We will cancel the job if it is queued
+	 * Else we will request the cancellation of the running job
+	 * If the job is running in this instance, we will cancel it right away
+	 */
+	//cancelling the queued job
+	ok, err := j.CancelQueued(a)
+	if err != nil {
+		return false, err
+	}
+	if ok {
+		return true, nil
+	}
+
+	//requesting the cancellation of the running job
+	ok, err = j.RequestCancel(a)
+	if err != nil {
+		return false, err
+	}
+	if !ok {
+		return false, ErrNotCancellable
+	}
+
+	//cancelling the job running in this instance
+	runningLock.Lock()
+	r, ok := running[j.ID]
+	runningLock.Unlock()
+	if ok {
+		r.cancel()
+	}
+	return false, nil
+}
+
+//isStopping returns true if the service is shutting down
+func isStopping() bool {
+	select {
+	case <-stopping:
+		return true
+	default:
+		return false
+	}
+}
+
+//Checkpoint returns ErrInterrupted if the service is shutting down. Handlers call it before each of their stages
+//so that the job stops and is queued again to be resumed from the stage by another instance
+func Checkpoint(j *db.Job, stage string) error {
+	if !isStopping() {
+		return nil
+	}
+	j.FromStage = stage
+	return ErrInterrupted
+}
+
+//Interrupted returns true if the job stopped with the error since the service is shutting down.
+//It is false if the job failed or stopped since its cancellation was requested
+func Interrupted(a *config.AppContext, j *db.Job, err error) bool {
+	if errors.Is(err, ErrInterrupted) {
+		return true
+	}
+	if !errors.Is(err, context.Canceled) || !isStopping() {
+		return false
+	}
+	requested, rErr := j.IsCancelRequested(a)
+	return rErr == nil && !requested
+}
+
+//Stop stops the workers from claiming the queued jobs and waits for the running jobs to finish or stop at a checkpoint till the context is done.
+//The jobs stopped at a checkpoint are queued again to be resumed from it. When the context is done, the jobs still running are cancelled and
+//the ones which don't stop even then are queued again to be resumed from their current stage. The error of the context is returned in that case
+func Stop(ctx context.Context, a *config.AppContext) error {
+	/*
+	 * We will stop the workers from claiming the jobs
+	 * Then we will wait for the running jobs to stop
+	 * If the context is done, we will cancel the running jobs and give them some time to stop
+	 * Then we will queue the jobs still running again
+	 */
+	//stopping the workers
+	//the lock makes sure that no worker starts claiming a job once we start waiting for them
+	stopOnce.Do(func() {
+		runningLock.Lock()
+		close(stopping)
+		runningLock.Unlock()
+	})
+	done := make(chan struct{})
+	go func() {
+		active.Wait()
+		close(done)
+	}()
+
+	//waiting for the running jobs to stop
+	select {
+	case <-done:
+		a.Log.Info("All the running jobs have stopped")
+		return nil
+	case <-ctx.Done():
+	}
+
+	//cancelling the running jobs
+	runningLock.Lock()
+	a.Log.Warn("Cancelling", len(running), "jobs which didn't stop in time")
+	for _, v := range running {
+		v.cancel()
+	}
+	runningLock.Unlock()
+	select {
+	case <-done:
+		a.Log.Info("All the running jobs have stopped after cancelling")
+		return nil
+	case <-time.After(cancelGrace):
+	}
+
+	//queueing the jobs still running again
+	runningLock.Lock()
+	ids := []uint{}
+	for k, v := range running {
+		v.abandoned = true
+		ids = append(ids, k)
+	}
+	runningLock.Unlock()
+	for _, id := range ids {
+		j := &db.Job{}
+		j.ID = id
+		err := j.Requeue(a, "", ErrInterrupted.Error())
+		if err != nil {
+			//error while queueing the job again
+			a.Log.Error("error while queueing the job", id, "again which didn't stop while shutting down", err)
+			continue
+		}
+		a.Log.Warn("Queued the job", id, "again since it didn't stop while shutting down")
+	}
+	return ctx.Err()
+}
+
+//Start starts the workers and the recovery of the interrupted jobs. The jobs interrupted by the previous run of the service
+//are recovered once their heartbeat goes stale. It returns once Stop is called
+func Start(a *config.AppContext) {
+	/*
+	 * We will start the workers
+	 * Then we will keep recovering the interrupted jobs
+	 */
+	if a.Db == nil {
+		a.Log.Warn("database is not enabled. So not starting the job workers")
+		return
+	}
+
+	//starting the workers
+	for i := 0; i < config.JobWorkers; i++ {
+		go worker(a)
+	}
+
+	//recovering the interrupted jobs
+	for {
+		n, err := db.RecoverJobs(a, time.Now().Add(-3*config.JobHeartbeatInterval), config.JobMaxAttempts)
+		if err != nil {
+			//error while recovering the jobs
+			a.Log.Error("error while recovering the interrupted jobs", err)
+		}
+		if n > 0 {
+			a.Log.Info("Recovered", n, "interrupted jobs")
+			Wake()
+		}
+		select {
+		case <-stopping:
+			return
+		case <-time.After(config.JobHeartbeatInterval):
+		}
+	}
+}
+
+//worker keeps claiming and running the queued jobs till the service starts shutting down.
+//When there are no jobs, it waits for a job to be enqueued or the poll interval
+func worker(a *config.AppContext) {
+	for {
+		runningLock.Lock()
+		if isStopping() {
+			runningLock.Unlock()
+			return
+		}
+		active.Add(1)
+		runningLock.Unlock()
+		j, err := db.ClaimJob(a)
+		if err != nil {
+			//error while claiming the job
+			a.Log.Error("error while claiming a job", err)
+		}
+		if j == nil {
+			active.Done()
+			select {
+			case <-stopping:
+			case <-wake:
+			case <-time.After(config.JobPollInterval):
+			}
+			continue
+		}
+		run(a, j)
+		active.Done()
+	}
+}
+
+//run runs the job with its handler and records the outcome. The heartbeat of the job is updated while it runs
+func run(a *config.AppContext, j *db.Job) {
+	/*
+	 * We will lock the dataset of the job
+	 * We will create the app context for the user of the job
+	 * Then we will start the heartbeat and the checks for the cancellation
+	 * Then we will run the handler
+	 * If the job was interrupted by the shutdown, we will queue it again
+	 * Else we will record the outcome
+	 */
+	//locking the dataset of the job
+	//the dataset is locked in the database so that no other job works on it even from the other instances
+	lock, err := lockDataset(a, j)
+	if errors.Is(err, errDatasetLocked) {
+		//the dataset is being worked on. So the job is tried again after the poll interval
+		a.Log.Info("dataset", j.DatasetID, "of the job", j.ID, "is locked. queueing the job again")
+		if pErr := j.Postpone(a, time.Now().Add(config.JobPollInterval), err.Error()); pErr != nil {
+			a.Log.Error("error while queueing the job", j.ID, "again", pErr)
+		}
+		return
+	}
+	if err != nil {
+		//error while locking the dataset
+		a.Log.Error("error while locking the dataset of the job", j.ID, err)
+		if fErr := j.Finish(a, err); fErr != nil {
+			a.Log.Error("error while recording the outcome of the job", j.ID, fErr)
+		}
+		return
+	}
+	if lock != nil {
+		defer func() {
+			if uErr := lock.Unlock(); uErr != nil {
+				a.Log.Error("error while unlocking the dataset", lock.DatasetID, "of the job", j.ID, uErr)
+			}
+		}()
+	}
+
+	//creating the app context for the user
+	appCtx := config.NewAppContext(a.Log)
+	appCtx.Session = authConfig.Session{
+		ID:            j.SessionID,
+		Authenticated: true,
+		User:          &authConfig.User{ID: j.UserID, UserType: j.UserType},
+	}
+	a.Log.Info("Started running the job", j.ID, "of type", j.Type, "attempt", j.Attempts)
+
+	//starting the heartbeat and the checks for the cancellation
+	//the cancellation can be requested from any instance. So it is checked in the database every poll interval
+	ctx, cancel := context.WithCancel(WithJob(context.Background(), j))
+	runningLock.Lock()
+	running[j.ID] = &runningJob{cancel: cancel}
+	runningLock.Unlock()
+	done := make(chan struct{})
+	hb := &db.Job{}
+	hb.ID = j.ID
+	go func() {
+		t := time.NewTicker(config.JobHeartbeatInterval)
+		defer t.Stop()
+		c := time.NewTicker(config.JobPollInterval)
+		defer c.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-t.C:
+				if err := hb.Heartbeat(a); err != nil {
+					a.Log.Error("error while updating the heartbeat of the job", hb.ID, err)
+				}
+			case <-c.C:
+				ok, err := hb.IsCancelRequested(a)
+				if err != nil {
+					a.Log.Error("error while checking the cancellation of the job", hb.ID, err)
+				}
+				if ok {
+					a.Log.Info("Cancelling the job", hb.ID)
+					cancel()
+				}
+			}
+		}
+	}()
+
+	//running the handler
+	err = handle(ctx, appCtx, j)
+	close(done)
+	runningLock.Lock()
+	abandoned := running[j.ID].abandoned
+	delete(running, j.ID)
+	runningLock.Unlock()
+	cancel()
+	if abandoned {
+		//the job has already been queued again while shutting down
+		return
+	}
+
+	//queueing the job again if it was interrupted by the shutdown
+	if Interrupted(a, j, err) {
+		fromStage := ""
+		if errors.Is(err, ErrInterrupted) {
+			fromStage = j.FromStage
+		}
+		rErr := j.Requeue(a, fromStage, ErrInterrupted.Error())
+		if rErr != nil {
+			//error while queueing the job again
+			a.Log.Error("error while queueing the interrupted job", j.ID, "again", rErr)
+			return
+		}
+		a.Log.Info("Queued the job", j.ID, "again to be resumed since it was interrupted by the shutdown")
+		return
+	}
+
+	//recording the outcome
+	if err != nil {
+		a.Log.Error("error while running the job", j.ID, "of type", j.Type, err)
+	}
+	fErr := j.Finish(a, err)
+	if fErr != nil {
+		//error while recording the outcome of the job
+		a.Log.Error("error while recording the outcome of the job", j.ID, fErr)
+		return
+	}
+	a.Log.Info("Finished running the job", j.ID, "with status", j.Status)
+}
+
+//lockDataset locks the dataset of the job. Nil lock is returned if the job is not on a dataset. errDatasetLocked is returned if the dataset is already locked
+func lockDataset(a *config.AppContext, j *db.Job) (*db.DatasetLock, error) {
+	err := j.ResolveDataset(a)
+	if err != nil {
+		return nil, err
+	}
+	if j.DatasetID == 0 {
+		return nil, nil
+	}
+	lock, ok, err := db.TryLockDataset(a, j.DatasetID)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return nil, errDatasetLocked
+	}
+	return lock, nil
+}
+
+//handle runs the handler of the job. Panics in the handler are returned as errors so that a worker is not lost
+func handle(ctx context.Context, a *config.AppContext, j *db.Job) (err error) {
+	h, ok := getHandler(j.Type)
+	if !ok {
+		return fmt.Errorf("couldn't find the handler for the job type %s", j.Type)
+	}
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("job panicked: %v", r)
+		}
+	}()
+	return h(ctx, a, j)
+}
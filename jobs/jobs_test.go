@@ -0,0 +1,173 @@
+// Copyright 2019 Cuttle.ai. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package jobs_test
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/cuttle-ai/file-uploader-service/config"
+	"github.com/cuttle-ai/file-uploader-service/config/configtest"
+	"github.com/cuttle-ai/file-uploader-service/jobs"
+	"github.com/cuttle-ai/file-uploader-service/models"
+	"github.com/cuttle-ai/file-uploader-service/models/db"
+)
+
+/*
+ * This file contains the integration tests for running the jobs and stopping the workers. They need the database and run only if it is enabled
+ */
+
+//startOnce starts the workers only once for all the tests since they can be stopped only once
+var startOnce sync.Once
+
+//appContext returns the app context for the tests and starts the workers. The test is skipped if the database is not enabled
+func appContext(t *testing.T) *config.AppContext {
+	a := configtest.AppContext(t)
+	startOnce.Do(func() {
+		config.JobWorkers = 2
+		config.JobPollInterval = 100 * time.Millisecond
+		go jobs.Start(a)
+	})
+	return a
+}
+
+//waitForJob waits till the job satisfies the condition and returns the job
+func waitForJob(t *testing.T, a *config.AppContext, id uint, cond func(j *db.Job) bool) *db.Job {
+	deadline := time.Now().Add(10 * time.Second)
+	for {
+		j := &db.Job{}
+		if err := a.Db.Where("id = ?", id).First(j).Error; err != nil {
+			t.Fatal("couldn't get the job", id, err)
+		}
+		if cond(j) {
+			return j
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("job", id, "didn't reach the expected state. got the status", j.Status, "and the error", j.LastError)
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+}
+
+func TestLockedDataset(t *testing.T) {
+	a := appContext(t)
+	var runs int32
+	jobs.Register("TEST_LOCKED_DATASET", func(ctx context.Context, a *config.AppContext, j *db.Job) error {
+		atomic.AddInt32(&runs, 1)
+		return nil
+	})
+	dSet := &db.Dataset{Name: "locked"}
+	if err := a.Db.Create(dSet).Error; err != nil {
+		t.Fatal("couldn't create the dataset", err)
+	}
+	defer a.Db.Unscoped().Delete(dSet)
+
+	//the dataset is locked like by a request replacing its file
+	lock, ok, err := db.TryLockDataset(a, dSet.ID)
+	if err != nil || !ok {
+		t.Fatal("couldn't lock the dataset", ok, err)
+	}
+	j := &db.Job{Type: "TEST_LOCKED_DATASET", DatasetID: dSet.ID}
+	if err := jobs.Enqueue(a, j); err != nil {
+		t.Fatal("couldn't enqueue the job", err)
+	}
+	defer a.Db.Unscoped().Delete(&models.Job{}, j.ID)
+
+	//the job is queued behind the lock without running it or counting the attempt
+	got := waitForJob(t, a, j.ID, func(j *db.Job) bool {
+		return j.Status == models.JobStatusQueued && len(j.LastError) != 0
+	})
+	if atomic.LoadInt32(&runs) != 0 || got.Attempts != 0 {
+		t.Error("expected the job on the locked dataset not to run. got", runs, "runs and", got.Attempts, "attempts")
+	}
+
+	//the job runs once the lock is released
+	if err := lock.Unlock(); err != nil {
+		t.Fatal("couldn't unlock the dataset", err)
+	}
+	waitForJob(t, a, j.ID, func(j *db.Job) bool {
+		return j.Status == models.JobStatusSucceeded
+	})
+	if atomic.LoadInt32(&runs) != 1 {
+		t.Error("expected the job to run once after the dataset is unlocked. got", runs, "runs")
+	}
+}
+
+//TestStop has to be the last test since the workers can be stopped only once
+func TestStop(t *testing.T) {
+	a := appContext(t)
+	started := make(chan struct{}, 2)
+	release := make(chan struct{})
+	defer close(release)
+	jobs.Register("TEST_STOP_CHECKPOINT", func(ctx context.Context, a *config.AppContext, j *db.Job) error {
+		started <- struct{}{}
+		for {
+			if err := jobs.Checkpoint(j, "TEST_CHECKPOINT"); err != nil {
+				return err
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	})
+	jobs.Register("TEST_STOP_STUCK", func(ctx context.Context, a *config.AppContext, j *db.Job) error {
+		//the job neither reaches a checkpoint nor stops once cancelled
+		started <- struct{}{}
+		<-release
+		return nil
+	})
+	checkpointed := &db.Job{Type: "TEST_STOP_CHECKPOINT"}
+	stuck := &db.Job{Type: "TEST_STOP_STUCK", Stage: "TEST_STUCK"}
+	for _, j := range []*db.Job{checkpointed, stuck} {
+		if err := jobs.Enqueue(a, j); err != nil {
+			t.Fatal("couldn't enqueue the job", err)
+		}
+		defer a.Db.Unscoped().Delete(&models.Job{}, j.ID)
+	}
+	for i := 0; i < 2; i++ {
+		select {
+		case <-started:
+		case <-time.After(10 * time.Second):
+			t.Fatal("expected the jobs to be running before stopping")
+		}
+	}
+
+	//the job stopping at a checkpoint is resumed from it and the stuck job is resumed from its current stage
+	ctx, cancel := context.WithTimeout(context.Background(), 500*time.Millisecond)
+	defer cancel()
+	if err := jobs.Stop(ctx, a); !errors.Is(err, context.DeadlineExceeded) {
+		t.Error("expected the stop to time out because of the stuck job. got", err)
+	}
+	cases := []struct {
+		name      string
+		job       *db.Job
+		fromStage string
+	}{
+		{"job stopped at a checkpoint", checkpointed, "TEST_CHECKPOINT"},
+		{"job which didn't stop", stuck, "TEST_STUCK"},
+	}
+	for i, c := range cases {
+		got := waitForJob(t, a, c.job.ID, func(j *db.Job) bool {
+			return j.Status != models.JobStatusRunning
+		})
+		if got.Status != models.JobStatusQueued || got.FromStage != c.fromStage || got.Attempts != 0 || got.LastError != jobs.ErrInterrupted.Error() {
+			t.Error("test case", i+1, c.name, "expected the job to be queued again from", c.fromStage, "without the attempt. got",
+				got.Status, got.FromStage, got.Attempts, got.LastError)
+		}
+	}
+
+	//the queued jobs aren't claimed once stopped
+	queued := &db.Job{Type: "TEST_STOP_CHECKPOINT"}
+	if err := jobs.Enqueue(a, queued); err != nil {
+		t.Fatal("couldn't enqueue the job", err)
+	}
+	defer a.Db.Unscoped().Delete(&models.Job{}, queued.ID)
+	time.Sleep(3 * config.JobPollInterval)
+	if got := waitForJob(t, a, queued.ID, func(j *db.Job) bool { return true }); got.Status != models.JobStatusQueued || got.Attempts != 0 {
+		t.Error("expected the job enqueued after stopping to stay queued. got", got.Status, got.Attempts)
+	}
+}
@@ -10,11 +10,18 @@ import (
 	"net/http"
 	"os"
 	"os/signal"
+	"syscall"
 
 	"github.com/cuttle-ai/file-uploader-service/config"
+	"github.com/cuttle-ai/file-uploader-service/jobs"
 	"github.com/cuttle-ai/file-uploader-service/log"
 	"github.com/cuttle-ai/file-uploader-service/routes"
+	"github.com/cuttle-ai/file-uploader-service/scheduler"
 
+	_ "github.com/cuttle-ai/file-uploader-service/file/csv"
+	_ "github.com/cuttle-ai/file-uploader-service/file/json"
+	_ "github.com/cuttle-ai/file-uploader-service/file/parquet"
+	_ "github.com/cuttle-ai/file-uploader-service/file/xlsx"
 	_ "github.com/cuttle-ai/file-uploader-service/routes/datasets"
 	_ "github.com/cuttle-ai/file-uploader-service/routes/file"
 )
@@ -29,8 +36,12 @@ func main() {
 	 * Create a default server
 	 * Init the routes
 	 * Now listen and serve
+	 * Start the job workers and the refresh scheduler
 	 * Listen to the os signals for exit
 	 * Graceful exit when command comes
+	 * Deregister from the discovery service and stop accepting the requests
+	 * Drain the refreshes and the jobs
+	 * Stop the rpc service
 	 */
 	//creating a new server mux
 	m := http.NewServeMux()
@@ -52,21 +63,56 @@ func main() {
 		log.Info("Starting the server at :" + config.Port)
 		log.Error(s.ListenAndServe())
 	}()
+	log.Info("Starting the rpc service at :" + config.RPCPort)
+	config.StartRPC()
+	jobsCtx := config.NewAppContext(log.NewLogger(0))
 	go func() {
-		log.Info("Starting the rpc service at :" + config.RPCPort)
-		config.StartRPC()
+		log.Info("Starting the job workers")
+		jobs.Start(jobsCtx)
+	}()
+	go func() {
+		log.Info("Starting the refresh scheduler")
+		scheduler.Start(config.NewAppContext(log.NewLogger(0)))
 	}()
 
 	//listening for syscalls
 	var gracefulStop = make(chan os.Signal, 1)
-	signal.Notify(gracefulStop, os.Interrupt)
+	signal.Notify(gracefulStop, os.Interrupt, syscall.SIGTERM)
 	sig := <-gracefulStop
 
 	//gracefulling exiting when request comes in
 	log.Info("Received the interrupt", sig)
+	ctx, cancel := context.WithTimeout(context.Background(), config.ShutdownTimeout)
+	defer cancel()
+
+	//deregistering from the discovery service and stopping the server
+	log.Info("Deregistering from the discovery service")
+	err := config.Deregister()
+	if err != nil {
+		log.Error("Couldn't deregister from the discovery service", err)
+	}
 	log.Info("Shutting down the server")
-	err := s.Shutdown(context.Background())
+	err = s.Shutdown(ctx)
+	if err != nil {
+		log.Error("Couldn't end the server gracefully", err)
+	}
+
+	//draining the refreshes and the jobs
+	log.Info("Stopping the refresh scheduler")
+	err = scheduler.Stop(ctx)
+	if err != nil {
+		log.Error("Couldn't finish the running refreshes", err)
+	}
+	log.Info("Draining the running jobs")
+	err = jobs.Stop(ctx, jobsCtx)
+	if err != nil {
+		log.Error("Couldn't drain the running jobs in time. They have been queued again", err)
+	}
+
+	//stopping the rpc service
+	log.Info("Stopping the rpc service")
+	err = config.StopRPC()
 	if err != nil {
-		log.Error("Couldn't end the server gracefully")
+		log.Error("Couldn't stop the rpc service", err)
 	}
 }
@@ -29,6 +29,58 @@ func GetDatasets(a *config.AppContext) ([]models.Dataset, error) {
 	return results, err
 }
 
+//DatasetWithStatus is a dataset along with the status of its upload
+type DatasetWithStatus struct {
+	models.Dataset
+	//Status is the status of the file upload of the dataset. Empty if the dataset is not from a file
+	Status string
+}
+
+//GetDatasetsWithStatus returns the list of datasets uploads for a user along with the status of their file uploads
+func GetDatasetsWithStatus(a *config.AppContext) ([]DatasetWithStatus, error) {
+	/*
+	 * We will get the datasets
+	 * Then we will get the file uploads of the datasets
+	 * Then we will set the status of the file upload to each dataset
+	 */
+	//getting the datasets
+	datasets, err := GetDatasets(a)
+	if err != nil {
+		return nil, err
+	}
+
+	//getting the file uploads of the datasets
+	ids := []uint{}
+	for _, v := range datasets {
+		if v.Source == models.DatasetSourceFile {
+			ids = append(ids, v.ResourceID)
+		}
+	}
+	uploads := []fModels.FileUpload{}
+	if len(ids) != 0 {
+		err = a.Db.Select("id, status").Where("id in (?)", ids).Find(&uploads).Error
+		if err != nil {
+			a.Log.Error("error while getting the status of the file uploads of the datasets")
+			return nil, err
+		}
+	}
+	statuses := map[uint]string{}
+	for _, v := range uploads {
+		statuses[v.ID] = v.Status
+	}
+
+	//setting the status to each dataset
+	results := []DatasetWithStatus{}
+	for _, v := range datasets {
+		r := DatasetWithStatus{Dataset: v}
+		if v.Source == models.DatasetSourceFile {
+			r.Status = statuses[v.ResourceID]
+		}
+		results = append(results, r)
+	}
+	return results, nil
+}
+
 //Dataset is the type alias for models.Dataset
 type Dataset models.Dataset
 
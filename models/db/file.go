@@ -6,13 +6,96 @@
 package db
 
 import (
+	"errors"
+	"fmt"
+
+	brainModels "github.com/cuttle-ai/brain/models"
 	"github.com/cuttle-ai/file-uploader-service/config"
 	"github.com/cuttle-ai/file-uploader-service/models"
+	"github.com/jinzhu/gorm"
 )
 
+//ErrInvalidTransition is returned when a file upload can't move from its current status to the requested one
+var ErrInvalidTransition = errors.New("invalid status transition")
+
 //FileUpload is the type alias for models.FileUpload
 type FileUpload models.FileUpload
 
+//StoreFileUpload stores the given file upload record along with the dataset created for it and the creator's user mapping.
+//If the app context is already in a transaction like while storing all the datasets of an upload together, the records are stored in it
+func StoreFileUpload(a *config.AppContext, fileRecord *models.FileUpload) (*brainModels.Dataset, error) {
+	/*
+	 * If the app context is in a transaction, we will store the records in it
+	 * Else we will start the transaction
+	 * Then we will store the records
+	 */
+	if inTransaction(a) {
+		return storeFileUpload(a, a.Db, fileRecord)
+	}
+
+	//starting the transaction
+	tx := a.Db.Begin()
+	defer func() {
+		if r := recover(); r != nil {
+			tx.Rollback()
+		}
+	}()
+	if err := tx.Error; err != nil {
+		//error while beginning the transaction
+		return nil, err
+	}
+
+	//storing the records
+	dataset, err := storeFileUpload(a, tx, fileRecord)
+	if err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+	return dataset, tx.Commit().Error
+}
+
+//storeFileUpload stores the file upload record along with its dataset and the creator's user mapping in the transaction
+func storeFileUpload(a *config.AppContext, tx *gorm.DB, fileRecord *models.FileUpload) (*brainModels.Dataset, error) {
+	/*
+	 * We will create the file upload
+	 * Then we will record the initial status in the status history
+	 * Then we will create the dataset with resource id as the of the file
+	 * Then we will create the dataset user mappings
+	 */
+	//saving the file upload
+	if err := tx.Create(fileRecord).Error; err != nil {
+		//error while creating the upload
+		a.Log.Error("error while creating the file upload record")
+		return nil, err
+	}
+
+	//recording the initial status
+	history := &models.FileUploadStatusHistory{FileUploadID: fileRecord.ID, ToStatus: fileRecord.Status}
+	if err := tx.Create(history).Error; err != nil {
+		//error while creating the status history
+		a.Log.Error("error while creating the status history of the file upload")
+		return nil, err
+	}
+
+	//saving the dataset record
+	dataset := &brainModels.Dataset{Name: fileRecord.Name, UserID: fileRecord.UserID, ResourceID: fileRecord.ID, Source: brainModels.DatasetSourceFile}
+	if err := tx.Create(dataset).Error; err != nil {
+		//error while creating the dataset
+		a.Log.Error("error while creating the datset record")
+		return nil, err
+	}
+	dataset.UploadedDataset = fileRecord
+
+	//creating the dataset user mappings
+	datasetMapping := &brainModels.DatsetUserMapping{DatasetID: dataset.ID, UserID: fileRecord.UserID, AccessType: brainModels.DatasetAccessTypeCreator}
+	if err := tx.Create(datasetMapping).Error; err != nil {
+		//error while creating the dataset user mapping
+		a.Log.Error("error while creating the datset user mapping")
+		return nil, err
+	}
+	return dataset, nil
+}
+
 //GetFileUpload returns the info about a fileupload for the given id with error details
 func GetFileUpload(a *config.AppContext, id uint, maskSensitiveInfo bool) (models.FileDataset, error) {
 	result := models.FileDataset{}
@@ -37,6 +120,70 @@ func (f FileUpload) DeleteErrors(a *config.AppContext) error {
 	return a.Db.Where("file_upload_id = ?", f.ID).Delete(&models.FileUploadError{}).Error
 }
 
+//DeleteErrorsWithCodes will delete the errors of the given file upload having any of the codes
+func (f FileUpload) DeleteErrorsWithCodes(a *config.AppContext, codes []string) error {
+	return a.Db.Where("file_upload_id = ? and code in (?)", f.ID, codes).Delete(&models.FileUploadError{}).Error
+}
+
+//ListErrors returns all the errors of the given file upload in the order of their rows
+func (f FileUpload) ListErrors(a *config.AppContext) ([]models.FileUploadError, error) {
+	results := []models.FileUploadError{}
+	err := a.Db.Where("file_upload_id = ?", f.ID).Order(`"row"`).Order("id").Find(&results).Error
+	return results, err
+}
+
+//CountErrors returns the no. of errors of the given file upload
+func (f FileUpload) CountErrors(a *config.AppContext) (int64, error) {
+	var n int64
+	err := a.Db.Model(&models.FileUploadError{}).Where("file_upload_id = ?", f.ID).Count(&n).Error
+	return n, err
+}
+
+//ErrorFilter filters the errors of a file upload. The empty fields are not filtered
+type ErrorFilter struct {
+	//Code is the code of the errors
+	Code string
+	//Severity is the severity of the errors
+	Severity string
+}
+
+//GetErrors returns the errors of the file upload matching the filter in the order of their rows starting from the offset.
+//The total no. of errors matching the filter is also returned
+func (f FileUpload) GetErrors(a *config.AppContext, filter ErrorFilter, offset int, limit int) ([]models.FileUploadError, int64, error) {
+	results := []models.FileUploadError{}
+	q := a.Db.Model(&models.FileUploadError{}).Where("file_upload_id = ?", f.ID)
+	if len(filter.Code) != 0 {
+		q = q.Where("code = ?", filter.Code)
+	}
+	if len(filter.Severity) != 0 {
+		q = q.Where("severity = ?", filter.Severity)
+	}
+	var total int64
+	if err := q.Count(&total).Error; err != nil {
+		return nil, 0, err
+	}
+	err := q.Order(`"row"`).Order("id").Offset(offset).Limit(limit).Find(&results).Error
+	return results, total, err
+}
+
+//CountErrorsByCode returns the no. of errors of the file upload per error code
+func (f FileUpload) CountErrorsByCode(a *config.AppContext) (map[string]int64, error) {
+	counts := []struct {
+		Code  string
+		Count int64
+	}{}
+	err := a.Db.Model(&models.FileUploadError{}).Select("coalesce(nullif(code, ''), ?) as code, count(*) as count", models.FileUploadErrorCodeUnknown).
+		Where("file_upload_id = ?", f.ID).Group("1").Scan(&counts).Error
+	if err != nil {
+		return nil, err
+	}
+	results := map[string]int64{}
+	for _, v := range counts {
+		results[v.Code] += v.Count
+	}
+	return results, nil
+}
+
 //GetDataset returns the dataset corresponding to a file upload
 func (f FileUpload) GetDataset(a *config.AppContext) (*Dataset, error) {
 	var dset Dataset
@@ -44,13 +191,146 @@ func (f FileUpload) GetDataset(a *config.AppContext) (*Dataset, error) {
 	return &dset, err
 }
 
-//DeleteErrorsAndUpdateStatus will delete the file upload errors and update the status as uploaded
-func (f *FileUpload) DeleteErrorsAndUpdateStatus(a *config.AppContext) error {
+//UpdateLocation updates the location of the uploaded file when it is replaced by a new file
+func (f *FileUpload) UpdateLocation(a *config.AppContext, location string) error {
+	f.Location = location
+	return a.Db.Model(f).Updates(map[string]interface{}{
+		"location": location,
+	}).Error
+}
+
+//CountFileUploadsAt returns the no. of file uploads whose file is at the given location. The datasets created from the sheets of a workbook share its location
+func CountFileUploadsAt(a *config.AppContext, location string) (int, error) {
+	n := 0
+	err := a.Db.Model(&models.FileUpload{}).Where("location = ?", location).Count(&n).Error
+	return n, err
+}
+
+//UpdateSource updates the checksum, size and the source url of the uploaded file
+func (f *FileUpload) UpdateSource(a *config.AppContext, checksum string, size int64, sourceURL string) error {
+	f.Checksum = checksum
+	f.Size = size
+	f.SourceURL = sourceURL
+	return a.Db.Model(f).Updates(map[string]interface{}{
+		"checksum":   checksum,
+		"size":       size,
+		"source_url": sourceURL,
+	}).Error
+}
+
+//UpdateEncoding updates the character encoding in which the file was uploaded
+func (f *FileUpload) UpdateEncoding(a *config.AppContext, encoding string) error {
+	f.Encoding = encoding
+	return a.Db.Model(f).Updates(map[string]interface{}{
+		"encoding": encoding,
+	}).Error
+}
+
+//UpdateTolerated updates the no. of rows left out and coerced by the last validation as per the error policy
+func (f *FileUpload) UpdateTolerated(a *config.AppContext, skipped int64, coerced int64) error {
+	f.RowsSkipped = skipped
+	f.RowsCoerced = coerced
+	return a.Db.Model(f).Updates(map[string]interface{}{
+		"rows_skipped": skipped,
+		"rows_coerced": coerced,
+	}).Error
+}
+
+//UpdateStatus moves the file upload to the given status and records the transition in the status history.
+//ErrInvalidTransition is returned if the file upload can't move from its current status to the given one.
+//reason is stored along with the transition like the error with which the processing failed
+func (f *FileUpload) UpdateStatus(a *config.AppContext, status string, reason string) error {
 	/*
 	 * We will start the transaction
-	 * We will then delete the file upload errors
-	 * Then we will update the status of the file upload as status
+	 * Then we will make the transition
+	 */
+	//starting the transaction
+	tx := a.Db.Begin()
+	defer func() {
+		if r := recover(); r != nil {
+			tx.Rollback()
+		}
+	}()
+	if err := tx.Error; err != nil {
+		//error while beginning the transaction
+		return err
+	}
+
+	//making the transition
+	if err := f.transition(a, tx, status, reason); err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit().Error
+}
+
+//transition moves the file upload to the given status in the transaction.
+//The file upload is locked while checking the current status so that concurrent transitions are serialized
+func (f *FileUpload) transition(a *config.AppContext, tx *gorm.DB, status string, reason string) error {
+	/*
+	 * We will lock the file upload and get its current status
+	 * Then we will check whether the transition is allowed
+	 * Then we will update the status
+	 * Then we will record the transition in the history
 	 */
+	//locking the file upload
+	current := &FileUpload{}
+	err := tx.Set("gorm:query_option", "FOR UPDATE").Where("id = ?", f.ID).First(current).Error
+	if err != nil {
+		//error while locking the file upload
+		a.Log.Error("error while locking the file upload", f.ID, "to update its status")
+		return err
+	}
+
+	//checking whether the transition is allowed
+	if current.Status == status {
+		f.Status = status
+		return nil
+	}
+	if !models.CanTransition(current.Status, status) {
+		a.Log.Warn("file upload", f.ID, "can't move from", current.Status, "to", status)
+		return fmt.Errorf("%w from %s to %s for the file upload %d", ErrInvalidTransition, current.Status, status, f.ID)
+	}
+
+	//updating the status
+	err = tx.Model(f).Updates(map[string]interface{}{
+		"status": status,
+	}).Error
+	if err != nil {
+		//error while updating the status
+		a.Log.Error("error while updating the status of the file upload", f.ID, "to", status)
+		return err
+	}
+	f.Status = status
+
+	//recording the transition
+	history := &models.FileUploadStatusHistory{FileUploadID: f.ID, FromStatus: current.Status, ToStatus: status, Reason: reason}
+	if err := tx.Create(history).Error; err != nil {
+		//error while recording the transition
+		a.Log.Error("error while recording the status transition of the file upload", f.ID)
+		return err
+	}
+	return nil
+}
+
+//GetStatusHistory returns the status transitions of the file upload in the order in which they happened
+func (f FileUpload) GetStatusHistory(a *config.AppContext) ([]models.FileUploadStatusHistory, error) {
+	results := []models.FileUploadStatusHistory{}
+	err := a.Db.Where("file_upload_id = ?", f.ID).Order("id").Find(&results).Error
+	return results, err
+}
+
+//DeleteErrorsAndUpdateStatus will delete the file upload errors and update the status as uploaded.
+//If the app context is already in a transaction like while replacing the file of the upload, the changes are made in it
+func (f *FileUpload) DeleteErrorsAndUpdateStatus(a *config.AppContext) error {
+	/*
+	 * If the app context is in a transaction, we will make the changes in it
+	 * Else we will start the transaction
+	 * Then we will delete the errors and update the status
+	 */
+	if inTransaction(a) {
+		return f.deleteErrorsAndUpdateStatus(a, a.Db)
+	}
 
 	//starting the transaction
 	tx := a.Db.Begin()
@@ -64,25 +344,34 @@ func (f *FileUpload) DeleteErrorsAndUpdateStatus(a *config.AppContext) error {
 		return err
 	}
 
+	//deleting the errors and updating the status
+	if err := f.deleteErrorsAndUpdateStatus(a, tx); err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit().Error
+}
+
+//deleteErrorsAndUpdateStatus deletes the file upload errors and moves the file upload to uploaded status in the transaction
+func (f *FileUpload) deleteErrorsAndUpdateStatus(a *config.AppContext, tx *gorm.DB) error {
+	/*
+	 * We will delete the file upload errors
+	 * Then we will move the file upload to uploaded status
+	 */
 	//deleting the errors
 	if err := tx.Where("file_upload_id = ?", f.ID).Delete(&models.FileUploadError{}).Error; err != nil {
 		//error while creating the upload
-		tx.Rollback()
 		a.Log.Error("error while deleting the file upload errors for", f.ID)
 		return err
 	}
 
 	//updating the status
-	status := map[string]interface{}{
-		"status": models.FileUploadStatusUploaded,
-	}
-	if err := tx.Model(f).Updates(status).Error; err != nil {
-		//error while creating the upload
-		tx.Rollback()
-		a.Log.Error("error while updating the file upload status to updating for", f.ID)
+	if err := f.transition(a, tx, models.FileUploadStatusUploaded, "file updated"); err != nil {
+		//error while updating the status
+		a.Log.Error("error while updating the file upload status to uploaded for", f.ID)
 		return err
 	}
-	return tx.Commit().Error
+	return nil
 }
 
 //CreateErrors will create the error record for the given file
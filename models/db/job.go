@@ -0,0 +1,383 @@
+// Copyright 2019 Cuttle.ai. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package db
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/cuttle-ai/file-uploader-service/config"
+	"github.com/cuttle-ai/file-uploader-service/models"
+	"github.com/jinzhu/gorm"
+)
+
+//Job is the type alias for models.Job
+type Job models.Job
+
+//Create creates the job record in the database
+func (j *Job) Create(a *config.AppContext) error {
+	return a.Db.Create(j).Error
+}
+
+//ClaimJob claims the oldest queued job of the given types and marks it as running. The jobs of any type are claimed if no types are given. The job is locked while claiming and
+//the jobs locked by the other workers are skipped. So a job is claimed only by one worker even across the instances of the service.
+//The jobs on a dataset which has a running job and the postponed jobs are skipped so that the jobs on a dataset run one after the other.
+//Nil is returned if there are no queued jobs
+func ClaimJob(a *config.AppContext, types ...string) (*Job, error) {
+	/*
+	 * We will start the transaction
+	 * Then we will lock the oldest queued job
+	 * Then we will mark it as running
+	 */
+	//starting the transaction
+	tx := a.Db.Begin()
+	defer func() {
+		if r := recover(); r != nil {
+			tx.Rollback()
+		}
+	}()
+	if err := tx.Error; err != nil {
+		//error while beginning the transaction
+		return nil, err
+	}
+
+	//locking the oldest queued job
+	//the jobs on a dataset with a running job are queued behind it
+	busy := tx.Model(&models.Job{}).Select("dataset_id").Where("status = ? and dataset_id <> 0", models.JobStatusRunning).SubQuery()
+	j := &Job{}
+	q := tx.Set("gorm:query_option", "FOR UPDATE SKIP LOCKED").Where("status = ? and (dataset_id = 0 or dataset_id not in ?) and (run_after is null or run_after <= ?)", models.JobStatusQueued, busy, time.Now())
+	if len(types) != 0 {
+		q = q.Where("type in (?)", types)
+	}
+	err := q.Order("id").First(j).Error
+	if gorm.IsRecordNotFoundError(err) {
+		//no queued jobs
+		tx.Rollback()
+		return nil, nil
+	}
+	if err != nil {
+		//error while locking the job
+		tx.Rollback()
+		return nil, err
+	}
+
+	//marking the job as running
+	now := time.Now()
+	j.Status = models.JobStatusRunning
+	j.Attempts++
+	j.StartedAt = now
+	j.HeartbeatAt = now
+	err = tx.Model(j).Updates(map[string]interface{}{
+		"status":       j.Status,
+		"attempts":     j.Attempts,
+		"started_at":   j.StartedAt,
+		"heartbeat_at": j.HeartbeatAt,
+	}).Error
+	if err != nil {
+		//error while marking the job as running
+		tx.Rollback()
+		a.Log.Error("error while marking the job as running", j.ID)
+		return nil, err
+	}
+	return j, tx.Commit().Error
+}
+
+//ResolveDataset sets the dataset of the job from its file upload if it is not set
+func (j *Job) ResolveDataset(a *config.AppContext) error {
+	if j.DatasetID != 0 || j.FileUploadID == 0 {
+		return nil
+	}
+	f := FileUpload{}
+	f.ID = j.FileUploadID
+	d, err := f.GetDataset(a)
+	if err != nil {
+		return err
+	}
+	j.DatasetID = d.ID
+	return nil
+}
+
+//Postpone queues the claimed job again to be run after the given time without counting the attempt. It is used when the job can't run yet
+func (j *Job) Postpone(a *config.AppContext, runAfter time.Time, reason string) error {
+	j.Status = models.JobStatusQueued
+	j.RunAfter = runAfter
+	j.LastError = reason
+	return a.Db.Model(&models.Job{}).Where("id = ? and status = ?", j.ID, models.JobStatusRunning).Updates(map[string]interface{}{
+		"status":     j.Status,
+		"run_after":  j.RunAfter,
+		"attempts":   gorm.Expr("greatest(attempts - 1, 0)"),
+		"last_error": j.LastError,
+	}).Error
+}
+
+//HasActiveJob returns true if a job is queued or running on the file upload
+func HasActiveJob(a *config.AppContext, fileUploadID uint) (bool, error) {
+	count := 0
+	err := a.Db.Model(&models.Job{}).Where("file_upload_id = ? and status in (?)", fileUploadID, []string{models.JobStatusQueued, models.JobStatusRunning}).Count(&count).Error
+	return count != 0, err
+}
+
+//JobStage is the type alias for models.JobStage
+type JobStage models.JobStage
+
+//UpdateStage updates the current stage of the job
+func (j *Job) UpdateStage(a *config.AppContext, stage string) error {
+	j.Stage = stage
+	return a.Db.Model(j).Updates(map[string]interface{}{
+		"stage": stage,
+	}).Error
+}
+
+//StartStage updates the current stage of the job and records the start of the stage
+func (j *Job) StartStage(a *config.AppContext, stage string) (*JobStage, error) {
+	s := &JobStage{JobID: j.ID, Stage: stage, Status: models.JobStatusRunning, StartedAt: time.Now()}
+	err := j.UpdateStage(a, stage)
+	if err != nil {
+		return s, err
+	}
+	return s, a.Db.Create(s).Error
+}
+
+//SkipStage updates the current stage of the job and records the stage as skipped
+func (j *Job) SkipStage(a *config.AppContext, stage string) error {
+	err := j.UpdateStage(a, stage)
+	if err != nil {
+		return err
+	}
+	now := time.Now()
+	return a.Db.Create(&JobStage{JobID: j.ID, Stage: stage, Status: models.JobStatusSkipped, StartedAt: now, FinishedAt: now}).Error
+}
+
+//Finish records the end of the stage with its metrics and the time taken by it. The stage is marked as failed if the error is not nil.
+//If the stage stopped since it was cancelled, it is marked as cancelled
+func (s *JobStage) Finish(a *config.AppContext, m models.StageMetrics, err error) error {
+	s.Status = models.JobStatusSucceeded
+	if err != nil {
+		s.Status = models.JobStatusFailed
+		s.Error = err.Error()
+	}
+	if errors.Is(err, context.Canceled) {
+		s.Status = models.JobStatusCancelled
+	}
+	s.StageMetrics = m
+	s.FinishedAt = time.Now()
+	s.Duration = int64(s.FinishedAt.Sub(s.StartedAt) / time.Millisecond)
+	if s.ID == 0 {
+		//the start of the stage couldn't be recorded
+		return a.Db.Create(s).Error
+	}
+	return a.Db.Model(s).Updates(map[string]interface{}{
+		"status":        s.Status,
+		"rows":          m.Rows,
+		"rows_rejected": m.RowsRejected,
+		"bytes_written": m.BytesWritten,
+		"datastore_id":  m.DatastoreID,
+		"duration":      s.Duration,
+		"error":         s.Error,
+		"finished_at":   s.FinishedAt,
+	}).Error
+}
+
+//GetStages returns the stages of the job in the order in which they ran
+func (j Job) GetStages(a *config.AppContext) ([]JobStage, error) {
+	results := []JobStage{}
+	err := a.Db.Where("job_id = ?", j.ID).Order("id").Find(&results).Error
+	return results, err
+}
+
+//RetryAttempt is the type alias for models.RetryAttempt
+type RetryAttempt models.RetryAttempt
+
+//Create creates the retry attempt record in the database
+func (r *RetryAttempt) Create(a *config.AppContext) error {
+	return a.Db.Create(r).Error
+}
+
+//GetRetryAttempts returns the failed attempts of the calls made by the job in the order in which they were made
+func (j Job) GetRetryAttempts(a *config.AppContext) ([]RetryAttempt, error) {
+	results := []RetryAttempt{}
+	err := a.Db.Where("job_id = ?", j.ID).Order("id").Find(&results).Error
+	return results, err
+}
+
+//GetLatestJob returns the latest job run on the file upload of the user
+func GetLatestJob(a *config.AppContext, fileUploadID uint) (*Job, error) {
+	j := &Job{}
+	err := a.Db.Where("user_id = ? and file_upload_id = ?", a.Session.User.ID, fileUploadID).Order("id desc").First(j).Error
+	return j, err
+}
+
+//GetLatestJobOfType returns the latest job of the given type run on the file upload of the user
+func GetLatestJobOfType(a *config.AppContext, fileUploadID uint, jobType string) (*Job, error) {
+	j := &Job{}
+	err := a.Db.Where("user_id = ? and file_upload_id = ? and type = ?", a.Session.User.ID, fileUploadID, jobType).Order("id desc").First(j).Error
+	return j, err
+}
+
+//CancelQueued cancels the job if it is still queued. It returns false if the job is not queued anymore
+func (j *Job) CancelQueued(a *config.AppContext) (bool, error) {
+	d := a.Db.Model(&models.Job{}).Where("id = ? and status = ?", j.ID, models.JobStatusQueued).Updates(map[string]interface{}{
+		"status":      models.JobStatusCancelled,
+		"finished_at": time.Now(),
+	})
+	return d.RowsAffected != 0, d.Error
+}
+
+//RequestCancel requests the cancellation of the job if it is running. It returns false if the job is not running
+func (j *Job) RequestCancel(a *config.AppContext) (bool, error) {
+	d := a.Db.Model(&models.Job{}).Where("id = ? and status = ?", j.ID, models.JobStatusRunning).Updates(map[string]interface{}{
+		"cancel_requested": true,
+	})
+	return d.RowsAffected != 0, d.Error
+}
+
+//IsCancelRequested returns true if the cancellation of the job has been requested
+func (j Job) IsCancelRequested(a *config.AppContext) (bool, error) {
+	r := &Job{}
+	err := a.Db.Select("cancel_requested").Where("id = ?", j.ID).First(r).Error
+	return r.CancelRequested, err
+}
+
+//Heartbeat updates the time at which the worker running the job reported last
+func (j *Job) Heartbeat(a *config.AppContext) error {
+	j.HeartbeatAt = time.Now()
+	return a.Db.Model(j).Updates(map[string]interface{}{
+		"heartbeat_at": j.HeartbeatAt,
+	}).Error
+}
+
+//Finish marks the job as succeeded if the error is nil. Else the job is marked as failed with the error.
+//If the job stopped since it was cancelled, it is marked as cancelled
+func (j *Job) Finish(a *config.AppContext, err error) error {
+	j.Status = models.JobStatusSucceeded
+	j.LastError = ""
+	if err != nil {
+		j.Status = models.JobStatusFailed
+		j.LastError = err.Error()
+	}
+	if errors.Is(err, context.Canceled) {
+		j.Status = models.JobStatusCancelled
+	}
+	j.FinishedAt = time.Now()
+	return a.Db.Model(j).Updates(map[string]interface{}{
+		"status":      j.Status,
+		"last_error":  j.LastError,
+		"finished_at": j.FinishedAt,
+	}).Error
+}
+
+//Requeue queues the running job again to be resumed from the given stage. If the stage is empty, the job is resumed from its current stage.
+//It is used when the job is interrupted by the shutdown of the instance running it. So the attempt is not counted
+func (j *Job) Requeue(a *config.AppContext, fromStage string, reason string) error {
+	var from interface{} = fromStage
+	if len(fromStage) == 0 {
+		from = gorm.Expr("stage")
+	}
+	j.Status = models.JobStatusQueued
+	j.LastError = reason
+	return a.Db.Model(&models.Job{}).Where("id = ? and status = ?", j.ID, models.JobStatusRunning).Updates(map[string]interface{}{
+		"status":     j.Status,
+		"from_stage": from,
+		"attempts":   gorm.Expr("greatest(attempts - 1, 0)"),
+		"last_error": j.LastError,
+	}).Error
+}
+
+//RecoverJobs recovers the running jobs whose heartbeat is older than the given time. Those jobs were interrupted
+//by a restart or a crash of the instance running them. The jobs are queued again if they have attempts left, else they are marked as failed.
+//The interrupted jobs whose cancellation was requested are marked as cancelled. The file uploads of the failed and cancelled jobs are moved
+//to the same status so that they aren't left in the middle of the processing. The no. of jobs queued again is returned
+func RecoverJobs(a *config.AppContext, staleBefore time.Time, maxAttempts int) (int64, error) {
+	/*
+	 * We will get the running jobs whose heartbeat is stale
+	 * Then we will recover them one by one
+	 */
+	//getting the stale jobs
+	stale := []Job{}
+	err := a.Db.Where("status = ? and heartbeat_at < ?", models.JobStatusRunning, staleBefore).Order("id").Find(&stale).Error
+	if err != nil {
+		return 0, err
+	}
+
+	//recovering the jobs
+	n := int64(0)
+	for i := range stale {
+		queued, err := stale[i].recoverJob(a, staleBefore, maxAttempts)
+		if err != nil {
+			return n, err
+		}
+		if queued {
+			n++
+		}
+	}
+	return n, nil
+}
+
+//recoverJob queues the interrupted job again or marks it as failed or cancelled along with its file upload in a transaction.
+//The job is recovered only if it is still stale, since another instance could be recovering it as well. True is returned if the job is queued again
+func (j *Job) recoverJob(a *config.AppContext, staleBefore time.Time, maxAttempts int) (bool, error) {
+	/*
+	 * We will find the status to which the job is recovered
+	 * Then we will update the job if it is still stale
+	 * Then we will move the file upload of the failed or cancelled job to the same status
+	 */
+	//finding the status
+	status, reason := models.JobStatusQueued, "job was interrupted"
+	if j.CancelRequested {
+		status, reason = models.JobStatusCancelled, "job was interrupted after its cancellation was requested"
+	} else if j.Attempts >= maxAttempts {
+		status, reason = models.JobStatusFailed, "job was interrupted and has no attempts left"
+	}
+	updates := map[string]interface{}{
+		"status":     status,
+		"last_error": reason,
+	}
+	if status != models.JobStatusQueued {
+		updates["finished_at"] = time.Now()
+	}
+
+	recovered := false
+	err := a.Db.Transaction(func(tx *gorm.DB) error {
+		//updating the job
+		d := tx.Model(&models.Job{}).Where("id = ? and status = ? and heartbeat_at < ?", j.ID, models.JobStatusRunning, staleBefore).Updates(updates)
+		if d.Error != nil {
+			return d.Error
+		}
+		recovered = d.RowsAffected != 0
+		if !recovered || status == models.JobStatusQueued || j.FileUploadID == 0 {
+			return nil
+		}
+
+		//moving the file upload to the status of the job
+		return stopUpload(a, tx, j.FileUploadID, status, reason)
+	})
+	if err != nil {
+		return false, err
+	}
+	j.Status = status
+	j.LastError = reason
+	return recovered && status == models.JobStatusQueued, nil
+}
+
+//stopUpload moves the file upload of a failed or cancelled job to failed or cancelled in the transaction.
+//A file upload which can't be cancelled from its status is marked as failed. File uploads which have already moved on
+//like the ones found invalid are left as such
+func stopUpload(a *config.AppContext, tx *gorm.DB, fileUploadID uint, jobStatus string, reason string) error {
+	f := &FileUpload{}
+	f.ID = fileUploadID
+	status := models.FileUploadStatusFailed
+	if jobStatus == models.JobStatusCancelled {
+		status = models.FileUploadStatusCancelled
+	}
+	err := f.transition(a, tx, status, reason)
+	if errors.Is(err, ErrInvalidTransition) && status == models.FileUploadStatusCancelled {
+		err = f.transition(a, tx, models.FileUploadStatusFailed, reason)
+	}
+	if errors.Is(err, ErrInvalidTransition) || gorm.IsRecordNotFoundError(err) {
+		return nil
+	}
+	return err
+}
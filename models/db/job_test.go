@@ -0,0 +1,161 @@
+// Copyright 2019 Cuttle.ai. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package db_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/cuttle-ai/file-uploader-service/config"
+	"github.com/cuttle-ai/file-uploader-service/config/configtest"
+	"github.com/cuttle-ai/file-uploader-service/models"
+	"github.com/cuttle-ai/file-uploader-service/models/db"
+)
+
+/*
+ * This file contains the integration tests for claiming, postponing and recovering the jobs. They need the database and run only if it is enabled
+ */
+
+//createJobs creates the jobs and returns a func to delete them
+func createJobs(t *testing.T, a *config.AppContext, jobs ...*db.Job) func() {
+	for _, j := range jobs {
+		if err := a.Db.Create(j).Error; err != nil {
+			t.Fatal("couldn't create the job", err)
+		}
+	}
+	return func() {
+		for _, j := range jobs {
+			a.Db.Unscoped().Delete(&models.Job{}, j.ID)
+		}
+	}
+}
+
+//getJob returns the job stored in the database
+func getJob(t *testing.T, a *config.AppContext, id uint) *db.Job {
+	j := &db.Job{}
+	if err := a.Db.Where("id = ?", id).First(j).Error; err != nil {
+		t.Fatal("couldn't get the job", id, err)
+	}
+	return j
+}
+
+func TestClaimJob(t *testing.T) {
+	a := configtest.AppContext(t)
+	dSet := &db.Dataset{Name: "claim"}
+	if err := a.Db.Create(dSet).Error; err != nil {
+		t.Fatal("couldn't create the dataset", err)
+	}
+	defer a.Db.Unscoped().Delete(dSet)
+
+	first := &db.Job{Type: "TEST_CLAIM", Status: models.JobStatusQueued, DatasetID: dSet.ID}
+	behind := &db.Job{Type: "TEST_CLAIM", Status: models.JobStatusQueued, DatasetID: dSet.ID}
+	postponed := &db.Job{Type: "TEST_CLAIM", Status: models.JobStatusQueued, RunAfter: time.Now().Add(time.Hour)}
+	free := &db.Job{Type: "TEST_CLAIM", Status: models.JobStatusQueued}
+	defer createJobs(t, a, first, behind, postponed, free)()
+
+	//the jobs are claimed oldest first skipping the jobs queued behind a running job on the dataset and the postponed ones.
+	//Only the jobs of the test are claimed since the database can have the queued jobs of the other tests
+	cases := []struct {
+		name    string
+		prepare func(t *testing.T)
+		claimed *db.Job
+	}{
+		{"oldest job", func(t *testing.T) {}, first},
+		{"job behind the running job on the dataset is skipped", func(t *testing.T) {}, free},
+		{"job behind the postponed job on the dataset", func(t *testing.T) {
+			if err := first.Postpone(a, time.Now().Add(time.Hour), "dataset is locked"); err != nil {
+				t.Fatal("couldn't postpone the job", err)
+			}
+		}, behind},
+	}
+	for i, c := range cases {
+		c.prepare(t)
+		j, err := db.ClaimJob(a, "TEST_CLAIM")
+		if err != nil || j == nil || j.ID != c.claimed.ID {
+			t.Error("test case", i+1, c.name, "expected the job", c.claimed.ID, "to be claimed. got", j, err)
+			continue
+		}
+		got := getJob(t, a, j.ID)
+		if got.Status != models.JobStatusRunning || got.Attempts != 1 || got.HeartbeatAt.IsZero() {
+			t.Error("test case", i+1, c.name, "expected the claimed job to be running with an attempt. got", got.Status, got.Attempts, got.HeartbeatAt)
+		}
+	}
+
+	//the postponed job is queued without counting its attempt and isn't claimed till the time it is postponed to
+	got := getJob(t, a, first.ID)
+	if got.Status != models.JobStatusQueued || got.Attempts != 0 || got.LastError != "dataset is locked" || !got.RunAfter.After(time.Now()) {
+		t.Error("expected the postponed job to be queued without the attempt. got", got.Status, got.Attempts, got.LastError, got.RunAfter)
+	}
+	if j, err := db.ClaimJob(a, "TEST_CLAIM"); err != nil || (j != nil && (j.ID == first.ID || j.ID == postponed.ID)) {
+		t.Error("expected the postponed jobs not to be claimed. got", j, err)
+	}
+}
+
+func TestRecoverJobs(t *testing.T) {
+	a := configtest.AppContext(t)
+	uploads := map[string]*models.FileUpload{}
+	for _, name := range []string{"retried", "exhausted", "cancelled", "optimizing"} {
+		status := models.FileUploadStatusLoading
+		if name == "optimizing" {
+			status = models.FileUploadStatusOptimizing
+		}
+		fU := &models.FileUpload{Name: name, Type: models.FileUploadTypeCSV, Status: status}
+		if err := a.Db.Create(fU).Error; err != nil {
+			t.Fatal("couldn't create the file upload", err)
+		}
+		defer a.Db.Unscoped().Where("file_upload_id = ?", fU.ID).Delete(&models.FileUploadStatusHistory{})
+		defer a.Db.Unscoped().Delete(fU)
+		uploads[name] = fU
+	}
+	stale := time.Now().Add(-time.Hour)
+	retried := &db.Job{Type: "TEST_RECOVER", Status: models.JobStatusRunning, Attempts: 1, HeartbeatAt: stale, FileUploadID: uploads["retried"].ID}
+	exhausted := &db.Job{Type: "TEST_RECOVER", Status: models.JobStatusRunning, Attempts: 3, HeartbeatAt: stale, FileUploadID: uploads["exhausted"].ID}
+	cancelled := &db.Job{Type: "TEST_RECOVER", Status: models.JobStatusRunning, Attempts: 1, HeartbeatAt: stale, CancelRequested: true, FileUploadID: uploads["cancelled"].ID}
+	optimizing := &db.Job{Type: "TEST_RECOVER", Status: models.JobStatusRunning, Attempts: 1, HeartbeatAt: stale, CancelRequested: true, FileUploadID: uploads["optimizing"].ID}
+	alive := &db.Job{Type: "TEST_RECOVER", Status: models.JobStatusRunning, Attempts: 1, HeartbeatAt: time.Now()}
+	defer createJobs(t, a, retried, exhausted, cancelled, optimizing, alive)()
+
+	n, err := db.RecoverJobs(a, time.Now().Add(-time.Minute), 3)
+	if err != nil || n < 1 {
+		t.Fatal("expected the interrupted jobs to be recovered. got", n, err)
+	}
+	cases := []struct {
+		name         string
+		job          *db.Job
+		status       string
+		finished     bool
+		upload       *models.FileUpload
+		uploadStatus string
+	}{
+		{"stale job with attempts left", retried, models.JobStatusQueued, false, uploads["retried"], models.FileUploadStatusLoading},
+		{"stale job without attempts left", exhausted, models.JobStatusFailed, true, uploads["exhausted"], models.FileUploadStatusFailed},
+		{"stale job with the cancellation requested", cancelled, models.JobStatusCancelled, true, uploads["cancelled"], models.FileUploadStatusCancelled},
+		{"stale job with the cancellation requested while optimizing", optimizing, models.JobStatusCancelled, true, uploads["optimizing"], models.FileUploadStatusFailed},
+		{"running job", alive, models.JobStatusRunning, false, nil, ""},
+	}
+	for i, c := range cases {
+		got := getJob(t, a, c.job.ID)
+		if got.Status != c.status || got.FinishedAt.IsZero() == c.finished {
+			t.Error("test case", i+1, c.name, "expected the status", c.status, "and finished as", c.finished, "got", got.Status, got.FinishedAt)
+		}
+		if c.upload == nil {
+			continue
+		}
+		//the file upload is moved to the status of the job along with its history
+		fU := &db.FileUpload{}
+		fU.ID = c.upload.ID
+		if err := a.Db.Where("id = ?", fU.ID).First(fU).Error; err != nil {
+			t.Fatal("couldn't get the file upload", err)
+		}
+		history, err := fU.GetStatusHistory(a)
+		if err != nil {
+			t.Fatal("couldn't get the status history", err)
+		}
+		moved := len(history) != 0 && history[len(history)-1].ToStatus == c.uploadStatus
+		if fU.Status != c.uploadStatus || moved == (c.uploadStatus == c.upload.Status) {
+			t.Error("test case", i+1, c.name, "expected the file upload status", c.uploadStatus, "with its history. got", fU.Status, history)
+		}
+	}
+}
@@ -0,0 +1,120 @@
+// Copyright 2019 Cuttle.ai. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package db
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+
+	"github.com/cuttle-ai/file-uploader-service/config"
+)
+
+//datasetLockNamespace is the first key of the advisory locks of the datasets. It keeps them apart from the other advisory locks taken in the database
+const datasetLockNamespace = 4711
+
+//resumableUploadLockNamespace is the first key of the advisory locks of the resumable uploads
+const resumableUploadLockNamespace = 4712
+
+//advisoryLock is a postgres advisory lock held by a dedicated database session
+type advisoryLock struct {
+	//conn is the database session holding the lock
+	conn *sql.Conn
+	//namespace is the first key of the lock
+	namespace int32
+	//key is the second key of the lock
+	key int32
+}
+
+//tryAdvisoryLock tries to acquire the advisory lock with the given keys without waiting. False is returned if the lock is held by someone else
+func tryAdvisoryLock(a *config.AppContext, namespace int32, key int32) (*advisoryLock, bool, error) {
+	/*
+	 * We will get a dedicated database session since the advisory locks are held by the session
+	 * Then we will try to acquire the lock
+	 */
+	//getting a dedicated session
+	conn, err := a.Db.DB().Conn(context.Background())
+	if err != nil {
+		return nil, false, err
+	}
+
+	//trying to acquire the lock
+	ok := false
+	err = conn.QueryRowContext(context.Background(), "select pg_try_advisory_lock($1, $2)", namespace, key).Scan(&ok)
+	if err != nil {
+		//the lock could have been acquired before the error. So the session is not returned to the pool
+		discard(conn)
+		return nil, false, err
+	}
+	if !ok {
+		conn.Close()
+		return nil, false, nil
+	}
+	return &advisoryLock{conn: conn, namespace: namespace, key: key}, true, nil
+}
+
+//unlock releases the lock and the database session holding it. If the lock couldn't be released, the session is discarded
+//instead of returning it to the pool so that the lock doesn't stay with a pooled session. The lock is released once the session ends
+func (l *advisoryLock) unlock() error {
+	_, err := l.conn.ExecContext(context.Background(), "select pg_advisory_unlock($1, $2)", l.namespace, l.key)
+	if err != nil {
+		discard(l.conn)
+		return err
+	}
+	return l.conn.Close()
+}
+
+//discard closes the database session instead of returning it to the pool
+func discard(conn *sql.Conn) {
+	//database/sql closes the driver connection marked as bad instead of pooling it
+	conn.Raw(func(driverConn interface{}) error {
+		return driver.ErrBadConn
+	})
+	conn.Close()
+}
+
+//DatasetLock is a postgres advisory lock on a dataset. Since the lock is held by the database, it excludes
+//the work on the dataset across all the instances of the service
+type DatasetLock struct {
+	lock *advisoryLock
+	//DatasetID is the id of the locked dataset
+	DatasetID uint
+}
+
+//TryLockDataset tries to acquire the lock of the dataset without waiting. The lock is held till it is unlocked
+//or the database session holding it ends like when the instance crashes. False is returned if the lock is held by someone else
+func TryLockDataset(a *config.AppContext, datasetID uint) (*DatasetLock, bool, error) {
+	l, ok, err := tryAdvisoryLock(a, datasetLockNamespace, int32(datasetID))
+	if err != nil || !ok {
+		return nil, false, err
+	}
+	return &DatasetLock{lock: l, DatasetID: datasetID}, true, nil
+}
+
+//Unlock releases the lock and the database session holding it
+func (l *DatasetLock) Unlock() error {
+	return l.lock.unlock()
+}
+
+//ResumableUploadLock is a postgres advisory lock on a resumable upload. It lets only one request write the chunks of the upload at a time
+type ResumableUploadLock struct {
+	lock *advisoryLock
+	//ResumableUploadID is the id of the locked resumable upload
+	ResumableUploadID uint
+}
+
+//TryLockResumableUpload tries to acquire the lock of the resumable upload without waiting. False is returned if the lock is held by someone else
+func TryLockResumableUpload(a *config.AppContext, id uint) (*ResumableUploadLock, bool, error) {
+	l, ok, err := tryAdvisoryLock(a, resumableUploadLockNamespace, int32(id))
+	if err != nil || !ok {
+		return nil, false, err
+	}
+	return &ResumableUploadLock{lock: l, ResumableUploadID: id}, true, nil
+}
+
+//Unlock releases the lock and the database session holding it
+func (l *ResumableUploadLock) Unlock() error {
+	return l.lock.unlock()
+}
@@ -0,0 +1,34 @@
+// Copyright 2019 Cuttle.ai. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package db
+
+import (
+	"strings"
+
+	"github.com/cuttle-ai/file-uploader-service/config"
+	"github.com/cuttle-ai/file-uploader-service/models"
+	"github.com/jinzhu/gorm"
+)
+
+//GetDatasetStages returns the order of the stages of the pipeline of the dataset. Nil is returned if the dataset doesn't override the order
+func GetDatasetStages(a *config.AppContext, datasetID uint) ([]string, error) {
+	p := &models.DatasetPipeline{}
+	err := a.Db.Where("dataset_id = ?", datasetID).First(p).Error
+	if gorm.IsRecordNotFoundError(err) {
+		return nil, nil
+	}
+	if err != nil || len(p.Stages) == 0 {
+		return nil, err
+	}
+	return strings.Split(p.Stages, ","), nil
+}
+
+//SetDatasetStages sets the order of the stages of the pipeline of the dataset. The override is removed if no stages are given
+func SetDatasetStages(a *config.AppContext, datasetID uint, stages []string) error {
+	p := &models.DatasetPipeline{}
+	return a.Db.Where(models.DatasetPipeline{DatasetID: datasetID}).Assign(map[string]interface{}{
+		"stages": strings.Join(stages, ","),
+	}).FirstOrCreate(p).Error
+}
@@ -0,0 +1,79 @@
+// Copyright 2019 Cuttle.ai. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package db
+
+import (
+	"time"
+
+	"github.com/cuttle-ai/file-uploader-service/config"
+	"github.com/cuttle-ai/file-uploader-service/models"
+)
+
+//RefreshSchedule is the type alias for models.RefreshSchedule
+type RefreshSchedule models.RefreshSchedule
+
+//RefreshRun is the type alias for models.RefreshRun
+type RefreshRun models.RefreshRun
+
+//Save creates the refresh schedule for the file upload or updates the existing one
+func (r *RefreshSchedule) Save(a *config.AppContext) error {
+	return a.Db.Where("file_upload_id = ?", r.FileUploadID).Assign(map[string]interface{}{
+		"user_id":       r.UserID,
+		"cron":          r.Cron,
+		"append":        r.Append,
+		"headers":       r.Headers,
+		"e_tag":         r.ETag,
+		"last_modified": r.LastModified,
+		"next_run_at":   r.NextRunAt,
+	}).FirstOrCreate(r).Error
+}
+
+//Get returns the refresh schedule of the file upload from the database
+func (r *RefreshSchedule) Get(a *config.AppContext) error {
+	return a.Db.Where("user_id = ? and file_upload_id = ?", a.Session.User.ID, r.FileUploadID).Find(r).Error
+}
+
+//Delete deletes the refresh schedule of the file upload from the database
+func (r RefreshSchedule) Delete(a *config.AppContext) error {
+	return a.Db.Unscoped().Where("user_id = ? and file_upload_id = ?", a.Session.User.ID, r.FileUploadID).Delete(&models.RefreshSchedule{}).Error
+}
+
+//Claim moves the next run of the schedule to the given time. The update happens only if the next run in the db is
+//the same as that of the schedule so that a run is claimed only once even if there are multiple instances of the service
+func (r *RefreshSchedule) Claim(a *config.AppContext, next time.Time) (bool, error) {
+	d := a.Db.Model(r).Where("next_run_at = ?", r.NextRunAt).Updates(map[string]interface{}{
+		"next_run_at": next,
+	})
+	return d.RowsAffected == 1, d.Error
+}
+
+//UpdateValidators updates the etag and the last modified time of the remote file as per the last run
+func (r *RefreshSchedule) UpdateValidators(a *config.AppContext, etag string, lastModified string) error {
+	r.ETag = etag
+	r.LastModified = lastModified
+	return a.Db.Model(r).Updates(map[string]interface{}{
+		"e_tag":         etag,
+		"last_modified": lastModified,
+	}).Error
+}
+
+//GetDueRefreshSchedules returns the refresh schedules of all the users which are due by the given time
+func GetDueRefreshSchedules(a *config.AppContext, t time.Time) ([]RefreshSchedule, error) {
+	results := []RefreshSchedule{}
+	err := a.Db.Where("next_run_at <= ?", t).Find(&results).Error
+	return results, err
+}
+
+//Create creates the refresh run record in the database
+func (r *RefreshRun) Create(a *config.AppContext) error {
+	return a.Db.Create(r).Error
+}
+
+//GetRefreshRuns returns the latest refresh runs of the file upload. Limit is the max no. of runs to be returned
+func GetRefreshRuns(a *config.AppContext, fileUploadID uint, limit int) ([]RefreshRun, error) {
+	results := []RefreshRun{}
+	err := a.Db.Where("user_id = ? and file_upload_id = ?", a.Session.User.ID, fileUploadID).Order("id desc").Limit(limit).Find(&results).Error
+	return results, err
+}
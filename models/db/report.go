@@ -0,0 +1,68 @@
+// Copyright 2019 Cuttle.ai. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package db
+
+import (
+	"time"
+
+	"github.com/cuttle-ai/file-uploader-service/config"
+	"github.com/cuttle-ai/file-uploader-service/models"
+)
+
+//StageStats are the metrics of a stage aggregated across the runs of the pipeline
+type StageStats struct {
+	//Stage is the name of the stage
+	Stage string
+	//FileType is the type of the files for which the metrics are aggregated. Empty for the aggregate across all the file types
+	FileType string
+	//Runs is the no. of runs of the stage
+	Runs int64
+	//Failed is the no. of runs of the stage which failed
+	Failed int64
+	//P50 is the median time taken by the stage in milliseconds
+	P50 float64
+	//P95 is the 95th percentile of the time taken by the stage in milliseconds
+	P95 float64
+	//AvgRows is the avg no. of rows processed by the stage
+	AvgRows float64
+	//AvgBytesWritten is the avg no. of bytes written to the datastore by the stage
+	AvgBytesWritten float64
+	//RowsRejected is the total no. of rows rejected by the stage
+	RowsRejected int64
+}
+
+//GetFileUploadJobs returns the latest jobs run on the file upload with the latest job first
+func GetFileUploadJobs(a *config.AppContext, fileUploadID uint, limit int) ([]Job, error) {
+	results := []Job{}
+	err := a.Db.Where("file_upload_id = ?", fileUploadID).Order("id desc").Limit(limit).Find(&results).Error
+	return results, err
+}
+
+//GetStagesOfJobs returns the stages of the given jobs in the order in which they ran
+func GetStagesOfJobs(a *config.AppContext, jobIDs []uint) ([]JobStage, error) {
+	results := []JobStage{}
+	if len(jobIDs) == 0 {
+		return results, nil
+	}
+	err := a.Db.Where("job_id in (?)", jobIDs).Order("id").Find(&results).Error
+	return results, err
+}
+
+//GetStageStats returns the metrics of the stages which finished since the given time aggregated per stage and per stage and file type.
+//The cancelled and the skipped stages are left out
+func GetStageStats(a *config.AppContext, since time.Time) ([]StageStats, error) {
+	results := []StageStats{}
+	err := a.Db.Raw(`select s.stage, coalesce(f.type, '') as file_type, count(*) as runs,
+		count(*) filter (where s.status = ?) as failed,
+		percentile_cont(0.5) within group (order by extract(epoch from s.finished_at - s.started_at) * 1000) as p50,
+		percentile_cont(0.95) within group (order by extract(epoch from s.finished_at - s.started_at) * 1000) as p95,
+		avg(s.rows) as avg_rows, avg(s.bytes_written) as avg_bytes_written, sum(s.rows_rejected) as rows_rejected
+		from job_stages s join jobs j on j.id = s.job_id join file_uploads f on f.id = j.file_upload_id
+		where s.deleted_at is null and s.status in (?) and s.finished_at >= ?
+		group by grouping sets ((s.stage), (s.stage, f.type))
+		order by s.stage, file_type`,
+		models.JobStatusFailed, []string{models.JobStatusSucceeded, models.JobStatusFailed}, since).Scan(&results).Error
+	return results, err
+}
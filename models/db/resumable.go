@@ -0,0 +1,58 @@
+// Copyright 2019 Cuttle.ai. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package db
+
+import (
+	"errors"
+
+	"github.com/cuttle-ai/file-uploader-service/config"
+	"github.com/cuttle-ai/file-uploader-service/models"
+)
+
+//ErrFinalized is returned while finalizing a resumable upload which is already finalized
+var ErrFinalized = errors.New("resumable upload is already finalized")
+
+//ResumableUpload is the type alias for models.ResumableUpload
+type ResumableUpload models.ResumableUpload
+
+//Create creates the resumable upload record in the database
+func (r *ResumableUpload) Create(a *config.AppContext) error {
+	return a.Db.Create(r).Error
+}
+
+//Get returns the resumable upload info from the database
+func (r *ResumableUpload) Get(a *config.AppContext) error {
+	return a.Db.Where("user_id = ? and id = ?", a.Session.User.ID, r.ID).Find(r).Error
+}
+
+//UpdateOffset updates the offset of the resumable upload. The update happens only if the offset in the db is
+//the same as the given previous offset so that concurrent chunks for the same upload can't overwrite each other
+func (r *ResumableUpload) UpdateOffset(a *config.AppContext, previous int64) (bool, error) {
+	d := a.Db.Model(r).Where("upload_offset = ?", previous).Updates(map[string]interface{}{
+		"upload_offset": r.UploadOffset,
+	})
+	return d.RowsAffected == 1, d.Error
+}
+
+//Finalize marks the resumable upload as finalized. ErrFinalized is returned if the upload was already finalized,
+//so that the datasets of an upload are stored only once when it is finalized in the transaction storing them
+func (r *ResumableUpload) Finalize(a *config.AppContext) error {
+	d := a.Db.Model(r).Where("finalized = ?", false).Updates(map[string]interface{}{
+		"finalized": true,
+	})
+	if d.Error != nil {
+		return d.Error
+	}
+	if d.RowsAffected == 0 {
+		return ErrFinalized
+	}
+	r.Finalized = true
+	return nil
+}
+
+//Delete deletes the resumable upload from the database
+func (r ResumableUpload) Delete(a *config.AppContext) error {
+	return a.Db.Where("user_id = ? and id = ?", a.Session.User.ID, r.ID).Delete(&models.ResumableUpload{}).Error
+}
@@ -0,0 +1,76 @@
+// Copyright 2019 Cuttle.ai. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package db
+
+import (
+	"github.com/cuttle-ai/file-uploader-service/config"
+	"github.com/cuttle-ai/file-uploader-service/models"
+)
+
+//GetDatasetRules returns the rules of the dataset in the order in which they were added
+func GetDatasetRules(a *config.AppContext, datasetID uint) ([]models.DatasetRule, error) {
+	results := []models.DatasetRule{}
+	err := a.Db.Where("dataset_id = ?", datasetID).Order("id").Find(&results).Error
+	return results, err
+}
+
+//HasDatasetRuleOfType returns true if the dataset has a rule of the given type
+func HasDatasetRuleOfType(a *config.AppContext, datasetID uint, ruleType string) (bool, error) {
+	n := 0
+	err := a.Db.Model(&models.DatasetRule{}).Where("dataset_id = ? and type = ?", datasetID, ruleType).Count(&n).Error
+	return n != 0, err
+}
+
+//IsAppended returns true if the dataset is appended to, either by a job appending to it or by a refresh schedule of its file upload appending to it
+func IsAppended(a *config.AppContext, datasetID uint, fileUploadID uint) (bool, error) {
+	n := 0
+	err := a.Db.Model(&models.Job{}).Where("dataset_id = ? and append = ?", datasetID, true).Count(&n).Error
+	if err != nil || n != 0 {
+		return n != 0, err
+	}
+	err = a.Db.Model(&models.RefreshSchedule{}).Where("file_upload_id = ? and append = ?", fileUploadID, true).Count(&n).Error
+	return n != 0, err
+}
+
+//SetDatasetRules replaces the rules of the dataset with the given rules. The rules are removed if no rules are given
+func SetDatasetRules(a *config.AppContext, datasetID uint, rules []models.DatasetRule) error {
+	/*
+	 * We will start the transaction
+	 * Then we will delete the existing rules
+	 * Then we will create the given rules
+	 */
+	//starting the transaction
+	tx := a.Db.Begin()
+	defer func() {
+		if r := recover(); r != nil {
+			tx.Rollback()
+		}
+	}()
+	if err := tx.Error; err != nil {
+		//error while beginning the transaction
+		return err
+	}
+
+	//deleting the existing rules
+	if err := tx.Where("dataset_id = ?", datasetID).Delete(&models.DatasetRule{}).Error; err != nil {
+		//error while deleting the rules
+		tx.Rollback()
+		a.Log.Error("error while deleting the existing rules of the dataset", datasetID)
+		return err
+	}
+
+	//creating the rules
+	for i := range rules {
+		rules[i].ID = 0
+		rules[i].DatasetID = datasetID
+		if err := tx.Create(&rules[i]).Error; err != nil {
+			//error while creating the rule
+			tx.Rollback()
+			a.Log.Error("error while creating the rule of the dataset", datasetID)
+			return err
+		}
+	}
+	return tx.Commit().Error
+}
@@ -0,0 +1,28 @@
+// Copyright 2019 Cuttle.ai. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package db
+
+import (
+	"database/sql"
+
+	"github.com/cuttle-ai/file-uploader-service/config"
+	"github.com/jinzhu/gorm"
+)
+
+//InTransaction runs the function with a copy of the app context whose database is in a transaction. The transaction is committed
+//if the function succeeds, else it is rolled back. So the records stored by the function are either stored together or not at all
+func InTransaction(a *config.AppContext, fn func(txCtx *config.AppContext) error) error {
+	return a.Db.Transaction(func(tx *gorm.DB) error {
+		txCtx := *a
+		txCtx.Db = tx
+		return fn(&txCtx)
+	})
+}
+
+//inTransaction returns true if the database of the app context is in a transaction
+func inTransaction(a *config.AppContext) bool {
+	_, ok := a.Db.CommonDB().(*sql.Tx)
+	return ok
+}
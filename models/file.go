@@ -12,17 +12,151 @@ import (
 const (
 	//FileUploadStatusUploaded indicates that the file has been uploaded
 	FileUploadStatusUploaded = "UPLOADED"
-	//FileUploadStatusValidatingError indicates that the validation occurred due to some error
-	FileUploadStatusValidatingError = "VALIDATING_ERROR"
-	//FileUploadStatusValidated indicates that the validation process is completed. The errors will be available in the file upoload error records for the file
+	//FileUploadStatusValidating indicates that the file is being validated
+	FileUploadStatusValidating = "VALIDATING"
+	//FileUploadStatusValidated indicates that the validation process is completed without any errors
 	FileUploadStatusValidated = "VALIDATED"
+	//FileUploadStatusInvalid indicates that the validation found errors in the file or couldn't complete due to some error.
+	//The errors will be available in the file upload error records for the file
+	FileUploadStatusInvalid = "INVALID"
+	//FileUploadStatusIdentifyingColumns indicates that the columns of the file are being identified
+	FileUploadStatusIdentifyingColumns = "IDENTIFYING_COLUMNS"
+	//FileUploadStatusLoading indicates that the file is being loaded to the datastore
+	FileUploadStatusLoading = "LOADING"
+	//FileUploadStatusLoaded indicates that the file has been loaded to the datastore
+	FileUploadStatusLoaded = "LOADED"
+	//FileUploadStatusOptimizing indicates that the metadata of the dataset is being optimized and the dictionary is being updated
+	FileUploadStatusOptimizing = "OPTIMIZING"
+	//FileUploadStatusReady indicates that the dataset of the file is ready to be queried
+	FileUploadStatusReady = "READY"
+	//FileUploadStatusFailed indicates that the processing of the file failed
+	FileUploadStatusFailed = "FAILED"
+	//FileUploadStatusCancelled indicates that the processing of the file was cancelled by the user
+	FileUploadStatusCancelled = "CANCELLED"
 )
 
+//fileUploadStages are the statuses with which the stages of the processing start.
+//They can be moved to from any status after validation so that a pipeline can be retried, recovered or reprocessed from a stage
+var fileUploadStages = []string{
+	FileUploadStatusValidating,
+	FileUploadStatusIdentifyingColumns,
+	FileUploadStatusLoading,
+	FileUploadStatusOptimizing,
+}
+
+//FileUploadStatusTransitions has the statuses to which a file upload can move from a status
+var FileUploadStatusTransitions = map[string][]string{
+	FileUploadStatusUploaded:           {FileUploadStatusValidating, FileUploadStatusFailed, FileUploadStatusCancelled},
+	FileUploadStatusValidating:         append([]string{FileUploadStatusValidated, FileUploadStatusInvalid, FileUploadStatusFailed, FileUploadStatusCancelled}, fileUploadStages...),
+	FileUploadStatusValidated:          append([]string{FileUploadStatusUploaded, FileUploadStatusCancelled}, fileUploadStages...),
+	FileUploadStatusInvalid:            {FileUploadStatusUploaded, FileUploadStatusValidating},
+	FileUploadStatusIdentifyingColumns: append([]string{FileUploadStatusValidated, FileUploadStatusFailed, FileUploadStatusCancelled}, fileUploadStages...),
+	FileUploadStatusLoading:            append([]string{FileUploadStatusLoaded, FileUploadStatusFailed, FileUploadStatusCancelled}, fileUploadStages...),
+	FileUploadStatusLoaded:             append([]string{FileUploadStatusUploaded}, fileUploadStages...),
+	FileUploadStatusOptimizing:         append([]string{FileUploadStatusReady, FileUploadStatusFailed}, fileUploadStages...),
+	FileUploadStatusReady:              append([]string{FileUploadStatusUploaded}, fileUploadStages...),
+	FileUploadStatusFailed:             append([]string{FileUploadStatusUploaded}, fileUploadStages...),
+	FileUploadStatusCancelled:          append([]string{FileUploadStatusUploaded}, fileUploadStages...),
+}
+
+//CanTransition returns true if a file upload can move from the given status to the other.
+//Moving to the same status is always allowed. Files uploaded before the statuses were introduced
+//with the status VALIDATING_ERROR are treated as invalid
+func CanTransition(from, to string) bool {
+	if from == to {
+		return true
+	}
+	if from == "VALIDATING_ERROR" {
+		from = FileUploadStatusInvalid
+	}
+	for _, v := range FileUploadStatusTransitions[from] {
+		if v == to {
+			return true
+		}
+	}
+	return false
+}
+
 const (
 	//FileUploadTypeCSV indicates that the uploaded file's type is csv
 	FileUploadTypeCSV = "CSV"
+	//FileUploadTypeXLSX indicates that the uploaded file's type is excel workbook (xlsx)
+	FileUploadTypeXLSX = "XLSX"
+	//FileUploadTypeJSON indicates that the uploaded file's type is json. It can be either an array of objects or newline delimited objects
+	FileUploadTypeJSON = "JSON"
+	//FileUploadTypeParquet indicates that the uploaded file's type is apache parquet
+	FileUploadTypeParquet = "PARQUET"
 )
 
+const (
+	//FileUploadErrorCodeFieldCount indicates that a row has more or less values than the columns in the header
+	FileUploadErrorCodeFieldCount = "FIELD_COUNT"
+	//FileUploadErrorCodeParse indicates that a row couldn't be parsed like a bare quote in a delimited file
+	FileUploadErrorCodeParse = "PARSE_ERROR"
+	//FileUploadErrorCodeHeader indicates that a column in the header is empty or repeated
+	FileUploadErrorCodeHeader = "INVALID_HEADER"
+	//FileUploadErrorCodeRecord indicates that a record couldn't be read or flattened into rows like a json record which is not an object
+	FileUploadErrorCodeRecord = "INVALID_RECORD"
+	//FileUploadErrorCodeSchema indicates that a column in the schema of the file is not supported like the nested columns
+	FileUploadErrorCodeSchema = "UNSUPPORTED_SCHEMA"
+	//FileUploadErrorCodeUnknown is the code of the errors reported by the file formats without a position in the file
+	FileUploadErrorCodeUnknown = "UNKNOWN"
+	//FileUploadErrorCodeMissingColumn indicates that a column required by the rules of the dataset is not there in the file
+	FileUploadErrorCodeMissingColumn = "MISSING_COLUMN"
+	//FileUploadErrorCodeNullValue indicates that a value is empty in a column which can't be null as per the rules of the dataset
+	FileUploadErrorCodeNullValue = "NULL_VALUE"
+	//FileUploadErrorCodeOutOfRange indicates that a value is not a number or is beyond the range allowed by the rules of the dataset
+	FileUploadErrorCodeOutOfRange = "OUT_OF_RANGE"
+	//FileUploadErrorCodePatternMismatch indicates that a value doesn't match the pattern of the column in the rules of the dataset
+	FileUploadErrorCodePatternMismatch = "PATTERN_MISMATCH"
+	//FileUploadErrorCodeInvalidValue indicates that a value is not one of the values allowed by the rules of the dataset
+	FileUploadErrorCodeInvalidValue = "INVALID_VALUE"
+	//FileUploadErrorCodeDuplicateValue indicates that a value is repeated in a column which has to be unique as per the rules of the dataset
+	FileUploadErrorCodeDuplicateValue = "DUPLICATE_VALUE"
+)
+
+const (
+	//FileUploadErrorSeverityError indicates that the error makes the file invalid or its row is skipped as per the error policy of the upload
+	FileUploadErrorSeverityError = "ERROR"
+	//FileUploadErrorSeverityWarning indicates that the error doesn't make the file invalid like a row whose values were coerced as per the error policy
+	FileUploadErrorSeverityWarning = "WARNING"
+)
+
+const (
+	//FileUploadErrorPolicyStrict fails the processing of the file if any error is found while validating it
+	FileUploadErrorPolicyStrict = "STRICT"
+	//FileUploadErrorPolicySkip leaves out the rows with errors while loading the file
+	FileUploadErrorPolicySkip = "SKIP"
+	//FileUploadErrorPolicyCoerce loads the rows with errors after setting their bad values to null.
+	//The rows which can't be coerced like the ones which couldn't be parsed are left out
+	FileUploadErrorPolicyCoerce = "COERCE"
+)
+
+//FileUploadOptions has the options with which an uploaded file has to be parsed.
+//They are stored along with the file upload so that re-validation and re-uploads use the same options
+type FileUploadOptions struct {
+	//ExplodeArrays will create a row for each element of the arrays found in the json records. If false such records are rejected
+	ExplodeArrays bool
+	//Delimiter is the character separating the fields in delimited files. Default is comma
+	Delimiter string
+	//Quote is the character used for quoting the fields in delimited files. Default is double quotes
+	Quote string
+	//Comment is the character with which the comment lines start in delimited files. Empty if there are no comments
+	Comment string
+	//Headerless indicates that the delimited file doesn't have a header row
+	Headerless bool
+	//SkipLines is the no. of lines to be skipped from the start of the delimited file
+	SkipLines int
+	//ErrorPolicy is the policy with which the rows with errors are handled. It is one of the FileUploadErrorPolicy constants. Strict if empty
+	ErrorPolicy string
+	//MaxErrors is the max no. of rows with errors which can be skipped or coerced when the error policy is not strict. Zero for no limit
+	MaxErrors int64
+	//MaxErrorPercent is the max percentage of the rows with errors which can be skipped or coerced when the error policy is not strict. Zero for no limit
+	MaxErrorPercent float64
+	//Charset is the character encoding of the text files like windows-1252 or utf-16. It is detected from the file if empty
+	Charset string
+}
+
 //FileUpload represents the file uploads in the system
 type FileUpload struct {
 	gorm.Model
@@ -36,15 +170,58 @@ type FileUpload struct {
 	Type string
 	//Status is the status of the uploaded file
 	Status string
+	//Sheet is the name of the sheet in the workbook from which the dataset is created. Applicable only for spreadsheets
+	Sheet string
+	//Options are the options with which the file has to be parsed
+	Options FileUploadOptions `gorm:"embedded"`
+	//Checksum is the hex encoded sha256 checksum of the uploaded file
+	Checksum string
+	//Size is the size of the uploaded file in bytes
+	Size int64
+	//SourceURL is the url from which the file was imported. Empty if the file was uploaded
+	SourceURL string
+	//RowsSkipped is the no. of rows with errors left out by the last validation as per the error policy
+	RowsSkipped int64
+	//RowsCoerced is the no. of rows with errors whose bad values were set to null by the last validation as per the error policy
+	RowsCoerced int64
+	//Encoding is the character encoding in which the file was uploaded. Text files in other encodings are transcoded to utf-8 before processing them
+	Encoding string
 }
 
-//FileUploadError stores the errors happened while uploading a file
+//FileUploadError stores the errors happened while uploading a file along with their position in the file
 type FileUploadError struct {
 	gorm.Model
 	//FileUploadID is the id of the upload
-	FileUploadID uint
+	FileUploadID uint `gorm:"index"`
 	//Error is the error associated with the file upload
 	Error string
+	//Row is the no. of the data row or record in the file starting from 1. Zero if the error is not in a row like an error in the schema
+	Row int64
+	//Line is the line in the file at which the error occurred. Zero if not known
+	Line int64
+	//Column is the index of the column starting from 1. Zero if not known
+	Column int
+	//ColumnName is the name of the column. Empty if not known
+	ColumnName string
+	//Code is the code of the error. It is one of the FileUploadErrorCode constants
+	Code string `gorm:"index"`
+	//Value is a snippet of the offending value
+	Value string `gorm:"type:text"`
+	//Severity is the severity of the error. It is one of the FileUploadErrorSeverity constants
+	Severity string
+}
+
+//FileUploadStatusHistory is a transition of the status of a file upload
+type FileUploadStatusHistory struct {
+	gorm.Model
+	//FileUploadID is the id of the upload
+	FileUploadID uint `gorm:"index"`
+	//FromStatus is the status from which the file upload moved. Empty for a new upload
+	FromStatus string
+	//ToStatus is the status to which the file upload moved
+	ToStatus string
+	//Reason is the reason for the transition like the error with which the processing failed
+	Reason string `gorm:"type:text"`
 }
 
 //FileDataset has the info about an uploaded datatset and its errors
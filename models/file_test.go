@@ -0,0 +1,42 @@
+// Copyright 2019 Cuttle.ai. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package models_test
+
+import (
+	"testing"
+
+	"github.com/cuttle-ai/file-uploader-service/models"
+)
+
+/*
+ * This file contains the tests for the status transitions of the file uploads
+ */
+
+func TestCanTransition(t *testing.T) {
+	cases := []struct {
+		from    string
+		to      string
+		allowed bool
+	}{
+		{models.FileUploadStatusUploaded, models.FileUploadStatusValidating, true},
+		{models.FileUploadStatusUploaded, models.FileUploadStatusLoading, false},
+		{models.FileUploadStatusValidating, models.FileUploadStatusInvalid, true},
+		{models.FileUploadStatusInvalid, models.FileUploadStatusLoading, false},
+		{models.FileUploadStatusInvalid, models.FileUploadStatusFailed, false},
+		{models.FileUploadStatusValidated, models.FileUploadStatusIdentifyingColumns, true},
+		{models.FileUploadStatusLoading, models.FileUploadStatusUploaded, false},
+		{models.FileUploadStatusOptimizing, models.FileUploadStatusReady, true},
+		{models.FileUploadStatusReady, models.FileUploadStatusCancelled, false},
+		{models.FileUploadStatusFailed, models.FileUploadStatusOptimizing, true},
+		{models.FileUploadStatusCancelled, models.FileUploadStatusUploaded, true},
+		{models.FileUploadStatusReady, models.FileUploadStatusReady, true},
+		{"VALIDATING_ERROR", models.FileUploadStatusValidating, true},
+	}
+	for i, c := range cases {
+		if got := models.CanTransition(c.from, c.to); got != c.allowed {
+			t.Error("test case", i+1, "expected the transition from", c.from, "to", c.to, "to be allowed as", c.allowed, "got", got)
+		}
+	}
+}
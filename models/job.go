@@ -0,0 +1,163 @@
+// Copyright 2019 Cuttle.ai. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package models
+
+import (
+	"time"
+
+	"github.com/jinzhu/gorm"
+)
+
+const (
+	//JobTypePipeline is the job running the whole pipeline of uploading a file to the datastore
+	JobTypePipeline = "PIPELINE"
+	//JobTypeValidate is the job validating a file
+	JobTypeValidate = "VALIDATE"
+	//JobTypeProcessColumns is the job identifying the columns in a file
+	JobTypeProcessColumns = "PROCESS_COLUMNS"
+	//JobTypeUploadToDatastore is the job uploading a file to the datastore
+	JobTypeUploadToDatastore = "UPLOAD_TO_DATASTORE"
+	//JobTypeDeleteDataset is the job deleting a dataset from the platform
+	JobTypeDeleteDataset = "DELETE_DATASET"
+	//JobTypeImport is the job importing a file published over http(s) as a dataset
+	JobTypeImport = "IMPORT"
+)
+
+const (
+	//JobStatusQueued indicates that the job is waiting for a worker
+	JobStatusQueued = "QUEUED"
+	//JobStatusRunning indicates that the job has been claimed by a worker
+	JobStatusRunning = "RUNNING"
+	//JobStatusSucceeded indicates that the job has completed successfully
+	JobStatusSucceeded = "SUCCEEDED"
+	//JobStatusFailed indicates that the job has failed. The error is available in the last error of the job
+	JobStatusFailed = "FAILED"
+	//JobStatusCancelled indicates that the job was cancelled before it could complete
+	JobStatusCancelled = "CANCELLED"
+	//JobStatusSkipped indicates that the stage of the job was skipped since it had succeeded in an earlier run
+	JobStatusSkipped = "SKIPPED"
+)
+
+const (
+	//JobStageValidate is the stage validating the file
+	JobStageValidate = "VALIDATE"
+	//JobStageIdentifyColumns is the stage identifying the columns in the file
+	JobStageIdentifyColumns = "IDENTIFY_COLUMNS"
+	//JobStageApplyRules is the stage validating the rows of the file against the rules of the dataset
+	JobStageApplyRules = "APPLY_RULES"
+	//JobStageUpload is the stage uploading the file to the datastore
+	JobStageUpload = "UPLOAD"
+	//JobStageOptimize is the stage optimizing the metadata of the dataset
+	JobStageOptimize = "OPTIMIZE"
+	//JobStageDictUpdate is the stage updating the dict of the user in the octopus service
+	JobStageDictUpdate = "DICT_UPDATE"
+	//JobStageDelete is the stage deleting the dataset
+	JobStageDelete = "DELETE"
+	//JobStageDownload is the stage downloading the remote file to be imported
+	JobStageDownload = "DOWNLOAD"
+)
+
+//Job is a background job persisted in the database so that it survives the restarts of the service.
+//The jobs are claimed by the workers with row locks
+type Job struct {
+	gorm.Model
+	//Type is the type of the job. It is one of the JobType constants
+	Type string
+	//Status is the status of the job
+	Status string
+	//Stage is the current stage of the job
+	Stage string
+	//FileUploadID is the id of the file upload on which the job runs
+	FileUploadID uint
+	//DatasetID is the id of the dataset on which the job runs
+	DatasetID uint
+	//Append indicates that the data has to be appended to the dataset instead of replacing it
+	Append bool
+	//FromStage is the stage from which the pipeline has to be started. The stages before it are skipped since they had succeeded in an earlier run
+	FromStage string
+	//SourceURL is the http(s) url of the remote file to be imported. Applicable only for the import jobs
+	SourceURL string `gorm:"type:text"`
+	//Headers are the json encoded headers to be sent while downloading the remote file to be imported
+	Headers string `json:"-" gorm:"type:text"`
+	//Name is the name of the file to be imported. The name in the response of the remote file is used if empty
+	Name string
+	//Location is the location to which the remote file to be imported is downloaded
+	Location string `json:"-"`
+	//Options are the options with which the imported file has to be parsed
+	Options FileUploadOptions `gorm:"embedded"`
+	//UserID is the id of the user with whom the job is associated with
+	UserID uint
+	//UserType is the type of the user who created the job
+	UserType string `json:"-"`
+	//SessionID is the id of the session with which the job has to be run
+	SessionID string `json:"-"`
+	//CancelRequested indicates that the cancellation of the running job has been requested.
+	//The worker running the job checks it periodically and cancels the job
+	CancelRequested bool
+	//Attempts is the no. of times the job has been claimed by a worker
+	Attempts int
+	//LastError is the error with which the last attempt of the job failed
+	LastError string `gorm:"type:text"`
+	//StartedAt is the time at which the last attempt of the job started
+	StartedAt time.Time
+	//FinishedAt is the time at which the job finished
+	FinishedAt time.Time
+	//HeartbeatAt is the time at which the worker running the job reported last. Jobs with stale heartbeats are recovered
+	HeartbeatAt time.Time
+	//RunAfter is the time before which a postponed job is not claimed
+	RunAfter time.Time
+}
+
+//StageMetrics are the metrics of a stage of a job recorded to find out why some runs take longer
+type StageMetrics struct {
+	//Rows is the no. of rows processed in the stage. Zero if not known
+	Rows int64
+	//RowsRejected is the no. of rows rejected in the stage like the rows with validation errors
+	RowsRejected int64
+	//BytesWritten is the no. of bytes written to the datastore in the stage
+	BytesWritten int64
+	//DatastoreID is the id of the datastore chosen for the dataset in the stage. Zero if the stage didn't use a datastore
+	DatastoreID uint
+}
+
+//JobStage is a stage of a job. It records when the stage ran, its metrics and the error with which it failed
+type JobStage struct {
+	gorm.Model
+	//JobID is the id of the job to which the stage belongs
+	JobID uint `gorm:"index"`
+	//Stage is the name of the stage. It is one of the JobStage constants
+	Stage string
+	//Status is the status of the stage. It is one of the JobStatus constants
+	Status string
+	//StageMetrics are the metrics of the stage like the no. of rows processed in it
+	StageMetrics
+	//Duration is the time taken by the stage in milliseconds
+	Duration int64
+	//Error is the error with which the stage failed
+	Error string `gorm:"type:text"`
+	//StartedAt is the time at which the stage started
+	StartedAt time.Time
+	//FinishedAt is the time at which the stage finished
+	FinishedAt time.Time
+}
+
+//RetryAttempt is a failed attempt of a call to another service made by a job. They are recorded to find the flaky dependencies
+type RetryAttempt struct {
+	gorm.Model
+	//JobID is the id of the job which made the call
+	JobID uint `gorm:"index"`
+	//Stage is the stage of the job in which the call was made
+	Stage string
+	//Operation is the name of the call like datastores.ListDatastores
+	Operation string `gorm:"index"`
+	//Attempt is the no. of the attempt starting from 1
+	Attempt int
+	//Error is the error with which the attempt failed
+	Error string `gorm:"type:text"`
+	//Transient indicates that the error was classified as transient
+	Transient bool
+	//Backoff is the time waited before the next attempt in milliseconds. Zero if the call was not retried anymore
+	Backoff int64
+}
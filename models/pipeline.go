@@ -0,0 +1,18 @@
+// Copyright 2019 Cuttle.ai. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package models
+
+import (
+	"github.com/jinzhu/gorm"
+)
+
+//DatasetPipeline has the order of the stages of the pipeline of a dataset. It overrides the order of the file type of the dataset
+type DatasetPipeline struct {
+	gorm.Model
+	//DatasetID is the id of the dataset
+	DatasetID uint `gorm:"unique_index"`
+	//Stages are the names of the stages separated by commas in the order in which they run. Empty if the dataset doesn't override the order
+	Stages string
+}
@@ -0,0 +1,67 @@
+// Copyright 2019 Cuttle.ai. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package models
+
+import (
+	"time"
+
+	"github.com/jinzhu/gorm"
+)
+
+const (
+	//RefreshRunStatusSucceeded is the status of a refresh run where the file was downloaded and its pipeline was enqueued
+	RefreshRunStatusSucceeded = "SUCCEEDED"
+	//RefreshRunStatusSkipped is the status of a refresh run where the remote file hasn't changed since the last run
+	RefreshRunStatusSkipped = "SKIPPED"
+	//RefreshRunStatusFailed is the status of a refresh run which failed
+	RefreshRunStatusFailed = "FAILED"
+)
+
+//RefreshSchedule is the schedule to refresh a dataset imported from a remote url.
+//There can be only one schedule for a file upload
+type RefreshSchedule struct {
+	gorm.Model
+	//FileUploadID is the id of the file upload to be refreshed
+	FileUploadID uint `gorm:"unique_index"`
+	//UserID is the id of the user with whom the schedule is associated with
+	UserID uint
+	//Cron is the cron expression as per which the dataset has to be refreshed
+	Cron string
+	//Append indicates that the data from the remote file has to be appended to the dataset instead of replacing it
+	Append bool
+	//Headers are the json encoded headers to be sent while downloading the remote file
+	Headers string `json:"-" gorm:"type:text"`
+	//ETag is the etag of the remote file as per the last run
+	ETag string
+	//LastModified is the last modified time of the remote file as per the last run
+	LastModified string
+	//NextRunAt is the time at which the dataset has to be refreshed next
+	NextRunAt time.Time
+}
+
+//RefreshRun is the outcome of a run of a refresh schedule
+type RefreshRun struct {
+	gorm.Model
+	//RefreshScheduleID is the id of the schedule as per which the run happened
+	RefreshScheduleID uint
+	//FileUploadID is the id of the file upload refreshed
+	FileUploadID uint
+	//UserID is the id of the user with whom the run is associated with
+	UserID uint
+	//Status is the status of the run
+	Status string
+	//Error is the error occurred during the run if any
+	Error string `gorm:"type:text"`
+	//Checksum is the checksum of the remote file downloaded
+	Checksum string
+	//Size is the size of the remote file downloaded in bytes
+	Size int64
+	//JobID is the id of the pipeline job enqueued for the refreshed file
+	JobID uint
+	//StartedAt is the time at which the run started
+	StartedAt time.Time
+	//FinishedAt is the time at which the run finished
+	FinishedAt time.Time
+}
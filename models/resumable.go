@@ -0,0 +1,31 @@
+// Copyright 2019 Cuttle.ai. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package models
+
+import (
+	"github.com/jinzhu/gorm"
+)
+
+//ResumableUpload represents a file being uploaded in chunks as per the tus protocol.
+//Once all the chunks are received, the upload is finalized and processed like a normal file upload
+type ResumableUpload struct {
+	gorm.Model
+	//Name is the name of the uploaded file
+	Name string
+	//MimeType is the mime type of the uploaded file
+	MimeType string
+	//UserID is the id of the user with whom the upload is associated with
+	UserID uint
+	//Location is the location where the partial file is stored
+	Location string
+	//Length is the total size of the file in bytes
+	Length int64
+	//UploadOffset is the no. of bytes received so far
+	UploadOffset int64
+	//Finalized indicates that the upload is complete and the file has been processed
+	Finalized bool
+	//Options are the options with which the file has to be parsed
+	Options FileUploadOptions `gorm:"embedded"`
+}
@@ -0,0 +1,48 @@
+// Copyright 2019 Cuttle.ai. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package models
+
+import (
+	"github.com/jinzhu/gorm"
+	"github.com/lib/pq"
+)
+
+const (
+	//DatasetRuleTypeRequired checks that the column is there in the header of the file
+	DatasetRuleTypeRequired = "REQUIRED"
+	//DatasetRuleTypeNotNull checks that the values of the column are not empty
+	DatasetRuleTypeNotNull = "NOT_NULL"
+	//DatasetRuleTypeRange checks that the values of the column are numbers between the min and max of the rule
+	DatasetRuleTypeRange = "RANGE"
+	//DatasetRuleTypePattern checks that the values of the column match the regular expression of the rule
+	DatasetRuleTypePattern = "PATTERN"
+	//DatasetRuleTypeEnum checks that the values of the column are one of the allowed values of the rule
+	DatasetRuleTypeEnum = "ENUM"
+	//DatasetRuleTypeUnique checks that the values of the column are not repeated in the file.
+	//The values already loaded into the dataset are not checked, so a dataset with the unique rules can't be appended to
+	DatasetRuleTypeUnique = "UNIQUE"
+)
+
+//DatasetRule is a business rule validated on the rows of the files uploaded and appended to a dataset.
+//The empty values are validated only by the not null rules
+type DatasetRule struct {
+	gorm.Model
+	//DatasetID is the id of the dataset
+	DatasetID uint `gorm:"index"`
+	//Column is the name of the column in the header of the file
+	Column string
+	//Type is the type of the rule. It is one of the DatasetRuleType constants
+	Type string
+	//Min is the min value allowed in the column for the range rules. Nil if there is no lower limit
+	Min *float64
+	//Max is the max value allowed in the column for the range rules. Nil if there is no upper limit
+	Max *float64
+	//Pattern is the regular expression which the whole value has to match for the pattern rules
+	Pattern string
+	//Values are the values allowed in the column for the enum rules
+	Values pq.StringArray `gorm:"type:text[]"`
+	//Severity is the severity of the violations of the rule. It is one of the FileUploadErrorSeverity constants. Error if empty
+	Severity string
+}
@@ -39,6 +39,20 @@ func SendProcessedStatus(appCtx *config.AppContext, done float32, documentName s
 	}
 }
 
+//SendDownloadStatus will send the download status of a file imported from a remote url to the users frontend client.
+// total parameter should be -1 if the size of the file is not known
+func SendDownloadStatus(appCtx *config.AppContext, downloaded int64, total int64, documentName string) {
+	payload := fmt.Sprintf("downloaded %d bytes of %s", downloaded, documentName)
+	if total > 0 {
+		payload = fmt.Sprintf("downloaded %d of %d bytes of %s", downloaded, total, documentName)
+	}
+	err := sendNotification(appCtx, models.Notification{Payload: payload})
+	if err != nil {
+		//error while sending websocket notitication to user's client
+		appCtx.Log.Error("error while sending download status to users' frontend client", err)
+	}
+}
+
 //SendInfoMessage will send info messages to the users's frontend client
 func SendInfoMessage(appCtx *config.AppContext, message string) {
 	err := sendNotification(appCtx, models.Notification{Payload: message})
@@ -0,0 +1,403 @@
+// Copyright 2019 Cuttle.ai. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+//Package pipeline has the registry of the stages of the pipeline processing an uploaded file.
+//The built-in stages like validation and the upload to the datastore are registered by the routes of the files.
+//Custom stages like scanning for personal information or filtering the rows can be added by registering them in an init function,
+//and placing them in the order of the stages of the file types or the datasets. Hooks can be run before and after the stages
+package pipeline
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+
+	"github.com/cuttle-ai/file-uploader-service/config"
+	libfile "github.com/cuttle-ai/file-uploader-service/file"
+	"github.com/cuttle-ai/file-uploader-service/jobs"
+	"github.com/cuttle-ai/file-uploader-service/models"
+	"github.com/cuttle-ai/file-uploader-service/models/db"
+	"github.com/cuttle-ai/file-uploader-service/notifications"
+)
+
+//AllStages is the name with which a hook is registered to run before or after all the stages
+const AllStages = "*"
+
+var (
+	//ErrUnknownStage is returned when a stage in the order of the stages is not registered
+	ErrUnknownStage = errors.New("stage is not registered")
+	//ErrMissingStage is returned when a required stage is missing in the order of the stages
+	ErrMissingStage = errors.New("required stage is missing")
+	//ErrStageOrder is returned when the stages are not in the required order
+	ErrStageOrder = errors.New("stages are not in the required order")
+)
+
+//State is the state of a pipeline run shared by its stages
+type State struct {
+	//App is the app context with the session of the user who started the pipeline
+	App *config.AppContext
+	//Job is the job running the pipeline
+	Job *db.Job
+	//Upload is the file upload being processed
+	Upload *db.FileUpload
+	//File is the file of the file upload
+	File libfile.File
+	//Dataset is the dataset of the file upload
+	Dataset *db.Dataset
+	//Append indicates that the data of the file is appended to the existing data of the dataset
+	Append bool
+	//Metrics are the metrics of the running stage like the no. of rows processed by it. The stages set them so that they are recorded with the stage of the job
+	Metrics models.StageMetrics
+}
+
+//Stage is a stage of the pipeline
+type Stage struct {
+	//Name is the unique name of the stage. It is recorded with the stages of the jobs and is used for ordering the stages
+	Name string
+	//Status is optional. It is the status to which the file upload moves when the stage starts
+	Status string
+	//Commits indicates that the stage commits the data to the datastore. The stages after it are run even if the pipeline is cancelled
+	Commits bool
+	//Applies is optional. It returns false if the stage has to be left out of a pipeline run like identifying the columns while appending the data.
+	//The file and the job of the state are not available while checking it
+	Applies func(s *State) bool
+	//Run runs the stage. The stage stops when the context is cancelled unless a stage committing the data has run before it
+	Run func(ctx context.Context, s *State) error
+}
+
+//Hook is run before or after a stage with the name of the stage. The error is the error of the stage for the hooks run after it and nil for the rest.
+//An error returned by a hook run before the stage fails the stage without running it. The error returned by a hook run after the stage replaces its error
+type Hook func(ctx context.Context, s *State, stage string, err error) error
+
+var (
+	stagesLock = &sync.RWMutex{}
+	stages     = map[string]Stage{}
+	before     = map[string][]Hook{}
+	after      = map[string][]Hook{}
+	//defaultStages are the stages run for the file types without an order of their own
+	defaultStages = []string{models.JobStageValidate, models.JobStageIdentifyColumns, models.JobStageApplyRules, models.JobStageUpload, models.JobStageOptimize, models.JobStageDictUpdate}
+	//fileTypeStages are the orders of the stages of the file types
+	fileTypeStages = map[string][]string{}
+	//required are the stages which have to be in every order of the stages in the order in which they have to run
+	required = []string{}
+	//requiredAfter has the stages which have to run after a stage if they are in the order
+	requiredAfter = map[string][]string{}
+)
+
+//Register registers a stage. It panics if a stage is already registered with the name
+func Register(s Stage) {
+	stagesLock.Lock()
+	defer stagesLock.Unlock()
+	if len(s.Name) == 0 || s.Name == AllStages || s.Run == nil {
+		panic("stage has to have a name and a run function")
+	}
+	if _, ok := stages[s.Name]; ok {
+		panic("pipeline stage is already registered with the name " + s.Name)
+	}
+	stages[s.Name] = s
+}
+
+//GetStage returns the stage registered with the name
+func GetStage(name string) (Stage, bool) {
+	stagesLock.RLock()
+	defer stagesLock.RUnlock()
+	s, ok := stages[name]
+	return s, ok
+}
+
+//Registered returns the names of the registered stages
+func Registered() []string {
+	stagesLock.RLock()
+	defer stagesLock.RUnlock()
+	names := []string{}
+	for k := range stages {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+	return names
+}
+
+//Before registers a hook to be run before the stage. AllStages can be given as the stage to run the hook before all the stages
+func Before(stage string, h Hook) {
+	stagesLock.Lock()
+	defer stagesLock.Unlock()
+	before[stage] = append(before[stage], h)
+}
+
+//After registers a hook to be run after the stage. AllStages can be given as the stage to run the hook after all the stages
+func After(stage string, h Hook) {
+	stagesLock.Lock()
+	defer stagesLock.Unlock()
+	after[stage] = append(after[stage], h)
+}
+
+//hooks returns the hooks registered for the stage followed by the ones registered for all the stages
+func hooks(registry map[string][]Hook, stage string) []Hook {
+	stagesLock.RLock()
+	defer stagesLock.RUnlock()
+	return append(append([]Hook{}, registry[stage]...), registry[AllStages]...)
+}
+
+//SetDefaultStages sets the order of the stages run for the file types without an order of their own
+func SetDefaultStages(names ...string) {
+	stagesLock.Lock()
+	defer stagesLock.Unlock()
+	defaultStages = append([]string{}, names...)
+}
+
+//SetFileTypeStages sets the order of the stages run for the file type. The order of a dataset overrides it
+func SetFileTypeStages(fileType string, names ...string) {
+	stagesLock.Lock()
+	defer stagesLock.Unlock()
+	fileTypeStages[fileType] = append([]string{}, names...)
+}
+
+//Require sets the stages which have to be in every order of the stages. They have to run in the order in which they are given
+func Require(names ...string) {
+	stagesLock.Lock()
+	defer stagesLock.Unlock()
+	required = append([]string{}, names...)
+}
+
+//RequireAfter sets the stages which have to run after the stage when they are in the order of the stages
+func RequireAfter(stage string, names ...string) {
+	stagesLock.Lock()
+	defer stagesLock.Unlock()
+	requiredAfter[stage] = append(requiredAfter[stage], names...)
+}
+
+//Check returns an error if any of the stages is not registered or is repeated, a required stage is missing or the stages
+//are not in the required order. An empty list of stages is valid as it resets the order
+func Check(names []string) error {
+	/*
+	 * We will check whether the stages are registered and not repeated
+	 * Then we will check whether the required stages are there in the required order
+	 * Then we will check whether the stages run after the ones they have to
+	 */
+	if len(names) == 0 {
+		return nil
+	}
+
+	//checking the stages are registered and not repeated
+	positions := map[string]int{}
+	for i, v := range names {
+		if _, ok := GetStage(v); !ok {
+			return fmt.Errorf("%w: %s", ErrUnknownStage, v)
+		}
+		if _, ok := positions[v]; ok {
+			return fmt.Errorf("stage %s is repeated", v)
+		}
+		positions[v] = i
+	}
+
+	//checking the required stages
+	stagesLock.RLock()
+	defer stagesLock.RUnlock()
+	last := -1
+	for i, v := range required {
+		p, ok := positions[v]
+		if !ok {
+			return fmt.Errorf("%w: %s", ErrMissingStage, v)
+		}
+		if p < last {
+			return fmt.Errorf("%w: %s has to run before %s", ErrStageOrder, required[i-1], v)
+		}
+		last = p
+	}
+
+	//checking the stages run after the ones they have to
+	for i, v := range names {
+		for _, w := range requiredAfter[v] {
+			if p, ok := positions[w]; ok && p < i {
+				return fmt.Errorf("%w: %s has to run after %s", ErrStageOrder, w, v)
+			}
+		}
+	}
+	return nil
+}
+
+//Order returns the order of the stages of the pipeline of a dataset of the given file type.
+//The order set for the dataset is used if any, else the order of the file type and at last the default order
+func Order(a *config.AppContext, fileType string, datasetID uint) ([]string, error) {
+	if datasetID != 0 {
+		names, err := db.GetDatasetStages(a, datasetID)
+		if err != nil {
+			return nil, err
+		}
+		if len(names) != 0 {
+			return names, nil
+		}
+	}
+	stagesLock.RLock()
+	defer stagesLock.RUnlock()
+	if names, ok := fileTypeStages[fileType]; ok {
+		return append([]string{}, names...), nil
+	}
+	return append([]string{}, defaultStages...), nil
+}
+
+//Plan returns the stages to be run for the pipeline run in the order in which they run. The stages which don't apply to the run are left out
+func Plan(s *State) ([]string, error) {
+	var datasetID uint
+	if s.Dataset != nil {
+		datasetID = s.Dataset.ID
+	}
+	names, err := Order(s.App, s.Upload.Type, datasetID)
+	if err != nil {
+		return nil, err
+	}
+	result := []string{}
+	for _, v := range names {
+		st, ok := GetStage(v)
+		if !ok {
+			return nil, fmt.Errorf("%w: %s", ErrUnknownStage, v)
+		}
+		if st.Applies != nil && !st.Applies(s) {
+			continue
+		}
+		result = append(result, v)
+	}
+	return result, nil
+}
+
+//Run runs the stages of the pipeline as per the plan. The stage of the job is updated as the pipeline progresses and the error with which
+//the pipeline stopped is returned. If the job has a stage to start from, the stages before it are skipped.
+//The pipeline stops when the context is cancelled till a stage committing the data runs. The stages after that are always completed.
+//The file upload is moved to ready once all the stages have run
+func Run(ctx context.Context, s *State) error {
+	/*
+	 * We will get the plan of the run
+	 * Then we will make sure that the stage to start from is in the plan
+	 * Then we will run the stages one by one skipping the ones before the stage to start from
+	 * Then we will move the file upload to ready
+	 */
+	//getting the plan
+	names, err := Plan(s)
+	if err != nil {
+		s.App.Log.Error("error while getting the stages of the pipeline of the file upload", s.Upload.ID, err)
+		return err
+	}
+
+	//making sure that the stage to start from is in the plan
+	starting := len(s.Job.FromStage) == 0
+	for _, v := range names {
+		starting = starting || v == s.Job.FromStage
+	}
+	if !starting {
+		return fmt.Errorf("stage %s to start from is not in the pipeline of the file upload %d", s.Job.FromStage, s.Upload.ID)
+	}
+
+	//running the stages
+	starting = len(s.Job.FromStage) == 0
+	committed := false
+	for _, v := range names {
+		st, _ := GetStage(v)
+		starting = starting || v == s.Job.FromStage
+		if !starting {
+			//the stage was run in an earlier run
+			if err := s.Job.SkipStage(s.App, v); err != nil {
+				s.App.Log.Error("error while recording the skipped stage", v, "of the job", s.Job.ID, err)
+			}
+			committed = committed || st.Commits
+			continue
+		}
+		sCtx := ctx
+		if committed {
+			//the data is in the datastore now. So the stage is completed even if the pipeline is cancelled
+			sCtx = jobs.WithJob(context.Background(), s.Job)
+		} else if err := ctx.Err(); err != nil {
+			//the pipeline was cancelled
+			return err
+		}
+		if err := run(sCtx, s, st); err != nil {
+			return err
+		}
+		committed = committed || st.Commits
+	}
+
+	//moving the file upload to ready
+	return s.Upload.UpdateStatus(s.App, models.FileUploadStatusReady, "")
+}
+
+//RunStage runs a single stage of the pipeline as a part of the given run
+func RunStage(ctx context.Context, s *State, name string) error {
+	st, ok := GetStage(name)
+	if !ok {
+		return fmt.Errorf("%w: %s", ErrUnknownStage, name)
+	}
+	return run(ctx, s, st)
+}
+
+//run runs the stage with its hooks. The stage of the job is updated and the start and end of the stage are recorded with the job.
+//If the service is shutting down, the stage is not started and jobs.ErrInterrupted is returned
+func run(ctx context.Context, s *State, st Stage) error {
+	/*
+	 * We will check whether the service is shutting down
+	 * Then we will record the start of the stage and move the file upload to the status of the stage
+	 * Then we will run the hooks registered before the stage
+	 * Then we will run the stage
+	 * Then we will run the hooks registered after the stage
+	 * Then we will record the end of the stage
+	 */
+	//checking whether the service is shutting down
+	a := s.App
+	if err := jobs.Checkpoint(s.Job, st.Name); err != nil {
+		//the job will be resumed from the stage by another instance
+		a.Log.Info("job", s.Job.ID, "is interrupted by the shutdown before the stage", st.Name)
+		return err
+	}
+
+	//recording the start of the stage and moving the file upload
+	rec, err := s.Job.StartStage(a, st.Name)
+	if err != nil {
+		//error while starting the stage of the job. the stages are informational. so we can proceed
+		a.Log.Error("error while starting the stage", st.Name, "of the job", s.Job.ID, err)
+	}
+	s.Metrics = models.StageMetrics{}
+	err = nil
+	if len(st.Status) != 0 {
+		err = s.Upload.UpdateStatus(a, st.Status, "")
+		if err != nil {
+			a.Log.Error("error while moving the file upload", s.Upload.ID, "to", st.Status, "for the stage", st.Name, err)
+		}
+	}
+
+	//running the hooks registered before the stage
+	for _, h := range hooks(before, st.Name) {
+		if err != nil {
+			break
+		}
+		err = h(ctx, s, st.Name, nil)
+		if err != nil {
+			a.Log.Error("hook before the stage", st.Name, "failed for the file upload", s.Upload.ID, err)
+		}
+	}
+
+	if err != nil {
+		//the stages notify the user of their errors. so the user is notified only when the stage couldn't run
+		go notifications.SendErrorMessage(a, "error while processing "+s.Upload.Name)
+	}
+
+	//running the stage and the hooks registered after it
+	if err == nil {
+		err = st.Run(ctx, s)
+		if err != nil && ctx.Err() != nil {
+			//the stage stopped since the pipeline was cancelled
+			err = ctx.Err()
+		}
+		for _, h := range hooks(after, st.Name) {
+			err = h(ctx, s, st.Name, err)
+		}
+	}
+
+	//recording the end of the stage
+	fErr := rec.Finish(a, s.Metrics, err)
+	if fErr != nil {
+		//error while finishing the stage of the job
+		a.Log.Error("error while finishing the stage", st.Name, "of the job", s.Job.ID, fErr)
+	}
+	return err
+}
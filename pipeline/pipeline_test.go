@@ -0,0 +1,109 @@
+// Copyright 2019 Cuttle.ai. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package pipeline_test
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/cuttle-ai/file-uploader-service/models/db"
+	"github.com/cuttle-ai/file-uploader-service/pipeline"
+)
+
+/*
+ * This file contains the tests for ordering and checking the stages of the pipeline
+ */
+
+func noop(ctx context.Context, s *pipeline.State) error {
+	return nil
+}
+
+func init() {
+	pipeline.Register(pipeline.Stage{Name: "TEST_READ", Run: noop})
+	pipeline.Register(pipeline.Stage{Name: "TEST_SCAN", Run: noop})
+	pipeline.Register(pipeline.Stage{Name: "TEST_CREATE", Run: noop, Applies: func(s *pipeline.State) bool { return !s.Append }})
+	pipeline.Register(pipeline.Stage{Name: "TEST_WRITE", Run: noop, Commits: true})
+	pipeline.SetFileTypeStages("test", "TEST_READ", "TEST_CREATE", "TEST_SCAN", "TEST_WRITE")
+	pipeline.Require("TEST_READ", "TEST_WRITE")
+	pipeline.RequireAfter("TEST_READ", "TEST_SCAN")
+}
+
+func TestCheck(t *testing.T) {
+	cases := []struct {
+		name    string
+		stages  []string
+		err     bool
+		unknown bool
+		missing bool
+		order   bool
+	}{
+		{"empty", []string{}, false, false, false, false},
+		{"registered", []string{"TEST_READ", "TEST_SCAN", "TEST_WRITE"}, false, false, false, false},
+		{"optional stage left out", []string{"TEST_READ", "TEST_WRITE"}, false, false, false, false},
+		{"unknown", []string{"TEST_READ", "TEST_SEND"}, true, true, false, false},
+		{"repeated", []string{"TEST_READ", "TEST_READ"}, true, false, false, false},
+		{"required stage missing", []string{"TEST_SCAN", "TEST_WRITE"}, true, false, true, false},
+		{"required stages out of order", []string{"TEST_WRITE", "TEST_SCAN", "TEST_READ"}, true, false, false, true},
+		{"stage before the one it has to run after", []string{"TEST_SCAN", "TEST_READ", "TEST_WRITE"}, true, false, false, true},
+	}
+	for i, c := range cases {
+		err := pipeline.Check(c.stages)
+		if (err != nil) != c.err {
+			t.Error("test case", i+1, c.name, "expected error as", c.err, "got", err)
+		}
+		if errors.Is(err, pipeline.ErrUnknownStage) != c.unknown {
+			t.Error("test case", i+1, c.name, "expected unknown stage error as", c.unknown, "got", err)
+		}
+		if errors.Is(err, pipeline.ErrMissingStage) != c.missing {
+			t.Error("test case", i+1, c.name, "expected missing stage error as", c.missing, "got", err)
+		}
+		if errors.Is(err, pipeline.ErrStageOrder) != c.order {
+			t.Error("test case", i+1, c.name, "expected stage order error as", c.order, "got", err)
+		}
+	}
+}
+
+func TestPlan(t *testing.T) {
+	cases := []struct {
+		name     string
+		fileType string
+		append   bool
+		stages   []string
+	}{
+		{"file type", "test", false, []string{"TEST_READ", "TEST_CREATE", "TEST_SCAN", "TEST_WRITE"}},
+		{"stage not applying", "test", true, []string{"TEST_READ", "TEST_SCAN", "TEST_WRITE"}},
+	}
+	for i, c := range cases {
+		u := &db.FileUpload{}
+		u.Type = c.fileType
+		got, err := pipeline.Plan(&pipeline.State{Upload: u, Append: c.append})
+		if err != nil {
+			t.Error("test case", i+1, c.name, "expected no error. got", err)
+			continue
+		}
+		if !reflect.DeepEqual(got, c.stages) {
+			t.Error("test case", i+1, c.name, "expected the stages", c.stages, "got", got)
+		}
+	}
+
+	//stages of the file types not registered
+	u := &db.FileUpload{}
+	u.Type = "none"
+	_, err := pipeline.Plan(&pipeline.State{Upload: u})
+	if !errors.Is(err, pipeline.ErrUnknownStage) {
+		t.Error("expected the default stages to be unknown without the built-in stages. got", err)
+	}
+}
+
+func TestRegisterDuplicate(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Error("expected registering a stage twice to panic")
+		}
+	}()
+	pipeline.Register(pipeline.Stage{Name: "TEST_READ", Run: noop})
+}
@@ -0,0 +1,201 @@
+// Copyright 2019 Cuttle.ai. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+//Package retry has the utilities to retry the calls to the other services which fail now and then.
+//The errors are classified as transient or permanent and only the transient ones are retried
+//with exponential backoff and jitter as per a retry policy
+package retry
+
+import (
+	"context"
+	"errors"
+	"io"
+	"math/rand"
+	"net"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+//Policy is the policy with which a call failing with transient errors is retried
+type Policy struct {
+	//MaxAttempts is the max no. of times the call is made including the first attempt
+	MaxAttempts int
+	//InitialBackoff is the backoff after the first failed attempt. It is doubled after every attempt
+	InitialBackoff time.Duration
+	//MaxBackoff is the max backoff between two attempts
+	MaxBackoff time.Duration
+}
+
+//Attempt is a failed attempt of a call
+type Attempt struct {
+	//Attempt is the no. of the attempt starting from 1
+	Attempt int
+	//Err is the error with which the attempt failed
+	Err error
+	//Transient indicates that the error is transient
+	Transient bool
+	//Backoff is the time waited before the next attempt. Zero if the call is not retried anymore
+	Backoff time.Duration
+}
+
+//transientError marks an error as transient
+type transientError struct {
+	error
+}
+
+//Unwrap returns the underlying error
+func (t transientError) Unwrap() error {
+	return t.error
+}
+
+//permanentError marks an error as permanent
+type permanentError struct {
+	error
+}
+
+//Unwrap returns the underlying error
+func (p permanentError) Unwrap() error {
+	return p.error
+}
+
+//Transient marks the error as transient so that it is retried
+func Transient(err error) error {
+	if err == nil {
+		return nil
+	}
+	return transientError{err}
+}
+
+//Permanent marks the error as permanent so that it is not retried
+func Permanent(err error) error {
+	if err == nil {
+		return nil
+	}
+	return permanentError{err}
+}
+
+//transientMessages are the parts of the error messages of the transient failures returned as plain errors by the other services
+var transientMessages = []string{
+	"connection refused",
+	"connection reset",
+	"broken pipe",
+	"timeout",
+	"timed out",
+	"too many connections",
+	"temporarily unavailable",
+	"service unavailable",
+	"bad gateway",
+	"gateway timeout",
+	"status code 502",
+	"status code 503",
+	"status code 504",
+}
+
+//transientPqClasses are the classes of the postgres error codes which are transient
+var transientPqClasses = []pq.ErrorClass{
+	//connection exception
+	"08",
+	//transaction rollback like serialization failure and deadlock
+	"40",
+	//insufficient resources
+	"53",
+	//operator intervention like admin shutdown
+	"57",
+}
+
+//IsTransient returns true if the error is a transient failure which may succeed when retried.
+//Errors marked with Transient and Permanent are classified as per the mark. Network failures, timeouts, unexpected ends of the responses,
+//transient postgres errors and the errors of the services reporting their unavailability are transient. The rest are permanent
+func IsTransient(err error) bool {
+	if err == nil {
+		return false
+	}
+	var t transientError
+	if errors.As(err, &t) {
+		return true
+	}
+	var p permanentError
+	if errors.As(err, &p) {
+		return false
+	}
+	if errors.Is(err, context.Canceled) {
+		return false
+	}
+	if errors.Is(err, context.DeadlineExceeded) || errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) ||
+		errors.Is(err, syscall.ECONNREFUSED) || errors.Is(err, syscall.ECONNRESET) || errors.Is(err, syscall.EPIPE) {
+		return true
+	}
+	var nErr net.Error
+	if errors.As(err, &nErr) && nErr.Timeout() {
+		return true
+	}
+	var opErr *net.OpError
+	if errors.As(err, &opErr) {
+		return true
+	}
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) {
+		for _, v := range transientPqClasses {
+			if pqErr.Code.Class() == v {
+				return true
+			}
+		}
+		return false
+	}
+	msg := strings.ToLower(err.Error())
+	for _, v := range transientMessages {
+		if strings.Contains(msg, v) {
+			return true
+		}
+	}
+	return false
+}
+
+//Backoff returns the backoff after the given failed attempt. The backoff grows exponentially from the initial backoff till the max backoff.
+//Half of it is randomized so that the calls retried together are spread out
+func (p Policy) Backoff(attempt int) time.Duration {
+	d := p.InitialBackoff
+	for i := 1; i < attempt && d < p.MaxBackoff; i++ {
+		d *= 2
+	}
+	if d > p.MaxBackoff {
+		d = p.MaxBackoff
+	}
+	if d <= 0 {
+		return 0
+	}
+	half := d / 2
+	return half + time.Duration(rand.Int63n(int64(d-half)+1))
+}
+
+//Do makes the call and retries it as per the policy till it succeeds, fails with a permanent error or runs out of attempts.
+//onFailure is invoked for each failed attempt if it is not nil. If the context is done while waiting for the next attempt, the error of the context is returned
+func Do(ctx context.Context, p Policy, call func() error, onFailure func(Attempt)) error {
+	for i := 1; ; i++ {
+		err := call()
+		if err == nil {
+			return nil
+		}
+		a := Attempt{Attempt: i, Err: err, Transient: IsTransient(err)}
+		if a.Transient && i < p.MaxAttempts {
+			a.Backoff = p.Backoff(i)
+		}
+		if onFailure != nil {
+			onFailure(a)
+		}
+		if !a.Transient || i >= p.MaxAttempts {
+			return err
+		}
+		t := time.NewTimer(a.Backoff)
+		select {
+		case <-ctx.Done():
+			t.Stop()
+			return ctx.Err()
+		case <-t.C:
+		}
+	}
+}
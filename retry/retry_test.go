@@ -0,0 +1,116 @@
+// Copyright 2019 Cuttle.ai. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package retry_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/cuttle-ai/file-uploader-service/retry"
+	"github.com/lib/pq"
+)
+
+/*
+ * This file contains the tests for classifying and retrying the errors
+ */
+
+func TestIsTransient(t *testing.T) {
+	cases := []struct {
+		name      string
+		err       error
+		transient bool
+	}{
+		{"nil", nil, false},
+		{"plain", errors.New("dataset not found"), false},
+		{"marked transient", retry.Transient(errors.New("dataset not found")), true},
+		{"marked permanent", retry.Permanent(io.EOF), false},
+		{"wrapped eof", fmt.Errorf("error while reading the response: %w", io.ErrUnexpectedEOF), true},
+		{"cancelled", context.Canceled, false},
+		{"unavailable", errors.New("Service Unavailable"), true},
+		{"deadlock", &pq.Error{Code: "40P01"}, true},
+		{"unique violation", &pq.Error{Code: "23505"}, false},
+	}
+	for i, c := range cases {
+		if got := retry.IsTransient(c.err); got != c.transient {
+			t.Error("test case", i+1, c.name, "expected transient to be", c.transient, "got", got)
+		}
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	p := retry.Policy{MaxAttempts: 5, InitialBackoff: 100 * time.Millisecond, MaxBackoff: time.Second}
+	cases := []struct {
+		attempt int
+		min     time.Duration
+		max     time.Duration
+	}{
+		{1, 50 * time.Millisecond, 100 * time.Millisecond},
+		{2, 100 * time.Millisecond, 200 * time.Millisecond},
+		{3, 200 * time.Millisecond, 400 * time.Millisecond},
+		{10, 500 * time.Millisecond, time.Second},
+	}
+	for i, c := range cases {
+		d := p.Backoff(c.attempt)
+		if d < c.min || d > c.max {
+			t.Error("test case", i+1, "expected the backoff of attempt", c.attempt, "to be between", c.min, "and", c.max, "got", d)
+		}
+	}
+}
+
+func TestDo(t *testing.T) {
+	p := retry.Policy{MaxAttempts: 3, InitialBackoff: time.Millisecond, MaxBackoff: 2 * time.Millisecond}
+	transient := retry.Transient(errors.New("flaky"))
+	permanent := errors.New("invalid")
+
+	cases := []struct {
+		name     string
+		errs     []error
+		calls    int
+		failures int
+		err      error
+	}{
+		{"succeeds", []error{nil}, 1, 0, nil},
+		{"succeeds after retries", []error{transient, transient, nil}, 3, 2, nil},
+		{"runs out of attempts", []error{transient, transient, transient, nil}, 3, 3, transient},
+		{"permanent", []error{permanent, nil}, 1, 1, permanent},
+	}
+	for i, c := range cases {
+		calls := 0
+		failures := []retry.Attempt{}
+		err := retry.Do(context.Background(), p, func() error {
+			calls++
+			return c.errs[calls-1]
+		}, func(a retry.Attempt) {
+			failures = append(failures, a)
+		})
+		if err != c.err {
+			t.Error("test case", i+1, c.name, "expected the error", c.err, "got", err)
+		}
+		if calls != c.calls {
+			t.Error("test case", i+1, c.name, "expected", c.calls, "calls. got", calls)
+		}
+		if len(failures) != c.failures {
+			t.Error("test case", i+1, c.name, "expected", c.failures, "failed attempts. got", len(failures))
+			continue
+		}
+		if c.err != nil && failures[len(failures)-1].Backoff != 0 {
+			t.Error("test case", i+1, c.name, "expected no backoff after the last attempt")
+		}
+	}
+
+	//cancelling while waiting for the next attempt
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	err := retry.Do(ctx, retry.Policy{MaxAttempts: 3, InitialBackoff: time.Minute, MaxBackoff: time.Minute}, func() error {
+		return transient
+	}, nil)
+	if err != context.Canceled {
+		t.Error("expected the error of the context while waiting for the next attempt. got", err)
+	}
+}
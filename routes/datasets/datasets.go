@@ -8,6 +8,7 @@ package datasets
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"net/http"
 	"os"
 	"path/filepath"
@@ -17,6 +18,7 @@ import (
 	"github.com/cuttle-ai/brain/appctx"
 	"github.com/cuttle-ai/brain/models"
 	"github.com/cuttle-ai/file-uploader-service/config"
+	"github.com/cuttle-ai/file-uploader-service/jobs"
 	"github.com/cuttle-ai/file-uploader-service/models/db"
 	"github.com/cuttle-ai/file-uploader-service/notifications"
 	"github.com/cuttle-ai/file-uploader-service/routes"
@@ -33,14 +35,14 @@ import (
 func GetDatasets(ctx context.Context, w http.ResponseWriter, r *http.Request) {
 	/*
 	 * We will get the app context
-	 * Then we will get the datasets for the current user session
+	 * Then we will get the datasets for the current user session along with the status of their uploads
 	 */
 
 	//getting the app context
 	appCtx := ctx.Value(routes.AppContextKey).(*config.AppContext)
 	appCtx.Log.Info("Got a request to get the datasets list by", appCtx.Session.User.ID)
 
-	datasets, err := db.GetDatasets(appCtx)
+	datasets, err := db.GetDatasetsWithStatus(appCtx)
 	if err != nil {
 		//error while getting the list
 		appCtx.Log.Error("error while getting the list", err.Error())
@@ -58,6 +60,7 @@ func GetDataSet(ctx context.Context, w http.ResponseWriter, r *http.Request) {
 	 * We will get the app context
 	 * Then we will try to parse the request param id
 	 * Then we will get the dataset info for the current user session
+	 * Then we will get the status of the upload of the dataset and its history
 	 */
 
 	//getting the app context
@@ -101,11 +104,29 @@ func GetDataSet(ctx context.Context, w http.ResponseWriter, r *http.Request) {
 	for _, v := range cols {
 		iCols = append(iCols, v.ColumnNode())
 	}
+
+	//getting the status of the upload of the dataset and its history
+	status := ""
+	history := []fModels.FileUploadStatusHistory{}
+	if fD, ok := d.UploadedDataset.(fModels.FileDataset); ok {
+		status = fD.Info.Status
+		fU := db.FileUpload(fD.Info)
+		history, err = fU.GetStatusHistory(appCtx)
+		if err != nil {
+			//error while getting the status history of the file upload
+			appCtx.Log.Error("error while getting the status history of datatset with id", id, err.Error())
+			response.WriteError(w, response.Error{Err: "Couldn't fetch the info"}, http.StatusInternalServerError)
+			return
+		}
+	}
+
 	appCtx.Log.Info("Successfully fetched the dataset info of", id)
 	response.Write(w, response.Message{Message: "Successfully fetched the info", Data: struct {
-		Dataset *db.Dataset
-		Columns []interpreter.ColumnNode
-	}{d, iCols}})
+		Dataset       *db.Dataset
+		Columns       []interpreter.ColumnNode
+		Status        string
+		StatusHistory []fModels.FileUploadStatusHistory
+	}{d, iCols, status, history}})
 }
 
 //UpdateDataset will update a dataset for a given user
@@ -157,7 +178,31 @@ func UpdateDataset(ctx context.Context, w http.ResponseWriter, r *http.Request)
 	response.Write(w, response.Message{Message: "Successfully updated the info", Data: d})
 }
 
-func startDeletingDataset(a *config.AppContext, d *db.Dataset) {
+//deleteDatasetJob is the job deleting the dataset of the job from the platform
+func deleteDatasetJob(ctx context.Context, a *config.AppContext, j *db.Job) error {
+	/*
+	 * We will get the information about the dataset
+	 * Then we will start deleting the dataset from the platform
+	 */
+	//getting the information about the dataset
+	d := &db.Dataset{}
+	d.ID = j.DatasetID
+	if a.Session.User.UserType != authConfig.AdminUser && a.Session.User.UserType != authConfig.SuperAdmin {
+		d.UserID = a.Session.User.ID
+	}
+	err := d.Get(a, false)
+	if err != nil {
+		return err
+	}
+
+	//deleting the dataset
+	if err := j.UpdateStage(a, fModels.JobStageDelete); err != nil {
+		a.Log.Error("error while updating the stage of the job", j.ID, err)
+	}
+	return startDeletingDataset(a, d)
+}
+
+func startDeletingDataset(a *config.AppContext, d *db.Dataset) error {
 	/*
 	 * If the dataset type is file,
 	 * 		we will remove the physical files associated with the dataset
@@ -175,7 +220,7 @@ func startDeletingDataset(a *config.AppContext, d *db.Dataset) {
 			//couldn't type cast the file data source as fileDataset
 			a.Log.Error("error while inferring the dataset type to fileDataset with the datasource as file")
 			notifications.SendErrorMessage(a, "error while processing dataset")
-			return
+			return errors.New("uploaded dataset of the file dataset couldn't be inferred")
 		}
 
 		//removing the physical files
@@ -188,7 +233,7 @@ func startDeletingDataset(a *config.AppContext, d *db.Dataset) {
 			//error while removing the dataset's physical files
 			a.Log.Error("error while removing the physical files for the dataset", d.ID, f.Info.Location, err)
 			notifications.SendErrorMessage(a, "error while deleting dataset temporary")
-			return
+			return err
 		}
 	}
 
@@ -199,7 +244,7 @@ func startDeletingDataset(a *config.AppContext, d *db.Dataset) {
 			//error while removing the dataset from the datastore
 			a.Log.Error("error while removing the dataset from the datastore", d.ID, err)
 			notifications.SendErrorMessage(a, "error while deleting data from secure datstore")
-			return
+			return err
 		}
 	}
 
@@ -209,7 +254,7 @@ func startDeletingDataset(a *config.AppContext, d *db.Dataset) {
 		//error while removing the db info from the database
 		a.Log.Error("error while removing the db info from the database", d.DatastoreID, err)
 		notifications.SendErrorMessage(a, "error while deleting dataset metadata")
-		return
+		return err
 	}
 
 	//remove the user dict from octopus service memory
@@ -218,10 +263,11 @@ func startDeletingDataset(a *config.AppContext, d *db.Dataset) {
 		//error while removing the dict from octopus
 		a.Log.Error("error while removing the dict from the octopus service for user", a.Session.User.ID, err)
 		notifications.SendErrorMessage(a, "error while synchronizing dataset change across devices")
-		return
+		return err
 	}
 
 	notifications.SendActionNotification(a, "successfully deleted the dataset", models.ActionFetchDatasets)
+	return nil
 }
 
 func deleteDatasetFromDatastore(a *config.AppContext, d *db.Dataset) error {
@@ -322,13 +368,20 @@ func DeleteDataset(ctx context.Context, w http.ResponseWriter, r *http.Request)
 	}
 
 	//start deleting the dataset from the platform
-	go startDeletingDataset(appCtx, d)
+	err = jobs.Enqueue(appCtx, &db.Job{Type: fModels.JobTypeDeleteDataset, DatasetID: d.ID})
+	if err != nil {
+		//error while enqueueing the job
+		appCtx.Log.Error("error while enqueueing the deletion of the dataset", d.ID, err.Error())
+		response.WriteError(w, response.Error{Err: "Couldn't start deleting the dataset"}, http.StatusInternalServerError)
+		return
+	}
 
 	appCtx.Log.Info("Successfully started deleting the dataset", d.ID)
 	response.Write(w, response.Message{Message: "Successfully initiated deleting of the dataset", Data: nil})
 }
 
 func init() {
+	jobs.Register(fModels.JobTypeDeleteDataset, deleteDatasetJob)
 	routes.AddRoutes(
 		routes.Route{
 			Version:     "v1",
@@ -0,0 +1,235 @@
+// Copyright 2019 Cuttle.ai. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package datasets
+
+/*
+ * This file contains the api to import a file published over http(s) as a dataset
+ */
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+
+	"github.com/cuttle-ai/file-uploader-service/config"
+	libfile "github.com/cuttle-ai/file-uploader-service/file"
+	"github.com/cuttle-ai/file-uploader-service/file/archive"
+	"github.com/cuttle-ai/file-uploader-service/file/remote"
+	"github.com/cuttle-ai/file-uploader-service/jobs"
+	"github.com/cuttle-ai/file-uploader-service/models"
+	"github.com/cuttle-ai/file-uploader-service/models/db"
+	"github.com/cuttle-ai/file-uploader-service/notifications"
+	"github.com/cuttle-ai/file-uploader-service/routes"
+	routesFile "github.com/cuttle-ai/file-uploader-service/routes/file"
+	"github.com/cuttle-ai/file-uploader-service/routes/response"
+)
+
+//ImportRequest is the request to import a remote file as a dataset
+type ImportRequest struct {
+	//URL is the http(s) url of the file
+	URL string
+	//Headers are the headers to be sent while downloading the file like the authorization headers
+	Headers map[string]string
+	//Name is optional. It is the name of the file to be used instead of the one in the response or the url
+	Name string
+}
+
+//Import will enqueue the import of a file from a remote url as a dataset. The import runs as a job,
+//so it is not lost if the service shuts down and is resumed by another instance.
+//The progress of the import is sent as notifications to the user
+func Import(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+	/*
+	 * We will get the app context
+	 * Then we will parse the import request
+	 * Then we will parse the upload options
+	 * Then we will validate the url
+	 * Then we will create the location for the file in the file dump directory
+	 * Then we will enqueue the import
+	 */
+
+	//getting the app context
+	appCtx := ctx.Value(routes.AppContextKey).(*config.AppContext)
+	appCtx.Log.Info("Got a request to import a remote file by", appCtx.Session.User.Email)
+
+	//parsing the import request
+	iR := &ImportRequest{}
+	err := json.NewDecoder(r.Body).Decode(iR)
+	if err != nil {
+		//bad request
+		appCtx.Log.Error("error while parsing the import request", err.Error())
+		response.WriteError(w, response.Error{Err: "Invalid Params " + err.Error()}, http.StatusBadRequest)
+		return
+	}
+	defer r.Body.Close()
+
+	//parsing the options with which the file has to be processed
+	options, err := routesFile.ParseUploadOptions(r)
+	if err != nil {
+		//bad request
+		appCtx.Log.Error("error while parsing the upload options", err.Error())
+		response.WriteError(w, response.Error{Err: "Invalid upload options. " + err.Error()}, http.StatusBadRequest)
+		return
+	}
+
+	//validating the url
+	req := remote.Request{URL: iR.URL, Headers: iR.Headers, MaxSize: config.MaxUploadSize, AllowPrivate: config.RemoteImportAllowPrivate}
+	err = req.Validate()
+	if err != nil {
+		//bad request
+		appCtx.Log.Error("invalid url to import", iR.URL, err.Error())
+		response.WriteError(w, response.Error{Err: "Invalid url. " + err.Error()}, http.StatusBadRequest)
+		return
+	}
+
+	//creating the location for the file
+	//the location is created here since the directory of the file is named after the user who is known only to the request.
+	//The name of the file is not known till the response is received. So a default name is used for the time being
+	location, err := dumpLocation(appCtx, remote.DefaultFilename)
+	if err != nil {
+		//error while creating the location for the file
+		appCtx.Log.Error("error while creating the location for the remote file", iR.URL, err.Error())
+		response.WriteError(w, response.Error{Err: "Couldn't start importing the file"}, http.StatusInternalServerError)
+		return
+	}
+
+	//enqueueing the import
+	headers, err := json.Marshal(iR.Headers)
+	if err != nil {
+		//bad request
+		appCtx.Log.Error("error while encoding the headers of the import request", err.Error())
+		response.WriteError(w, response.Error{Err: "Invalid headers " + err.Error()}, http.StatusBadRequest)
+		return
+	}
+	j := &db.Job{
+		Type:      models.JobTypeImport,
+		SourceURL: iR.URL,
+		Headers:   string(headers),
+		Name:      iR.Name,
+		Location:  location,
+		Options:   options,
+	}
+	err = jobs.Enqueue(appCtx, j)
+	if err != nil {
+		//error while enqueueing the import
+		appCtx.Log.Error("error while enqueueing the import of the remote file", iR.URL, err.Error())
+		os.RemoveAll(filepath.Dir(location))
+		response.WriteError(w, response.Error{Err: "Couldn't start importing the file"}, http.StatusInternalServerError)
+		return
+	}
+	appCtx.Log.Info("Started importing the remote file", iR.URL, "with the job", j.ID)
+	response.Write(w, response.Message{Message: "Successfully started importing the file", Data: j})
+}
+
+//importJob runs the job importing the remote file
+func importJob(ctx context.Context, a *config.AppContext, j *db.Job) error {
+	headers := map[string]string{}
+	if len(j.Headers) != 0 {
+		err := json.Unmarshal([]byte(j.Headers), &headers)
+		if err != nil {
+			return err
+		}
+	}
+	req := remote.Request{URL: j.SourceURL, Headers: headers, MaxSize: config.MaxUploadSize, AllowPrivate: config.RemoteImportAllowPrivate}
+	if err := j.UpdateStage(a, models.JobStageDownload); err != nil {
+		a.Log.Error("error while updating the stage of the job", j.ID, err)
+	}
+	return importFile(ctx, a, req, j.Location, j.Name, j.Options)
+}
+
+//importFile will download the remote file to the location, process it, store a file upload for each of the datasets in it
+//and enqueue the pipeline for each of them. The download stops once the context is done
+func importFile(ctx context.Context, appCtx *config.AppContext, req remote.Request, location string, name string, options models.FileUploadOptions) error {
+	/*
+	 * We will download the file
+	 * Then we will rename the file as per its name
+	 * Then we will process the file
+	 * Then we will store the datasets and start their pipeline
+	 */
+	//downloading the file
+	//the last element in the url is used as the name of the file in the notifications till the response is received
+	displayName := name
+	if len(displayName) == 0 {
+		displayName = path.Base(req.URL)
+	}
+	req.Progress = func(downloaded int64, total int64) {
+		notifications.SendDownloadStatus(appCtx, downloaded, total, displayName)
+	}
+	dCtx, cancel := context.WithTimeout(ctx, config.RemoteImportTimeout)
+	defer cancel()
+	res, err := remote.Download(dCtx, req, location)
+	if err != nil {
+		//error while downloading the file
+		appCtx.Log.Error("error while downloading the remote file", req.URL, err.Error())
+		if ctx.Err() != nil {
+			//the job was either cancelled or interrupted by the shutdown to be resumed later. So the user is not notified of the error
+			return err
+		}
+		if errors.Is(err, remote.ErrTooLarge) {
+			notifications.SendErrorMessage(appCtx, fmt.Sprintf("Couldn't import %s. It is larger than the allowed size of %d bytes", displayName, config.MaxUploadSize))
+			return err
+		}
+		notifications.SendErrorMessage(appCtx, "Error while downloading "+displayName)
+		return err
+	}
+	appCtx.Log.Info("Downloaded the remote file", req.URL, "of size", res.Size, "with checksum", res.Checksum)
+
+	//the file is renamed as per the name in the response so that its format can be resolved by the extension
+	if len(name) == 0 {
+		name = res.Filename
+	}
+	newfile := filepath.Join(filepath.Dir(location), filepath.Base(name))
+	err = os.Rename(location, newfile)
+	if err != nil {
+		//error while renaming the file
+		appCtx.Log.Error("error while renaming the remote file", location, "to", newfile, err.Error())
+		notifications.SendErrorMessage(appCtx, "Error while importing "+name)
+		return err
+	}
+
+	//processing the file
+	fTs, err := libfile.ProcessUpload(newfile, name, res.MimeType, options)
+	if err != nil {
+		//error while processing the file
+		appCtx.Log.Error("error while identifying the file type of the remote file", req.URL, err.Error())
+		if errors.Is(err, archive.ErrLimitExceeded) {
+			notifications.SendErrorMessage(appCtx, "Couldn't import "+name+". "+err.Error())
+			return err
+		}
+		notifications.SendErrorMessage(appCtx, "Unidentified file format of "+name)
+		return err
+	}
+
+	//storing the datasets
+	_, err = storeFiles(appCtx, fTs, routesFile.UploadedFile{
+		Location:  newfile,
+		Checksum:  res.Checksum,
+		Size:      res.Size,
+		SourceURL: req.URL,
+	}, nil)
+	if err != nil {
+		//error while storing the datasets
+		appCtx.Log.Error("error while storing the remote file", req.URL, err.Error())
+		notifications.SendErrorMessage(appCtx, "Error while importing "+name)
+		return err
+	}
+	notifications.SendSuccessMessage(appCtx, "Successfully imported "+name)
+	return nil
+}
+
+func init() {
+	jobs.Register(models.JobTypeImport, importJob)
+	routes.AddRoutes(
+		routes.Route{
+			Version:     "v1",
+			Pattern:     "/datasets/import",
+			HandlerFunc: Import,
+		},
+	)
+}
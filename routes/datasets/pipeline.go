@@ -0,0 +1,177 @@
+// Copyright 2019 Cuttle.ai. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package datasets
+
+/*
+ * This file contains the apis to get and set the order of the stages of the pipeline of the datasets
+ */
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+
+	authConfig "github.com/cuttle-ai/auth-service/config"
+	"github.com/cuttle-ai/file-uploader-service/config"
+	fModels "github.com/cuttle-ai/file-uploader-service/models"
+	"github.com/cuttle-ai/file-uploader-service/models/db"
+	"github.com/cuttle-ai/file-uploader-service/pipeline"
+	"github.com/cuttle-ai/file-uploader-service/routes"
+	"github.com/cuttle-ai/file-uploader-service/routes/response"
+)
+
+//DatasetPipeline is the order of the stages of the pipeline of a dataset
+type DatasetPipeline struct {
+	//Stages are the stages of the pipeline in the order in which they run
+	Stages []string
+	//Available are the stages registered with the service which can be used in the order
+	Available []string
+}
+
+//getUserDataset returns the dataset with the id in the query params accessible to the user of the session and the type of its file
+func getUserDataset(appCtx *config.AppContext, w http.ResponseWriter, r *http.Request) (*db.Dataset, string, bool) {
+	//parse the request param id
+	idStr := r.URL.Query().Get("id")
+	id, err := strconv.Atoi(idStr)
+	if err != nil {
+		//bad request
+		appCtx.Log.Error("error while parsing the dataset id", err.Error(), idStr)
+		response.WriteError(w, response.Error{Err: "Invalid Params " + idStr + " as id of the dataset"}, http.StatusBadRequest)
+		return nil, "", false
+	}
+
+	//getting the dataset info
+	d := &db.Dataset{}
+	d.ID = uint(id)
+	if appCtx.Session.User.UserType != authConfig.AdminUser && appCtx.Session.User.UserType != authConfig.SuperAdmin {
+		d.UserID = appCtx.Session.User.ID
+	}
+	err = d.Get(appCtx, true)
+	if err != nil {
+		//error while getting the info
+		appCtx.Log.Error("error while getting the info for datatset with id", id, err.Error())
+		response.WriteError(w, response.Error{Err: "Couldn't fetch the info"}, http.StatusInternalServerError)
+		return nil, "", false
+	}
+	fileType := ""
+	if fD, ok := d.UploadedDataset.(fModels.FileDataset); ok {
+		fileType = fD.Info.Type
+	}
+	return d, fileType, true
+}
+
+//GetPipeline will return the order of the stages of the pipeline of a dataset and the stages available
+func GetPipeline(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+	/*
+	 * We will get the app context
+	 * Then we will get the dataset
+	 * Then we will get the order of the stages of its pipeline
+	 */
+
+	//getting the app context
+	appCtx := ctx.Value(routes.AppContextKey).(*config.AppContext)
+	appCtx.Log.Info("Got a request to get the pipeline of a dataset by", appCtx.Session.User.ID)
+
+	//getting the dataset
+	d, fileType, ok := getUserDataset(appCtx, w, r)
+	if !ok {
+		return
+	}
+
+	//getting the order of the stages
+	stages, err := pipeline.Order(appCtx, fileType, d.ID)
+	if err != nil {
+		//error while getting the stages
+		appCtx.Log.Error("error while getting the stages of the pipeline of the dataset", d.ID, err.Error())
+		response.WriteError(w, response.Error{Err: "Couldn't fetch the pipeline of the dataset"}, http.StatusInternalServerError)
+		return
+	}
+
+	appCtx.Log.Info("Successfully fetched the pipeline of the dataset", d.ID)
+	response.Write(w, response.Message{Message: "Successfully fetched the pipeline of the dataset", Data: DatasetPipeline{Stages: stages, Available: pipeline.Registered()}})
+}
+
+//UpdatePipeline will set the order of the stages of the pipeline of a dataset. It overrides the order of the file type of the dataset.
+//The override is removed if no stages are given
+func UpdatePipeline(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+	/*
+	 * We will get the app context
+	 * Then we will get the dataset
+	 * Then we will parse the stages and check them
+	 * Then we will save the order of the stages
+	 */
+
+	//getting the app context
+	appCtx := ctx.Value(routes.AppContextKey).(*config.AppContext)
+	appCtx.Log.Info("Got a request to update the pipeline of a dataset by", appCtx.Session.User.ID)
+
+	//getting the dataset
+	d, fileType, ok := getUserDataset(appCtx, w, r)
+	if !ok {
+		return
+	}
+
+	//parsing the stages
+	p := &DatasetPipeline{}
+	err := json.NewDecoder(r.Body).Decode(p)
+	if err != nil {
+		//bad request
+		appCtx.Log.Error("error while parsing the stages of the pipeline", err.Error())
+		response.WriteError(w, response.Error{Err: "Invalid Params " + err.Error()}, http.StatusBadRequest)
+		return
+	}
+	defer r.Body.Close()
+	for i, v := range p.Stages {
+		p.Stages[i] = strings.ToUpper(strings.TrimSpace(v))
+	}
+	err = pipeline.Check(p.Stages)
+	if err != nil {
+		//bad request
+		appCtx.Log.Error("invalid stages", p.Stages, "for the pipeline of the dataset", d.ID, err.Error())
+		msg := "Invalid Params " + err.Error()
+		if errors.Is(err, pipeline.ErrUnknownStage) {
+			msg += ". The stages have to be from " + strings.Join(pipeline.Registered(), ", ")
+		}
+		response.WriteError(w, response.Error{Err: msg}, http.StatusBadRequest)
+		return
+	}
+
+	//saving the order
+	err = db.SetDatasetStages(appCtx, d.ID, p.Stages)
+	if err != nil {
+		//error while saving the stages
+		appCtx.Log.Error("error while saving the stages of the pipeline of the dataset", d.ID, err.Error())
+		response.WriteError(w, response.Error{Err: "Couldn't update the pipeline of the dataset"}, http.StatusInternalServerError)
+		return
+	}
+	stages, err := pipeline.Order(appCtx, fileType, d.ID)
+	if err != nil {
+		//error while getting the stages
+		appCtx.Log.Error("error while getting the stages of the pipeline of the dataset", d.ID, err.Error())
+		response.WriteError(w, response.Error{Err: "Couldn't fetch the pipeline of the dataset"}, http.StatusInternalServerError)
+		return
+	}
+
+	appCtx.Log.Info("Successfully updated the pipeline of the dataset", d.ID)
+	response.Write(w, response.Message{Message: "Successfully updated the pipeline of the dataset", Data: DatasetPipeline{Stages: stages, Available: pipeline.Registered()}})
+}
+
+func init() {
+	routes.AddRoutes(
+		routes.Route{
+			Version:     "v1",
+			Pattern:     "/dataset/pipeline",
+			HandlerFunc: GetPipeline,
+		},
+		routes.Route{
+			Version:     "v1",
+			Pattern:     "/dataset/pipeline/update",
+			HandlerFunc: UpdatePipeline,
+		},
+	)
+}
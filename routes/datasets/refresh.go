@@ -0,0 +1,273 @@
+// Copyright 2019 Cuttle.ai. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package datasets
+
+/*
+ * This file contains the apis to schedule the refresh of the datasets imported from remote urls
+ */
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/cuttle-ai/file-uploader-service/config"
+	"github.com/cuttle-ai/file-uploader-service/models/db"
+	"github.com/cuttle-ai/file-uploader-service/routes"
+	routesFile "github.com/cuttle-ai/file-uploader-service/routes/file"
+	"github.com/cuttle-ai/file-uploader-service/routes/response"
+	"github.com/cuttle-ai/file-uploader-service/scheduler"
+)
+
+//RefreshHistoryLimit is the max no. of refresh runs returned by the refresh history api
+const RefreshHistoryLimit = 50
+
+//RefreshScheduleRequest is the request to schedule the refresh of a dataset
+type RefreshScheduleRequest struct {
+	//Cron is the cron expression with the standard 5 fields as per which the dataset has to be refreshed
+	Cron string
+	//Append indicates that the data from the remote file has to be appended to the dataset instead of replacing it
+	Append bool
+	//Headers are the headers to be sent while downloading the remote file like the authorization headers
+	Headers map[string]string
+}
+
+//ScheduleRefresh will create or update the refresh schedule of a dataset imported from a remote url
+func ScheduleRefresh(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+	/*
+	 * We will get the app context
+	 * Then we will try to parse the request param id
+	 * Then we will parse the schedule request
+	 * Then we will compute the next run as per the cron expression
+	 * Then we will get the file upload and check whether it is imported from a remote url
+	 * If the data has to be appended, we will make sure that the dataset can be appended to
+	 * Then we will save the schedule
+	 */
+
+	//getting the app context
+	appCtx := ctx.Value(routes.AppContextKey).(*config.AppContext)
+	appCtx.Log.Info("Got a request to schedule the refresh of a file upload by", appCtx.Session.User.ID)
+
+	//parse the request param id
+	idStr := r.URL.Query().Get("id")
+	id, err := strconv.Atoi(idStr)
+	if err != nil {
+		//bad request
+		appCtx.Log.Error("error while parsing the file upload id", err.Error(), idStr)
+		response.WriteError(w, response.Error{Err: "Invalid Params " + idStr + " as id of the file upload"}, http.StatusBadRequest)
+		return
+	}
+
+	//parsing the schedule request
+	sR := &RefreshScheduleRequest{}
+	err = json.NewDecoder(r.Body).Decode(sR)
+	if err != nil {
+		//bad request
+		appCtx.Log.Error("error while parsing the refresh schedule", err.Error())
+		response.WriteError(w, response.Error{Err: "Invalid Params " + err.Error()}, http.StatusBadRequest)
+		return
+	}
+	defer r.Body.Close()
+
+	//computing the next run
+	next, err := scheduler.Next(sR.Cron, time.Now())
+	if err != nil {
+		//bad request
+		appCtx.Log.Error("error while parsing the cron expression", sR.Cron, err.Error())
+		response.WriteError(w, response.Error{Err: "Invalid cron expression " + sR.Cron + ". " + err.Error()}, http.StatusBadRequest)
+		return
+	}
+
+	//getting the file upload
+	f := &db.FileUpload{}
+	f.ID = uint(id)
+	err = f.Get(appCtx)
+	if err != nil {
+		//error while getting the info
+		appCtx.Log.Error("error while getting the info for file uploaded with id", id, err.Error())
+		response.WriteError(w, response.Error{Err: "Couldn't fetch the info of the file upload"}, http.StatusInternalServerError)
+		return
+	}
+	if len(f.SourceURL) == 0 {
+		//bad request
+		appCtx.Log.Error("file upload is not imported from a remote url to schedule its refresh", id)
+		response.WriteError(w, response.Error{Err: "Only the datasets imported from a remote url can be refreshed"}, http.StatusBadRequest)
+		return
+	}
+	if sR.Append {
+		dSet, err := f.GetDataset(appCtx)
+		if err != nil {
+			//error while getting the dataset
+			appCtx.Log.Error("error while getting the dataset of the file upload", id, err.Error())
+			response.WriteError(w, response.Error{Err: "Couldn't fetch the info of the file upload"}, http.StatusInternalServerError)
+			return
+		}
+		if err := routesFile.CheckAppend(appCtx, dSet.ID); err != nil {
+			appCtx.Log.Error("dataset", dSet.ID, "of the file upload", id, "can't be appended to", err.Error())
+			routesFile.WriteAppendError(w, err)
+			return
+		}
+	}
+
+	//saving the schedule
+	headers, err := json.Marshal(sR.Headers)
+	if err != nil {
+		//bad request
+		appCtx.Log.Error("error while encoding the headers of the refresh schedule", err.Error())
+		response.WriteError(w, response.Error{Err: "Invalid Params " + err.Error()}, http.StatusBadRequest)
+		return
+	}
+	s := &db.RefreshSchedule{
+		FileUploadID: f.ID,
+		UserID:       appCtx.Session.User.ID,
+		Cron:         sR.Cron,
+		Append:       sR.Append,
+		Headers:      string(headers),
+		NextRunAt:    next,
+	}
+	err = s.Save(appCtx)
+	if err != nil {
+		//error while saving the schedule
+		appCtx.Log.Error("error while saving the refresh schedule of file upload", id, err.Error())
+		response.WriteError(w, response.Error{Err: "Couldn't save the refresh schedule"}, http.StatusInternalServerError)
+		return
+	}
+
+	appCtx.Log.Info("Successfully scheduled the refresh of file upload", id, "next at", next)
+	response.Write(w, response.Message{Message: "Successfully scheduled the refresh", Data: s})
+}
+
+//GetRefreshSchedule will return the refresh schedule of a dataset
+func GetRefreshSchedule(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+	/*
+	 * We will get the app context
+	 * Then we will try to parse the request param id
+	 * Then we will get the schedule
+	 */
+
+	//getting the app context
+	appCtx := ctx.Value(routes.AppContextKey).(*config.AppContext)
+	appCtx.Log.Info("Got a request to get the refresh schedule of a file upload by", appCtx.Session.User.ID)
+
+	//parse the request param id
+	idStr := r.URL.Query().Get("id")
+	id, err := strconv.Atoi(idStr)
+	if err != nil {
+		//bad request
+		appCtx.Log.Error("error while parsing the file upload id", err.Error(), idStr)
+		response.WriteError(w, response.Error{Err: "Invalid Params " + idStr + " as id of the file upload"}, http.StatusBadRequest)
+		return
+	}
+
+	//getting the schedule
+	s := &db.RefreshSchedule{FileUploadID: uint(id)}
+	err = s.Get(appCtx)
+	if err != nil {
+		//error while getting the schedule
+		appCtx.Log.Error("error while getting the refresh schedule of file upload", id, err.Error())
+		response.WriteError(w, response.Error{Err: "Couldn't fetch the refresh schedule"}, http.StatusInternalServerError)
+		return
+	}
+
+	appCtx.Log.Info("Successfully fetched the refresh schedule of file upload", id)
+	response.Write(w, response.Message{Message: "Successfully fetched the refresh schedule", Data: s})
+}
+
+//DeleteRefreshSchedule will delete the refresh schedule of a dataset
+func DeleteRefreshSchedule(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+	/*
+	 * We will get the app context
+	 * Then we will try to parse the request param id
+	 * Then we will delete the schedule
+	 */
+
+	//getting the app context
+	appCtx := ctx.Value(routes.AppContextKey).(*config.AppContext)
+	appCtx.Log.Info("Got a request to delete the refresh schedule of a file upload by", appCtx.Session.User.ID)
+
+	//parse the request param id
+	idStr := r.URL.Query().Get("id")
+	id, err := strconv.Atoi(idStr)
+	if err != nil {
+		//bad request
+		appCtx.Log.Error("error while parsing the file upload id", err.Error(), idStr)
+		response.WriteError(w, response.Error{Err: "Invalid Params " + idStr + " as id of the file upload"}, http.StatusBadRequest)
+		return
+	}
+
+	//deleting the schedule
+	err = db.RefreshSchedule{FileUploadID: uint(id)}.Delete(appCtx)
+	if err != nil {
+		//error while deleting the schedule
+		appCtx.Log.Error("error while deleting the refresh schedule of file upload", id, err.Error())
+		response.WriteError(w, response.Error{Err: "Couldn't delete the refresh schedule"}, http.StatusInternalServerError)
+		return
+	}
+
+	appCtx.Log.Info("Successfully deleted the refresh schedule of file upload", id)
+	response.Write(w, response.Message{Message: "Successfully deleted the refresh schedule"})
+}
+
+//RefreshHistory will return the latest refresh runs of a dataset
+func RefreshHistory(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+	/*
+	 * We will get the app context
+	 * Then we will try to parse the request param id
+	 * Then we will get the refresh runs
+	 */
+
+	//getting the app context
+	appCtx := ctx.Value(routes.AppContextKey).(*config.AppContext)
+	appCtx.Log.Info("Got a request to get the refresh history of a file upload by", appCtx.Session.User.ID)
+
+	//parse the request param id
+	idStr := r.URL.Query().Get("id")
+	id, err := strconv.Atoi(idStr)
+	if err != nil {
+		//bad request
+		appCtx.Log.Error("error while parsing the file upload id", err.Error(), idStr)
+		response.WriteError(w, response.Error{Err: "Invalid Params " + idStr + " as id of the file upload"}, http.StatusBadRequest)
+		return
+	}
+
+	//getting the refresh runs
+	runs, err := db.GetRefreshRuns(appCtx, uint(id), RefreshHistoryLimit)
+	if err != nil {
+		//error while getting the refresh runs
+		appCtx.Log.Error("error while getting the refresh runs of file upload", id, err.Error())
+		response.WriteError(w, response.Error{Err: "Couldn't fetch the refresh history"}, http.StatusInternalServerError)
+		return
+	}
+
+	appCtx.Log.Info("Successfully fetched the refresh history of file upload", id)
+	response.Write(w, response.Message{Message: "Successfully fetched the refresh history", Data: runs})
+}
+
+func init() {
+	routes.AddRoutes(
+		routes.Route{
+			Version:     "v1",
+			Pattern:     "/datasets/refresh/schedule",
+			HandlerFunc: ScheduleRefresh,
+		},
+		routes.Route{
+			Version:     "v1",
+			Pattern:     "/datasets/refresh/get",
+			HandlerFunc: GetRefreshSchedule,
+		},
+		routes.Route{
+			Version:     "v1",
+			Pattern:     "/datasets/refresh/delete",
+			HandlerFunc: DeleteRefreshSchedule,
+		},
+		routes.Route{
+			Version:     "v1",
+			Pattern:     "/datasets/refresh/history",
+			HandlerFunc: RefreshHistory,
+		},
+	)
+}
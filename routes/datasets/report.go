@@ -0,0 +1,181 @@
+// Copyright 2019 Cuttle.ai. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package datasets
+
+/*
+ * This file contains the apis to report the time taken by the stages of the pipeline runs of the datasets
+ */
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"time"
+
+	authConfig "github.com/cuttle-ai/auth-service/config"
+	"github.com/cuttle-ai/file-uploader-service/config"
+	"github.com/cuttle-ai/file-uploader-service/models/db"
+	"github.com/cuttle-ai/file-uploader-service/routes"
+	"github.com/cuttle-ai/file-uploader-service/routes/response"
+)
+
+//ProcessingReportLimit is the max no. of runs returned by the processing report api
+const ProcessingReportLimit = 20
+
+//DefaultStatsDays is the no. of days for which the stats of the stages are aggregated if not given
+const DefaultStatsDays = 30
+
+//ProcessingReport is the report of a run on a dataset
+type ProcessingReport struct {
+	//Job is the job of the run
+	Job db.Job
+	//Stages are the stages of the run with their durations and metrics
+	Stages []db.JobStage
+	//Duration is the total time taken by the stages of the run in milliseconds
+	Duration int64
+	//RowsRead is the no. of rows read from the file in the run
+	RowsRead int64
+	//RowsRejected is the no. of rows rejected in the run
+	RowsRejected int64
+	//BytesWritten is the no. of bytes written to the datastore in the run
+	BytesWritten int64
+	//DatastoreID is the id of the datastore chosen for the dataset in the run
+	DatastoreID uint
+}
+
+//GetProcessingReport will return the report of the latest runs on a dataset with the time taken by each stage,
+//the no. of rows read and rejected, the no. of bytes written and the datastore chosen
+func GetProcessingReport(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+	/*
+	 * We will get the app context
+	 * Then we will get the dataset
+	 * Then we will get the latest runs on the dataset
+	 * Then we will get the stages of the runs
+	 * Then we will prepare the report of each run
+	 */
+
+	//getting the app context
+	appCtx := ctx.Value(routes.AppContextKey).(*config.AppContext)
+	appCtx.Log.Info("Got a request to get the processing report of a dataset by", appCtx.Session.User.ID)
+
+	//getting the dataset
+	d, _, ok := getUserDataset(appCtx, w, r)
+	if !ok {
+		return
+	}
+
+	//getting the latest runs
+	runs, err := db.GetFileUploadJobs(appCtx, d.ResourceID, ProcessingReportLimit)
+	if err != nil {
+		//error while getting the jobs
+		appCtx.Log.Error("error while getting the jobs of the dataset", d.ID, err.Error())
+		response.WriteError(w, response.Error{Err: "Couldn't fetch the processing report"}, http.StatusInternalServerError)
+		return
+	}
+
+	//getting the stages of the runs
+	ids := []uint{}
+	for _, v := range runs {
+		ids = append(ids, v.ID)
+	}
+	stages, err := db.GetStagesOfJobs(appCtx, ids)
+	if err != nil {
+		//error while getting the stages
+		appCtx.Log.Error("error while getting the stages of the jobs of the dataset", d.ID, err.Error())
+		response.WriteError(w, response.Error{Err: "Couldn't fetch the processing report"}, http.StatusInternalServerError)
+		return
+	}
+	jobStages := map[uint][]db.JobStage{}
+	for _, v := range stages {
+		jobStages[v.JobID] = append(jobStages[v.JobID], v)
+	}
+
+	//preparing the report of each run
+	reports := []ProcessingReport{}
+	for _, v := range runs {
+		rep := ProcessingReport{Job: v, Stages: jobStages[v.ID]}
+		if rep.Stages == nil {
+			rep.Stages = []db.JobStage{}
+		}
+		for _, s := range rep.Stages {
+			rep.Duration += s.Duration
+			rep.RowsRejected += s.RowsRejected
+			rep.BytesWritten += s.BytesWritten
+			if s.Rows > rep.RowsRead {
+				rep.RowsRead = s.Rows
+			}
+			if s.DatastoreID != 0 {
+				rep.DatastoreID = s.DatastoreID
+			}
+		}
+		reports = append(reports, rep)
+	}
+
+	appCtx.Log.Info("Successfully fetched the processing report of the dataset", d.ID)
+	response.Write(w, response.Message{Message: "Successfully fetched the processing report", Data: reports})
+}
+
+//GetStageStats will return the p50 and p95 of the time taken by the stages of the pipeline per stage and per file type.
+//The stages finished in the last days query param no. of days are aggregated. It is available only to the admins
+func GetStageStats(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+	/*
+	 * We will get the app context
+	 * Then we will make sure that the user is an admin
+	 * Then we will parse the no. of days
+	 * Then we will get the stats of the stages
+	 */
+
+	//getting the app context
+	appCtx := ctx.Value(routes.AppContextKey).(*config.AppContext)
+	appCtx.Log.Info("Got a request to get the stats of the pipeline stages by", appCtx.Session.User.ID)
+
+	//making sure that the user is an admin
+	if appCtx.Session.User.UserType != authConfig.AdminUser && appCtx.Session.User.UserType != authConfig.SuperAdmin {
+		//forbidden
+		appCtx.Log.Error("user", appCtx.Session.User.ID, "is not an admin to get the stats of the pipeline stages")
+		response.WriteError(w, response.Error{Err: "Only the admins can get the stats of the pipeline"}, http.StatusForbidden)
+		return
+	}
+
+	//parsing the no. of days
+	days := DefaultStatsDays
+	if dStr := r.URL.Query().Get("days"); len(dStr) != 0 {
+		d, err := strconv.Atoi(dStr)
+		if err != nil || d <= 0 {
+			//bad request
+			appCtx.Log.Error("error while parsing the no. of days", dStr)
+			response.WriteError(w, response.Error{Err: "Invalid Params " + dStr + " as the no. of days"}, http.StatusBadRequest)
+			return
+		}
+		days = d
+	}
+
+	//getting the stats of the stages
+	stats, err := db.GetStageStats(appCtx, time.Now().AddDate(0, 0, -days))
+	if err != nil {
+		//error while getting the stats
+		appCtx.Log.Error("error while getting the stats of the pipeline stages", err.Error())
+		response.WriteError(w, response.Error{Err: "Couldn't fetch the stats of the pipeline"}, http.StatusInternalServerError)
+		return
+	}
+
+	appCtx.Log.Info("Successfully fetched the stats of the pipeline stages for the last", days, "days")
+	response.Write(w, response.Message{Message: "Successfully fetched the stats of the pipeline", Data: stats})
+}
+
+func init() {
+	routes.AddRoutes(
+		routes.Route{
+			Version:     "v1",
+			Pattern:     "/dataset/report",
+			HandlerFunc: GetProcessingReport,
+		},
+		routes.Route{
+			Version:     "v1",
+			Pattern:     "/dataset/report/stats",
+			HandlerFunc: GetStageStats,
+		},
+	)
+}
@@ -0,0 +1,403 @@
+// Copyright 2019 Cuttle.ai. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package datasets
+
+/*
+ * This file contains the resumable file upload api as per the tus 1.0 protocol (https://tus.io/protocols/resumable-upload.html)
+ * The creation and termination extensions of the protocol are supported
+ */
+
+import (
+	"context"
+	"encoding/base64"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/cuttle-ai/file-uploader-service/config"
+	"github.com/cuttle-ai/file-uploader-service/models/db"
+	"github.com/cuttle-ai/file-uploader-service/routes"
+	routesFile "github.com/cuttle-ai/file-uploader-service/routes/file"
+	"github.com/cuttle-ai/file-uploader-service/routes/response"
+)
+
+//TusVersion is the version of the tus protocol supported
+const TusVersion = "1.0.0"
+
+//TusExtensions are the extensions of the tus protocol supported
+const TusExtensions = "creation,termination"
+
+//ResumableUploadPattern is the url pattern of the resumable upload api. The uploads are available at the pattern followed by their id
+const ResumableUploadPattern = "/datasets/resumable/"
+
+//ResumableUpload handles the requests of the resumable upload api as per the method of the request
+func ResumableUpload(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+	/*
+	 * We will get the app context
+	 * Then we will set the protocol headers
+	 * Then we will check the version of the protocol used by the client
+	 * Then we will handle the request as per the method
+	 */
+	//getting the app context
+	appCtx := ctx.Value(routes.AppContextKey).(*config.AppContext)
+
+	//setting the protocol headers
+	w.Header().Set("Tus-Resumable", TusVersion)
+	method := r.Method
+	if o := r.Header.Get("X-HTTP-Method-Override"); len(o) != 0 {
+		method = o
+	}
+	if method == http.MethodOptions {
+		w.Header().Set("Tus-Version", TusVersion)
+		w.Header().Set("Tus-Extension", TusExtensions)
+		if config.MaxUploadSize > 0 {
+			w.Header().Set("Tus-Max-Size", strconv.FormatInt(config.MaxUploadSize, 10))
+		}
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	//checking the version of the protocol
+	if r.Header.Get("Tus-Resumable") != TusVersion {
+		appCtx.Log.Error("unsupported tus version", r.Header.Get("Tus-Resumable"))
+		w.Header().Set("Tus-Version", TusVersion)
+		response.WriteError(w, response.Error{Err: "Unsupported tus version " + r.Header.Get("Tus-Resumable")}, http.StatusPreconditionFailed)
+		return
+	}
+
+	//handling the request
+	switch method {
+	case http.MethodPost:
+		createResumableUpload(appCtx, w, r)
+		return
+	case http.MethodHead:
+		getResumableUploadOffset(appCtx, w, r)
+		return
+	case http.MethodPatch:
+		patchResumableUpload(appCtx, w, r)
+		return
+	case http.MethodDelete:
+		deleteResumableUpload(appCtx, w, r)
+		return
+	}
+	response.WriteError(w, response.Error{Err: "Method " + method + " not allowed"}, http.StatusMethodNotAllowed)
+}
+
+//parseUploadMetadata parses the Upload-Metadata header. It has comma separated key value pairs
+//where the key and value are separated by a space and the value is base64 encoded
+func parseUploadMetadata(header string) map[string]string {
+	result := map[string]string{}
+	for _, v := range strings.Split(header, ",") {
+		kv := strings.Fields(v)
+		if len(kv) == 0 {
+			continue
+		}
+		if len(kv) == 1 {
+			result[kv[0]] = ""
+			continue
+		}
+		value, err := base64.StdEncoding.DecodeString(kv[1])
+		if err != nil {
+			continue
+		}
+		result[kv[0]] = string(value)
+	}
+	return result
+}
+
+//getResumableUpload returns the resumable upload whose id is in the url of the request
+func getResumableUpload(appCtx *config.AppContext, r *http.Request) (*db.ResumableUpload, int, error) {
+	/*
+	 * We will parse the id from the url
+	 * Then we will get the upload from the database
+	 */
+	//parsing the id
+	idStr := r.URL.Path[strings.LastIndex(r.URL.Path, "/")+1:]
+	id, err := strconv.Atoi(idStr)
+	if err != nil {
+		return nil, http.StatusNotFound, err
+	}
+
+	//getting the upload
+	u := &db.ResumableUpload{}
+	u.ID = uint(id)
+	err = u.Get(appCtx)
+	if err != nil {
+		return nil, http.StatusNotFound, err
+	}
+	return u, http.StatusOK, nil
+}
+
+//createResumableUpload creates a new resumable upload
+func createResumableUpload(appCtx *config.AppContext, w http.ResponseWriter, r *http.Request) {
+	/*
+	 * We will parse the length and the metadata of the upload
+	 * Then we will parse the upload options
+	 * Then we will create the partial file in the file dump directory
+	 * Then we will store the upload in the database
+	 */
+	//parsing the length and the metadata
+	appCtx.Log.Info("Got a request to create a resumable upload by", appCtx.Session.User.Email)
+	length, err := strconv.ParseInt(r.Header.Get("Upload-Length"), 10, 64)
+	if err != nil || length <= 0 {
+		appCtx.Log.Error("invalid upload length for the resumable upload", r.Header.Get("Upload-Length"))
+		response.WriteError(w, response.Error{Err: "Invalid Upload-Length " + r.Header.Get("Upload-Length")}, http.StatusBadRequest)
+		return
+	}
+	if config.MaxUploadSize > 0 && length > config.MaxUploadSize {
+		appCtx.Log.Error("upload length of the resumable upload is larger than the max upload size", length)
+		routesFile.WriteUploadError(w, routesFile.ErrUploadTooLarge)
+		return
+	}
+	metadata := parseUploadMetadata(r.Header.Get("Upload-Metadata"))
+	name := metadata["filename"]
+	if len(name) == 0 {
+		name = metadata["name"]
+	}
+	if len(name) == 0 {
+		appCtx.Log.Error("filename is missing in the metadata of the resumable upload")
+		response.WriteError(w, response.Error{Err: "filename is missing in the Upload-Metadata"}, http.StatusBadRequest)
+		return
+	}
+	mimeType := metadata["filetype"]
+	if len(mimeType) == 0 {
+		mimeType = metadata["type"]
+	}
+
+	//parsing the options with which the file has to be processed
+	options, err := routesFile.ParseUploadOptions(r)
+	if err != nil {
+		//bad request
+		appCtx.Log.Error("error while parsing the upload options", name, err.Error())
+		response.WriteError(w, response.Error{Err: "Invalid upload options. " + err.Error()}, http.StatusBadRequest)
+		return
+	}
+
+	//creating the partial file
+	location, err := dumpLocation(appCtx, name)
+	if err != nil {
+		//error while creating the location for the file
+		appCtx.Log.Error("error while creating the location for the resumable upload", name, err.Error())
+		response.WriteError(w, response.Error{Err: "Error while creating the upload"}, http.StatusInternalServerError)
+		return
+	}
+	f, err := os.Create(location)
+	if err != nil {
+		appCtx.Log.Error("error while creating the partial file for the resumable upload", name, err.Error())
+		response.WriteError(w, response.Error{Err: "Error while creating the upload"}, http.StatusInternalServerError)
+		return
+	}
+	f.Close()
+
+	//storing the upload
+	u := &db.ResumableUpload{Name: name, MimeType: mimeType, UserID: appCtx.Session.User.ID, Location: location, Length: length, Options: options}
+	err = u.Create(appCtx)
+	if err != nil {
+		appCtx.Log.Error("error while storing the resumable upload", name, err.Error())
+		os.Remove(location)
+		response.WriteError(w, response.Error{Err: "Error while creating the upload"}, http.StatusInternalServerError)
+		return
+	}
+
+	appCtx.Log.Info("Successfully created the resumable upload", u.ID, "for", name, "of size", length)
+	w.Header().Set("Location", strings.TrimSuffix(r.URL.Path, "/")+"/"+strconv.Itoa(int(u.ID)))
+	w.WriteHeader(http.StatusCreated)
+}
+
+//getResumableUploadOffset writes the no. of bytes received so far for the resumable upload
+func getResumableUploadOffset(appCtx *config.AppContext, w http.ResponseWriter, r *http.Request) {
+	u, code, err := getResumableUpload(appCtx, r)
+	if err != nil {
+		appCtx.Log.Error("error while getting the resumable upload", r.URL.Path, err.Error())
+		w.WriteHeader(code)
+		return
+	}
+	w.Header().Set("Upload-Offset", strconv.FormatInt(u.UploadOffset, 10))
+	w.Header().Set("Upload-Length", strconv.FormatInt(u.Length, 10))
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(http.StatusOK)
+}
+
+//patchResumableUpload writes a chunk of the resumable upload at the offset. Once all the chunks are received,
+//the file is processed and the datasets created from it are written as the response. The upload is finalized only after the datasets are stored,
+//so that the processing can be retried with an empty chunk at the final offset if it fails.
+//Only one request can write to an upload at a time. The concurrent requests are rejected with a conflict
+func patchResumableUpload(appCtx *config.AppContext, w http.ResponseWriter, r *http.Request) {
+	/*
+	 * We will get the upload
+	 * Then we will check the content type
+	 * Then we will lock the upload and get it again since it could have changed before it was locked
+	 * Then we will check the offset
+	 * Then we will write the chunk at the offset in the partial file
+	 * Then we will update the offset
+	 * If the upload is complete we will process the file and finalize the upload
+	 */
+	//getting the upload
+	u, code, err := getResumableUpload(appCtx, r)
+	if err != nil {
+		appCtx.Log.Error("error while getting the resumable upload", r.URL.Path, err.Error())
+		response.WriteError(w, response.Error{Err: "Couldn't find the upload"}, code)
+		return
+	}
+
+	//checking the content type
+	if r.Header.Get("Content-Type") != "application/offset+octet-stream" {
+		response.WriteError(w, response.Error{Err: "Content-Type has to be application/offset+octet-stream"}, http.StatusUnsupportedMediaType)
+		return
+	}
+
+	//locking the upload
+	lock, ok, err := db.TryLockResumableUpload(appCtx, u.ID)
+	if err != nil {
+		appCtx.Log.Error("error while locking the resumable upload", u.ID, err.Error())
+		response.WriteError(w, response.Error{Err: "Error while saving the chunk"}, http.StatusInternalServerError)
+		return
+	}
+	if !ok {
+		appCtx.Log.Error("resumable upload", u.ID, "is being written by a concurrent request")
+		response.WriteError(w, response.Error{Err: "The upload is being written by another request"}, http.StatusConflict)
+		return
+	}
+	defer func() {
+		if uErr := lock.Unlock(); uErr != nil {
+			appCtx.Log.Error("error while unlocking the resumable upload", u.ID, uErr.Error())
+		}
+	}()
+	err = u.Get(appCtx)
+	if err != nil {
+		appCtx.Log.Error("error while getting the resumable upload", u.ID, err.Error())
+		response.WriteError(w, response.Error{Err: "Couldn't find the upload"}, http.StatusInternalServerError)
+		return
+	}
+
+	//checking the offset
+	offset, err := strconv.ParseInt(r.Header.Get("Upload-Offset"), 10, 64)
+	if err != nil || offset != u.UploadOffset || u.Finalized {
+		appCtx.Log.Error("offset mismatch for the resumable upload", u.ID, r.Header.Get("Upload-Offset"), u.UploadOffset)
+		response.WriteError(w, response.Error{Err: "Upload-Offset doesn't match the offset of the upload"}, http.StatusConflict)
+		return
+	}
+
+	//writing the chunk
+	f, err := os.OpenFile(u.Location, os.O_WRONLY, 0644)
+	if err != nil {
+		appCtx.Log.Error("error while opening the partial file for the resumable upload", u.ID, err.Error())
+		response.WriteError(w, response.Error{Err: "Error while saving the chunk"}, http.StatusInternalServerError)
+		return
+	}
+	defer f.Close()
+	_, err = f.Seek(offset, io.SeekStart)
+	if err != nil {
+		appCtx.Log.Error("error while seeking the partial file for the resumable upload", u.ID, err.Error())
+		response.WriteError(w, response.Error{Err: "Error while saving the chunk"}, http.StatusInternalServerError)
+		return
+	}
+	n, cErr := io.Copy(f, io.LimitReader(r.Body, u.Length-offset))
+
+	//updating the offset
+	//the bytes received are saved even if the request was interrupted so that the client can resume from there
+	u.UploadOffset = offset + n
+	ok, err = u.UpdateOffset(appCtx, offset)
+	if err != nil {
+		appCtx.Log.Error("error while updating the offset of the resumable upload", u.ID, err.Error())
+		response.WriteError(w, response.Error{Err: "Error while saving the chunk"}, http.StatusInternalServerError)
+		return
+	}
+	if !ok {
+		appCtx.Log.Error("offset of the resumable upload was changed by a concurrent request", u.ID)
+		response.WriteError(w, response.Error{Err: "Upload-Offset doesn't match the offset of the upload"}, http.StatusConflict)
+		return
+	}
+	if cErr != nil {
+		appCtx.Log.Error("error while saving the chunk of the resumable upload", u.ID, cErr.Error())
+		response.WriteError(w, response.Error{Err: "Error while saving the chunk"}, http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Upload-Offset", strconv.FormatInt(u.UploadOffset, 10))
+	if u.UploadOffset < u.Length {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	//processing the file and finalizing the upload
+	appCtx.Log.Info("Received all the chunks of the resumable upload", u.ID)
+	uploaded, err := routesFile.Checksum(u.Location)
+	if err != nil {
+		appCtx.Log.Error("error while computing the checksum of the resumable upload", u.ID, err.Error())
+		response.WriteError(w, response.Error{Err: "Error while finalizing the upload"}, http.StatusInternalServerError)
+		return
+	}
+	//the upload is finalized in the transaction storing the datasets so that they are never stored twice
+	processUpload(appCtx, w, uploaded, u.Name, u.MimeType, u.Options, u.Finalize)
+}
+
+//deleteResumableUpload terminates the resumable upload. The partial file is deleted.
+//The upload is locked like while writing a chunk so that the file is not deleted while it is being processed
+func deleteResumableUpload(appCtx *config.AppContext, w http.ResponseWriter, r *http.Request) {
+	/*
+	 * We will get the upload
+	 * Then we will lock the upload and get it again since it could have been finalized before it was locked
+	 * Then we will delete the upload and its partial file
+	 */
+	//getting the upload
+	u, code, err := getResumableUpload(appCtx, r)
+	if err != nil {
+		appCtx.Log.Error("error while getting the resumable upload", r.URL.Path, err.Error())
+		response.WriteError(w, response.Error{Err: "Couldn't find the upload"}, code)
+		return
+	}
+
+	//locking the upload
+	lock, ok, err := db.TryLockResumableUpload(appCtx, u.ID)
+	if err != nil {
+		appCtx.Log.Error("error while locking the resumable upload", u.ID, err.Error())
+		response.WriteError(w, response.Error{Err: "Error while deleting the upload"}, http.StatusInternalServerError)
+		return
+	}
+	if !ok {
+		appCtx.Log.Error("resumable upload", u.ID, "is being written by a concurrent request")
+		response.WriteError(w, response.Error{Err: "The upload is being written by another request"}, http.StatusConflict)
+		return
+	}
+	defer func() {
+		if uErr := lock.Unlock(); uErr != nil {
+			appCtx.Log.Error("error while unlocking the resumable upload", u.ID, uErr.Error())
+		}
+	}()
+	err = u.Get(appCtx)
+	if err != nil {
+		appCtx.Log.Error("error while getting the resumable upload", u.ID, err.Error())
+		response.WriteError(w, response.Error{Err: "Couldn't find the upload"}, http.StatusInternalServerError)
+		return
+	}
+	if u.Finalized {
+		response.WriteError(w, response.Error{Err: "Upload is already finalized"}, http.StatusConflict)
+		return
+	}
+
+	//deleting the upload
+	err = u.Delete(appCtx)
+	if err != nil {
+		appCtx.Log.Error("error while deleting the resumable upload", u.ID, err.Error())
+		response.WriteError(w, response.Error{Err: "Error while deleting the upload"}, http.StatusInternalServerError)
+		return
+	}
+	os.Remove(u.Location)
+	appCtx.Log.Info("Successfully deleted the resumable upload", u.ID)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func init() {
+	routes.AddRoutes(
+		routes.Route{
+			Version:     "v1",
+			Pattern:     ResumableUploadPattern,
+			HandlerFunc: ResumableUpload,
+			Stream:      true,
+		},
+	)
+}
@@ -0,0 +1,156 @@
+// Copyright 2019 Cuttle.ai. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package datasets
+
+/*
+ * This file contains the apis to get and set the rules validated on the rows of the files of the datasets
+ */
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	"github.com/cuttle-ai/file-uploader-service/config"
+	fModels "github.com/cuttle-ai/file-uploader-service/models"
+	"github.com/cuttle-ai/file-uploader-service/models/db"
+	"github.com/cuttle-ai/file-uploader-service/routes"
+	"github.com/cuttle-ai/file-uploader-service/routes/response"
+	"github.com/cuttle-ai/file-uploader-service/rules"
+)
+
+//DatasetRules is the rule set of a dataset
+type DatasetRules struct {
+	//Rules are the rules of the dataset
+	Rules []fModels.DatasetRule
+}
+
+//GetRules will return the rules of a dataset
+func GetRules(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+	/*
+	 * We will get the app context
+	 * Then we will get the dataset
+	 * Then we will get its rules
+	 */
+
+	//getting the app context
+	appCtx := ctx.Value(routes.AppContextKey).(*config.AppContext)
+	appCtx.Log.Info("Got a request to get the rules of a dataset by", appCtx.Session.User.ID)
+
+	//getting the dataset
+	d, _, ok := getUserDataset(appCtx, w, r)
+	if !ok {
+		return
+	}
+
+	//getting the rules
+	rs, err := db.GetDatasetRules(appCtx, d.ID)
+	if err != nil {
+		//error while getting the rules
+		appCtx.Log.Error("error while getting the rules of the dataset", d.ID, err.Error())
+		response.WriteError(w, response.Error{Err: "Couldn't fetch the rules of the dataset"}, http.StatusInternalServerError)
+		return
+	}
+
+	appCtx.Log.Info("Successfully fetched the rules of the dataset", d.ID)
+	response.Write(w, response.Message{Message: "Successfully fetched the rules of the dataset", Data: DatasetRules{Rules: rs}})
+}
+
+//UpdateRules will replace the rules of a dataset with the given rule set. The rules are removed if no rules are given.
+//The rules are applied from the next upload or append to the dataset. The unique rules can't be attached to a dataset which is appended to,
+//since they are checked only within a file
+func UpdateRules(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+	/*
+	 * We will get the app context
+	 * Then we will get the dataset
+	 * Then we will parse the rules and check them
+	 * If there are unique rules, we will make sure that the dataset is not appended to
+	 * Then we will save the rules
+	 */
+
+	//getting the app context
+	appCtx := ctx.Value(routes.AppContextKey).(*config.AppContext)
+	appCtx.Log.Info("Got a request to update the rules of a dataset by", appCtx.Session.User.ID)
+
+	//getting the dataset
+	d, _, ok := getUserDataset(appCtx, w, r)
+	if !ok {
+		return
+	}
+
+	//parsing the rules
+	rs := &DatasetRules{}
+	err := json.NewDecoder(r.Body).Decode(rs)
+	if err != nil {
+		//bad request
+		appCtx.Log.Error("error while parsing the rules of the dataset", err.Error())
+		response.WriteError(w, response.Error{Err: "Invalid Params " + err.Error()}, http.StatusBadRequest)
+		return
+	}
+	defer r.Body.Close()
+	for i := range rs.Rules {
+		rs.Rules[i].Type = strings.ToUpper(strings.TrimSpace(rs.Rules[i].Type))
+		rs.Rules[i].Severity = strings.ToUpper(strings.TrimSpace(rs.Rules[i].Severity))
+		err = rules.Check(rs.Rules[i])
+		if err != nil {
+			//bad request
+			appCtx.Log.Error("invalid rule", i+1, "for the dataset", d.ID, err.Error())
+			response.WriteError(w, response.Error{Err: "Invalid Params " + err.Error()}, http.StatusBadRequest)
+			return
+		}
+	}
+
+	//making sure that the dataset with the unique rules is not appended to
+	unique := false
+	for _, v := range rs.Rules {
+		if v.Type == fModels.DatasetRuleTypeUnique {
+			unique = true
+			break
+		}
+	}
+	if unique {
+		appended, err := db.IsAppended(appCtx, d.ID, d.ResourceID)
+		if err != nil {
+			//error while checking the appends
+			appCtx.Log.Error("error while checking whether the dataset", d.ID, "is appended to", err.Error())
+			response.WriteError(w, response.Error{Err: "Couldn't update the rules of the dataset"}, http.StatusInternalServerError)
+			return
+		}
+		if appended {
+			//the unique rules can't be checked on the appends
+			appCtx.Log.Error("unique rules can't be attached to the dataset", d.ID, "which is appended to")
+			response.WriteError(w, response.Error{Err: rules.ErrUniqueOnAppend.Error()}, http.StatusConflict)
+			return
+		}
+	}
+
+	//saving the rules
+	err = db.SetDatasetRules(appCtx, d.ID, rs.Rules)
+	if err != nil {
+		//error while saving the rules
+		appCtx.Log.Error("error while saving the rules of the dataset", d.ID, err.Error())
+		response.WriteError(w, response.Error{Err: "Couldn't update the rules of the dataset"}, http.StatusInternalServerError)
+		return
+	}
+
+	appCtx.Log.Info("Successfully updated the rules of the dataset", d.ID)
+	response.Write(w, response.Message{Message: "Successfully updated the rules of the dataset", Data: rs})
+}
+
+func init() {
+	routes.AddRoutes(
+		routes.Route{
+			Version:     "v1",
+			Pattern:     "/dataset/rules",
+			HandlerFunc: GetRules,
+		},
+		routes.Route{
+			Version:     "v1",
+			Pattern:     "/dataset/rules/update",
+			HandlerFunc: UpdateRules,
+		},
+	)
+}
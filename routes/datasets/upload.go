@@ -10,32 +10,32 @@ package datasets
 
 import (
 	"context"
-	"io"
+	"errors"
 	"net/http"
 	"os"
 	"os/user"
 	"path/filepath"
 
+	brainModels "github.com/cuttle-ai/brain/models"
 	"github.com/cuttle-ai/file-uploader-service/config"
 	libfile "github.com/cuttle-ai/file-uploader-service/file"
+	"github.com/cuttle-ai/file-uploader-service/file/archive"
+	"github.com/cuttle-ai/file-uploader-service/jobs"
 	"github.com/cuttle-ai/file-uploader-service/models"
 	"github.com/cuttle-ai/file-uploader-service/models/db"
 	"github.com/cuttle-ai/file-uploader-service/routes"
 	routesFile "github.com/cuttle-ai/file-uploader-service/routes/file"
 	"github.com/cuttle-ai/file-uploader-service/routes/response"
-	"github.com/jinzhu/gorm"
 )
 
 //Upload will upload a file to the platform and will start the process of verifying the file
 func Upload(ctx context.Context, w http.ResponseWriter, r *http.Request) {
 	/*
 	 * We will get the app context
-	 * Then we will parse the multipart file
-	 * we will get the file
-	 * Then we will get the system user home directory
-	 * we will create the new directory location where the uploaded file has to be moved
-	 * Will create the new file name
-	 * Then will move the file into that location
+	 * Then we will parse the upload options
+	 * we will get the file part from the multipart request
+	 * Will create the new file name in the file dump directory
+	 * Then will stream the file into that location
 	 * Then will start to process the file and store it
 	 */
 
@@ -43,80 +43,170 @@ func Upload(ctx context.Context, w http.ResponseWriter, r *http.Request) {
 	appCtx := ctx.Value(routes.AppContextKey).(*config.AppContext)
 	appCtx.Log.Info("Got a request to upload a file by", appCtx.Session.User.Email)
 
-	//parsing the multipart form
-	//maximum we can parse 1Gb file size
-	r.ParseMultipartForm(10 << 30)
+	//parsing the options with which the file has to be processed
+	options, err := routesFile.ParseUploadOptions(r)
+	if err != nil {
+		//bad request
+		appCtx.Log.Error("error while parsing the upload options", err.Error())
+		response.WriteError(w, response.Error{Err: "Invalid upload options. " + err.Error()}, http.StatusBadRequest)
+		return
+	}
 
-	//we are getting the file
-	file, handler, err := r.FormFile("file")
+	//we are getting the file part
+	part, err := routesFile.FilePart(w, r)
 	if err != nil {
 		appCtx.Log.Error("error retrieving the File", err)
+		if errors.Is(err, routesFile.ErrUploadTooLarge) {
+			routesFile.WriteUploadError(w, err)
+			return
+		}
 		response.WriteError(w, response.Error{Err: "Error while reading the uploaded file"}, http.StatusBadRequest)
 		return
 	}
-	defer file.Close()
+	defer part.Close()
+	appCtx.Log.Info("A file upload has been initiated", part.FileName())
 
-	appCtx.Log.Info("A file upload has been initiated", handler.Filename, "of size", handler.Size)
+	//creating the new file name
+	newfile, err := dumpLocation(appCtx, part.FileName())
+	if err != nil {
+		//error while creating the location for the file
+		appCtx.Log.Error("error while creating the location for the uploaded file", part.FileName(), err.Error())
+		response.WriteError(w, response.Error{Err: "Error while saving the uploaded file to a server location"}, http.StatusInternalServerError)
+		return
+	}
 
+	//stream the file
+	uploaded, err := routesFile.StreamUpload(part, newfile)
+	if err != nil {
+		appCtx.Log.Error("error while streaming the file to the processing location", part.FileName(), err.Error())
+		routesFile.WriteUploadError(w, err)
+		return
+	}
+	appCtx.Log.Info("Streamed the uploaded file", part.FileName(), "of size", uploaded.Size, "with checksum", uploaded.Checksum)
+
+	//we will start processing the file
+	processUpload(appCtx, w, uploaded, part.FileName(), part.Header.Get("Content-Type"), options, nil)
+}
+
+//dumpLocation creates a new directory in the file dump directory of the user and returns the location
+//for the uploaded file with the given name in it
+func dumpLocation(a *config.AppContext, filename string) (string, error) {
+	/*
+	 * We will get the system user home directory
+	 * we will create the new directory location where the uploaded file has to be moved
+	 * Will create the new file name
+	 */
 	//we are getting the user home
 	usr, err := user.Current()
 	if err != nil {
 		//error while getting the current user home
-		appCtx.Log.Error("error while uploading the user home details while moving the uploaded file", handler.Filename)
-		response.WriteError(w, response.Error{Err: "Error while saving the uploaded file to a server location"}, http.StatusInternalServerError)
-		return
+		return "", err
 	}
 
 	//will create the directory if required for the new file
 	req := libfile.NewNameGenerate()
-	req.Name = usr.HomeDir + config.FileDumpDirectory + appCtx.Session.User.Email
+	req.Name = usr.HomeDir + config.FileDumpDirectory + a.Session.User.Email
 	libfile.GenerateNameChan <- req
 	out := <-req.Out
 	newpath := out.Generated
-	os.MkdirAll(newpath, 0755)
-
-	//creating the new file name
-	newfile := newpath + string([]rune{filepath.Separator}) + handler.Filename
-
-	//move the file
-	f, err := os.Create(newfile)
-	if err != nil {
-		appCtx.Log.Error("error while moving the file to the processing location", handler.Filename, err.Error())
-		response.WriteError(w, response.Error{Err: "Error while moving the uploaded file to a server location"}, http.StatusInternalServerError)
-		return
-	}
-	defer f.Close()
-	_, err = io.Copy(f, file)
+	err = os.MkdirAll(newpath, 0755)
 	if err != nil {
-		appCtx.Log.Error("error while moving the file to the processing location", handler.Filename, err.Error())
-		response.WriteError(w, response.Error{Err: "Error while moving the uploaded file to a server location"}, http.StatusInternalServerError)
-		return
+		return "", err
 	}
 
+	//creating the new file name
+	return newpath + string([]rune{filepath.Separator}) + filepath.Base(filename), nil
+}
+
+//processUpload will process the uploaded file, store a file upload for each of the datasets in it
+//and enqueue the pipeline for each of them. The response is written with the stored datasets. It returns true if the datasets were stored.
+//stored is run in the same transaction in which the datasets are stored, if given
+func processUpload(appCtx *config.AppContext, w http.ResponseWriter, uploaded routesFile.UploadedFile, uploadname string, mimeType string, options models.FileUploadOptions, stored func(txCtx *config.AppContext) error) bool {
+	/*
+	 * We will start processing the file
+	 * Then we will store each of the files with the checksum and size of the uploaded file
+	 * Then we will start the pipeline for each of them
+	 */
 	//we will start processing the file
-	fT, err := libfile.ProcessFile(newfile, handler.Filename)
+	newfile := uploaded.Location
+	fTs, err := libfile.ProcessUpload(newfile, uploadname, mimeType, options)
 	if err != nil {
 		//error while identifying the file
 		appCtx.Log.Error("error while identifying the file type", newfile, err.Error())
+		if errors.Is(err, archive.ErrLimitExceeded) {
+			response.WriteError(w, response.Error{Err: "Uploaded archive exceeds the allowed limits"}, http.StatusRequestEntityTooLarge)
+			return false
+		}
 		response.WriteError(w, response.Error{Err: "Unidentified file format"}, http.StatusBadRequest)
-		return
+		return false
 	}
 	//and store it
-	d, err := fT.Store(appCtx)
+	datasets, err := storeFiles(appCtx, fTs, uploaded, stored)
 	if err != nil {
 		//error whilen storing the record
 		appCtx.Log.Error("error while storing the file type", newfile, err.Error())
-		response.WriteError(w, response.Error{Err: "Unidentified file format"}, http.StatusBadRequest)
-		return
+		response.WriteError(w, response.Error{Err: "Error while storing the uploaded file"}, http.StatusInternalServerError)
+		return false
 	}
-	fR, _ := d.UploadedDataset.(*models.FileUpload)
-	fR.Location = ""
-	d.UploadedDataset = fR
 
-	go routesFile.StartPipelineProcess(appCtx, &db.FileUpload{Model: gorm.Model{ID: fR.ID}}, false)
+	//a single dataset is written as such to keep the response same as before for the files having one dataset
+	if len(datasets) == 1 {
+		response.Write(w, response.Message{Message: "Successfully uploaded the file", Data: datasets[0]})
+		return true
+	}
+	response.Write(w, response.Message{Message: "Successfully uploaded the file", Data: datasets})
+	return true
+}
 
-	appCtx.Log.Info("Successfully moved the uploaded file", handler.Filename, "to", newfile, "and stored to db with id", d.ID)
-	response.Write(w, response.Message{Message: "Successfully uploaded the file", Data: d})
+//storeFiles stores each of the files processed from an uploaded file with the checksum, size and source of the uploaded file and enqueues
+//the pipeline for each of them. They are stored along with their pipelines in a transaction, so that either all of them are stored or none.
+//If given, stored is run in the same transaction once the files are stored, like to finalize the resumable upload of the files.
+//The stored datasets are returned with the location of the files masked
+func storeFiles(appCtx *config.AppContext, fTs []libfile.File, uploaded routesFile.UploadedFile, stored func(txCtx *config.AppContext) error) ([]*brainModels.Dataset, error) {
+	/*
+	 * We will store the files and enqueue their pipelines in a transaction
+	 * Then we will wake up the workers for the pipelines
+	 */
+	//storing the files and enqueueing their pipelines
+	datasets := []*brainModels.Dataset{}
+	err := db.InTransaction(appCtx, func(txCtx *config.AppContext) error {
+		datasets = []*brainModels.Dataset{}
+		for _, fT := range fTs {
+			d, err := fT.Store(txCtx)
+			if err != nil {
+				//error whilen storing the record
+				return err
+			}
+			fR, _ := d.UploadedDataset.(*models.FileUpload)
+			err = (*db.FileUpload)(fR).UpdateSource(txCtx, uploaded.Checksum, uploaded.Size, uploaded.SourceURL)
+			if err != nil {
+				//error while storing the checksum of the file
+				return err
+			}
+			err = jobs.Enqueue(txCtx, &db.Job{Type: models.JobTypePipeline, FileUploadID: fR.ID})
+			if err != nil {
+				//error while enqueueing the pipeline of the file
+				return err
+			}
+			fR.Location = ""
+			d.UploadedDataset = fR
+			datasets = append(datasets, d)
+		}
+		if stored == nil {
+			return nil
+		}
+		return stored(txCtx)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	//waking up the workers since the pipelines can be claimed only after the transaction is committed
+	jobs.Wake()
+	for _, d := range datasets {
+		appCtx.Log.Info("Successfully stored the uploaded file", uploaded.Location, "to db with id", d.ID)
+	}
+	return datasets, nil
 }
 
 func init() {
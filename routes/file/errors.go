@@ -0,0 +1,141 @@
+// Copyright 2019 Cuttle.ai. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package file
+
+/*
+ * This file contains the api to list the validation errors of a file upload
+ */
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/cuttle-ai/file-uploader-service/config"
+	"github.com/cuttle-ai/file-uploader-service/models"
+	"github.com/cuttle-ai/file-uploader-service/models/db"
+	"github.com/cuttle-ai/file-uploader-service/routes"
+	"github.com/cuttle-ai/file-uploader-service/routes/response"
+)
+
+//DefaultErrorsPageSize is the no. of errors returned in a page if the page size is not given
+const DefaultErrorsPageSize = 50
+
+//MaxErrorsPageSize is the max no. of errors returned in a page
+const MaxErrorsPageSize = 500
+
+//ErrorsPage is a page of the validation errors of a file upload
+type ErrorsPage struct {
+	//Errors are the errors in the page in the order of their rows
+	Errors []models.FileUploadError
+	//Page is the no. of the page starting from 1
+	Page int
+	//Size is the max no. of errors in a page
+	Size int
+	//Total is the total no. of errors matching the filter
+	Total int64
+	//Counts are the no. of errors of the file upload per error code so that the errors can be grouped
+	Counts map[string]int64
+}
+
+//queryInt returns the positive integer in the query param. The default value is returned if the query param is not given
+func queryInt(r *http.Request, name string, defaultValue int) (int, bool) {
+	str := r.URL.Query().Get(name)
+	if len(str) == 0 {
+		return defaultValue, true
+	}
+	v, err := strconv.Atoi(str)
+	if err != nil || v <= 0 {
+		return 0, false
+	}
+	return v, true
+}
+
+//GetErrors will return a page of the validation errors of a file upload with their rows, columns and codes.
+//The page can be given with the page and size query params and the errors can be filtered with the code and severity query params
+func GetErrors(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+	/*
+	 * We will get the app context
+	 * Then we will try to parse the request param id, the page and the filter
+	 * Then we will get the file upload record from the database
+	 * Then we will get the page of the errors
+	 * Then we will get the no. of errors per code
+	 */
+
+	//getting the app context
+	appCtx := ctx.Value(routes.AppContextKey).(*config.AppContext)
+	appCtx.Log.Info("Got a request to get the errors of a file upload by", appCtx.Session.User.ID)
+
+	//parse the request param id, the page and the filter
+	idStr := r.URL.Query().Get("id")
+	id, err := strconv.Atoi(idStr)
+	if err != nil {
+		//bad request
+		appCtx.Log.Error("error while parsing the file upload id", err.Error(), idStr)
+		response.WriteError(w, response.Error{Err: "Invalid Params " + idStr + " as id of the file upload"}, http.StatusBadRequest)
+		return
+	}
+	page, ok := queryInt(r, "page", 1)
+	if !ok {
+		//bad request
+		appCtx.Log.Error("invalid page", r.URL.Query().Get("page"), "for the errors of the file upload", id)
+		response.WriteError(w, response.Error{Err: "Invalid Params " + r.URL.Query().Get("page") + " as the page"}, http.StatusBadRequest)
+		return
+	}
+	size, ok := queryInt(r, "size", DefaultErrorsPageSize)
+	if !ok || size > MaxErrorsPageSize {
+		//bad request
+		appCtx.Log.Error("invalid page size", r.URL.Query().Get("size"), "for the errors of the file upload", id)
+		response.WriteError(w, response.Error{Err: "Invalid Params " + r.URL.Query().Get("size") + " as the page size. It has to be between 1 and " + strconv.Itoa(MaxErrorsPageSize)}, http.StatusBadRequest)
+		return
+	}
+	filter := db.ErrorFilter{
+		Code:     strings.ToUpper(r.URL.Query().Get("code")),
+		Severity: strings.ToUpper(r.URL.Query().Get("severity")),
+	}
+
+	//we will get the db record for the file
+	f := &db.FileUpload{}
+	f.ID = uint(id)
+	err = f.Get(appCtx)
+	if err != nil {
+		//error while getting the info
+		appCtx.Log.Error("error while getting the info for file uploaded with id", id, err.Error())
+		response.WriteError(w, response.Error{Err: "Couldn't fetch the info"}, http.StatusInternalServerError)
+		return
+	}
+
+	//getting the page of the errors
+	errs, total, err := f.GetErrors(appCtx, filter, (page-1)*size, size)
+	if err != nil {
+		//error while getting the errors
+		appCtx.Log.Error("error while getting the errors of the file upload", id, err.Error())
+		response.WriteError(w, response.Error{Err: "Couldn't fetch the errors of the file upload"}, http.StatusInternalServerError)
+		return
+	}
+
+	//getting the no. of errors per code
+	counts, err := f.CountErrorsByCode(appCtx)
+	if err != nil {
+		//error while counting the errors
+		appCtx.Log.Error("error while counting the errors of the file upload", id, err.Error())
+		response.WriteError(w, response.Error{Err: "Couldn't fetch the errors of the file upload"}, http.StatusInternalServerError)
+		return
+	}
+
+	appCtx.Log.Info("Successfully fetched page", page, "of the errors of the file upload", id)
+	response.Write(w, response.Message{Message: "Successfully fetched the errors of the file upload", Data: ErrorsPage{Errors: errs, Page: page, Size: size, Total: total, Counts: counts}})
+}
+
+func init() {
+	routes.AddRoutes(
+		routes.Route{
+			Version:     "v1",
+			Pattern:     "/file/errors",
+			HandlerFunc: GetErrors,
+		},
+	)
+}
@@ -7,65 +7,128 @@ package file
 
 import (
 	"context"
+	"errors"
 	"fmt"
-	"io"
 	"net/http"
 	"os"
+	"path/filepath"
 	"strconv"
+	"strings"
+	"time"
 
 	authConfig "github.com/cuttle-ai/auth-service/config"
 	"github.com/cuttle-ai/brain/appctx"
 	bModels "github.com/cuttle-ai/brain/models"
-	dDataset "github.com/cuttle-ai/db-toolkit/dataset"
 	"github.com/cuttle-ai/db-toolkit/datastores/services"
 	"github.com/cuttle-ai/file-uploader-service/config"
 	libfile "github.com/cuttle-ai/file-uploader-service/file"
+	"github.com/cuttle-ai/file-uploader-service/file/csv"
+	"github.com/cuttle-ai/file-uploader-service/jobs"
 	"github.com/cuttle-ai/file-uploader-service/models"
 	"github.com/cuttle-ai/file-uploader-service/models/db"
 	"github.com/cuttle-ai/file-uploader-service/notifications"
+	"github.com/cuttle-ai/file-uploader-service/pipeline"
+	"github.com/cuttle-ai/file-uploader-service/retry"
 	"github.com/cuttle-ai/file-uploader-service/routes"
 	"github.com/cuttle-ai/file-uploader-service/routes/response"
 	"github.com/cuttle-ai/go-sdk/services/datastores"
-	"github.com/cuttle-ai/go-sdk/services/octopus"
 	"github.com/cuttle-ai/octopus/interpreter"
 	"github.com/google/uuid"
 )
 
-//StartValidating will start validating a given file
-func StartValidating(a *config.AppContext, f libfile.File) (bool, error) {
+//ParseUploadOptions parses the options with which an uploaded file has to be parsed from the request query params.
+//explodeArrays query param has to be true to create a row for each element of the arrays in json records.
+//delimiter, quote, comment, header and skipLines query params are the dialect of the delimited files.
+//delimiter can also be given as tab. header has to be false if the file doesn't have a header row.
+//errorPolicy is the policy with which the rows with errors are handled, strict, skip or coerce,
+//and maxErrors and maxErrorPercent are the limits of the rows with errors that can be skipped or coerced.
+//charset is the character encoding of the text files like windows-1252 if it can't be detected from the file
+func ParseUploadOptions(r *http.Request) (models.FileUploadOptions, error) {
+	q := r.URL.Query()
+	options := models.FileUploadOptions{
+		ExplodeArrays: q.Get("explodeArrays") == "true",
+		Delimiter:     q.Get("delimiter"),
+		Quote:         q.Get("quote"),
+		Comment:       q.Get("comment"),
+		Headerless:    q.Get("header") == "false",
+		ErrorPolicy:   strings.ToUpper(q.Get("errorPolicy")),
+		Charset:       strings.ToLower(strings.TrimSpace(q.Get("charset"))),
+	}
+	if options.Delimiter == "tab" || options.Delimiter == "\\t" {
+		options.Delimiter = "\t"
+	}
+	if sL := q.Get("skipLines"); len(sL) != 0 {
+		skip, err := strconv.Atoi(sL)
+		if err != nil {
+			return options, fmt.Errorf("couldn't parse the skipLines %s: %s", sL, err.Error())
+		}
+		options.SkipLines = skip
+	}
+	if mE := q.Get("maxErrors"); len(mE) != 0 {
+		max, err := strconv.ParseInt(mE, 10, 64)
+		if err != nil {
+			return options, fmt.Errorf("couldn't parse the maxErrors %s: %s", mE, err.Error())
+		}
+		options.MaxErrors = max
+	}
+	if mP := q.Get("maxErrorPercent"); len(mP) != 0 {
+		max, err := strconv.ParseFloat(mP, 64)
+		if err != nil {
+			return options, fmt.Errorf("couldn't parse the maxErrorPercent %s: %s", mP, err.Error())
+		}
+		options.MaxErrorPercent = max
+	}
+	if err := libfile.CheckErrorPolicy(options); err != nil {
+		return options, err
+	}
+	if err := libfile.CheckEncoding(options.Charset); err != nil {
+		return options, err
+	}
+	return options, csv.CheckDialect(options)
+}
+
+//StartValidating will start validating a given file. It returns true if errors were found in the file.
+//If the errors can be tolerated as per the error policy of the upload, the rows with errors are left out or coerced and no error is returned.
+//Else the file upload is moved to the invalid status and the errors are returned
+func StartValidating(ctx context.Context, a *config.AppContext, f libfile.File) (bool, error) {
 	/*
 	 * First we will get validate the file
+	 * If the file couldn't be validated, we will update the status set by the validation in database
+	 * We will delete the existing errors and the error reports
+	 * We will check whether the errors can be tolerated as per the error policy
 	 * Then we will update the status in database
-	 * We will delete the existing errors
 	 * We will update the new errors if any
+	 * We will write the error reports
 	 */
 	//validating the file
 	a.Log.Info("Started validating the file", f.ID())
-	errs, err := f.Validate()
-	if err != nil {
-		//error while validating the file
-		a.Log.Error("error while validating the file for the file", f.ID(), err)
+	errs, err := f.Validate(ctx)
+	if err != nil && ctx.Err() != nil {
+		//the validation was cancelled. so the file is neither valid nor invalid
+		return false, ctx.Err()
 	}
 	hasValidationError := len(errs) != 0
-
-	//update the status in database
-	a.Log.Info("Started updating the status of the file", f.ID())
-	nErr := f.UpdateStatus(a)
-	if nErr != nil {
-		//error while updating the validation error status
-		a.Log.Error("error while updating the validation error status in db for the file", f.ID(), nErr)
-	}
 	if err != nil {
+		//error while validating the file
+		a.Log.Error("error while validating the file for the file", f.ID(), err)
+		if nErr := f.UpdateStatus(a); nErr != nil {
+			//error while updating the validation error status
+			a.Log.Error("error while updating the validation error status in db for the file", f.ID(), nErr)
+		}
 		return hasValidationError, err
 	}
-	if nErr != nil {
-		return hasValidationError, nErr
-	}
 
-	//deleteing the existing errors
+	//deleteing the existing errors and the error reports
 	a.Log.Info("Started deleting the existing validation error of the file", f.ID())
 	fR := db.FileUpload{}
 	fR.ID = f.ID()
+	err = fR.Get(a)
+	if err != nil {
+		//error while getting the file record
+		a.Log.Error("error while getting the file upload record for", fR.ID, err)
+		return hasValidationError, err
+	}
+	removeErrorReports(fR.Location, fR.ID)
 	err = fR.DeleteErrors(a)
 	if err != nil {
 		//error while deleting the errors in the file record
@@ -73,6 +136,29 @@ func StartValidating(a *config.AppContext, f libfile.File) (bool, error) {
 		return hasValidationError, err
 	}
 
+	//checking whether the errors can be tolerated
+	skipped, coerced, tolerated := libfile.Tolerates(f, fR.Options, errs)
+	err = fR.UpdateTolerated(a, skipped, coerced)
+	if err != nil {
+		//error while updating the no. of rows left out and coerced
+		a.Log.Error("error while updating the no. of rows left out and coerced for", fR.ID, err)
+		return hasValidationError, err
+	}
+
+	//update the status in database
+	//the files with errors which can't be tolerated are invalid
+	a.Log.Info("Started updating the status of the file", f.ID())
+	if hasValidationError && !tolerated {
+		err = fR.UpdateStatus(a, models.FileUploadStatusInvalid, fmt.Sprintf("found %d errors while validating the file", len(errs)))
+	} else {
+		err = f.UpdateStatus(a)
+	}
+	if err != nil {
+		//error while updating the validation status
+		a.Log.Error("error while updating the validation status in db for the file", f.ID(), err)
+		return hasValidationError, err
+	}
+
 	//if errors are found, we need to record it
 	a.Log.Info("Have found", len(errs), "errors while validating", f.ID())
 	if len(errs) == 0 {
@@ -84,7 +170,12 @@ func StartValidating(a *config.AppContext, f libfile.File) (bool, error) {
 	a.Log.Info("Started storing the validation errors of the file", f.ID())
 	errM := []models.FileUploadError{}
 	for _, v := range errs {
-		errM = append(errM, models.FileUploadError{FileUploadID: fR.ID, Error: v.Error()})
+		e := libfile.UploadError(fR.ID, v)
+		if !tolerated {
+			//none of the rows are loaded if the errors are not tolerated
+			e.Severity = models.FileUploadErrorSeverityError
+		}
+		errM = append(errM, e)
 	}
 	err = db.CreateErrors(a, errM)
 	if err != nil {
@@ -92,6 +183,18 @@ func StartValidating(a *config.AppContext, f libfile.File) (bool, error) {
 		a.Log.Error("error while creating the file upload errors for", fR.ID, err)
 		return hasValidationError, err
 	}
+
+	//writing the error reports
+	a.Log.Info("Started writing the error reports of the file", f.ID())
+	err = writeErrorReports(ctx, f, fR.Location, fR.ID, errM)
+	if err != nil {
+		//error while writing the reports. the errors are already recorded, so we will just log it
+		a.Log.Error("error while writing the error reports of the file", f.ID(), err)
+	}
+	if tolerated {
+		a.Log.Info("Left out", skipped, "rows and coerced", coerced, "rows with errors as per the error policy of the file", f.ID())
+		return hasValidationError, nil
+	}
 	a.Log.Info("File validation exited sucessfully for", f.ID())
 	return hasValidationError, fmt.Errorf("%+v", errs)
 }
@@ -102,7 +205,7 @@ func Validate(ctx context.Context, w http.ResponseWriter, r *http.Request) {
 	 * We will get the app context
 	 * Then we will try to parse the request param id
 	 * Then we will get the file upload record from the database
-	 * Then we will get the file corresponding to it and start the process of validating it
+	 * Then we will enqueue the job validating it
 	 */
 
 	//getting the app context
@@ -130,17 +233,15 @@ func Validate(ctx context.Context, w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	lF, err := libfile.GetFile(f.Type, *f)
+	//now we start processing it
+	err = jobs.Enqueue(appCtx, &db.Job{Type: models.JobTypeValidate, FileUploadID: f.ID})
 	if err != nil {
-		//error while getting the info
-		appCtx.Log.Error("error while getting the underlying file processor id", id, err.Error())
-		response.WriteError(w, response.Error{Err: "Couldn't fetch the info"}, http.StatusInternalServerError)
+		//error while enqueueing the job
+		appCtx.Log.Error("error while enqueueing the validation of the file", id, err.Error())
+		response.WriteError(w, response.Error{Err: "Couldn't start validating the file"}, http.StatusInternalServerError)
 		return
 	}
 
-	//now we start processing it
-	go StartValidating(appCtx, lF)
-
 	appCtx.Log.Info("Successfully started validating the file", id)
 	response.Write(w, response.Message{Message: "Successfully started validating"})
 }
@@ -152,10 +253,13 @@ func UpdateUpload(ctx context.Context, w http.ResponseWriter, r *http.Request) {
 	 * We will try to parse the id of the file
 	 * We will try to get the append flag
 	 * We will get the file model from the database
-	 * Then we will get the file payload
-	 * Then we will save the file in the same location that of the existing file, thus by replacing the original file
-	 * Then delete all the existing errors and update the existing file validation errors
-	 * Then we will start start the uploading pipeline
+	 * We will lock the dataset of the file and make sure that the file is not being processed
+	 * Then we will get the file part from the multipart request
+	 * Then we will stream the file to a new location, since the existing file can be shared by the other uploads like the sheets of a workbook
+	 * Then we will transcode the file to utf-8
+	 * Then in a transaction we will update the location, checksum, size and encoding of the file,
+	 * delete all the existing errors, update the status and start the uploading pipeline
+	 * Then we will release the existing file
 	 */
 	//getting the app context
 	appCtx := ctx.Value(routes.AppContextKey).(*config.AppContext)
@@ -189,45 +293,142 @@ func UpdateUpload(ctx context.Context, w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	//parsing the multipart form
-	//maximum we can parse 1Gb file size
-	r.ParseMultipartForm(10 << 30)
+	//locking the dataset of the file so that the file is not replaced while it is being processed
+	dSet, err := f.GetDataset(appCtx)
+	if err != nil {
+		//error while getting the dataset
+		appCtx.Log.Error("error while getting the dataset of the file upload", id, err.Error())
+		response.WriteError(w, response.Error{Err: "Couldn't fetch the info of the file upload"}, http.StatusInternalServerError)
+		return
+	}
+	lock, ok, err := db.TryLockDataset(appCtx, dSet.ID)
+	if err != nil {
+		//error while locking the dataset
+		appCtx.Log.Error("error while locking the dataset", dSet.ID, "of the file upload", id, err.Error())
+		response.WriteError(w, response.Error{Err: "Error while updating the upload status"}, http.StatusInternalServerError)
+		return
+	}
+	if !ok {
+		//the dataset is locked by a running job or another upload
+		appCtx.Log.Error("dataset", dSet.ID, "of the file upload", id, "is locked")
+		response.WriteError(w, response.Error{Err: "The file is being processed. Try again once the processing finishes"}, http.StatusConflict)
+		return
+	}
+	defer func() {
+		if uErr := lock.Unlock(); uErr != nil {
+			appCtx.Log.Error("error while unlocking the dataset", dSet.ID, "of the file upload", id, uErr.Error())
+		}
+	}()
+	active, err := db.HasActiveJob(appCtx, f.ID)
+	if err != nil {
+		//error while checking the jobs on the file upload
+		appCtx.Log.Error("error while checking the jobs on the file upload", id, err.Error())
+		response.WriteError(w, response.Error{Err: "Error while updating the upload status"}, http.StatusInternalServerError)
+		return
+	}
+	if active {
+		//a job is queued or running on the file upload
+		appCtx.Log.Error("a job is queued or running on the file upload", id)
+		response.WriteError(w, response.Error{Err: "The file is being processed. Try again once the processing finishes"}, http.StatusConflict)
+		return
+	}
+	if !models.CanTransition(f.Status, models.FileUploadStatusUploaded) {
+		//the file upload is in the middle of the processing
+		appCtx.Log.Error("file upload", id, "can't move from", f.Status, "to", models.FileUploadStatusUploaded)
+		response.WriteError(w, response.Error{Err: "The file is being processed. Try again once the processing finishes"}, http.StatusConflict)
+		return
+	}
+	if appendFlag {
+		if err := CheckAppend(appCtx, dSet.ID); err != nil {
+			appCtx.Log.Error("dataset", dSet.ID, "of the file upload", id, "can't be appended to", err.Error())
+			WriteAppendError(w, err)
+			return
+		}
+	}
 
-	//we are getting the file
-	file, _, err := r.FormFile("file")
+	//we are getting the file part
+	part, err := FilePart(w, r)
 	if err != nil {
 		appCtx.Log.Error("error retrieving the File", err)
+		if errors.Is(err, ErrUploadTooLarge) {
+			WriteUploadError(w, err)
+			return
+		}
 		response.WriteError(w, response.Error{Err: "Error while reading the uploaded file"}, http.StatusBadRequest)
 		return
 	}
-	defer file.Close()
+	defer part.Close()
 
-	//move the file
-	nF, err := os.Create(f.Location)
+	//stream the file
+	location, err := libfile.NewLocation(f.Location)
 	if err != nil {
-		appCtx.Log.Error("error while moving the file to the processing location", f.Location, err.Error())
-		response.WriteError(w, response.Error{Err: "Error while moving the uploaded file to a server location"}, http.StatusInternalServerError)
+		//error while creating the location for the file
+		appCtx.Log.Error("error while creating the location for the file replacing", f.Location, err.Error())
+		response.WriteError(w, response.Error{Err: "Error while saving the uploaded file to a server location"}, http.StatusInternalServerError)
 		return
 	}
-	defer nF.Close()
-	_, err = io.Copy(nF, file)
+	uploaded, err := StreamUpload(part, location)
 	if err != nil {
-		appCtx.Log.Error("error while moving the file to the processing location", f.Location, err.Error())
-		response.WriteError(w, response.Error{Err: "Error while moving the uploaded file to a server location"}, http.StatusInternalServerError)
+		appCtx.Log.Error("error while streaming the file to the processing location", location, err.Error())
+		os.RemoveAll(filepath.Dir(location))
+		WriteUploadError(w, err)
 		return
 	}
 
-	//delete the existing errors and update the status of upload as uploaded
-	err = f.DeleteErrorsAndUpdateStatus(appCtx)
+	//transcoding the file to utf-8 from its encoding
+	replaced := *f
+	replaced.Location = location
+	encoding, err := libfile.TranscodeUpload(replaced)
+	if err != nil {
+		//error while transcoding the file
+		appCtx.Log.Error("error while transcoding the file for", f.ID, err.Error())
+		os.RemoveAll(filepath.Dir(location))
+		response.WriteError(w, response.Error{Err: "Error while reading the uploaded file " + err.Error()}, http.StatusBadRequest)
+		return
+	}
+
+	//updating the file, its status and starting the datastore uploading pipeline
+	//the file is no more from the source url since it is replaced by the uploaded file.
+	//everything is done in a transaction so that the upload is never left pointing to the new file without its pipeline
+	existing := f.Location
+	err = db.InTransaction(appCtx, func(txCtx *config.AppContext) error {
+		if err := f.UpdateLocation(txCtx, location); err != nil {
+			return err
+		}
+		if err := f.UpdateSource(txCtx, uploaded.Checksum, uploaded.Size, ""); err != nil {
+			return err
+		}
+		if err := f.UpdateEncoding(txCtx, encoding); err != nil {
+			return err
+		}
+		if err := f.DeleteErrorsAndUpdateStatus(txCtx); err != nil {
+			return err
+		}
+		return jobs.Enqueue(txCtx, &db.Job{Type: models.JobTypePipeline, FileUploadID: f.ID, Append: appendFlag})
+	})
+	if errors.Is(err, db.ErrInvalidTransition) {
+		//the file is being processed
+		appCtx.Log.Error("file upload", f.ID, "is being processed. can't update its status to uploaded", err.Error())
+		os.RemoveAll(filepath.Dir(location))
+		response.WriteError(w, response.Error{Err: "The file is being processed. Try again once the processing finishes"}, http.StatusConflict)
+		return
+	}
 	if err != nil {
-		//error while deleting the existing errors and updatingt the status
-		appCtx.Log.Error("error deleting the file upload errors and updating the status for", f.ID, err.Error())
+		//error while updating the file and starting the pipeline
+		appCtx.Log.Error("error while updating the file and enqueueing the pipeline for", f.ID, err.Error())
+		os.RemoveAll(filepath.Dir(location))
 		response.WriteError(w, response.Error{Err: "Error while updating the upload status"}, http.StatusInternalServerError)
 		return
 	}
+	//the pipeline can be claimed only after the transaction is committed
+	jobs.Wake()
 
-	//starts the datastore uploading pipeline
-	go StartPipelineProcess(appCtx, f, appendFlag)
+	//releasing the existing file
+	err = libfile.ReleaseLocation(appCtx, existing)
+	if err != nil {
+		//error while removing the existing file. the file is already replaced, so we will just log it
+		appCtx.Log.Error("error while removing the replaced file", existing, "of", f.ID, err.Error())
+	}
 
 	appCtx.Log.Info("Sucessfully updated the file for", f.ID)
 	f.Location = ""
@@ -235,7 +436,7 @@ func UpdateUpload(ctx context.Context, w http.ResponseWriter, r *http.Request) {
 }
 
 //StartProcessingColumns will start processing the columns of a given file
-func StartProcessingColumns(a *config.AppContext, f libfile.File) error {
+func StartProcessingColumns(ctx context.Context, a *config.AppContext, f libfile.File) error {
 	/*
 	 * First we will get the dataset corresponding to the file
 	 * Then we will get all the columns associated with the file
@@ -271,7 +472,7 @@ func StartProcessingColumns(a *config.AppContext, f libfile.File) error {
 	}
 
 	//start identifying the columns
-	columns, err = f.IdentifyColumns(columns)
+	columns, err = f.IdentifyColumns(ctx, columns)
 	if err != nil {
 		//error while identifying the columns in the dataset
 		a.Log.Error("error while identifying the columns in the dataset id", dSet.ID, err)
@@ -296,6 +497,18 @@ func StartProcessingColumns(a *config.AppContext, f libfile.File) error {
 	return nil
 }
 
+//dropTable drops the table from the datastore of the service
+func dropTable(ser services.Service, table string) error {
+	dS, err := ser.Datastore()
+	if err != nil {
+		return err
+	}
+	if dS == nil {
+		return nil
+	}
+	return dS.DeleteTable(table)
+}
+
 //ProcessColumns will process the columns in an uploaded data file.
 //If the columns are available, it will validate their data type same with file.
 //Else it will identify the column and store them the database
@@ -304,8 +517,7 @@ func ProcessColumns(ctx context.Context, w http.ResponseWriter, r *http.Request)
 	 * We will get the app context
 	 * Then we will try to parse the request param id
 	 * Then we will get the file upload record from the database
-	 * Then we will get the file corresponding to it
-	 * start the process for identifying the columns in the file
+	 * Then we will enqueue the job identifying the columns in the file
 	 */
 
 	//getting the app context
@@ -333,23 +545,22 @@ func ProcessColumns(ctx context.Context, w http.ResponseWriter, r *http.Request)
 		return
 	}
 
-	lF, err := libfile.GetFile(f.Type, *f)
+	//now we start processing it
+	err = jobs.Enqueue(appCtx, &db.Job{Type: models.JobTypeProcessColumns, FileUploadID: f.ID})
 	if err != nil {
-		//error while getting the info
-		appCtx.Log.Error("error while getting the underlying file processor id", id, err.Error())
-		response.WriteError(w, response.Error{Err: "Couldn't fetch the info"}, http.StatusInternalServerError)
+		//error while enqueueing the job
+		appCtx.Log.Error("error while enqueueing the identification of the columns in the file", id, err.Error())
+		response.WriteError(w, response.Error{Err: "Couldn't start identifying the columns"}, http.StatusInternalServerError)
 		return
 	}
 
-	//now we start processing it
-	go StartProcessingColumns(appCtx, lF)
-
 	appCtx.Log.Info("Successfully started identifying the columns in the file", id)
 	response.Write(w, response.Message{Message: "Successfully started identifying the columns"})
 }
 
-//StartUploadingToDatastore will start uploading the file to data store
-func StartUploadingToDatastore(a *config.AppContext, f libfile.File, appendFlag bool) (*db.Dataset, error) {
+//StartUploadingToDatastore will start uploading the file to data store.
+//If the upload is cancelled, the table created by it is dropped from the datastore
+func StartUploadingToDatastore(ctx context.Context, a *config.AppContext, f libfile.File, appendFlag bool) (*db.Dataset, error) {
 	/*
 	 * First we will get the dataset corresponding to the file
 	 * Then we will try to get the table associated with the dataset
@@ -360,6 +571,7 @@ func StartUploadingToDatastore(a *config.AppContext, f libfile.File, appendFlag
 	 * Then we will check whether the list of columns is not zero
 	 * If table is created, we will update the PUID of the columns in database
 	 * Then we start uploading the table to the datastore
+	 * If the upload was cancelled, we will drop the table created by it
 	 * If the table is not created, then we will then update the table created flag as true
 	 */
 	//getting the dataset corresponding to the the file
@@ -383,7 +595,12 @@ func StartUploadingToDatastore(a *config.AppContext, f libfile.File, appendFlag
 	}
 
 	//we will get the list of datastore services
-	dS, err := datastores.ListDatastores(appctx.WithAccessToken(a, authConfig.MasterAppDetails.AccessToken))
+	var dS []services.Service
+	err = withRetry(ctx, a, models.JobStageUpload, "datastores.ListDatastores", func() error {
+		var err error
+		dS, err = datastores.ListDatastores(appctx.WithAccessToken(a, authConfig.MasterAppDetails.AccessToken))
+		return err
+	})
 	if err != nil {
 		//error while getting the list of datastores in the platform
 		a.Log.Error("error while getting the list of datastores for uploading the datastore", dSet.ID, err)
@@ -466,7 +683,21 @@ func StartUploadingToDatastore(a *config.AppContext, f libfile.File, appendFlag
 
 	//we start uploading the table to the datastore
 	a.Log.Info("going to upload the dataset to datastore for dataset id", dSet.ID, "with append as", appendFlag)
-	err = f.Upload(a, tableNode, appendFlag, !dSet.TableCreated, ser)
+	err = f.Upload(ctx, a, tableNode, appendFlag, !dSet.TableCreated, ser)
+	//the file is written in a single dump. So an append cancelled before the dump leaves the table as such and one done can't be undone
+	if err == nil && !dSet.TableCreated {
+		//the table created by the upload can still be rolled back
+		err = ctx.Err()
+	}
+	if errors.Is(err, context.Canceled) && !dSet.TableCreated {
+		//dropping the table created by the upload
+		a.Log.Info("upload was cancelled. dropping the table created for the dataset", dSet.ID)
+		rErr := dropTable(ser, tableNode.Name)
+		if rErr != nil {
+			//error while dropping the table
+			a.Log.Error("error while dropping the table", tableNode.Name, "of the cancelled upload", rErr)
+		}
+	}
 	if err != nil {
 		//error while uploading the table to datastore
 		a.Log.Error("error while uploading the table to datastore", err)
@@ -497,7 +728,8 @@ func UploadToDatastore(ctx context.Context, w http.ResponseWriter, r *http.Reque
 	 * Then we will try to parse the request param id
 	 * Then we will try to parse the request param to append/replace data
 	 * Then we will get the file upload record from the database
-	 * start the process for uploading it to a datastore
+	 * If the data has to be appended, we will make sure that the dataset can be appended to
+	 * Then we will enqueue the job uploading it to a datastore
 	 */
 
 	//getting the app context
@@ -531,119 +763,204 @@ func UploadToDatastore(ctx context.Context, w http.ResponseWriter, r *http.Reque
 		response.WriteError(w, response.Error{Err: "Couldn't fetch the info"}, http.StatusInternalServerError)
 		return
 	}
+	if appendFlag {
+		dSet, err := f.GetDataset(appCtx)
+		if err != nil {
+			//error while getting the dataset
+			appCtx.Log.Error("error while getting the dataset of the file upload", id, err.Error())
+			response.WriteError(w, response.Error{Err: "Couldn't fetch the info"}, http.StatusInternalServerError)
+			return
+		}
+		if err := CheckAppend(appCtx, dSet.ID); err != nil {
+			appCtx.Log.Error("dataset", dSet.ID, "of the file upload", id, "can't be appended to", err.Error())
+			WriteAppendError(w, err)
+			return
+		}
+	}
 
-	lF, err := libfile.GetFile(f.Type, *f)
+	//now we start uploading it
+	err = jobs.Enqueue(appCtx, &db.Job{Type: models.JobTypeUploadToDatastore, FileUploadID: f.ID, Append: appendFlag})
 	if err != nil {
-		//error while getting the info
-		appCtx.Log.Error("error while getting the underlying file processor id", id, err.Error())
-		response.WriteError(w, response.Error{Err: "Couldn't fetch the info"}, http.StatusInternalServerError)
+		//error while enqueueing the job
+		appCtx.Log.Error("error while enqueueing the upload of the file to the datastore", id, err.Error())
+		response.WriteError(w, response.Error{Err: "Couldn't start uploading the file to the datastore"}, http.StatusInternalServerError)
 		return
 	}
 
-	//now we start uploading it
-	go StartUploadingToDatastore(appCtx, lF, appendFlag)
-
 	appCtx.Log.Info("Successfully started uploading the file to the datastore in", id)
 	response.Write(w, response.Message{Message: "Successfully started uploading the file to the datastore"})
 }
 
-//StartPipelineProcess will start the pipeline of uploading file to data store pipeline
-func StartPipelineProcess(a *config.AppContext, fU *db.FileUpload, appendFlag bool) {
+//StartPipelineProcess will start the pipeline of uploading file to data store pipeline for the file upload of the job.
+//The stages of the pipeline are run in the order of the dataset or the file type. See the pipeline package for the details
+func StartPipelineProcess(ctx context.Context, a *config.AppContext, j *db.Job) error {
 	/*
-	 * We will get the file upload details
-	 * We will get the file
-	 * Then we will validate
-	 * Then we will start processing the columns
-	 * Then we will start uploading to data store
-	 * We will get the datastore service
-	 * optimize the metadata of the dataset
-	 * Then we will update the dict
+	 * We will get the state of the pipeline run
+	 * Then we will run the stages of the pipeline
 	 */
-	//getting the file details
-	err := fU.Get(a)
+	//getting the state of the run
+	s, err := jobState(a, j)
 	if err != nil {
-		//error while getting the info
-		a.Log.Error("error while getting the info for file uploaded with id", fU.ID, err.Error())
-		go notifications.SendErrorMessage(a, "error while processing "+fU.Name)
-		return
+		//error while getting the file upload, its file or its dataset
+		a.Log.Error("error while getting the file upload", j.FileUploadID, "to be processed", err.Error())
+		go notifications.SendErrorMessage(a, "error while processing the uploaded file")
+		return err
 	}
 
-	//getting the file
-	f, err := libfile.GetFile(fU.Type, *fU)
+	//running the stages
+	err = pipeline.Run(ctx, s)
 	if err != nil {
-		//error while getting the info
-		a.Log.Error("error while getting the underlying file processor id", fU.ID, err.Error())
-		go notifications.SendErrorMessage(a, "error while processing "+fU.Name)
+		return err
 	}
+	go notifications.SendSuccessMessage(a, s.Upload.Name+" is ready to use")
+	return nil
+}
 
-	//start validating it
-	hasValidationErrors, err := StartValidating(a, f)
-	if hasValidationErrors {
-		go notifications.SendErrorMessage(a, fU.Name+" is not formatted correctly")
-	}
-	if err != nil && !hasValidationErrors {
-		go notifications.SendErrorMessage(a, "error while validating "+fU.Name)
+//withRetry makes the call to another service retrying its transient failures as per the retry policy of the stage.
+//The failed attempts are logged and recorded against the job in the context
+func withRetry(ctx context.Context, a *config.AppContext, stage string, operation string, call func() error) error {
+	j := jobs.FromContext(ctx)
+	return retry.Do(ctx, config.GetRetryPolicy(stage), call, func(at retry.Attempt) {
+		a.Log.Warn("attempt", at.Attempt, "of", operation, "failed with transient error as", at.Transient, "retrying after", at.Backoff, at.Err)
+		if j == nil {
+			return
+		}
+		r := &db.RetryAttempt{
+			JobID:     j.ID,
+			Stage:     stage,
+			Operation: operation,
+			Attempt:   at.Attempt,
+			Error:     at.Err.Error(),
+			Transient: at.Transient,
+			Backoff:   int64(at.Backoff / time.Millisecond),
+		}
+		err := r.Create(a)
+		if err != nil {
+			//error while recording the attempt
+			a.Log.Error("error while recording the failed attempt of", operation, "by the job", j.ID, err)
+		}
+	})
+}
+
+//rows returns the no. of rows in the file if the file format counts them. Else zero is returned
+func rows(f libfile.File) int64 {
+	c, ok := f.(libfile.RowCounter)
+	if !ok {
+		return 0
 	}
-	if err != nil {
-		//error while validating the file
-		a.Log.Error("error while validating "+fU.Name, err)
-		return
+	return c.Rows()
+}
+
+//bytesWritten returns the no. of bytes written to the datastore by the last upload of the file if the file format counts them. Else zero is returned
+func bytesWritten(f libfile.File) int64 {
+	c, ok := f.(libfile.ByteCounter)
+	if !ok {
+		return 0
 	}
-	go notifications.SendInfoMessage(a, "successfully validated "+fU.Name)
+	return c.BytesWritten()
+}
 
-	//if append flag is not there, it means that we have identify the columns
-	if !appendFlag {
-		err = StartProcessingColumns(a, f)
-		if err != nil {
-			//error while processing the file
-			a.Log.Error("error while processing the uploaded file", err)
-			go notifications.SendErrorMessage(a, "error while appending the data from "+fU.Name)
-			return
+//trackStatus wraps the handler of a job on a file upload to mark the file upload as cancelled if the job stopped since it was cancelled.
+//If the job failed, the file upload is marked as failed with the error unless it was found invalid while validating.
+//The status is left as it is if the job was interrupted by the shutdown since the job is resumed later
+func trackStatus(h jobs.Handler) jobs.Handler {
+	return func(ctx context.Context, a *config.AppContext, j *db.Job) error {
+		err := h(ctx, a, j)
+		if err == nil || jobs.Interrupted(a, j, err) {
+			return err
+		}
+		fU := &db.FileUpload{}
+		fU.ID = j.FileUploadID
+		uErr := fU.Get(a)
+		if uErr != nil {
+			//error while getting the file upload
+			a.Log.Error("error while getting the file upload", fU.ID, "to update its status", uErr)
+			return err
 		}
+		if fU.Status == models.FileUploadStatusInvalid {
+			//the file was found invalid while validating. so it hasn't failed
+			return err
+		}
+		if !errors.Is(err, context.Canceled) {
+			//marking the file upload as failed
+			uErr = fU.UpdateStatus(a, models.FileUploadStatusFailed, err.Error())
+			if uErr != nil {
+				a.Log.Error("error while marking the file upload", fU.ID, "as failed", uErr)
+			}
+			return err
+		}
+		a.Log.Info("processing of the file upload", j.FileUploadID, "was cancelled")
+		uErr = fU.UpdateStatus(a, models.FileUploadStatusCancelled, "cancelled by the user")
+		if uErr != nil {
+			//error while marking the file upload as cancelled
+			a.Log.Error("error while marking the file upload", fU.ID, "as cancelled", uErr)
+			return err
+		}
+		go notifications.SendInfoMessage(a, "cancelled processing "+fU.Name)
+		return err
 	}
-	go notifications.SendInfoMessage(a, "successfully processed "+fU.Name)
+}
 
-	//start uploading the data to the data store
-	dSet, err := StartUploadingToDatastore(a, f, appendFlag)
+//jobState returns the state of the pipeline run of the job with its file upload, the file and the dataset
+func jobState(a *config.AppContext, j *db.Job) (*pipeline.State, error) {
+	fU := &db.FileUpload{}
+	fU.ID = j.FileUploadID
+	err := fU.Get(a)
 	if err != nil {
-		//error while uploading the file to data store
-		a.Log.Error("error while uploading the file to data store", err)
-		go notifications.SendErrorMessage(a, "error uploading "+fU.Name+" to secure data storage")
-		return
+		return nil, err
 	}
-	go notifications.SendInfoMessage(a, "successfully uploaded "+fU.Name+" to a secure location")
+	f, err := libfile.GetFile(fU.Type, *fU)
+	if err != nil {
+		return nil, err
+	}
+	dSet, err := fU.GetDataset(a)
+	if err != nil {
+		return nil, err
+	}
+	return &pipeline.State{App: a, Job: j, Upload: fU, File: f, Dataset: dSet, Append: j.Append}, nil
+}
 
-	//getting the datastore service
-	dSe, err := datastores.GetDatastore(appctx.WithAccessToken(a, authConfig.MasterAppDetails.AccessToken), dSet.DatastoreID)
+//validateJob is the job validating the file of the file upload
+func validateJob(ctx context.Context, a *config.AppContext, j *db.Job) error {
+	s, err := jobState(a, j)
 	if err != nil {
-		//error while getting the datastore service in which the dataset is stored
-		a.Log.Error("error while getting the datastore service in which the dataset is stored", err)
-		go notifications.SendErrorMessage(a, "couldn't optimize "+fU.Name)
-		return
+		return err
 	}
+	return pipeline.RunStage(ctx, s, models.JobStageValidate)
+}
 
-	//optimize the metadata of the datastore
-	err = dDataset.OptimizeDatasetMetadata(a.Log, a.Db, dSet.ID, *dSe, a.Session.User.ID)
+//processColumnsJob is the job identifying the columns in the file of the file upload and validating its rows against the rules of the dataset
+func processColumnsJob(ctx context.Context, a *config.AppContext, j *db.Job) error {
+	s, err := jobState(a, j)
 	if err != nil {
-		//error while optimizing the datatset metadata
-		a.Log.Error("error while optimizing the datatset metadata", err)
-		go notifications.SendErrorMessage(a, "couldn't optimize your data")
-		return
+		return err
+	}
+	err = pipeline.RunStage(ctx, s, models.JobStageIdentifyColumns)
+	if err != nil {
+		return err
+	}
+	err = pipeline.RunStage(ctx, s, models.JobStageApplyRules)
+	if err != nil {
+		return err
 	}
-	go notifications.SendInfoMessage(a, fU.Name+" optimized your data")
+	//the file is ready to be loaded to the datastore
+	return s.Upload.UpdateStatus(a, models.FileUploadStatusValidated, "")
+}
 
-	//update the user dict from octopus service memory
-	err = octopus.UpdateDict(a)
+//uploadToDatastoreJob is the job uploading the file of the file upload to the datastore
+func uploadToDatastoreJob(ctx context.Context, a *config.AppContext, j *db.Job) error {
+	s, err := jobState(a, j)
 	if err != nil {
-		//error while updating the dict from octopus
-		a.Log.Error("error while updating the dict from the octopus service for user", a.Session.User.ID, err)
-		go notifications.SendErrorMessage(a, "couldn't synchronize your data across devices")
-		return
+		return err
 	}
-	go notifications.SendSuccessMessage(a, fU.Name+" is ready to use")
+	return pipeline.RunStage(ctx, s, models.JobStageUpload)
 }
 
 func init() {
+	jobs.Register(models.JobTypePipeline, trackStatus(StartPipelineProcess))
+	jobs.Register(models.JobTypeValidate, trackStatus(validateJob))
+	jobs.Register(models.JobTypeProcessColumns, trackStatus(processColumnsJob))
+	jobs.Register(models.JobTypeUploadToDatastore, trackStatus(uploadToDatastoreJob))
 	routes.AddRoutes(
 		routes.Route{
 			Version:     "v1",
@@ -0,0 +1,148 @@
+// Copyright 2019 Cuttle.ai. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package file_test
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+
+	"github.com/cuttle-ai/file-uploader-service/config/configtest"
+	"github.com/cuttle-ai/file-uploader-service/file/csv"
+	"github.com/cuttle-ai/file-uploader-service/models"
+	"github.com/cuttle-ai/file-uploader-service/models/db"
+	"github.com/cuttle-ai/file-uploader-service/routes"
+	routesFile "github.com/cuttle-ai/file-uploader-service/routes/file"
+)
+
+/*
+ * This file contains the integration tests for validating the file uploads and replacing their files. They need the database and run only if it is enabled
+ */
+
+func TestStartValidating(t *testing.T) {
+	a := configtest.AppContext(t)
+	dir, err := ioutil.TempDir("", "validate")
+	if err != nil {
+		t.Fatal("couldn't create the temp dir", err)
+	}
+	defer os.RemoveAll(dir)
+
+	cases := []struct {
+		name      string
+		content   string
+		options   models.FileUploadOptions
+		hasErrors bool
+		fail      bool
+		status    string
+	}{
+		{"valid", "a,b\n1,2\n", models.FileUploadOptions{}, false, false, models.FileUploadStatusValidated},
+		{"invalid", "a,b\n1,2\n3\n", models.FileUploadOptions{}, true, true, models.FileUploadStatusInvalid},
+		{"tolerated", "a,b\n1,2\n3\n", models.FileUploadOptions{ErrorPolicy: models.FileUploadErrorPolicySkip}, true, false, models.FileUploadStatusValidated},
+	}
+	for i, c := range cases {
+		filename := filepath.Join(dir, c.name+".csv")
+		if err := ioutil.WriteFile(filename, []byte(c.content), 0644); err != nil {
+			t.Fatal("couldn't write the file", err)
+		}
+		fU := &models.FileUpload{Name: c.name, Location: filename, Type: models.FileUploadTypeCSV, Status: models.FileUploadStatusValidating, Options: c.options}
+		if err := a.Db.Create(fU).Error; err != nil {
+			t.Fatal("couldn't create the file upload", err)
+		}
+		defer a.Db.Unscoped().Where("file_upload_id = ?", fU.ID).Delete(&models.FileUploadStatusHistory{})
+		defer a.Db.Unscoped().Where("file_upload_id = ?", fU.ID).Delete(&models.FileUploadError{})
+		defer a.Db.Unscoped().Delete(fU)
+
+		f, _ := csv.Get(db.FileUpload(*fU))
+		hasErrors, err := routesFile.StartValidating(context.Background(), a, f)
+		if hasErrors != c.hasErrors || (err != nil) != c.fail {
+			t.Error("test case", i+1, c.name, "expected errors found as", c.hasErrors, "and failure as", c.fail, "got", hasErrors, err)
+		}
+		got := &db.FileUpload{}
+		got.ID = fU.ID
+		if err := got.Get(a); err != nil {
+			t.Error("test case", i+1, c.name, "couldn't get the file upload", err)
+			continue
+		}
+		if got.Status != c.status {
+			t.Error("test case", i+1, c.name, "expected the status", c.status, "got", got.Status)
+		}
+	}
+}
+
+func TestUpdateUploadLocked(t *testing.T) {
+	a := configtest.AppContext(t)
+	fU := &models.FileUpload{Name: "locked", Type: models.FileUploadTypeCSV, Status: models.FileUploadStatusReady}
+	if err := a.Db.Create(fU).Error; err != nil {
+		t.Fatal("couldn't create the file upload", err)
+	}
+	defer a.Db.Unscoped().Delete(fU)
+	dSet := &db.Dataset{Name: "locked", ResourceID: fU.ID}
+	if err := a.Db.Create(dSet).Error; err != nil {
+		t.Fatal("couldn't create the dataset", err)
+	}
+	defer a.Db.Unscoped().Delete(dSet)
+
+	//the dataset is locked like by a job running on it
+	lock, ok, err := db.TryLockDataset(a, dSet.ID)
+	if err != nil || !ok {
+		t.Fatal("couldn't lock the dataset", ok, err)
+	}
+	if _, ok, err := db.TryLockDataset(a, dSet.ID); err != nil || ok {
+		t.Error("expected the dataset to be locked only once. got", ok, err)
+	}
+	r := httptest.NewRequest(http.MethodPost, "/file/upload?id="+strconv.Itoa(int(fU.ID)), nil)
+	w := httptest.NewRecorder()
+	routesFile.UpdateUpload(context.WithValue(context.Background(), routes.AppContextKey, a), w, r)
+	if w.Code != http.StatusConflict {
+		t.Error("expected the update of the file of a locked dataset to be a conflict. got", w.Code, w.Body.String())
+	}
+
+	//the lock is available once released
+	if err := lock.Unlock(); err != nil {
+		t.Fatal("couldn't unlock the dataset", err)
+	}
+	lock, ok, err = db.TryLockDataset(a, dSet.ID)
+	if err != nil || !ok {
+		t.Fatal("expected the dataset to be locked again once released. got", ok, err)
+	}
+	if err := lock.Unlock(); err != nil {
+		t.Error("couldn't unlock the dataset", err)
+	}
+}
+
+func TestUpdateUploadProcessing(t *testing.T) {
+	a := configtest.AppContext(t)
+	fU := &models.FileUpload{Name: "processing", Location: "processing.csv", Type: models.FileUploadTypeCSV, Status: models.FileUploadStatusValidating}
+	if err := a.Db.Create(fU).Error; err != nil {
+		t.Fatal("couldn't create the file upload", err)
+	}
+	defer a.Db.Unscoped().Delete(fU)
+	dSet := &db.Dataset{Name: "processing", ResourceID: fU.ID}
+	if err := a.Db.Create(dSet).Error; err != nil {
+		t.Fatal("couldn't create the dataset", err)
+	}
+	defer a.Db.Unscoped().Delete(dSet)
+
+	//the file of an upload in the middle of the processing is not replaced
+	r := httptest.NewRequest(http.MethodPost, "/file/upload?id="+strconv.Itoa(int(fU.ID)), nil)
+	w := httptest.NewRecorder()
+	routesFile.UpdateUpload(context.WithValue(context.Background(), routes.AppContextKey, a), w, r)
+	if w.Code != http.StatusConflict {
+		t.Error("expected the update of the file being processed to be a conflict. got", w.Code, w.Body.String())
+	}
+	got := &db.FileUpload{}
+	got.ID = fU.ID
+	if err := got.Get(a); err != nil {
+		t.Fatal("couldn't get the file upload", err)
+	}
+	if got.Location != fU.Location || got.Status != fU.Status {
+		t.Error("expected the file upload to be left as such. got", got.Location, got.Status)
+	}
+}
@@ -0,0 +1,390 @@
+// Copyright 2019 Cuttle.ai. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package file
+
+/*
+ * This file contains the apis to report the progress of the pipeline of a file upload, to cancel it and to reprocess it from a stage
+ */
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/cuttle-ai/file-uploader-service/config"
+	"github.com/cuttle-ai/file-uploader-service/jobs"
+	"github.com/cuttle-ai/file-uploader-service/models"
+	"github.com/cuttle-ai/file-uploader-service/models/db"
+	"github.com/cuttle-ai/file-uploader-service/pipeline"
+	"github.com/cuttle-ai/file-uploader-service/routes"
+	"github.com/cuttle-ai/file-uploader-service/routes/response"
+	"github.com/jinzhu/gorm"
+)
+
+//PipelineStatus is the status of the latest pipeline run of a file upload
+type PipelineStatus struct {
+	//Job is the job which ran the pipeline. Its stage is the current stage of the pipeline and its last error is the error with which it failed
+	Job *db.Job
+	//Stages are the stages of the pipeline run so far with their start and end times, the no. of rows processed and the error if any
+	Stages []db.JobStage
+	//RetryAttempts are the failed attempts of the calls to the other services made by the pipeline
+	RetryAttempts []db.RetryAttempt
+}
+
+//GetPipelineStatus will return the status of the latest pipeline run of a file upload.
+//The file upload can be given with the id query param or the dataset created from it with the datasetId query param
+func GetPipelineStatus(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+	/*
+	 * We will get the app context
+	 * Then we will try to parse the request param id or the dataset id
+	 * Then we will get the latest job of the file upload
+	 * Then we will get the stages of the job
+	 * Then we will get the failed attempts of the calls made by the job
+	 */
+
+	//getting the app context
+	appCtx := ctx.Value(routes.AppContextKey).(*config.AppContext)
+	appCtx.Log.Info("Got a request to get the pipeline status by", appCtx.Session.User.ID)
+
+	//parse the request param id or the dataset id
+	var fileUploadID uint
+	if dIDStr := r.URL.Query().Get("datasetId"); len(dIDStr) != 0 {
+		dID, err := strconv.Atoi(dIDStr)
+		if err != nil {
+			//bad request
+			appCtx.Log.Error("error while parsing the dataset id", err.Error(), dIDStr)
+			response.WriteError(w, response.Error{Err: "Invalid Params " + dIDStr + " as id of the dataset"}, http.StatusBadRequest)
+			return
+		}
+		d := &db.Dataset{}
+		d.ID = uint(dID)
+		d.UserID = appCtx.Session.User.ID
+		err = d.Get(appCtx, true)
+		if err != nil {
+			//error while getting the info
+			appCtx.Log.Error("error while getting the info for datatset with id", dID, err.Error())
+			response.WriteError(w, response.Error{Err: "Couldn't fetch the info of the dataset"}, http.StatusInternalServerError)
+			return
+		}
+		fileUploadID = d.ResourceID
+	} else {
+		idStr := r.URL.Query().Get("id")
+		id, err := strconv.Atoi(idStr)
+		if err != nil {
+			//bad request
+			appCtx.Log.Error("error while parsing the file upload id", err.Error(), idStr)
+			response.WriteError(w, response.Error{Err: "Invalid Params " + idStr + " as id of the file upload"}, http.StatusBadRequest)
+			return
+		}
+		fileUploadID = uint(id)
+	}
+
+	//getting the latest job of the file upload
+	j, err := db.GetLatestJob(appCtx, fileUploadID)
+	if gorm.IsRecordNotFoundError(err) {
+		//the pipeline has not been run yet
+		appCtx.Log.Error("couldn't find a pipeline run for the file upload", fileUploadID)
+		response.WriteError(w, response.Error{Err: "Pipeline has not been run for the file upload"}, http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		//error while getting the job
+		appCtx.Log.Error("error while getting the latest job of the file upload", fileUploadID, err.Error())
+		response.WriteError(w, response.Error{Err: "Couldn't fetch the pipeline status"}, http.StatusInternalServerError)
+		return
+	}
+
+	//getting the stages of the job
+	stages, err := j.GetStages(appCtx)
+	if err != nil {
+		//error while getting the stages
+		appCtx.Log.Error("error while getting the stages of the job", j.ID, err.Error())
+		response.WriteError(w, response.Error{Err: "Couldn't fetch the pipeline status"}, http.StatusInternalServerError)
+		return
+	}
+
+	//getting the failed attempts of the calls
+	attempts, err := j.GetRetryAttempts(appCtx)
+	if err != nil {
+		//error while getting the attempts
+		appCtx.Log.Error("error while getting the retry attempts of the job", j.ID, err.Error())
+		response.WriteError(w, response.Error{Err: "Couldn't fetch the pipeline status"}, http.StatusInternalServerError)
+		return
+	}
+
+	appCtx.Log.Info("Successfully fetched the pipeline status of file upload", fileUploadID)
+	response.Write(w, response.Message{Message: "Successfully fetched the pipeline status", Data: PipelineStatus{Job: j, Stages: stages, RetryAttempts: attempts}})
+}
+
+//CancelPipeline will cancel the pipeline running for a file upload. A queued pipeline is cancelled right away.
+//A running pipeline is cancelled by the worker running it and the file upload is marked as cancelled once it stops
+func CancelPipeline(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+	/*
+	 * We will get the app context
+	 * Then we will try to parse the request param id
+	 * Then we will get the file upload record from the database
+	 * Then we will get the latest job of the file upload
+	 * Then we will cancel the job
+	 * If the job was cancelled right away, we will mark the file upload as cancelled
+	 */
+
+	//getting the app context
+	appCtx := ctx.Value(routes.AppContextKey).(*config.AppContext)
+	appCtx.Log.Info("Got a request to cancel the pipeline of a file upload by", appCtx.Session.User.ID)
+
+	//parse the request param id
+	idStr := r.URL.Query().Get("id")
+	id, err := strconv.Atoi(idStr)
+	if err != nil {
+		//bad request
+		appCtx.Log.Error("error while parsing the file upload id", err.Error(), idStr)
+		response.WriteError(w, response.Error{Err: "Invalid Params " + idStr + " as id of the file upload"}, http.StatusBadRequest)
+		return
+	}
+
+	//we will get the db record for the file
+	f := &db.FileUpload{}
+	f.ID = uint(id)
+	err = f.Get(appCtx)
+	if err != nil {
+		//error while getting the info
+		appCtx.Log.Error("error while getting the info for file uploaded with id", id, err.Error())
+		response.WriteError(w, response.Error{Err: "Couldn't fetch the info"}, http.StatusInternalServerError)
+		return
+	}
+
+	//getting the latest job of the file upload
+	j, err := db.GetLatestJob(appCtx, f.ID)
+	if gorm.IsRecordNotFoundError(err) {
+		//the pipeline has not been run yet
+		appCtx.Log.Error("couldn't find a pipeline run for the file upload", id)
+		response.WriteError(w, response.Error{Err: "Pipeline has not been run for the file upload"}, http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		//error while getting the job
+		appCtx.Log.Error("error while getting the latest job of the file upload", id, err.Error())
+		response.WriteError(w, response.Error{Err: "Couldn't cancel the pipeline"}, http.StatusInternalServerError)
+		return
+	}
+
+	//cancelling the job
+	cancelled, err := jobs.Cancel(appCtx, j)
+	if errors.Is(err, jobs.ErrNotCancellable) {
+		//the pipeline has already finished
+		appCtx.Log.Error("pipeline of the file upload", id, "has already finished with status", j.Status)
+		response.WriteError(w, response.Error{Err: "Pipeline of the file upload has already finished"}, http.StatusConflict)
+		return
+	}
+	if err != nil {
+		//error while cancelling the job
+		appCtx.Log.Error("error while cancelling the job", j.ID, "of the file upload", id, err.Error())
+		response.WriteError(w, response.Error{Err: "Couldn't cancel the pipeline"}, http.StatusInternalServerError)
+		return
+	}
+	if !cancelled {
+		appCtx.Log.Info("Successfully requested the cancellation of the pipeline of the file upload", id)
+		response.Write(w, response.Message{Message: "Successfully requested the cancellation of the pipeline"})
+		return
+	}
+
+	//marking the file upload as cancelled
+	//a file upload whose data is in the datastore keeps its status since the data is still usable
+	err = f.UpdateStatus(appCtx, models.FileUploadStatusCancelled, "cancelled by the user")
+	if err != nil && !errors.Is(err, db.ErrInvalidTransition) {
+		//error while updating the status
+		appCtx.Log.Error("error while marking the file upload", id, "as cancelled", err.Error())
+		response.WriteError(w, response.Error{Err: "Couldn't update the status of the file upload"}, http.StatusInternalServerError)
+		return
+	}
+
+	appCtx.Log.Info("Successfully cancelled the pipeline of the file upload", id)
+	response.Write(w, response.Message{Message: "Successfully cancelled the pipeline"})
+}
+
+//ReprocessPipeline will run the pipeline of a file upload again from a stage. The stage can be given with the stage query param.
+//Else the pipeline is run from the stage at which its last run stopped. The stages before it are skipped. So all of them should have succeeded in the last run.
+//The dataset of the file upload is locked till the pipeline is enqueued, so that it isn't enqueued along with another job on the file upload
+func ReprocessPipeline(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+	/*
+	 * We will get the app context
+	 * Then we will try to parse the request param id
+	 * Then we will get the file upload record from the database
+	 * Then we will lock the dataset of the file upload and make sure that no job is queued or running on it
+	 * Then we will get the last run of the pipeline and its checkpoints
+	 * Then we will find the stage to start from
+	 * Then we will enqueue the pipeline from the stage
+	 */
+
+	//getting the app context
+	appCtx := ctx.Value(routes.AppContextKey).(*config.AppContext)
+	appCtx.Log.Info("Got a request to reprocess the pipeline of a file upload by", appCtx.Session.User.ID)
+
+	//parse the request param id
+	idStr := r.URL.Query().Get("id")
+	id, err := strconv.Atoi(idStr)
+	if err != nil {
+		//bad request
+		appCtx.Log.Error("error while parsing the file upload id", err.Error(), idStr)
+		response.WriteError(w, response.Error{Err: "Invalid Params " + idStr + " as id of the file upload"}, http.StatusBadRequest)
+		return
+	}
+
+	//we will get the db record for the file
+	f := &db.FileUpload{}
+	f.ID = uint(id)
+	err = f.Get(appCtx)
+	if err != nil {
+		//error while getting the info
+		appCtx.Log.Error("error while getting the info for file uploaded with id", id, err.Error())
+		response.WriteError(w, response.Error{Err: "Couldn't fetch the info"}, http.StatusInternalServerError)
+		return
+	}
+
+	//locking the dataset of the file upload
+	//the lock is held till the pipeline is enqueued so that no other job or upload gets in between the check and the enqueue
+	d, err := f.GetDataset(appCtx)
+	if err != nil {
+		//error while getting the dataset
+		appCtx.Log.Error("error while getting the dataset of the file upload", id, err.Error())
+		response.WriteError(w, response.Error{Err: "Couldn't reprocess the pipeline"}, http.StatusInternalServerError)
+		return
+	}
+	lock, ok, err := db.TryLockDataset(appCtx, d.ID)
+	if err != nil {
+		//error while locking the dataset
+		appCtx.Log.Error("error while locking the dataset", d.ID, "of the file upload", id, err.Error())
+		response.WriteError(w, response.Error{Err: "Couldn't reprocess the pipeline"}, http.StatusInternalServerError)
+		return
+	}
+	if !ok {
+		//the dataset is locked by a running job or another upload
+		appCtx.Log.Error("dataset", d.ID, "of the file upload", id, "is locked")
+		response.WriteError(w, response.Error{Err: "File upload is being processed already"}, http.StatusConflict)
+		return
+	}
+	defer func() {
+		if uErr := lock.Unlock(); uErr != nil {
+			appCtx.Log.Error("error while unlocking the dataset", d.ID, "of the file upload", id, uErr.Error())
+		}
+	}()
+	active, err := db.HasActiveJob(appCtx, f.ID)
+	if err != nil {
+		//error while checking the jobs on the file upload
+		appCtx.Log.Error("error while checking the jobs on the file upload", id, err.Error())
+		response.WriteError(w, response.Error{Err: "Couldn't reprocess the pipeline"}, http.StatusInternalServerError)
+		return
+	}
+	if active {
+		//a job is queued or running on the file upload
+		appCtx.Log.Error("a job is queued or running on the file upload", id)
+		response.WriteError(w, response.Error{Err: "File upload is being processed already"}, http.StatusConflict)
+		return
+	}
+
+	//getting the last run of the pipeline and its checkpoints
+	j, err := db.GetLatestJobOfType(appCtx, f.ID, models.JobTypePipeline)
+	if gorm.IsRecordNotFoundError(err) {
+		//the pipeline has not been run yet
+		appCtx.Log.Error("couldn't find a pipeline run for the file upload", id)
+		response.WriteError(w, response.Error{Err: "Pipeline has not been run for the file upload"}, http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		//error while getting the job
+		appCtx.Log.Error("error while getting the latest pipeline job of the file upload", id, err.Error())
+		response.WriteError(w, response.Error{Err: "Couldn't reprocess the pipeline"}, http.StatusInternalServerError)
+		return
+	}
+	stages, err := j.GetStages(appCtx)
+	if err != nil {
+		//error while getting the stages
+		appCtx.Log.Error("error while getting the stages of the job", j.ID, err.Error())
+		response.WriteError(w, response.Error{Err: "Couldn't reprocess the pipeline"}, http.StatusInternalServerError)
+		return
+	}
+	checkpoints := map[string]bool{}
+	for _, v := range stages {
+		checkpoints[v.Stage] = v.Status == models.JobStatusSucceeded || v.Status == models.JobStatusSkipped
+	}
+
+	//finding the stage to start from
+	order, err := pipeline.Plan(&pipeline.State{App: appCtx, Upload: f, Dataset: d, Append: j.Append})
+	if err != nil {
+		//error while getting the stages of the pipeline
+		appCtx.Log.Error("error while getting the stages of the pipeline of the file upload", id, err.Error())
+		response.WriteError(w, response.Error{Err: "Couldn't reprocess the pipeline"}, http.StatusInternalServerError)
+		return
+	}
+	from := strings.ToUpper(r.URL.Query().Get("stage"))
+	if len(from) == 0 {
+		for _, v := range order {
+			if !checkpoints[v] {
+				from = v
+				break
+			}
+		}
+	}
+	if len(from) == 0 {
+		//all the stages have succeeded
+		appCtx.Log.Error("all the stages of the pipeline of the file upload", id, "have succeeded")
+		response.WriteError(w, response.Error{Err: "All the stages of the pipeline have succeeded. Give the stage to reprocess from"}, http.StatusBadRequest)
+		return
+	}
+	found := false
+	for _, v := range order {
+		if v == from {
+			found = true
+			break
+		}
+		if !checkpoints[v] {
+			//bad request
+			appCtx.Log.Error("stage", v, "before", from, "has not succeeded for the file upload", id)
+			response.WriteError(w, response.Error{Err: "Stage " + v + " has to succeed before reprocessing from " + from}, http.StatusBadRequest)
+			return
+		}
+	}
+	if !found {
+		//bad request
+		appCtx.Log.Error("invalid stage", from, "to reprocess the pipeline of the file upload", id)
+		response.WriteError(w, response.Error{Err: "Invalid Params " + from + " as the stage. It has to be one of " + strings.Join(order, ", ")}, http.StatusBadRequest)
+		return
+	}
+
+	//enqueueing the pipeline from the stage
+	nJ := &db.Job{Type: models.JobTypePipeline, FileUploadID: f.ID, Append: j.Append, FromStage: from}
+	err = jobs.Enqueue(appCtx, nJ)
+	if err != nil {
+		//error while enqueueing the job
+		appCtx.Log.Error("error while enqueueing the pipeline of the file upload", id, "from", from, err.Error())
+		response.WriteError(w, response.Error{Err: "Couldn't reprocess the pipeline"}, http.StatusInternalServerError)
+		return
+	}
+
+	appCtx.Log.Info("Successfully started reprocessing the pipeline of the file upload", id, "from", from)
+	response.Write(w, response.Message{Message: "Successfully started reprocessing the pipeline from " + from, Data: nJ})
+}
+
+func init() {
+	routes.AddRoutes(
+		routes.Route{
+			Version:     "v1",
+			Pattern:     "/file/pipeline",
+			HandlerFunc: GetPipelineStatus,
+		},
+		routes.Route{
+			Version:     "v1",
+			Pattern:     "/file/cancel",
+			HandlerFunc: CancelPipeline,
+		},
+		routes.Route{
+			Version:     "v1",
+			Pattern:     "/file/reprocess",
+			HandlerFunc: ReprocessPipeline,
+		},
+	)
+}
@@ -0,0 +1,266 @@
+// Copyright 2019 Cuttle.ai. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package file
+
+/*
+ * This file contains the utilities to write the error report and the rejected rows of a file upload during its validation and the apis to download them
+ */
+
+import (
+	"context"
+	"encoding/csv"
+	"mime"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/cuttle-ai/file-uploader-service/config"
+	libfile "github.com/cuttle-ai/file-uploader-service/file"
+	"github.com/cuttle-ai/file-uploader-service/models"
+	"github.com/cuttle-ai/file-uploader-service/models/db"
+	"github.com/cuttle-ai/file-uploader-service/routes"
+	"github.com/cuttle-ai/file-uploader-service/routes/response"
+)
+
+//errorReportColumns are the columns added to the rows of the file in the error report
+var errorReportColumns = []string{"error_row", "error_line", "error_column", "error_code", "error_severity", "error"}
+
+//errorReportSuffix is the suffix of the error reports of the file uploads
+const errorReportSuffix = ".errors.csv"
+
+//rejectedRowsSuffix is the suffix of the rejected rows of the file uploads
+const rejectedRowsSuffix = ".rejected.csv"
+
+//reportFilename returns the location of the report of the file upload with the given suffix.
+//It has the id of the file upload since the datasets created from the sheets of a workbook share its location
+func reportFilename(location string, id uint, suffix string) string {
+	return location + "." + strconv.FormatUint(uint64(id), 10) + suffix
+}
+
+//errorReportFilename returns the location of the error report of the file upload
+func errorReportFilename(location string, id uint) string {
+	return reportFilename(location, id, errorReportSuffix)
+}
+
+//rejectedRowsFilename returns the location of the rejected rows of the file upload
+func rejectedRowsFilename(location string, id uint) string {
+	return reportFilename(location, id, rejectedRowsSuffix)
+}
+
+//removeErrorReports removes the error report and the rejected rows of the file upload if any
+func removeErrorReports(location string, id uint) {
+	os.Remove(errorReportFilename(location, id))
+	os.Remove(rejectedRowsFilename(location, id))
+}
+
+//errorReportRecord returns the row of the error report for the error with the row of the file.
+//The row is padded to the no. of columns in the header so that the error columns are aligned
+func errorReportRecord(record []string, columns int, e models.FileUploadError) []string {
+	result := make([]string, columns, columns+len(errorReportColumns))
+	copy(result, record)
+	column := e.ColumnName
+	if len(column) == 0 && e.Column != 0 {
+		column = strconv.Itoa(e.Column)
+	}
+	return append(result, strconv.FormatInt(e.Row, 10), strconv.FormatInt(e.Line, 10), column, e.Code, e.Severity, e.Error)
+}
+
+//writeErrorReports writes the error report and the rejected rows of the file upload with the given errors.
+//The error report has a row for each error with the row of the file in which it occurred followed by the error columns.
+//The rejected rows file has the header and the rows which were not loaded due to the errors as they are in the file, so that they can be fixed and appended to the dataset.
+//The rows coerced as per the error policy have only warnings and are not rejected.
+//The rows of the file are available only for the file formats implementing file.RowReader. For the rest, only the error columns are reported
+func writeErrorReports(ctx context.Context, f libfile.File, location string, id uint, errs []models.FileUploadError) (err error) {
+	/*
+	 * We will group the errors by their rows
+	 * Then we will create the report files
+	 * Then we will go through the rows of the file writing the rows having errors
+	 * Then we will write the errors whose rows couldn't be found
+	 */
+	//grouping the errors by their rows
+	rowErrs := map[int64][]models.FileUploadError{}
+	for _, v := range errs {
+		rowErrs[v.Row] = append(rowErrs[v.Row], v)
+	}
+
+	//creating the report files
+	report, err := os.Create(errorReportFilename(location, id))
+	if err != nil {
+		return err
+	}
+	rejected, err := os.Create(rejectedRowsFilename(location, id))
+	if err != nil {
+		report.Close()
+		os.Remove(errorReportFilename(location, id))
+		return err
+	}
+	defer func() {
+		report.Close()
+		rejected.Close()
+		if err != nil {
+			removeErrorReports(location, id)
+		}
+	}()
+	rw := csv.NewWriter(report)
+	jw := csv.NewWriter(rejected)
+
+	//writing the rows having errors
+	columns := 0
+	reader, ok := f.(libfile.RowReader)
+	if ok {
+		err = reader.ReadRows(ctx, func(row int64, record []string) error {
+			if row == 0 {
+				//the header of the file
+				columns = len(record)
+				if err := rw.Write(append(append([]string{}, record...), errorReportColumns...)); err != nil {
+					return err
+				}
+				if err := jw.Write(record); err != nil {
+					return err
+				}
+			}
+			es, ok := rowErrs[row]
+			if !ok {
+				return nil
+			}
+			delete(rowErrs, row)
+			if row == 0 {
+				//errors not in a data row are reported without the row
+				record = nil
+			}
+			reject := false
+			for _, e := range es {
+				if err := rw.Write(errorReportRecord(record, columns, e)); err != nil {
+					return err
+				}
+				reject = reject || e.Severity != models.FileUploadErrorSeverityWarning
+			}
+			if row == 0 || !reject {
+				return nil
+			}
+			return jw.Write(record)
+		})
+		if err != nil {
+			return err
+		}
+	} else if err := rw.Write(errorReportColumns); err != nil {
+		return err
+	}
+
+	//writing the errors whose rows couldn't be found
+	for _, v := range errs {
+		if _, ok := rowErrs[v.Row]; !ok {
+			continue
+		}
+		if err := rw.Write(errorReportRecord(nil, columns, v)); err != nil {
+			return err
+		}
+	}
+	rw.Flush()
+	jw.Flush()
+	if err := rw.Error(); err != nil {
+		return err
+	}
+	return jw.Error()
+}
+
+//downloadReport writes the report of the file upload given with the id query param with the given suffix as an attachment
+func downloadReport(ctx context.Context, w http.ResponseWriter, r *http.Request, name string, suffix string) {
+	/*
+	 * We will get the app context
+	 * Then we will try to parse the request param id
+	 * Then we will get the file upload record from the database
+	 * Then we will write the report
+	 */
+
+	//getting the app context
+	appCtx := ctx.Value(routes.AppContextKey).(*config.AppContext)
+	appCtx.Log.Info("Got a request to download the", name, "of a file upload by", appCtx.Session.User.ID)
+
+	//parse the request param id
+	idStr := r.URL.Query().Get("id")
+	id, err := strconv.Atoi(idStr)
+	if err != nil {
+		//bad request
+		appCtx.Log.Error("error while parsing the file upload id", err.Error(), idStr)
+		response.WriteError(w, response.Error{Err: "Invalid Params " + idStr + " as id of the file upload"}, http.StatusBadRequest)
+		return
+	}
+
+	//we will get the db record for the file
+	f := &db.FileUpload{}
+	f.ID = uint(id)
+	err = f.Get(appCtx)
+	if err != nil {
+		//error while getting the info
+		appCtx.Log.Error("error while getting the info for file uploaded with id", id, err.Error())
+		response.WriteError(w, response.Error{Err: "Couldn't fetch the info"}, http.StatusInternalServerError)
+		return
+	}
+
+	//writing the report
+	location := reportFilename(f.Location, f.ID, suffix)
+	rf, err := os.Open(location)
+	if os.IsNotExist(err) {
+		//the file upload doesn't have errors
+		appCtx.Log.Error("couldn't find the", name, "of the file upload", id)
+		response.WriteError(w, response.Error{Err: "File upload doesn't have the " + name}, http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		//error while opening the report
+		appCtx.Log.Error("error while opening the", name, "of the file upload", id, err.Error())
+		response.WriteError(w, response.Error{Err: "Couldn't fetch the " + name}, http.StatusInternalServerError)
+		return
+	}
+	defer rf.Close()
+	info, err := rf.Stat()
+	if err != nil {
+		//error while reading the report
+		appCtx.Log.Error("error while reading the", name, "of the file upload", id, err.Error())
+		response.WriteError(w, response.Error{Err: "Couldn't fetch the " + name}, http.StatusInternalServerError)
+		return
+	}
+	attachment := strings.TrimSuffix(f.Name, filepath.Ext(f.Name)) + suffix
+	w.Header().Set("Content-Type", "text/csv")
+	//the name of the file is quoted and escaped so that the names with quotes or non ascii characters don't break the header
+	disposition := mime.FormatMediaType("attachment", map[string]string{"filename": attachment})
+	if len(disposition) == 0 {
+		disposition = "attachment"
+	}
+	w.Header().Set("Content-Disposition", disposition)
+	appCtx.Log.Info("Sending the", name, "of the file upload", id)
+	http.ServeContent(w, r, attachment, info.ModTime(), rf)
+}
+
+//DownloadErrorReport will download the error report of a file upload. It has the rows of the file having errors with the error columns
+func DownloadErrorReport(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+	downloadReport(ctx, w, r, "error report", errorReportSuffix)
+}
+
+//DownloadRejectedRows will download the rows of a file upload rejected while validating it.
+//The rows can be fixed and appended to the dataset with the append flag in the upload api
+func DownloadRejectedRows(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+	downloadReport(ctx, w, r, "rejected rows", rejectedRowsSuffix)
+}
+
+func init() {
+	routes.AddRoutes(
+		routes.Route{
+			Version:     "v1",
+			Pattern:     "/file/errors/report",
+			HandlerFunc: DownloadErrorReport,
+			Stream:      true,
+		},
+		routes.Route{
+			Version:     "v1",
+			Pattern:     "/file/errors/rejected",
+			HandlerFunc: DownloadRejectedRows,
+			Stream:      true,
+		},
+	)
+}
@@ -0,0 +1,168 @@
+// Copyright 2019 Cuttle.ai. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package file
+
+/*
+ * This file contains the utilities to validate the rows of a file against the rules of its dataset
+ */
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/cuttle-ai/file-uploader-service/config"
+	libfile "github.com/cuttle-ai/file-uploader-service/file"
+	"github.com/cuttle-ai/file-uploader-service/models"
+	"github.com/cuttle-ai/file-uploader-service/models/db"
+	"github.com/cuttle-ai/file-uploader-service/routes/response"
+	"github.com/cuttle-ai/file-uploader-service/rules"
+)
+
+//CheckAppend returns rules.ErrUniqueOnAppend if the dataset has the unique rules, since they can't be checked against the data already in the dataset
+func CheckAppend(a *config.AppContext, datasetID uint) error {
+	ok, err := db.HasDatasetRuleOfType(a, datasetID, models.DatasetRuleTypeUnique)
+	if err != nil {
+		return err
+	}
+	if ok {
+		return rules.ErrUniqueOnAppend
+	}
+	return nil
+}
+
+//WriteAppendError writes the error response for the errors returned by CheckAppend
+func WriteAppendError(w http.ResponseWriter, err error) {
+	if errors.Is(err, rules.ErrUniqueOnAppend) {
+		response.WriteError(w, response.Error{Err: err.Error()}, http.StatusConflict)
+		return
+	}
+	response.WriteError(w, response.Error{Err: "Couldn't check whether the dataset can be appended to"}, http.StatusInternalServerError)
+}
+
+//StartApplyingRules will validate the rows of the file against the rules of the dataset and record the violations as the errors of the file upload.
+//The rows violating the rules are left out or coerced as per the error policy of the upload. It returns true if any of the rules were violated.
+//Error is returned if the violations have the error severity and can't be tolerated, in which case the file upload is marked as invalid
+func StartApplyingRules(ctx context.Context, a *config.AppContext, f libfile.File, fU *db.FileUpload, datasetID uint) (bool, error) {
+	/*
+	 * We will get the rules of the dataset
+	 * Then we will validate the rows of the file against the rules
+	 * Then we will replace the existing violations with the new ones
+	 * Then we will leave out or coerce the rows violating the rules as per the error policy
+	 * Then we will record the violations and write the error reports
+	 * If the violations can't be tolerated, we will mark the file upload as invalid
+	 */
+	//getting the rules
+	a.Log.Info("Started applying the rules of the dataset", datasetID, "on the file", fU.ID)
+	rs, err := db.GetDatasetRules(a, datasetID)
+	if err != nil {
+		//error while getting the rules
+		a.Log.Error("error while getting the rules of the dataset", datasetID, err)
+		return false, err
+	}
+
+	//validating the rows
+	errs := []error{}
+	if len(rs) != 0 {
+		reader, ok := f.(libfile.RowReader)
+		if !ok {
+			return false, fmt.Errorf("rules can't be applied on the files of type %s", fU.Type)
+		}
+		errs, err = rules.Validate(ctx, reader, rs)
+		if err != nil {
+			//error while validating the rows
+			a.Log.Error("error while applying the rules of the dataset", datasetID, "on the file", fU.ID, err)
+			return false, err
+		}
+	}
+
+	//replacing the existing violations
+	err = fU.DeleteErrorsWithCodes(a, rules.Codes)
+	if err != nil {
+		//error while deleting the existing violations
+		a.Log.Error("error while deleting the existing rule violations of the file upload", fU.ID, err)
+		return false, err
+	}
+	a.Log.Info("Have found", len(errs), "rule violations in the file", fU.ID)
+	if len(errs) == 0 {
+		//no violations so no need to go further
+		return false, nil
+	}
+
+	//leaving out or coercing the rows violating the rules
+	errM := []models.FileUploadError{}
+	violations := []error{}
+	for _, v := range errs {
+		e := libfile.UploadError(fU.ID, v)
+		if e.Severity == models.FileUploadErrorSeverityError {
+			violations = append(violations, v)
+		}
+		errM = append(errM, e)
+	}
+	tolerated, coerced, err := tolerateViolations(ctx, a, f, fU, violations)
+	if err != nil {
+		//error while leaving out or coercing the rows
+		a.Log.Error("error while leaving out the rows violating the rules in the file", fU.ID, err)
+		return true, err
+	}
+
+	//recording the violations
+	if tolerated && coerced {
+		//the coerced values are loaded as null. so the violations are recorded as warnings
+		for i := range errM {
+			errM[i].Severity = models.FileUploadErrorSeverityWarning
+		}
+	}
+	err = db.CreateErrors(a, errM)
+	if err != nil {
+		//error while creating the error records
+		a.Log.Error("error while creating the rule violations of the file upload", fU.ID, err)
+		return true, err
+	}
+
+	//writing the error reports with all the errors of the file upload
+	all, err := fU.ListErrors(a)
+	if err == nil {
+		err = writeErrorReports(ctx, f, fU.Location, fU.ID, all)
+	}
+	if err != nil {
+		//error while writing the reports. the violations are already recorded, so we will just log it
+		a.Log.Error("error while writing the error reports of the file", fU.ID, err)
+	}
+	if tolerated {
+		return true, nil
+	}
+
+	//marking the file upload as invalid
+	msg := fmt.Sprintf("found %d violations of the rules of the dataset in the file", len(violations))
+	err = fU.UpdateStatus(a, models.FileUploadStatusInvalid, msg)
+	if err != nil {
+		//error while updating the status. the violations still fail the pipeline
+		a.Log.Error("error while marking the file upload", fU.ID, "violating the rules as invalid", err)
+	}
+	return true, errors.New(msg)
+}
+
+//tolerateViolations leaves out or coerces the rows violating the rules as per the error policy if the file can do so, and updates the
+//no. of rows left out and coerced. It returns true if the violations can be tolerated within the limits of the policy and whether the rows were coerced
+func tolerateViolations(ctx context.Context, a *config.AppContext, f libfile.File, fU *db.FileUpload, violations []error) (bool, bool, error) {
+	if len(violations) == 0 {
+		return true, false, nil
+	}
+	r, ok := f.(libfile.Rejecter)
+	if !ok || libfile.ErrorPolicy(fU.Options) == models.FileUploadErrorPolicyStrict {
+		return false, false, nil
+	}
+	coerced, err := r.Reject(ctx, violations)
+	if err != nil {
+		return false, false, err
+	}
+	skipped, coercedRows, tolerated := libfile.Tolerates(f, fU.Options, violations)
+	if !tolerated {
+		return false, false, nil
+	}
+	return true, coerced, fU.UpdateTolerated(a, skipped, coercedRows)
+}
@@ -0,0 +1,236 @@
+// Copyright 2019 Cuttle.ai. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package file
+
+/*
+ * This file contains the built-in stages of the pipeline processing an uploaded file
+ */
+
+import (
+	"context"
+	"fmt"
+
+	authConfig "github.com/cuttle-ai/auth-service/config"
+	"github.com/cuttle-ai/brain/appctx"
+	dDataset "github.com/cuttle-ai/db-toolkit/dataset"
+	"github.com/cuttle-ai/db-toolkit/datastores/services"
+	"github.com/cuttle-ai/file-uploader-service/models"
+	"github.com/cuttle-ai/file-uploader-service/notifications"
+	"github.com/cuttle-ai/file-uploader-service/pipeline"
+	"github.com/cuttle-ai/go-sdk/services/datastores"
+	"github.com/cuttle-ai/go-sdk/services/octopus"
+)
+
+//validateStage validates the file and records the validation errors
+func validateStage(ctx context.Context, s *pipeline.State) error {
+	a := s.App
+	hasValidationErrors, err := StartValidating(ctx, a, s.File)
+	s.Metrics.Rows = rows(s.File)
+	if err != nil && ctx.Err() != nil {
+		//the pipeline was cancelled
+		return err
+	}
+	if hasValidationErrors && err == nil {
+		//the rows with the validation errors were left out or coerced as per the error policy
+		if gErr := s.Upload.Get(a); gErr != nil {
+			a.Log.Error("error while getting the no. of rows left out of the file upload", s.Upload.ID, gErr)
+		}
+		s.Metrics.RowsRejected = s.Upload.RowsSkipped
+		go notifications.SendInfoMessage(a, fmt.Sprintf("validated %s leaving out %d and coercing %d rows with errors", s.Upload.Name, s.Upload.RowsSkipped, s.Upload.RowsCoerced))
+		return nil
+	}
+	if hasValidationErrors {
+		//the rows with the validation errors are rejected
+		n, cErr := s.Upload.CountErrors(a)
+		if cErr != nil {
+			a.Log.Error("error while counting the validation errors of the file upload", s.Upload.ID, cErr)
+		}
+		s.Metrics.RowsRejected = n
+		go notifications.SendErrorMessage(a, s.Upload.Name+" is not formatted correctly")
+	}
+	if err != nil && !hasValidationErrors {
+		go notifications.SendErrorMessage(a, "error while validating "+s.Upload.Name)
+	}
+	if err != nil {
+		//error while validating the file
+		a.Log.Error("error while validating "+s.Upload.Name, err)
+		return err
+	}
+	go notifications.SendInfoMessage(a, "successfully validated "+s.Upload.Name)
+	return nil
+}
+
+//identifyColumnsStage identifies the columns of the dataset from the file
+func identifyColumnsStage(ctx context.Context, s *pipeline.State) error {
+	a := s.App
+	err := StartProcessingColumns(ctx, a, s.File)
+	s.Metrics.Rows = rows(s.File)
+	if err != nil && ctx.Err() != nil {
+		//the pipeline was cancelled
+		return err
+	}
+	if err != nil {
+		//error while processing the file
+		a.Log.Error("error while processing the uploaded file", err)
+		go notifications.SendErrorMessage(a, "error while appending the data from "+s.Upload.Name)
+		return err
+	}
+	go notifications.SendInfoMessage(a, "successfully processed "+s.Upload.Name)
+	return nil
+}
+
+//applyRulesStage validates the rows of the file against the rules of the dataset and records the violations.
+//An append fails if the dataset has the unique rules, like the appends queued before the rules were attached
+func applyRulesStage(ctx context.Context, s *pipeline.State) error {
+	a := s.App
+	if s.Append {
+		if err := CheckAppend(a, s.Dataset.ID); err != nil {
+			a.Log.Error("couldn't append "+s.Upload.Name+" to the dataset", s.Dataset.ID, err)
+			go notifications.SendErrorMessage(a, "couldn't append "+s.Upload.Name+". "+err.Error())
+			return err
+		}
+	}
+	hasViolations, err := StartApplyingRules(ctx, a, s.File, s.Upload, s.Dataset.ID)
+	if err != nil && ctx.Err() != nil {
+		//the pipeline was cancelled
+		return err
+	}
+	if hasViolations {
+		n, cErr := s.Upload.CountErrors(a)
+		if cErr != nil {
+			a.Log.Error("error while counting the errors of the file upload", s.Upload.ID, cErr)
+		}
+		s.Metrics.RowsRejected = n
+	}
+	if err != nil && hasViolations {
+		//the rows of the file violate the rules
+		a.Log.Error("rows of "+s.Upload.Name+" violate the rules of the dataset", err)
+		go notifications.SendErrorMessage(a, s.Upload.Name+" doesn't follow the rules of the dataset")
+		return err
+	}
+	if err != nil {
+		//error while applying the rules
+		a.Log.Error("error while applying the rules of the dataset on "+s.Upload.Name, err)
+		go notifications.SendErrorMessage(a, "error while applying the rules of the dataset on "+s.Upload.Name)
+		return err
+	}
+	return nil
+}
+
+//uploadStage uploads the data of the file to the datastore
+func uploadStage(ctx context.Context, s *pipeline.State) error {
+	a := s.App
+	dSet, err := StartUploadingToDatastore(ctx, a, s.File, s.Append)
+	if err == nil {
+		s.Dataset = dSet
+		s.Metrics.DatastoreID = dSet.DatastoreID
+		err = s.Upload.UpdateStatus(a, models.FileUploadStatusLoaded, "")
+	}
+	s.Metrics.Rows = rows(s.File)
+	s.Metrics.BytesWritten = bytesWritten(s.File)
+	if err != nil && ctx.Err() != nil {
+		//the pipeline was cancelled
+		return err
+	}
+	if err != nil {
+		//error while uploading the file to data store
+		a.Log.Error("error while uploading the file to data store", err)
+		go notifications.SendErrorMessage(a, "error uploading "+s.Upload.Name+" to secure data storage")
+		return err
+	}
+	go notifications.SendInfoMessage(a, "successfully uploaded "+s.Upload.Name+" to a secure location")
+	return nil
+}
+
+//optimizeStage optimizes the metadata of the dataset in the datastore
+func optimizeStage(ctx context.Context, s *pipeline.State) error {
+	/*
+	 * We will get the datastore service
+	 * Then we will optimize the metadata of the dataset
+	 */
+	//getting the datastore service
+	a := s.App
+	s.Metrics.DatastoreID = s.Dataset.DatastoreID
+	var dSe *services.Service
+	err := withRetry(ctx, a, models.JobStageOptimize, "datastores.GetDatastore", func() error {
+		var err error
+		dSe, err = datastores.GetDatastore(appctx.WithAccessToken(a, authConfig.MasterAppDetails.AccessToken), s.Dataset.DatastoreID)
+		return err
+	})
+	if err != nil {
+		//error while getting the datastore service in which the dataset is stored
+		a.Log.Error("error while getting the datastore service in which the dataset is stored", err)
+		go notifications.SendErrorMessage(a, "couldn't optimize "+s.Upload.Name)
+		return err
+	}
+
+	//optimize the metadata of the datastore
+	err = withRetry(ctx, a, models.JobStageOptimize, "dataset.OptimizeDatasetMetadata", func() error {
+		return dDataset.OptimizeDatasetMetadata(a.Log, a.Db, s.Dataset.ID, *dSe, a.Session.User.ID)
+	})
+	if err != nil {
+		//error while optimizing the datatset metadata
+		a.Log.Error("error while optimizing the datatset metadata", err)
+		go notifications.SendErrorMessage(a, "couldn't optimize your data")
+		return err
+	}
+	go notifications.SendInfoMessage(a, s.Upload.Name+" optimized your data")
+	return nil
+}
+
+//dictUpdateStage updates the dict of the user in the octopus service
+func dictUpdateStage(ctx context.Context, s *pipeline.State) error {
+	a := s.App
+	err := withRetry(ctx, a, models.JobStageDictUpdate, "octopus.UpdateDict", func() error {
+		return octopus.UpdateDict(a)
+	})
+	if err != nil {
+		//error while updating the dict from octopus
+		a.Log.Error("error while updating the dict from the octopus service for user", a.Session.User.ID, err)
+		go notifications.SendErrorMessage(a, "couldn't synchronize your data across devices")
+		return err
+	}
+	return nil
+}
+
+func init() {
+	pipeline.Register(pipeline.Stage{
+		Name:   models.JobStageValidate,
+		Status: models.FileUploadStatusValidating,
+		Run:    validateStage,
+	})
+	pipeline.Register(pipeline.Stage{
+		Name:   models.JobStageIdentifyColumns,
+		Status: models.FileUploadStatusIdentifyingColumns,
+		//the columns are identified only while creating the dataset
+		Applies: func(s *pipeline.State) bool { return !s.Append },
+		Run:     identifyColumnsStage,
+	})
+	pipeline.Register(pipeline.Stage{
+		Name:   models.JobStageApplyRules,
+		Status: models.FileUploadStatusValidating,
+		Run:    applyRulesStage,
+	})
+	pipeline.Register(pipeline.Stage{
+		Name:    models.JobStageUpload,
+		Status:  models.FileUploadStatusLoading,
+		Commits: true,
+		Run:     uploadStage,
+	})
+	pipeline.Register(pipeline.Stage{
+		Name:   models.JobStageOptimize,
+		Status: models.FileUploadStatusOptimizing,
+		Run:    optimizeStage,
+	})
+	pipeline.Register(pipeline.Stage{
+		Name:   models.JobStageDictUpdate,
+		Status: models.FileUploadStatusOptimizing,
+		Run:    dictUpdateStage,
+	})
+	//the rules are applied on the identified columns and the data is optimized after it is uploaded
+	pipeline.Require(models.JobStageValidate, models.JobStageIdentifyColumns, models.JobStageApplyRules, models.JobStageUpload)
+	pipeline.RequireAfter(models.JobStageUpload, models.JobStageOptimize, models.JobStageDictUpdate)
+	pipeline.RequireAfter(models.JobStageOptimize, models.JobStageDictUpdate)
+}
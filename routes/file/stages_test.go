@@ -0,0 +1,48 @@
+// Copyright 2019 Cuttle.ai. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package file_test
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/cuttle-ai/file-uploader-service/models"
+	"github.com/cuttle-ai/file-uploader-service/pipeline"
+)
+
+/*
+ * This file contains the tests for the order of the built-in stages of the pipeline
+ */
+
+func TestBuiltInStagesOrder(t *testing.T) {
+	//the built-in stages are registered by the routes/file package and the default order has to satisfy their requirements
+	stages, err := pipeline.Order(nil, models.FileUploadTypeCSV, 0)
+	if err != nil {
+		t.Fatal("couldn't get the default order of the stages", err)
+	}
+	if err := pipeline.Check(stages); err != nil {
+		t.Error("expected the default order", stages, "to be valid. got", err)
+	}
+
+	cases := []struct {
+		name   string
+		stages []string
+		err    error
+	}{
+		{"without the optional stages", []string{models.JobStageValidate, models.JobStageIdentifyColumns, models.JobStageApplyRules, models.JobStageUpload}, nil},
+		{"without validation", []string{models.JobStageIdentifyColumns, models.JobStageApplyRules, models.JobStageUpload}, pipeline.ErrMissingStage},
+		{"without upload", []string{models.JobStageValidate, models.JobStageIdentifyColumns, models.JobStageApplyRules, models.JobStageOptimize}, pipeline.ErrMissingStage},
+		{"rules before the columns", []string{models.JobStageValidate, models.JobStageApplyRules, models.JobStageIdentifyColumns, models.JobStageUpload}, pipeline.ErrStageOrder},
+		{"upload before validation", []string{models.JobStageUpload, models.JobStageValidate, models.JobStageIdentifyColumns, models.JobStageApplyRules}, pipeline.ErrStageOrder},
+		{"optimize before upload", []string{models.JobStageValidate, models.JobStageIdentifyColumns, models.JobStageApplyRules, models.JobStageOptimize, models.JobStageUpload}, pipeline.ErrStageOrder},
+		{"dict update before optimize", []string{models.JobStageValidate, models.JobStageIdentifyColumns, models.JobStageApplyRules, models.JobStageUpload, models.JobStageDictUpdate, models.JobStageOptimize}, pipeline.ErrStageOrder},
+	}
+	for i, c := range cases {
+		err := pipeline.Check(c.stages)
+		if (err == nil) != (c.err == nil) || (c.err != nil && !errors.Is(err, c.err)) {
+			t.Error("test case", i+1, c.name, "expected the error", c.err, "got", err)
+		}
+	}
+}
@@ -0,0 +1,163 @@
+// Copyright 2019 Cuttle.ai. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package file
+
+/*
+ * This file contains the utilities to stream the uploaded files from multipart requests
+ * without buffering the whole file in memory or temporary files
+ */
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"os"
+
+	"github.com/cuttle-ai/file-uploader-service/config"
+	"github.com/cuttle-ai/file-uploader-service/routes/response"
+)
+
+//FileField is the name of the field in the multipart request with the uploaded file
+const FileField = "file"
+
+//MultipartOverhead is the no. of bytes allowed in the request body over the max upload size for the other fields and boundaries in the multipart request
+const MultipartOverhead = 1 << 20
+
+//ErrUploadTooLarge is returned when the uploaded file is larger than the max upload size
+var ErrUploadTooLarge = errors.New("uploaded file is larger than the allowed size")
+
+//UploadedFile has the info about a file streamed from the request or imported from a url
+type UploadedFile struct {
+	//Location is the location to which the file is streamed
+	Location string
+	//Checksum is the hex encoded sha256 checksum of the file
+	Checksum string
+	//Size is the size of the file in bytes
+	Size int64
+	//SourceURL is the url from which the file was imported. Empty if the file was uploaded
+	SourceURL string
+}
+
+//FilePart returns the part having the uploaded file in the multipart request.
+//ErrUploadTooLarge is returned if the content length of the request is already larger than the max upload size
+func FilePart(w http.ResponseWriter, r *http.Request) (*multipart.Part, error) {
+	/*
+	 * We will check the content length of the request
+	 * Then we will limit the request body
+	 * Then we will get the multipart reader
+	 * Then we will skip the parts till we find the file
+	 */
+	//checking the content length
+	if config.MaxUploadSize > 0 && r.ContentLength > config.MaxUploadSize+MultipartOverhead {
+		return nil, ErrUploadTooLarge
+	}
+
+	//limiting the request body
+	if config.MaxUploadSize > 0 {
+		r.Body = http.MaxBytesReader(w, r.Body, config.MaxUploadSize+MultipartOverhead)
+	}
+
+	//getting the multipart reader
+	mr, err := r.MultipartReader()
+	if err != nil {
+		return nil, err
+	}
+
+	//finding the file
+	for {
+		p, err := mr.NextPart()
+		if err == io.EOF {
+			return nil, fmt.Errorf("couldn't find the %s field in the request", FileField)
+		}
+		if err != nil {
+			return nil, err
+		}
+		if p.FormName() == FileField && len(p.FileName()) != 0 {
+			return p, nil
+		}
+		p.Close()
+	}
+}
+
+//StreamUpload streams the uploaded file to the given location computing its checksum and size.
+//The file is written to a temporary file next to the location and moved to the location only if the whole file
+//could be streamed. So an existing file at the location is retained if the streaming fails.
+//ErrUploadTooLarge is returned as soon as the file exceeds the max upload size
+func StreamUpload(src io.Reader, location string) (UploadedFile, error) {
+	/*
+	 * We will create the temporary file
+	 * Then we will stream the file computing the checksum
+	 * Then we will check the size
+	 * Then we will move the file to the location
+	 */
+	//creating the temporary file
+	result := UploadedFile{Location: location}
+	tmp := location + ".part"
+	f, err := os.Create(tmp)
+	if err != nil {
+		return result, err
+	}
+
+	//streaming the file
+	h := sha256.New()
+	if config.MaxUploadSize > 0 {
+		src = io.LimitReader(src, config.MaxUploadSize+1)
+	}
+	n, err := io.Copy(io.MultiWriter(f, h), src)
+	cErr := f.Close()
+	if err == nil {
+		err = cErr
+	}
+
+	//checking the size
+	if err == nil && config.MaxUploadSize > 0 && n > config.MaxUploadSize {
+		err = ErrUploadTooLarge
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return result, err
+	}
+
+	//moving the file
+	err = os.Rename(tmp, location)
+	if err != nil {
+		os.Remove(tmp)
+		return result, err
+	}
+	result.Checksum = hex.EncodeToString(h.Sum(nil))
+	result.Size = n
+	return result, nil
+}
+
+//Checksum computes the hex encoded sha256 checksum and the size of the file at the given location
+func Checksum(location string) (UploadedFile, error) {
+	result := UploadedFile{Location: location}
+	f, err := os.Open(location)
+	if err != nil {
+		return result, err
+	}
+	defer f.Close()
+	h := sha256.New()
+	n, err := io.Copy(h, f)
+	if err != nil {
+		return result, err
+	}
+	result.Checksum = hex.EncodeToString(h.Sum(nil))
+	result.Size = n
+	return result, nil
+}
+
+//WriteUploadError writes the error response for the errors occurred while streaming the uploaded file
+func WriteUploadError(w http.ResponseWriter, err error) {
+	if errors.Is(err, ErrUploadTooLarge) {
+		response.WriteError(w, response.Error{Err: fmt.Sprintf("Uploaded file is larger than the allowed size of %d bytes", config.MaxUploadSize)}, http.StatusRequestEntityTooLarge)
+		return
+	}
+	response.WriteError(w, response.Error{Err: "Error while saving the uploaded file to a server location"}, http.StatusInternalServerError)
+}
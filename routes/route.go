@@ -35,6 +35,9 @@ type Route struct {
 	HandlerFunc HandlerFunc
 	//ParseForm will do a form parse before invoking the handler
 	ParseForm bool
+	//Stream will register the route without the response timeout so that large files can be streamed to or from the client.
+	//Else the response is buffered in memory and cut short once the timeout exceeds
+	Stream bool
 }
 
 //AppContextKey is the key with which the application is saved in the request context
@@ -43,13 +46,18 @@ const AppContextKey = "app-context"
 //Register registers the route with the default http handler func
 func (r Route) Register(s *http.ServeMux) {
 	/*
+	 * Will wrap the route with the response timeout unless it is streamed
 	 * If the route version is default version then will register it without version string to http handler
 	 * Will register the router with the http handler
 	 */
+	var h http.Handler = r
+	if !r.Stream {
+		h = http.TimeoutHandler(r, config.ResponseTimeout, "timeout")
+	}
 	if r.Version == version.Default.API {
-		s.Handle(r.Pattern, http.TimeoutHandler(r, config.ResponseTimeout, "timeout"))
+		s.Handle(r.Pattern, h)
 	}
-	s.Handle("/"+r.Version+r.Pattern, http.TimeoutHandler(r, config.ResponseTimeout, "timeout"))
+	s.Handle("/"+r.Version+r.Pattern, h)
 }
 
 //ServeHTTP implements HandlerFunc of http package. It makes use of the context of request
@@ -0,0 +1,223 @@
+// Copyright 2019 Cuttle.ai. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+//Package rules has the utilities to validate the rows of a file against the business rules of its dataset
+//like the required columns, the columns which can't be null, the ranges, the patterns and the allowed values of the columns
+//and the columns which have to be unique
+package rules
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/cuttle-ai/file-uploader-service/file"
+	"github.com/cuttle-ai/file-uploader-service/models"
+)
+
+//MaxViolations is the max no. of violations reported for a file. The validation stops once they are found
+const MaxViolations = 10000
+
+//Codes are the codes of the errors reported for the violations of the rules
+var Codes = []string{
+	models.FileUploadErrorCodeMissingColumn,
+	models.FileUploadErrorCodeNullValue,
+	models.FileUploadErrorCodeOutOfRange,
+	models.FileUploadErrorCodePatternMismatch,
+	models.FileUploadErrorCodeInvalidValue,
+	models.FileUploadErrorCodeDuplicateValue,
+}
+
+//ErrUniqueOnAppend is returned when a dataset with the unique rules is appended to or the unique rules are attached to a dataset which is appended to.
+//The uniqueness is checked only within a file and not against the data already loaded into the dataset, so an append could repeat the values
+var ErrUniqueOnAppend = errors.New("UNIQUE rules are checked only within a file and not against the data already loaded into the dataset. So a dataset having them can't be appended to")
+
+//errMaxViolations stops reading the rows of the file once MaxViolations are found
+var errMaxViolations = errors.New("max violations found")
+
+//Check returns error if the rule is not valid like a range rule without limits or a pattern which is not a valid regular expression
+func Check(r models.DatasetRule) error {
+	if len(strings.TrimSpace(r.Column)) == 0 {
+		return errors.New("column of the rule is empty")
+	}
+	switch r.Severity {
+	case "", models.FileUploadErrorSeverityError, models.FileUploadErrorSeverityWarning:
+	default:
+		return fmt.Errorf("unsupported severity %s of the rule on the column %s", r.Severity, r.Column)
+	}
+	switch r.Type {
+	case models.DatasetRuleTypeRequired, models.DatasetRuleTypeNotNull, models.DatasetRuleTypeUnique:
+		return nil
+	case models.DatasetRuleTypeRange:
+		if r.Min == nil && r.Max == nil {
+			return fmt.Errorf("range rule on the column %s has to have a min or max", r.Column)
+		}
+		if r.Min != nil && r.Max != nil && *r.Min > *r.Max {
+			return fmt.Errorf("min %v of the range rule on the column %s is greater than the max %v", *r.Min, r.Column, *r.Max)
+		}
+		return nil
+	case models.DatasetRuleTypePattern:
+		if len(r.Pattern) == 0 {
+			return fmt.Errorf("pattern rule on the column %s has to have a pattern", r.Column)
+		}
+		if _, err := compile(r.Pattern); err != nil {
+			return fmt.Errorf("pattern of the rule on the column %s is invalid: %s", r.Column, err.Error())
+		}
+		return nil
+	case models.DatasetRuleTypeEnum:
+		if len(r.Values) == 0 {
+			return fmt.Errorf("enum rule on the column %s has to have the allowed values", r.Column)
+		}
+		return nil
+	}
+	return fmt.Errorf("unsupported type %s of the rule on the column %s", r.Type, r.Column)
+}
+
+//compile compiles the pattern so that the whole value has to match it
+func compile(pattern string) (*regexp.Regexp, error) {
+	return regexp.Compile("^(?:" + pattern + ")$")
+}
+
+//rule is a rule compiled for validating the rows
+type rule struct {
+	models.DatasetRule
+	//column is the index of the column of the rule in the header. -1 if the column is not there
+	column int
+	//pattern is the compiled pattern of the pattern rules
+	pattern *regexp.Regexp
+	//values are the allowed values of the enum rules
+	values map[string]bool
+	//seen has the rows in which the values were first seen for the unique rules
+	seen map[string]int64
+}
+
+//violation returns the validation error of a violation of the rule in the given row
+func (r rule) violation(code string, row int64, value string, format string, a ...interface{}) file.ValidationError {
+	v := file.RowError(code, row, format, a...)
+	v.Column = r.column + 1
+	v.ColumnName = r.Column
+	v.Value = value
+	if len(r.Severity) != 0 {
+		v.Severity = r.Severity
+	}
+	return v
+}
+
+//validate returns the violation of the rule by the value in the given row if any
+func (r rule) validate(row int64, value string) (file.ValidationError, bool) {
+	if len(strings.TrimSpace(value)) == 0 {
+		if r.Type == models.DatasetRuleTypeNotNull {
+			return r.violation(models.FileUploadErrorCodeNullValue, row, value, "row %d has no value in the column %s which can't be null", row, r.Column), true
+		}
+		return file.ValidationError{}, false
+	}
+	switch r.Type {
+	case models.DatasetRuleTypeRange:
+		n, err := strconv.ParseFloat(strings.TrimSpace(value), 64)
+		if err != nil {
+			return r.violation(models.FileUploadErrorCodeOutOfRange, row, value, "row %d has %s in the column %s which is not a number", row, file.Snippet(value), r.Column), true
+		}
+		if r.Min != nil && n < *r.Min {
+			return r.violation(models.FileUploadErrorCodeOutOfRange, row, value, "row %d has %s in the column %s which is less than %v", row, file.Snippet(value), r.Column, *r.Min), true
+		}
+		if r.Max != nil && n > *r.Max {
+			return r.violation(models.FileUploadErrorCodeOutOfRange, row, value, "row %d has %s in the column %s which is greater than %v", row, file.Snippet(value), r.Column, *r.Max), true
+		}
+	case models.DatasetRuleTypePattern:
+		if !r.pattern.MatchString(value) {
+			return r.violation(models.FileUploadErrorCodePatternMismatch, row, value, "row %d has %s in the column %s which doesn't match the pattern %s", row, file.Snippet(value), r.Column, r.Pattern), true
+		}
+	case models.DatasetRuleTypeEnum:
+		if !r.values[value] {
+			return r.violation(models.FileUploadErrorCodeInvalidValue, row, value, "row %d has %s in the column %s which is not one of %s", row, file.Snippet(value), r.Column, strings.Join(r.Values, ", ")), true
+		}
+	case models.DatasetRuleTypeUnique:
+		if first, ok := r.seen[value]; ok {
+			return r.violation(models.FileUploadErrorCodeDuplicateValue, row, value, "row %d has %s in the column %s which is already there in row %d", row, file.Snippet(value), r.Column, first), true
+		}
+		r.seen[value] = row
+	}
+	return file.ValidationError{}, false
+}
+
+//Validate validates the rows of the file against the rules and returns the violations as validation errors.
+//The missing columns are reported only for the required rules and the other rules on them are ignored.
+//The uniqueness of the values is checked within the file, so the datasets with the unique rules can't be appended to. At most MaxViolations are returned
+func Validate(ctx context.Context, f file.RowReader, rules []models.DatasetRule) ([]error, error) {
+	/*
+	 * We will compile the rules
+	 * Then we will find the columns of the rules in the header
+	 * Then we will validate the values of the rows
+	 */
+	//compiling the rules
+	compiled := []rule{}
+	for _, v := range rules {
+		if err := Check(v); err != nil {
+			return nil, err
+		}
+		r := rule{DatasetRule: v, column: -1}
+		if v.Type == models.DatasetRuleTypePattern {
+			r.pattern, _ = compile(v.Pattern)
+		}
+		if v.Type == models.DatasetRuleTypeEnum {
+			r.values = map[string]bool{}
+			for _, value := range v.Values {
+				r.values[value] = true
+			}
+		}
+		if v.Type == models.DatasetRuleTypeUnique {
+			r.seen = map[string]int64{}
+		}
+		compiled = append(compiled, r)
+	}
+
+	result := []error{}
+	err := f.ReadRows(ctx, func(row int64, record []string) error {
+		//finding the columns in the header
+		if row == 0 {
+			for i := range compiled {
+				compiled[i].column = -1
+				for j, c := range record {
+					if strings.TrimSpace(c) == strings.TrimSpace(compiled[i].Column) {
+						compiled[i].column = j
+						break
+					}
+				}
+				if compiled[i].column == -1 && compiled[i].Type == models.DatasetRuleTypeRequired {
+					v := compiled[i].violation(models.FileUploadErrorCodeMissingColumn, 0, "", "column %s is not there in the file", compiled[i].Column)
+					v.Column = 0
+					result = append(result, v)
+				}
+			}
+			return nil
+		}
+
+		//validating the values
+		for _, r := range compiled {
+			if r.column == -1 || r.Type == models.DatasetRuleTypeRequired {
+				continue
+			}
+			value := ""
+			if r.column < len(record) {
+				value = record[r.column]
+			}
+			v, ok := r.validate(row, value)
+			if !ok {
+				continue
+			}
+			result = append(result, v)
+			if len(result) >= MaxViolations {
+				return errMaxViolations
+			}
+		}
+		return nil
+	})
+	if err != nil && err != errMaxViolations {
+		return nil, err
+	}
+	return result, nil
+}
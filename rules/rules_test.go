@@ -0,0 +1,101 @@
+// Copyright 2019 Cuttle.ai. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package rules_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/cuttle-ai/file-uploader-service/file"
+	"github.com/cuttle-ai/file-uploader-service/models"
+	"github.com/cuttle-ai/file-uploader-service/rules"
+)
+
+/*
+ * This file contains the tests for validating the rows against the rules of a dataset
+ */
+
+//rows is a file with the given rows. The first row is the header
+type rows [][]string
+
+//ReadRows invokes the function with the rows
+func (r rows) ReadRows(ctx context.Context, fn func(row int64, record []string) error) error {
+	for i, v := range r {
+		if err := fn(int64(i), v); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func TestValidate(t *testing.T) {
+	f := rows{
+		{"id", "age", "email", "status"},
+		{"1", "30", "a@b.com", "active"},
+		{"2", "", "c@d", "deleted"},
+		{"2", "150", "e@f.com", "active"},
+		{"3", "ten", "", "inactive"},
+	}
+	min, max := 0.0, 120.0
+	cases := []struct {
+		name  string
+		rule  models.DatasetRule
+		codes map[int64]string
+	}{
+		{"required", models.DatasetRule{Column: "country", Type: models.DatasetRuleTypeRequired}, map[int64]string{0: models.FileUploadErrorCodeMissingColumn}},
+		{"required present", models.DatasetRule{Column: "id", Type: models.DatasetRuleTypeRequired}, map[int64]string{}},
+		{"not null", models.DatasetRule{Column: "age", Type: models.DatasetRuleTypeNotNull}, map[int64]string{2: models.FileUploadErrorCodeNullValue}},
+		{"range", models.DatasetRule{Column: "age", Type: models.DatasetRuleTypeRange, Min: &min, Max: &max}, map[int64]string{3: models.FileUploadErrorCodeOutOfRange, 4: models.FileUploadErrorCodeOutOfRange}},
+		{"pattern", models.DatasetRule{Column: "email", Type: models.DatasetRuleTypePattern, Pattern: `[^@]+@[^@]+\.[a-z]+`}, map[int64]string{2: models.FileUploadErrorCodePatternMismatch}},
+		{"enum", models.DatasetRule{Column: "status", Type: models.DatasetRuleTypeEnum, Values: []string{"active", "inactive"}}, map[int64]string{2: models.FileUploadErrorCodeInvalidValue}},
+		{"unique", models.DatasetRule{Column: "id", Type: models.DatasetRuleTypeUnique}, map[int64]string{3: models.FileUploadErrorCodeDuplicateValue}},
+		{"missing column", models.DatasetRule{Column: "country", Type: models.DatasetRuleTypeNotNull}, map[int64]string{}},
+	}
+	for i, c := range cases {
+		errs, err := rules.Validate(context.Background(), f, []models.DatasetRule{c.rule})
+		if err != nil {
+			t.Error("test case", i+1, c.name, "expected no error. got", err)
+			continue
+		}
+		if len(errs) != len(c.codes) {
+			t.Error("test case", i+1, c.name, "expected", len(c.codes), "violations. got", errs)
+			continue
+		}
+		for _, e := range errs {
+			var v file.ValidationError
+			if !errors.As(e, &v) {
+				t.Error("test case", i+1, c.name, "expected a validation error. got", e)
+				continue
+			}
+			if c.codes[v.Row] != v.Code || v.ColumnName != c.rule.Column {
+				t.Errorf("test case %d %s expected the violation %s in row %d. got %+v", i+1, c.name, c.codes[v.Row], v.Row, v)
+			}
+		}
+	}
+}
+
+func TestCheck(t *testing.T) {
+	min, max := 10.0, 1.0
+	cases := []struct {
+		name  string
+		rule  models.DatasetRule
+		valid bool
+	}{
+		{"valid", models.DatasetRule{Column: "id", Type: models.DatasetRuleTypeUnique}, true},
+		{"no column", models.DatasetRule{Type: models.DatasetRuleTypeUnique}, false},
+		{"unknown type", models.DatasetRule{Column: "id", Type: "FOREIGN_KEY"}, false},
+		{"range without limits", models.DatasetRule{Column: "age", Type: models.DatasetRuleTypeRange}, false},
+		{"range with min beyond max", models.DatasetRule{Column: "age", Type: models.DatasetRuleTypeRange, Min: &min, Max: &max}, false},
+		{"invalid pattern", models.DatasetRule{Column: "email", Type: models.DatasetRuleTypePattern, Pattern: "("}, false},
+		{"enum without values", models.DatasetRule{Column: "status", Type: models.DatasetRuleTypeEnum}, false},
+		{"unknown severity", models.DatasetRule{Column: "id", Type: models.DatasetRuleTypeNotNull, Severity: "FATAL"}, false},
+	}
+	for i, c := range cases {
+		if err := rules.Check(c.rule); (err == nil) != c.valid {
+			t.Error("test case", i+1, c.name, "expected valid", c.valid, "got", err)
+		}
+	}
+}
@@ -0,0 +1,362 @@
+// Copyright 2019 Cuttle.ai. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+//Package scheduler refreshes the datasets imported from remote urls as per their cron schedules.
+//The schedules are persisted in the database. So they survive the restarts of the service
+package scheduler
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	authConfig "github.com/cuttle-ai/auth-service/config"
+	"github.com/cuttle-ai/file-uploader-service/config"
+	libfile "github.com/cuttle-ai/file-uploader-service/file"
+	"github.com/cuttle-ai/file-uploader-service/file/archive"
+	"github.com/cuttle-ai/file-uploader-service/file/remote"
+	"github.com/cuttle-ai/file-uploader-service/jobs"
+	"github.com/cuttle-ai/file-uploader-service/models"
+	"github.com/cuttle-ai/file-uploader-service/models/db"
+	"github.com/robfig/cron/v3"
+)
+
+//ErrArchive is returned when the refreshed remote file is an archive. Only the files of a supported format can be refreshed
+var ErrArchive = errors.New("remote file is an archive. Only the files of a supported format can be refreshed")
+
+//ErrProcessing is returned when the file upload is being processed while refreshing it. The refresh is skipped since the file can't be replaced
+var ErrProcessing = errors.New("file upload is being processed. So the refresh is skipped")
+
+var (
+	stopLock = &sync.Mutex{}
+	//stopped indicates that the scheduler has been stopped
+	stopped = false
+	//stop is closed when the scheduler is stopped
+	stop = make(chan struct{})
+	//runs tracks the refreshes running in the background
+	runs = &sync.WaitGroup{}
+	//refreshCtx is the parent context of the downloads of the refreshes. It is cancelled when the refreshes don't finish while shutting down
+	refreshCtx, cancelRefreshes = context.WithCancel(context.Background())
+)
+
+//Next returns the next time after the given time as per the cron expression. The cron expression has the standard 5 fields
+func Next(expr string, t time.Time) (time.Time, error) {
+	s, err := cron.ParseStandard(expr)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return s.Next(t), nil
+}
+
+//Start starts the scheduler. It checks for the due schedules every refresh check interval and runs them in the background.
+//It returns once the scheduler is stopped
+func Start(a *config.AppContext) {
+	if a.Db == nil {
+		a.Log.Warn("database is not enabled. So not starting the refresh scheduler")
+		return
+	}
+	for {
+		Check(a, time.Now())
+		select {
+		case <-stop:
+			return
+		case <-time.After(config.RefreshCheckInterval):
+		}
+	}
+}
+
+//Stop stops the scheduler from running the due schedules and waits for the running refreshes to finish till the context is done.
+//The downloads of the refreshes still running after that are cancelled and the error of the context is returned
+func Stop(ctx context.Context) error {
+	/*
+	 * We will stop the scheduler
+	 * Then we will wait for the running refreshes
+	 * If the context is done, we will cancel the running refreshes
+	 */
+	//stopping the scheduler
+	//the lock makes sure that no refresh is started once we start waiting for them
+	stopLock.Lock()
+	if !stopped {
+		stopped = true
+		close(stop)
+	}
+	stopLock.Unlock()
+
+	//waiting for the running refreshes
+	done := make(chan struct{})
+	go func() {
+		runs.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+	}
+
+	//cancelling the running refreshes
+	cancelRefreshes()
+	return ctx.Err()
+}
+
+//Check runs the refresh schedules which are due by the given time
+func Check(a *config.AppContext, t time.Time) {
+	/*
+	 * We will get the due schedules
+	 * Then we will claim each of them by moving its next run
+	 * Then we will run the claimed schedules
+	 */
+	//getting the due schedules
+	schedules, err := db.GetDueRefreshSchedules(a, t)
+	if err != nil {
+		//error while getting the due schedules
+		a.Log.Error("error while getting the due refresh schedules", err)
+		return
+	}
+
+	for _, v := range schedules {
+		//the schedules are left to the other instances once the scheduler is stopped
+		stopLock.Lock()
+		if stopped {
+			stopLock.Unlock()
+			return
+		}
+		runs.Add(1)
+		stopLock.Unlock()
+
+		//claiming the schedule
+		s := v
+		next, err := Next(s.Cron, t)
+		if err != nil {
+			//invalid cron expressions are rejected while saving the schedule. So this shouldn't happen
+			a.Log.Error("error while parsing the cron expression of the refresh schedule", s.ID, s.Cron, err)
+			runs.Done()
+			continue
+		}
+		ok, err := s.Claim(a, next)
+		if err != nil {
+			//error while claiming the schedule
+			a.Log.Error("error while claiming the refresh schedule", s.ID, err)
+			runs.Done()
+			continue
+		}
+		if !ok {
+			//another instance has already claimed the schedule
+			runs.Done()
+			continue
+		}
+
+		//running the schedule
+		go func() {
+			defer runs.Done()
+			Run(a, &s)
+		}()
+	}
+}
+
+//Run refreshes the dataset as per the schedule and records the outcome of the run
+func Run(a *config.AppContext, s *db.RefreshSchedule) {
+	/*
+	 * We will create the app context for the user of the schedule
+	 * Then we will refresh the dataset
+	 * Then we will record the outcome of the run
+	 */
+	//creating the app context for the user
+	appCtx := config.NewAppContext(a.Log)
+	appCtx.Session = authConfig.Session{
+		ID:            authConfig.MasterAppDetails.AccessToken,
+		Authenticated: true,
+		User:          &authConfig.User{ID: s.UserID},
+	}
+	a.Log.Info("Started refreshing the file upload", s.FileUploadID, "as per the schedule", s.ID)
+
+	//refreshing the dataset
+	run := &db.RefreshRun{
+		RefreshScheduleID: s.ID,
+		FileUploadID:      s.FileUploadID,
+		UserID:            s.UserID,
+		StartedAt:         time.Now(),
+	}
+	err := refresh(appCtx, s, run)
+	run.FinishedAt = time.Now()
+	switch {
+	case err == nil:
+		run.Status = models.RefreshRunStatusSucceeded
+	case errors.Is(err, remote.ErrNotModified):
+		run.Status = models.RefreshRunStatusSkipped
+	case errors.Is(err, ErrProcessing):
+		run.Status = models.RefreshRunStatusSkipped
+		run.Error = err.Error()
+	default:
+		run.Status = models.RefreshRunStatusFailed
+		run.Error = err.Error()
+		a.Log.Error("error while refreshing the file upload", s.FileUploadID, "as per the schedule", s.ID, err)
+	}
+
+	//recording the outcome
+	err = run.Create(appCtx)
+	if err != nil {
+		//error while recording the outcome of the run
+		a.Log.Error("error while recording the refresh run of the schedule", s.ID, err)
+		return
+	}
+	a.Log.Info("Finished refreshing the file upload", s.FileUploadID, "as per the schedule", s.ID, "with status", run.Status)
+}
+
+//refresh downloads the remote file of the file upload if it has changed and enqueues the pipeline with the append flag of the schedule.
+//The downloaded file replaces the file of the upload at a new location, since the file can be shared by the other uploads like the sheets of a workbook.
+//The checksum and size of the downloaded file and the pipeline job are updated in the run. The etag and last modified time of the remote file
+//are stored only once the pipeline is enqueued, so that a change which couldn't be loaded is downloaded again in the next run.
+//remote.ErrNotModified is returned if the remote file hasn't changed since the last run and ErrProcessing if the file is being processed
+func refresh(a *config.AppContext, s *db.RefreshSchedule, run *db.RefreshRun) error {
+	/*
+	 * We will get the file upload
+	 * Then we will download the remote file to a new location
+	 * Then we will lock the dataset of the file and make sure that the file is not being processed
+	 * Then we will check whether the file has changed
+	 * Then we will transcode the file to utf-8
+	 * Then in a transaction we will update the location, source and encoding of the file and its status and enqueue the pipeline
+	 * Then we will release the existing file
+	 * Then we will update the validators of the remote file
+	 */
+	//getting the file upload
+	f := &db.FileUpload{}
+	f.ID = s.FileUploadID
+	err := f.Get(a)
+	if err != nil {
+		return err
+	}
+	if len(f.SourceURL) == 0 {
+		return fmt.Errorf("file upload %d is not imported from a remote url", f.ID)
+	}
+
+	//downloading the remote file
+	headers := map[string]string{}
+	if len(s.Headers) != 0 {
+		err = json.Unmarshal([]byte(s.Headers), &headers)
+		if err != nil {
+			return err
+		}
+	}
+	req := remote.Request{
+		URL:          f.SourceURL,
+		Headers:      headers,
+		MaxSize:      config.MaxUploadSize,
+		ETag:         s.ETag,
+		LastModified: s.LastModified,
+		AllowPrivate: config.RemoteImportAllowPrivate,
+	}
+	ctx, cancel := context.WithTimeout(refreshCtx, config.RemoteImportTimeout)
+	defer cancel()
+	location, err := libfile.NewLocation(f.Location)
+	if err != nil {
+		return err
+	}
+	replaced := false
+	defer func() {
+		if !replaced {
+			os.RemoveAll(filepath.Dir(location))
+		}
+	}()
+	res, err := remote.Download(ctx, req, location)
+	if err != nil {
+		return err
+	}
+	run.Checksum = res.Checksum
+	run.Size = res.Size
+
+	//locking the dataset
+	//the validators are not updated if the file is being processed so that it is downloaded again in the next run
+	dSet, err := f.GetDataset(a)
+	if err != nil {
+		return err
+	}
+	lock, ok, err := db.TryLockDataset(a, dSet.ID)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return ErrProcessing
+	}
+	defer func() {
+		if uErr := lock.Unlock(); uErr != nil {
+			a.Log.Error("error while unlocking the dataset", dSet.ID, "of the file upload", f.ID, uErr)
+		}
+	}()
+	active, err := db.HasActiveJob(a, f.ID)
+	if err != nil {
+		return err
+	}
+	if active || !models.CanTransition(f.Status, models.FileUploadStatusUploaded) {
+		return ErrProcessing
+	}
+
+	//checking whether the file has changed
+	//servers not supporting the conditional requests will send the same file again
+	if res.Checksum == f.Checksum {
+		err = s.UpdateValidators(a, res.ETag, res.LastModified)
+		if err != nil {
+			return err
+		}
+		return remote.ErrNotModified
+	}
+	isArchive, err := archive.IsArchive(location)
+	if err != nil {
+		return err
+	}
+	if isArchive {
+		return ErrArchive
+	}
+
+	//transcoding the file
+	downloaded := *f
+	downloaded.Location = location
+	encoding, err := libfile.TranscodeUpload(downloaded)
+	if err != nil {
+		return err
+	}
+
+	//updating the location, source and encoding of the file and its status and enqueueing the pipeline
+	//everything is done in a transaction so that the upload is never left pointing to the new file without its pipeline
+	existing := f.Location
+	j := &db.Job{Type: models.JobTypePipeline, FileUploadID: f.ID, Append: s.Append}
+	err = db.InTransaction(a, func(txCtx *config.AppContext) error {
+		if err := f.UpdateLocation(txCtx, location); err != nil {
+			return err
+		}
+		if err := f.UpdateSource(txCtx, res.Checksum, res.Size, f.SourceURL); err != nil {
+			return err
+		}
+		if err := f.UpdateEncoding(txCtx, encoding); err != nil {
+			return err
+		}
+		if err := f.DeleteErrorsAndUpdateStatus(txCtx); err != nil {
+			return err
+		}
+		return jobs.Enqueue(txCtx, j)
+	})
+	if errors.Is(err, db.ErrInvalidTransition) {
+		return ErrProcessing
+	}
+	if err != nil {
+		return err
+	}
+	replaced = true
+	run.JobID = j.ID
+	//the pipeline can be claimed only after the transaction is committed
+	jobs.Wake()
+
+	//releasing the existing file
+	if rErr := libfile.ReleaseLocation(a, existing); rErr != nil {
+		a.Log.Error("error while removing the replaced file", existing, "of the file upload", f.ID, rErr)
+	}
+
+	//updating the validators
+	return s.UpdateValidators(a, res.ETag, res.LastModified)
+}